@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/export"
+)
+
+const exportUsage = "usage: api export pets|sales -store <slug> [-format csv|ndjson] [-since date] [-until date] [-status list] [-include-breeder-emails] [-o file]"
+
+// runExport writes an export to a file or stdout. Operators running the
+// CLI already hold the database credentials and encryption key, so breeder
+// emails need no merchant permission here.
+func runExport(ctx context.Context, store db.Backend, defaultStore string, args []string) error {
+	if len(args) == 0 || (args[0] != "pets" && args[0] != "sales") {
+		return errors.New(exportUsage)
+	}
+	dataset := args[0]
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	storeSlug := fs.String("store", defaultStore, "store slug")
+	formatName := fs.String("format", "csv", "csv or ndjson")
+	since := fs.String("since", "", "earliest date or RFC 3339 time, inclusive")
+	until := fs.String("until", "", "latest date (inclusive) or RFC 3339 time (exclusive)")
+	status := fs.String("status", "", "comma-separated statuses to keep")
+	includeEmails := fs.Bool("include-breeder-emails", false, "decrypt breeder emails (pets only)")
+	output := fs.String("o", "", "output file; defaults to stdout")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 0 {
+		return errors.New(exportUsage)
+	}
+
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	from, to, err := export.ParseRange(*since, *until)
+	if err != nil {
+		return err
+	}
+	var statuses []string
+	for _, s := range strings.Split(*status, ",") {
+		if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+			statuses = append(statuses, s)
+		}
+	}
+	storeID, err := store.StoreIDBySlug(ctx, *storeSlug)
+	if err != nil {
+		return fmt.Errorf("store %q: %w", *storeSlug, err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if dataset == "pets" {
+		filter := db.PetExportFilter{Since: from, Until: to, IncludeBreederEmails: *includeEmails}
+		for _, s := range statuses {
+			filter.Statuses = append(filter.Statuses, db.PetStatus(s))
+		}
+		return export.Pets(ctx, store, storeID, filter, format, w, nil)
+	}
+	filter := db.SaleExportFilter{Since: from, Until: to}
+	for _, s := range statuses {
+		filter.Statuses = append(filter.Statuses, db.OrderItemStatus(s))
+	}
+	return export.Sales(ctx, store, storeID, filter, format, w, nil)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+const importUsage = "usage: api import-pets -store <slug> [-format csv|ndjson] [-dry-run] <file>"
+
+func runImportPets(ctx context.Context, store db.Backend, defaultStore string, args []string) error {
+	fs := flag.NewFlagSet("import-pets", flag.ContinueOnError)
+	storeSlug := fs.String("store", defaultStore, "store slug")
+	formatName := fs.String("format", "", "csv or ndjson; defaults to the file extension")
+	dryRun := fs.Bool("dry-run", false, "validate without creating pets")
+	if err := fs.Parse(args); err != nil {
+		return errors.New(importUsage)
+	}
+	if fs.NArg() != 1 {
+		return errors.New(importUsage)
+	}
+	path := fs.Arg(0)
+
+	format := db.ImportFormat(strings.ToUpper(*formatName))
+	if *formatName == "" {
+		var err error
+		if format, err = db.ImportFormatFromFilename(path); err != nil {
+			return err
+		}
+	}
+
+	storeID, err := store.StoreIDBySlug(ctx, *storeSlug)
+	if err != nil {
+		return fmt.Errorf("store %q: %w", *storeSlug, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rows, err := db.ParsePetImport(f, format)
+	if err != nil {
+		return err
+	}
+	report, err := store.ImportPets(ctx, storeID, rows, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tNAME\tRESULT")
+	for _, row := range report.Rows {
+		result := row.PetID
+		switch {
+		case row.Error != "":
+			result = "rejected: " + row.Error
+		case report.DryRun:
+			result = "ok"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", row.Line, row.Name, result)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if report.DryRun {
+		fmt.Printf("dry run: %d valid, %d rejected\n", len(report.Rows)-report.Rejected, report.Rejected)
+	} else {
+		fmt.Printf("created %d, rejected %d\n", report.Created, report.Rejected)
+	}
+	return nil
+}
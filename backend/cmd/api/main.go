@@ -10,17 +10,24 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 
 	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/blob"
 	"nimble-challenge/backend/internal/config"
 	"nimble-challenge/backend/internal/crypto"
 	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/events"
+	"nimble-challenge/backend/internal/export"
 	"nimble-challenge/backend/internal/graphql"
+	"nimble-challenge/backend/internal/mail"
+	"nimble-challenge/backend/internal/outbox"
+	"nimble-challenge/backend/internal/pictures"
+	"nimble-challenge/backend/internal/ratelimit"
+	"nimble-challenge/backend/internal/webhooks"
 )
 
 func main() {
@@ -36,26 +43,117 @@ func main() {
 		log.Fatalf("crypto: %v", err)
 	}
 
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
-		cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresHost, cfg.PostgresPort, cfg.PostgresDB,
-	)
-	store, err := db.NewStore(context.Background(), dsn, cipher)
+	dsn := cfg.PostgresDSN()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), dsn, os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	store, err := openStore(context.Background(), cfg, dsn, cipher)
 	if err != nil {
 		log.Fatalf("db: %v", err)
 	}
 	defer store.Close()
+	store.SetCartHoldTTL(cfg.CartHoldTTL)
+	store.SetCancelWindow(cfg.CancelWindow)
+	store.SetIdempotencyKeyTTL(cfg.IdempotencyTTL)
+	store.SetPasswordResetTTL(cfg.PasswordResetTTL)
+	bus, listen := newEventBus(store)
+	store.SetEventPublisher(bus)
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(context.Background(), store, cfg.StoreSlug, os.Args[2:]); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-pets" {
+		if err := runImportPets(context.Background(), store, cfg.StoreSlug, os.Args[2:]); err != nil {
+			log.Fatalf("import-pets: %v", err)
+		}
+		return
+	}
 
-	if err := store.EnsureDemoData(context.Background(), cfg.StoreSlug, cfg.StoreName, cfg.MerchantUser, cfg.MerchantPass, cfg.CustomerUser, cfg.CustomerPass); err != nil {
+	if err := store.EnsureDemoData(context.Background(), cfg.StoreSlug, cfg.StoreName, cfg.StoreCurrency, cfg.MerchantUser, cfg.MerchantPass, cfg.CustomerUser, cfg.CustomerPass); err != nil {
 		log.Fatalf("seed: %v", err)
 	}
+	if err := store.EnsureAdmin(context.Background(), cfg.AdminUser, cfg.AdminPass); err != nil {
+		log.Fatalf("seed admin: %v", err)
+	}
+
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go listen(bgCtx)
+	go runPeriodically(bgCtx, time.Minute, "release cart holds", func(ctx context.Context) error {
+		_, err := store.ReleaseExpiredHolds(ctx)
+		return err
+	})
+	go runPeriodically(bgCtx, time.Hour, "expire idempotency keys", func(ctx context.Context) error {
+		_, err := store.DeleteExpiredIdempotencyKeys(ctx)
+		return err
+	})
+	go runPeriodically(bgCtx, time.Hour, "expire password resets", func(ctx context.Context) error {
+		_, err := store.DeleteExpiredPasswordResets(ctx)
+		return err
+	})
+
+	mailer, closeMailer, err := newMailer(cfg)
+	if err != nil {
+		log.Fatalf("mail: %v", err)
+	}
+	defer closeMailer()
+
+	dispatcher := outbox.NewDispatcher(store, cipher, mailer)
+	dispatcher.SetMaxAttempts(cfg.OutboxAttempts)
+	go runPeriodically(bgCtx, cfg.OutboxInterval, "dispatch outbox", func(ctx context.Context) error {
+		_, err := dispatcher.Dispatch(ctx)
+		return err
+	})
+	go runPeriodically(bgCtx, time.Hour, "delete sent outbox messages", func(ctx context.Context) error {
+		_, err := store.DeleteSentOutboxMessages(ctx)
+		return err
+	})
 
-	handler := graphql.NewHandler(store)
-	handler = auth.Middleware(store)(handler)
+	hooks := webhooks.NewDispatcher(store, cipher)
+	hooks.SetMaxAttempts(cfg.WebhookAttempts)
+	go runPeriodically(bgCtx, cfg.WebhookInterval, "dispatch webhooks", func(ctx context.Context) error {
+		_, err := hooks.Dispatch(ctx)
+		return err
+	})
+	go runPeriodically(bgCtx, time.Hour, "delete old webhook deliveries", func(ctx context.Context) error {
+		_, err := store.DeleteOldWebhookDeliveries(ctx)
+		return err
+	})
+
+	blobs, err := blob.NewFileStore(cfg.PictureDir)
+	if err != nil {
+		log.Fatalf("pictures: %v", err)
+	}
+	library := pictures.NewLibrary(blobs)
+
+	handler := graphql.NewHandler(store, graphql.Options{
+		Mailer:           mailer,
+		PasswordResetURL: cfg.PasswordResetURL,
+		Pictures:         library,
+		PublicURL:        cfg.PublicURL,
+		Events:           bus,
+	})
+	handler = auth.OptionalMiddleware(store)(handler)
 	handler = withCORS(handler)
 	handler = withRateLimit(handler, 120, time.Minute)
 
+	exports := export.NewHandler(store)
+	exports = auth.Middleware(store)(exports)
+	exports = withCORS(exports)
+	exports = withRateLimit(exports, 10, time.Minute)
+
 	mux := http.NewServeMux()
 	mux.Handle("/graphql", handler)
+	mux.Handle("/export/", exports)
+	mux.Handle(pictures.PathPrefix, withRateLimit(library.Handler(), 600, time.Minute))
 
 	srv := &http.Server{
 		Addr:              fmt.Sprintf(":%d", cfg.AppPort),
@@ -77,6 +175,50 @@ func main() {
 	waitForShutdown(srv)
 }
 
+// openStore returns the configured storage backend. The in-memory backend
+// starts empty on every run and needs no database or migrations.
+func openStore(ctx context.Context, cfg config.Config, dsn string, cipher db.Crypto) (db.Backend, error) {
+	if cfg.StorageBackend == "memory" {
+		log.Printf("using in-memory storage; data is lost on restart")
+		return db.NewMemoryStore(cipher), nil
+	}
+	if cfg.AutoMigrate {
+		if err := migrateUp(ctx, dsn); err != nil {
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
+	}
+	return db.NewStore(ctx, dsn, cipher)
+}
+
+// newEventBus returns the bus that feeds subscriptions and the loop that
+// keeps it receiving. With Postgres, instances see each other's changes,
+// and so do imports run from the command line.
+func newEventBus(store db.Backend) (events.Bus, func(context.Context)) {
+	if pg, ok := store.(*db.Store); ok {
+		bus := pg.NewEventBus()
+		return bus, bus.Listen
+	}
+	return events.NewHub(), func(context.Context) {}
+}
+
+// newMailer returns the configured mail transport. The stdout and file
+// mailers only record messages, so resets and breeder mail work locally
+// without a mail server.
+func newMailer(cfg config.Config) (mail.Mailer, func(), error) {
+	switch cfg.Mailer {
+	case "smtp":
+		return mail.NewSMTPMailer(cfg.SMTPAddr, cfg.MailFrom, cfg.SMTPUser, cfg.SMTPPass), func() {}, nil
+	case "file":
+		f, err := os.OpenFile(cfg.MailFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mail.NewWriterMailer(f, cfg.MailFrom), func() { _ = f.Close() }, nil
+	default:
+		return mail.NewWriterMailer(os.Stdout, cfg.MailFrom), func() {}, nil
+	}
+}
+
 func serveTLS(srv *http.Server) error {
 	ln, err := net.Listen("tcp", srv.Addr)
 	if err != nil {
@@ -110,7 +252,7 @@ func withCORS(next http.Handler) http.Handler {
 		if origin != "" {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID, GraphQL-Preflight")
 			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
 		}
 		if r.Method == http.MethodOptions {
@@ -122,39 +264,32 @@ func withCORS(next http.Handler) http.Handler {
 }
 
 func withRateLimit(next http.Handler, maxTokens int, refill time.Duration) http.Handler {
-	type bucket struct {
-		tokens int
-		last   time.Time
-	}
-	var (
-		mu        sync.Mutex
-		bucketMap = map[string]*bucket{}
-	)
+	limiter := ratelimit.New(maxTokens, refill)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-		mu.Lock()
-		b, ok := bucketMap[ip]
-		if !ok {
-			b = &bucket{tokens: maxTokens, last: time.Now()}
-			bucketMap[ip] = b
-		}
-		now := time.Now()
-		elapsed := now.Sub(b.last)
-		if elapsed >= refill {
-			b.tokens = maxTokens
-			b.last = now
-		}
-		if b.tokens <= 0 {
-			mu.Unlock()
+		if !limiter.Allow(ip) {
 			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
-		b.tokens--
-		mu.Unlock()
 		next.ServeHTTP(w, r)
 	})
 }
 
+func runPeriodically(ctx context.Context, interval time.Duration, name string, fn func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := fn(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
 func waitForShutdown(srv *http.Server) {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
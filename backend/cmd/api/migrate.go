@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"nimble-challenge/backend/internal/migrate"
+)
+
+const migrateUsage = "usage: api migrate up | down [steps] | status"
+
+func runMigrate(ctx context.Context, dsn string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	switch args[0] {
+	case "up":
+		return migrateUp(ctx, dsn)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+			steps = n
+		}
+		return migrateDown(ctx, dsn, steps)
+	case "status":
+		return migrateStatus(ctx, dsn)
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+func migrateUp(ctx context.Context, dsn string) error {
+	m, err := migrate.New(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close(ctx)
+
+	applied, err := m.Up(ctx)
+	for _, mig := range applied {
+		log.Printf("applied migration %04d_%s", mig.Version, mig.Name)
+	}
+	return err
+}
+
+func migrateDown(ctx context.Context, dsn string, steps int) error {
+	m, err := migrate.New(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close(ctx)
+
+	reverted, err := m.Down(ctx, steps)
+	for _, mig := range reverted {
+		log.Printf("reverted migration %04d_%s", mig.Version, mig.Name)
+	}
+	return err
+}
+
+func migrateStatus(ctx context.Context, dsn string) error {
+	m, err := migrate.New(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close(ctx)
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, st := range statuses {
+		appliedAt := "pending"
+		if st.AppliedAt != nil {
+			appliedAt = st.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", st.Version, st.Name, appliedAt)
+	}
+	return w.Flush()
+}
@@ -12,45 +12,123 @@ type Role string
 const (
 	RoleMerchant Role = "merchant"
 	RoleCustomer Role = "customer"
+	// RoleAdmin runs the platform. Admins belong to no store and can only
+	// manage stores themselves.
+	RoleAdmin Role = "admin"
 )
 
-type Principal struct {
-	Role      Role
-	UserID    int64
+// Permission grants a merchant access beyond its role, such as seeing
+// decrypted breeder emails in exports.
+type Permission string
+
+const PermissionExportBreederEmails Permission = "EXPORT_BREEDER_EMAILS"
+
+// Membership is a store the principal may act in. Customers belong to
+// exactly one store; merchants may run several.
+type Membership struct {
 	StoreID   int64
 	StoreSlug string
-	Username  string
+}
+
+// Principal is the authenticated caller. StoreID and StoreSlug are the
+// store the account was created in; use Store to authorize access.
+type Principal struct {
+	Role        Role
+	UserID      int64
+	StoreID     int64
+	StoreSlug   string
+	Username    string
+	Permissions []Permission
+	Memberships []Membership
+}
+
+// Store returns the ID of the store with the given slug if the principal
+// is a member of it.
+func (p *Principal) Store(slug string) (int64, bool) {
+	for _, m := range p.Memberships {
+		if m.StoreSlug == slug {
+			return m.StoreID, true
+		}
+	}
+	return 0, false
+}
+
+func (p *Principal) Has(permission Permission) bool {
+	for _, granted := range p.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
 }
 
 type authenticator interface {
 	Authenticate(ctx context.Context, username, password string) (*Principal, error)
 }
 
+// ErrUnauthorized hides why credentials were refused.
+var ErrUnauthorized = errors.New("unauthorized")
+
 type contextKey struct{}
 
+// Middleware rejects requests without valid Basic Auth credentials.
 func Middleware(authenticator authenticator) func(http.Handler) http.Handler {
+	return middleware(authenticator, false)
+}
+
+// OptionalMiddleware lets requests that carry no credentials through
+// without a principal, for handlers that also serve anonymous callers.
+// Wrong credentials are still rejected.
+func OptionalMiddleware(authenticator authenticator) func(http.Handler) http.Handler {
+	return middleware(authenticator, true)
+}
+
+func middleware(authenticator authenticator, optional bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			username, password, ok := r.BasicAuth()
-			if !ok || strings.TrimSpace(username) == "" {
-				unauthorized(w)
+			meta := RequestMeta{ID: requestID(r), RemoteAddr: r.RemoteAddr}
+			w.Header().Set(RequestIDHeader, meta.ID)
+			ctx := WithRequestMeta(r.Context(), meta)
+
+			if optional && r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
-			principal, err := authenticator.Authenticate(r.Context(), username, password)
+			principal, err := FromAuthorization(ctx, authenticator, r.Header.Get("Authorization"))
 			if err != nil {
 				unauthorized(w)
 				return
 			}
-			ctx := context.WithValue(r.Context(), contextKey{}, principal)
+			ctx = WithPrincipal(ctx, principal)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// FromAuthorization checks an Authorization header value the way
+// Middleware does, for transports that carry it elsewhere, such as in the
+// first message of a WebSocket.
+func FromAuthorization(ctx context.Context, authenticator authenticator, header string) (*Principal, error) {
+	r := http.Request{Header: http.Header{"Authorization": {header}}}
+	username, password, ok := r.BasicAuth()
+	if !ok || strings.TrimSpace(username) == "" {
+		return nil, ErrUnauthorized
+	}
+	principal, err := authenticator.Authenticate(ctx, username, password)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	return principal, nil
+}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
 func FromContext(ctx context.Context) (*Principal, error) {
 	val := ctx.Value(contextKey{})
 	if val == nil {
-		return nil, errors.New("missing principal")
+		return nil, errors.New("authentication required")
 	}
 	principal, ok := val.(*Principal)
 	if !ok {
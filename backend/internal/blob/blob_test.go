@@ -0,0 +1,48 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, "pets/1/a.jpg", strings.NewReader("picture")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	obj, err := store.Open(ctx, "pets/1/a.jpg")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	data, _ := io.ReadAll(obj)
+	_ = obj.Close()
+	if string(data) != "picture" || obj.Size != 7 {
+		t.Fatalf("unexpected blob %q of size %d", data, obj.Size)
+	}
+
+	for _, key := range []string{"../a.jpg", "/etc/passwd", "pets/../../a.jpg", "."} {
+		if err := store.Put(ctx, key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("expected %q to be rejected, got %v", key, err)
+		}
+	}
+
+	if err := store.Delete(ctx, "pets/1/a.jpg"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := store.Open(ctx, "pets/1/a.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if _, err := store.Open(ctx, "pets"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a directory not to be a blob, got %v", err)
+	}
+	if err := store.Delete(ctx, "pets/1/a.jpg"); err != nil {
+		t.Fatalf("expected deleting a missing blob to succeed, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -14,15 +15,37 @@ type Config struct {
 	PostgresDB       string
 	PostgresHost     string
 	PostgresPort     int
+	AutoMigrate      bool
+	StorageBackend   string
 	TLSCertPath      string
 	TLSKeyPath       string
 	EncryptionKeyB64 string
 	StoreSlug        string
 	StoreName        string
+	StoreCurrency    string
 	MerchantUser     string
 	MerchantPass     string
 	CustomerUser     string
 	CustomerPass     string
+	AdminUser        string
+	AdminPass        string
+	CartHoldTTL      time.Duration
+	CancelWindow     time.Duration
+	IdempotencyTTL   time.Duration
+	PasswordResetTTL time.Duration
+	PasswordResetURL string
+	Mailer           string
+	MailFrom         string
+	MailFile         string
+	SMTPAddr         string
+	SMTPUser         string
+	SMTPPass         string
+	OutboxInterval   time.Duration
+	OutboxAttempts   int
+	WebhookInterval  time.Duration
+	WebhookAttempts  int
+	PublicURL        string
+	PictureDir       string
 }
 
 func Load() (Config, error) {
@@ -34,24 +57,67 @@ func Load() (Config, error) {
 		PostgresDB:       getenv("POSTGRES_DB", "nimble"),
 		PostgresHost:     getenv("POSTGRES_HOST", "db"),
 		PostgresPort:     getenvInt("POSTGRES_PORT", 5432),
+		AutoMigrate:      getenvBool("DB_AUTO_MIGRATE", true),
+		StorageBackend:   getenv("STORAGE_BACKEND", "postgres"),
 		TLSCertPath:      getenv("APP_TLS_CERT", ""),
 		TLSKeyPath:       getenv("APP_TLS_KEY", ""),
 		EncryptionKeyB64: getenv("APP_ENCRYPTION_KEY", ""),
 		StoreSlug:        getenv("STORE_SLUG", "demo"),
 		StoreName:        getenv("STORE_NAME", "Demo Pet Store"),
+		StoreCurrency:    getenv("STORE_CURRENCY", "USD"),
 		MerchantUser:     getenv("MERCHANT_USERNAME", "merchant_demo"),
 		MerchantPass:     getenv("MERCHANT_PASSWORD", "merchant_demo_pw"),
 		CustomerUser:     getenv("CUSTOMER_USERNAME", "customer_demo"),
 		CustomerPass:     getenv("CUSTOMER_PASSWORD", "customer_demo_pw"),
+		AdminUser:        getenv("ADMIN_USERNAME", ""),
+		AdminPass:        getenv("ADMIN_PASSWORD", ""),
+		CartHoldTTL:      getenvDuration("CART_HOLD_TTL", 15*time.Minute),
+		CancelWindow:     getenvDuration("PURCHASE_CANCEL_WINDOW", 30*time.Minute),
+		IdempotencyTTL:   getenvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		PasswordResetTTL: getenvDuration("PASSWORD_RESET_TTL", time.Hour),
+		PasswordResetURL: getenv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+		Mailer:           getenv("MAILER", "stdout"),
+		MailFrom:         getenv("MAIL_FROM", "no-reply@localhost"),
+		MailFile:         getenv("MAIL_FILE", "mail.log"),
+		SMTPAddr:         getenv("SMTP_ADDR", ""),
+		SMTPUser:         getenv("SMTP_USERNAME", ""),
+		SMTPPass:         getenv("SMTP_PASSWORD", ""),
+		OutboxInterval:   getenvDuration("OUTBOX_INTERVAL", 15*time.Second),
+		OutboxAttempts:   getenvInt("OUTBOX_MAX_ATTEMPTS", 8),
+		WebhookInterval:  getenvDuration("WEBHOOK_INTERVAL", 10*time.Second),
+		WebhookAttempts:  getenvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		PictureDir:       getenv("PICTURE_DIR", "pictures"),
 	}
+	cfg.PublicURL = getenv("PUBLIC_URL", fmt.Sprintf("https://localhost:%d", cfg.AppPort))
 
 	if cfg.EncryptionKeyB64 == "" {
 		return cfg, fmt.Errorf("APP_ENCRYPTION_KEY is required")
 	}
+	if cfg.AdminUser != "" && cfg.AdminPass == "" {
+		return cfg, fmt.Errorf("ADMIN_PASSWORD is required when ADMIN_USERNAME is set")
+	}
+	switch cfg.Mailer {
+	case "stdout", "file":
+	case "smtp":
+		if cfg.SMTPAddr == "" {
+			return cfg, fmt.Errorf("SMTP_ADDR is required when MAILER is smtp")
+		}
+	default:
+		return cfg, fmt.Errorf("MAILER must be stdout, file or smtp")
+	}
+	if cfg.StorageBackend != "postgres" && cfg.StorageBackend != "memory" {
+		return cfg, fmt.Errorf("STORAGE_BACKEND must be postgres or memory")
+	}
 
 	return cfg, nil
 }
 
+func (c Config) PostgresDSN() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
+		c.PostgresUser, c.PostgresPassword, c.PostgresHost, c.PostgresPort, c.PostgresDB,
+	)
+}
+
 func getenv(key, fallback string) string {
 	val := os.Getenv(key)
 	if val == "" {
@@ -71,3 +137,27 @@ func getenvInt(key string, fallback int) int {
 	}
 	return parsed
 }
+
+func getenvBool(key string, fallback bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(val)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+func getenvDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(val)
+	if err != nil || parsed <= 0 {
+		return fallback
+	}
+	return parsed
+}
@@ -53,9 +53,24 @@ func (c *Cipher) Decrypt(ciphertext, nonce []byte) (string, error) {
 	return string(plaintext), nil
 }
 
+// Password length limits, the one rule every password is held to.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+)
+
+// ValidatePassword reports whether password may be set, so a change can
+// be refused before anything else is checked.
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return fmt.Errorf("password must be %d-%d characters", MinPasswordLength, MaxPasswordLength)
+	}
+	return nil
+}
+
 func HashPassword(password string) (string, error) {
-	if len(password) < 8 {
-		return "", errors.New("password too short")
+	if err := ValidatePassword(password); err != nil {
+		return "", err
 	}
 	salt := make([]byte, 16)
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
@@ -35,3 +35,12 @@ func TestHashPassword(t *testing.T) {
 		t.Fatalf("expected password to verify")
 	}
 }
+
+func TestHashPasswordEnforcesLength(t *testing.T) {
+	if _, err := HashPassword("short"); err == nil {
+		t.Fatalf("expected a short password to be refused")
+	}
+	if err := ValidatePassword("eight_ch"); err != nil {
+		t.Fatalf("expected an %d-character password to be accepted: %v", MinPasswordLength, err)
+	}
+}
@@ -0,0 +1,391 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/crypto"
+)
+
+var (
+	ErrUsernameTaken = errors.New("username is already taken")
+	ErrWrongPassword = errors.New("current password is incorrect")
+)
+
+var (
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{2,31}$`)
+	phonePattern    = regexp.MustCompile(`^\+?[0-9 ()-]{6,24}$`)
+)
+
+// Registration is a customer signing up to a store.
+type Registration struct {
+	Username string
+	Password string
+	Email    string
+	Phone    string
+}
+
+// CustomerProfile is a customer's account with its contact fields
+// decrypted.
+type CustomerProfile struct {
+	ID        int64
+	StoreID   int64
+	Username  string
+	Email     string
+	Phone     string
+	CreatedAt time.Time
+}
+
+// ProfilePatch changes contact fields; nil fields are left alone and an
+// empty phone clears it.
+type ProfilePatch struct {
+	Email *string
+	Phone *string
+}
+
+func (r Registration) normalize() Registration {
+	r.Username = strings.TrimSpace(r.Username)
+	r.Email = strings.TrimSpace(r.Email)
+	r.Phone = strings.TrimSpace(r.Phone)
+	return r
+}
+
+func (r Registration) validate() error {
+	if !usernamePattern.MatchString(r.Username) {
+		return errors.New("username must be 3-32 letters, digits, dots, dashes or underscores")
+	}
+	if err := crypto.ValidatePassword(r.Password); err != nil {
+		return err
+	}
+	if err := validateEmail(r.Email); err != nil {
+		return err
+	}
+	return validatePhone(r.Phone)
+}
+
+func (p ProfilePatch) normalize() ProfilePatch {
+	if p.Email != nil {
+		email := strings.TrimSpace(*p.Email)
+		p.Email = &email
+	}
+	if p.Phone != nil {
+		phone := strings.TrimSpace(*p.Phone)
+		p.Phone = &phone
+	}
+	return p
+}
+
+func (p ProfilePatch) validate() error {
+	if p.Email != nil {
+		if err := validateEmail(*p.Email); err != nil {
+			return err
+		}
+	}
+	if p.Phone != nil {
+		return validatePhone(*p.Phone)
+	}
+	return nil
+}
+
+func (p ProfilePatch) apply(profile CustomerProfile) CustomerProfile {
+	if p.Email != nil {
+		profile.Email = *p.Email
+	}
+	if p.Phone != nil {
+		profile.Phone = *p.Phone
+	}
+	return profile
+}
+
+func (p ProfilePatch) auditDetail() string {
+	var changed []string
+	if p.Email != nil {
+		changed = append(changed, "email")
+	}
+	if p.Phone != nil {
+		changed = append(changed, "phone")
+	}
+	return strings.Join(changed, ", ")
+}
+
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("email is invalid")
+	}
+	return nil
+}
+
+func validatePhone(phone string) error {
+	if phone != "" && !phonePattern.MatchString(phone) {
+		return errors.New("phone is invalid")
+	}
+	return nil
+}
+
+// accountAuditEvent is attributed to the account it concerns, since the
+// customer registering has no principal yet.
+func accountAuditEvent(ctx context.Context, action AuditAction, profile CustomerProfile) AuditEvent {
+	event := newAuditEvent(ctx, action)
+	event.setActor(&auth.Principal{Role: auth.RoleCustomer, UserID: profile.ID, StoreID: profile.StoreID, Username: profile.Username})
+	return event
+}
+
+// usernameQuery is true if any account uses the username, ignoring case,
+// so that names cannot be told apart by case alone.
+const usernameQuery = `
+	SELECT EXISTS (SELECT 1 FROM merchants WHERE LOWER(username) = LOWER($1))
+	    OR EXISTS (SELECT 1 FROM customers WHERE LOWER(username) = LOWER($1))
+	    OR EXISTS (SELECT 1 FROM platform_admins WHERE LOWER(username) = LOWER($1))
+`
+
+// RegisterCustomer creates a customer of an active store. Usernames are
+// unique across merchants, customers and admins.
+func (s *Store) RegisterCustomer(ctx context.Context, storeSlug string, reg Registration) (CustomerProfile, error) {
+	reg = reg.normalize()
+	profile, storeID, err := s.registerCustomer(ctx, storeSlug, reg)
+	if err != nil {
+		event := newAuditEvent(ctx, AuditRegister)
+		event.ActorUsername = reg.Username
+		if storeID != 0 {
+			event.StoreID = &storeID
+		}
+		s.recordAuditFailure(ctx, event, err)
+	}
+	return profile, err
+}
+
+func (s *Store) registerCustomer(ctx context.Context, storeSlug string, reg Registration) (CustomerProfile, int64, error) {
+	if err := reg.validate(); err != nil {
+		return CustomerProfile{}, 0, err
+	}
+	hash, err := crypto.HashPassword(reg.Password)
+	if err != nil {
+		return CustomerProfile{}, 0, fmt.Errorf("hash password: %w", err)
+	}
+	emailEnc, emailNonce, err := s.crypto.Encrypt(reg.Email)
+	if err != nil {
+		return CustomerProfile{}, 0, fmt.Errorf("encrypt email: %w", err)
+	}
+	phoneEnc, phoneNonce, err := s.crypto.Encrypt(reg.Phone)
+	if err != nil {
+		return CustomerProfile{}, 0, fmt.Errorf("encrypt phone: %w", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return CustomerProfile{}, 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	profile := CustomerProfile{Username: reg.Username, Email: reg.Email, Phone: reg.Phone}
+	var active bool
+	err = tx.QueryRow(ctx, `SELECT id, deactivated_at IS NULL FROM stores WHERE slug = $1 FOR SHARE`, storeSlug).Scan(&profile.StoreID, &active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrStoreNotFound
+		return CustomerProfile{}, 0, err
+	}
+	if err != nil {
+		return CustomerProfile{}, 0, fmt.Errorf("select store: %w", err)
+	}
+	if !active {
+		err = ErrStoreInactive
+		return CustomerProfile{}, profile.StoreID, err
+	}
+
+	// The lock serialises registrations of the same name; the unique index
+	// on customers alone cannot see merchants and admins.
+	if _, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('username:' || LOWER($1)))`, reg.Username); err != nil {
+		return CustomerProfile{}, profile.StoreID, fmt.Errorf("lock username: %w", err)
+	}
+	var taken bool
+	if err = tx.QueryRow(ctx, usernameQuery, reg.Username).Scan(&taken); err != nil {
+		return CustomerProfile{}, profile.StoreID, fmt.Errorf("check username: %w", err)
+	}
+	if taken {
+		err = ErrUsernameTaken
+		return CustomerProfile{}, profile.StoreID, err
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO customers (store_id, username, password_hash, email_enc, email_nonce, phone_enc, phone_nonce)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, profile.StoreID, reg.Username, hash, emailEnc, emailNonce, phoneEnc, phoneNonce).Scan(&profile.ID, &profile.CreatedAt)
+	if err != nil {
+		return CustomerProfile{}, profile.StoreID, fmt.Errorf("insert customer: %w", err)
+	}
+	if err = insertAuditEvent(ctx, tx, accountAuditEvent(ctx, AuditRegister, profile)); err != nil {
+		return CustomerProfile{}, profile.StoreID, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return CustomerProfile{}, profile.StoreID, fmt.Errorf("commit: %w", err)
+	}
+	return profile, profile.StoreID, nil
+}
+
+const customerProfileColumns = `id, store_id, username, email_enc, email_nonce, phone_enc, phone_nonce, created_at`
+
+func (s *Store) scanCustomerProfile(row pgx.Row) (CustomerProfile, error) {
+	var (
+		profile                                    CustomerProfile
+		emailEnc, emailNonce, phoneEnc, phoneNonce []byte
+	)
+	err := row.Scan(&profile.ID, &profile.StoreID, &profile.Username,
+		&emailEnc, &emailNonce, &phoneEnc, &phoneNonce, &profile.CreatedAt)
+	if err != nil {
+		return CustomerProfile{}, err
+	}
+	// Seeded customers have no contact fields.
+	if emailEnc != nil {
+		if profile.Email, err = s.crypto.Decrypt(emailEnc, emailNonce); err != nil {
+			return CustomerProfile{}, fmt.Errorf("decrypt email: %w", err)
+		}
+	}
+	if phoneEnc != nil {
+		if profile.Phone, err = s.crypto.Decrypt(phoneEnc, phoneNonce); err != nil {
+			return CustomerProfile{}, fmt.Errorf("decrypt phone: %w", err)
+		}
+	}
+	return profile, nil
+}
+
+func (s *Store) CustomerProfile(ctx context.Context, customerID int64) (CustomerProfile, error) {
+	profile, err := s.scanCustomerProfile(s.pool.QueryRow(ctx, `SELECT `+customerProfileColumns+` FROM customers WHERE id = $1`, customerID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return CustomerProfile{}, errors.New("customer not found")
+	}
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("query customer: %w", err)
+	}
+	return profile, nil
+}
+
+func (s *Store) UpdateCustomerProfile(ctx context.Context, customerID int64, patch ProfilePatch) (CustomerProfile, error) {
+	profile, err := s.updateCustomerProfile(ctx, customerID, patch.normalize())
+	if err != nil {
+		s.recordAuditFailure(ctx, newAuditEvent(ctx, AuditUpdateProfile), err)
+	}
+	return profile, err
+}
+
+func (s *Store) updateCustomerProfile(ctx context.Context, customerID int64, patch ProfilePatch) (profile CustomerProfile, err error) {
+	if err := patch.validate(); err != nil {
+		return CustomerProfile{}, err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	profile, err = s.scanCustomerProfile(tx.QueryRow(ctx, `SELECT `+customerProfileColumns+` FROM customers WHERE id = $1 FOR UPDATE`, customerID))
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("select customer: %w", err)
+	}
+	profile = patch.apply(profile)
+	emailEnc, emailNonce, err := s.crypto.Encrypt(profile.Email)
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("encrypt email: %w", err)
+	}
+	phoneEnc, phoneNonce, err := s.crypto.Encrypt(profile.Phone)
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("encrypt phone: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE customers SET email_enc = $2, email_nonce = $3, phone_enc = $4, phone_nonce = $5
+		WHERE id = $1
+	`, customerID, emailEnc, emailNonce, phoneEnc, phoneNonce)
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("update customer: %w", err)
+	}
+	event := newAuditEvent(ctx, AuditUpdateProfile)
+	event.Detail = patch.auditDetail()
+	if err = insertAuditEvent(ctx, tx, event); err != nil {
+		return CustomerProfile{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return CustomerProfile{}, fmt.Errorf("commit: %w", err)
+	}
+	return profile, nil
+}
+
+// accountTables maps roles to the table their accounts live in.
+var accountTables = map[auth.Role]string{
+	auth.RoleMerchant: "merchants",
+	auth.RoleCustomer: "customers",
+	auth.RoleAdmin:    "platform_admins",
+}
+
+// ChangePassword replaces the password of the account identified by role
+// and ID after checking the current one. Basic Auth sends credentials with
+// every request, so the old password stops working immediately.
+func (s *Store) ChangePassword(ctx context.Context, role auth.Role, userID int64, currentPassword, newPassword string) error {
+	err := s.changePassword(ctx, role, userID, currentPassword, newPassword)
+	if err != nil {
+		s.recordAuditFailure(ctx, newAuditEvent(ctx, AuditChangePassword), err)
+	}
+	return err
+}
+
+func (s *Store) changePassword(ctx context.Context, role auth.Role, userID int64, currentPassword, newPassword string) (err error) {
+	table, ok := accountTables[role]
+	if !ok {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if err := crypto.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+	hash, err := crypto.HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	var current string
+	err = tx.QueryRow(ctx, fmt.Sprintf(`SELECT password_hash FROM %s WHERE id = $1 FOR UPDATE`, table), userID).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("select account: %w", err)
+	}
+	if ok, err := crypto.VerifyPassword(currentPassword, current); err != nil || !ok {
+		return ErrWrongPassword
+	}
+	if _, err = tx.Exec(ctx, fmt.Sprintf(`UPDATE %s SET password_hash = $2 WHERE id = $1`, table), userID, hash); err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	if role == auth.RoleCustomer {
+		if err = spendResetTokens(ctx, tx, userID); err != nil {
+			return err
+		}
+	}
+	if err = insertAuditEvent(ctx, tx, newAuditEvent(ctx, AuditChangePassword)); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"nimble-challenge/backend/internal/auth"
+)
+
+type AuditAction string
+
+const (
+	AuditLogin                AuditAction = "LOGIN"
+	AuditCreatePet            AuditAction = "CREATE_PET"
+	AuditPurchasePets         AuditAction = "PURCHASE_PETS"
+	AuditImportPets           AuditAction = "IMPORT_PETS"
+	AuditExport               AuditAction = "EXPORT"
+	AuditCreateStore          AuditAction = "CREATE_STORE"
+	AuditUpdateStore          AuditAction = "UPDATE_STORE"
+	AuditRegister             AuditAction = "REGISTER"
+	AuditUpdateProfile        AuditAction = "UPDATE_PROFILE"
+	AuditChangePassword       AuditAction = "CHANGE_PASSWORD"
+	AuditRequestPasswordReset AuditAction = "REQUEST_PASSWORD_RESET"
+	AuditResetPassword        AuditAction = "RESET_PASSWORD"
+	AuditCreateSpecies        AuditAction = "CREATE_SPECIES"
+	AuditUpdateSpecies        AuditAction = "UPDATE_SPECIES"
+	AuditDeleteSpecies        AuditAction = "DELETE_SPECIES"
+	AuditCreateWebhook        AuditAction = "CREATE_WEBHOOK"
+	AuditUpdateWebhook        AuditAction = "UPDATE_WEBHOOK"
+	AuditDeleteWebhook        AuditAction = "DELETE_WEBHOOK"
+	AuditRedeliverWebhook     AuditAction = "REDELIVER_WEBHOOK"
+)
+
+type AuditOutcome string
+
+const (
+	AuditSuccess AuditOutcome = "SUCCESS"
+	AuditPartial AuditOutcome = "PARTIAL"
+	AuditFailure AuditOutcome = "FAILURE"
+)
+
+// AuditEvent is one row of the append-only audit log. StoreID and the
+// actor fields are empty for logins with an unknown username; StoreID is
+// also empty for platform admins, who belong to no store.
+type AuditEvent struct {
+	ID            string
+	OccurredAt    time.Time
+	StoreID       *int64
+	ActorRole     string
+	ActorID       *int64
+	ActorUsername string
+	Action        AuditAction
+	TargetIDs     []string
+	RequestID     string
+	RemoteAddr    string
+	Outcome       AuditOutcome
+	Detail        string
+}
+
+type AuditFilter struct {
+	Action        *AuditAction
+	Outcome       *AuditOutcome
+	ActorUsername string
+	Since         *time.Time
+	Until         *time.Time
+}
+
+type AuditEventEdge struct {
+	Cursor string
+	Event  AuditEvent
+}
+
+type AuditEventPage struct {
+	Edges []AuditEventEdge
+	PageInfo
+}
+
+var sortOccurredDesc = sortKey{expr: "occurred_at", cast: "timestamptz", desc: true}
+
+// execer is satisfied by both the pool and a transaction, so an audit row
+// can be written inside the transaction of the action it describes.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// newAuditEvent describes action as performed by the request's principal.
+func newAuditEvent(ctx context.Context, action AuditAction, targetIDs ...string) AuditEvent {
+	meta := auth.RequestMetaFromContext(ctx)
+	event := AuditEvent{
+		Action:     action,
+		TargetIDs:  targetIDs,
+		RequestID:  meta.ID,
+		RemoteAddr: meta.RemoteAddr,
+		Outcome:    AuditSuccess,
+	}
+	if principal, err := auth.FromContext(ctx); err == nil {
+		event.setActor(principal)
+	}
+	return event
+}
+
+// storeAuditEvent attributes an action to the store it touched, which for
+// merchants may differ from their home store. Actions taken from the CLI
+// have no principal and are recorded with the "cli" role.
+func storeAuditEvent(ctx context.Context, action AuditAction, storeID int64, targetIDs ...string) AuditEvent {
+	event := newAuditEvent(ctx, action, targetIDs...)
+	event.StoreID = &storeID
+	if event.ActorRole == "" {
+		event.ActorRole = "cli"
+	}
+	return event
+}
+
+func (e *AuditEvent) setActor(principal *auth.Principal) {
+	if principal.StoreID != 0 {
+		e.StoreID = &principal.StoreID
+	}
+	e.ActorRole = string(principal.Role)
+	e.ActorID = &principal.UserID
+	e.ActorUsername = principal.Username
+}
+
+func insertAuditEvent(ctx context.Context, db execer, e AuditEvent) error {
+	targets := e.TargetIDs
+	if targets == nil {
+		targets = []string{}
+	}
+	_, err := db.Exec(ctx, `
+		INSERT INTO audit_events (
+			store_id, actor_role, actor_id, actor_username, action, target_ids,
+			request_id, remote_addr, outcome, detail
+		)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, NULLIF($10, ''))
+	`, e.StoreID, e.ActorRole, e.ActorID, e.ActorUsername, string(e.Action), targets,
+		e.RequestID, e.RemoteAddr, string(e.Outcome), e.Detail)
+	if err != nil {
+		return fmt.Errorf("insert audit event: %w", err)
+	}
+	return nil
+}
+
+// recordAuditFailure logs an action that failed. Its transaction, if any,
+// has been rolled back, so the row is written on its own.
+func (s *Store) recordAuditFailure(ctx context.Context, e AuditEvent, cause error) {
+	e.Outcome = AuditFailure
+	e.Detail = cause.Error()
+	if err := insertAuditEvent(ctx, s.pool, e); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+func (s *Store) PageAuditEvents(ctx context.Context, storeID int64, filter AuditFilter, page PageRequest) (AuditEventPage, error) {
+	var q listQuery
+	q.where("store_id = %s", storeID)
+	if filter.Action != nil {
+		q.where("action = %s", string(*filter.Action))
+	}
+	if filter.Outcome != nil {
+		q.where("outcome = %s", string(*filter.Outcome))
+	}
+	if username := strings.TrimSpace(filter.ActorUsername); username != "" {
+		q.where("actor_username = %s", username)
+	}
+	if filter.Since != nil {
+		q.where("occurred_at >= %s", *filter.Since)
+	}
+	if filter.Until != nil {
+		q.where("occurred_at < %s", *filter.Until)
+	}
+
+	tail, limit, backward, err := q.keyset(sortOccurredDesc, "id", page)
+	if err != nil {
+		return AuditEventPage{}, err
+	}
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+		SELECT id, occurred_at, store_id, COALESCE(actor_role, ''), actor_id,
+		       COALESCE(actor_username, ''), action, target_ids, COALESCE(request_id, ''),
+		       COALESCE(remote_addr, ''), outcome, COALESCE(detail, ''), (%s)::text
+		FROM audit_events
+		WHERE %s
+		%s
+	`, sortOccurredDesc.expr, q.clause(), tail), q.args...)
+	if err != nil {
+		return AuditEventPage{}, fmt.Errorf("query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var edges []AuditEventEdge
+	for rows.Next() {
+		var e AuditEvent
+		var key string
+		if err := rows.Scan(&e.ID, &e.OccurredAt, &e.StoreID, &e.ActorRole, &e.ActorID, &e.ActorUsername,
+			&e.Action, &e.TargetIDs, &e.RequestID, &e.RemoteAddr, &e.Outcome, &e.Detail, &key); err != nil {
+			return AuditEventPage{}, fmt.Errorf("scan audit event: %w", err)
+		}
+		edges = append(edges, AuditEventEdge{Cursor: encodeCursor(key, e.ID), Event: e})
+	}
+	if err := rows.Err(); err != nil {
+		return AuditEventPage{}, fmt.Errorf("iterate audit events: %w", err)
+	}
+
+	var result AuditEventPage
+	result.Edges, result.PageInfo = trimPage(edges, limit, backward, page, func(e AuditEventEdge) string { return e.Cursor })
+	return result, nil
+}
+
+// auditEvent summarises a committed purchase: which pets were asked for,
+// the order that resulted and why any pets were refused.
+func (r PurchaseResult) auditEvent(ctx context.Context, petIDs []string) AuditEvent {
+	event := newAuditEvent(ctx, AuditPurchasePets, petIDs...)
+	var details []string
+	if r.Order != nil {
+		details = append(details, "order "+r.Order.ID)
+	}
+	for _, e := range r.Errors {
+		details = append(details, e.PetName+": "+e.Message)
+	}
+	event.Detail = strings.Join(details, "; ")
+	switch {
+	case len(r.PurchasedIDs) == 0:
+		event.Outcome = AuditFailure
+	case len(r.Errors) > 0:
+		event.Outcome = AuditPartial
+	}
+	return event
+}
+
+// loginAuditInterval is how often an account's successful logins are
+// recorded. Basic Auth checks credentials on every request, so recording
+// each one would write on every read and bury everything else in the log.
+const loginAuditInterval = 15 * time.Minute
+
+// loginRecorder remembers when each account's last successful login was
+// recorded by this instance.
+type loginRecorder struct {
+	mu   sync.Mutex
+	last map[loginKey]time.Time
+}
+
+type loginKey struct {
+	role   auth.Role
+	userID int64
+}
+
+func (r *loginRecorder) due(p *auth.Principal, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last, ok := r.last[loginKey{p.Role, p.UserID}]
+	return !ok || now.Sub(last) >= loginAuditInterval
+}
+
+func (r *loginRecorder) recorded(p *auth.Principal, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last == nil {
+		r.last = make(map[loginKey]time.Time)
+	}
+	if len(r.last) >= 10000 {
+		for key, at := range r.last {
+			if now.Sub(at) >= loginAuditInterval {
+				delete(r.last, key)
+			}
+		}
+	}
+	r.last[loginKey{p.Role, p.UserID}] = now
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"nimble-challenge/backend/internal/auth"
+)
+
+func TestPurchaseAuditEventOutcome(t *testing.T) {
+	ctx := auth.WithRequestMeta(context.Background(), auth.RequestMeta{ID: "req-1", RemoteAddr: "10.0.0.1:5000"})
+	cases := []struct {
+		result PurchaseResult
+		want   AuditOutcome
+	}{
+		{PurchaseResult{PurchasedIDs: []string{"a"}, Order: &Order{ID: "o1"}}, AuditSuccess},
+		{PurchaseResult{PurchasedIDs: []string{"a"}, Order: &Order{ID: "o1"}, Errors: []PurchaseError{{PetName: "Rex", Message: "already purchased"}}}, AuditPartial},
+		{PurchaseResult{Errors: []PurchaseError{{PetName: "Rex", Message: "already purchased"}}}, AuditFailure},
+	}
+	for _, tc := range cases {
+		event := tc.result.auditEvent(ctx, []string{"a", "b"})
+		if event.Outcome != tc.want {
+			t.Fatalf("expected %s, got %s", tc.want, event.Outcome)
+		}
+		if event.RequestID != "req-1" || event.RemoteAddr != "10.0.0.1:5000" {
+			t.Fatalf("expected request metadata, got %+v", event)
+		}
+	}
+
+	event := cases[1].result.auditEvent(ctx, nil)
+	if want := "order o1; Rex: already purchased"; event.Detail != want {
+		t.Fatalf("expected detail %q, got %q", want, event.Detail)
+	}
+}
+
+func TestLoginAuditThrottlesSuccesses(t *testing.T) {
+	store, _, _ := newTestMemoryStore(t)
+	ctx := context.Background()
+	count := func(outcome AuditOutcome) int {
+		n := 0
+		for _, e := range store.audit {
+			if e.Action == AuditLogin && e.ActorUsername == "customer" && e.Outcome == outcome {
+				n++
+			}
+		}
+		return n
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := store.Authenticate(ctx, "customer", "customer_pw"); err != nil {
+			t.Fatalf("authenticate: %v", err)
+		}
+		if _, err := store.Authenticate(ctx, "customer", "wrong"); err == nil {
+			t.Fatal("expected a wrong password to fail")
+		}
+	}
+	if n := count(AuditSuccess); n != 1 {
+		t.Fatalf("expected one recorded success, got %d", n)
+	}
+	if n := count(AuditFailure); n != 3 {
+		t.Fatalf("expected every failure to be recorded, got %d", n)
+	}
+}
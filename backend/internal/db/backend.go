@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/events"
+)
+
+// Authenticator verifies Basic Auth credentials and records the attempt.
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*auth.Principal, error)
+}
+
+type PetStore interface {
+	CreatePet(ctx context.Context, storeID int64, input Pet) (Pet, error)
+	UpdatePet(ctx context.Context, storeID int64, petID string, expectedVersion int, patch PetPatch) (Pet, error)
+	ArchivePet(ctx context.Context, storeID int64, petID string, expectedVersion int) (Pet, error)
+	RestorePet(ctx context.Context, storeID int64, petID string, expectedVersion int) (Pet, error)
+	// GetPets returns the store's pets with the given IDs, skipping any
+	// that do not exist.
+	GetPets(ctx context.Context, storeID int64, petIDs []string) ([]Pet, error)
+	ListMerchantPets(ctx context.Context, storeID int64, archived ArchiveFilter) ([]Pet, error)
+	ListAvailablePets(ctx context.Context, storeID int64, customerID int64) ([]Pet, error)
+	ListPurchasedPets(ctx context.Context, storeID int64, customerID int64) ([]Pet, error)
+	PageMerchantPets(ctx context.Context, storeID int64, archived ArchiveFilter, page PageRequest) (PetPage, error)
+	PagePurchasedPets(ctx context.Context, storeID int64, customerID int64, page PageRequest) (PetPage, error)
+	SearchAvailablePets(ctx context.Context, storeID int64, customerID int64, search PetSearch) (PetPage, error)
+	AvailableSpeciesFacets(ctx context.Context, storeID int64, customerID int64, filter PetFilter) ([]SpeciesFacet, error)
+	ImportPets(ctx context.Context, storeID int64, rows []ImportRow, dryRun bool) (ImportReport, error)
+}
+
+// SpeciesCatalog is the list of species each store's merchants keep.
+type SpeciesCatalog interface {
+	ListSpecies(ctx context.Context, storeID int64) ([]Species, error)
+	CreateSpecies(ctx context.Context, storeID int64, input SpeciesInput) (Species, error)
+	UpdateSpecies(ctx context.Context, storeID int64, slug string, patch SpeciesPatch) (Species, error)
+	DeleteSpecies(ctx context.Context, storeID int64, slug string) error
+}
+
+type OrderStore interface {
+	PurchasePets(ctx context.Context, storeID int64, customerID int64, petIDs []string) (PurchaseResult, error)
+	PurchasePetsWithKey(ctx context.Context, storeID int64, customerID int64, key string, petIDs []string) (PurchaseResult, error)
+	GetOrder(ctx context.Context, storeID int64, orderID string) (Order, error)
+	PageCustomerOrders(ctx context.Context, storeID int64, customerID int64, page PageRequest) (OrderPage, error)
+	PageStoreOrders(ctx context.Context, storeID int64, page PageRequest) (OrderPage, error)
+	CancelPurchase(ctx context.Context, storeID int64, customerID int64, orderID string, petIDs []string, reason string) (Order, error)
+	RefundPurchase(ctx context.Context, storeID int64, merchantID int64, orderID string, petIDs []string, reason string) (Order, error)
+}
+
+type CartStore interface {
+	AddToCart(ctx context.Context, storeID int64, customerID int64, petID string) (CartItem, error)
+	RemoveFromCart(ctx context.Context, storeID int64, customerID int64, petID string) error
+	GetCart(ctx context.Context, storeID int64, customerID int64) (Cart, error)
+	CheckoutCart(ctx context.Context, storeID int64, customerID int64, idempotencyKey string) (PurchaseResult, error)
+}
+
+// Accounts lets customers sign up and anyone signed in manage their own
+// account.
+type Accounts interface {
+	RegisterCustomer(ctx context.Context, storeSlug string, reg Registration) (CustomerProfile, error)
+	CustomerProfile(ctx context.Context, customerID int64) (CustomerProfile, error)
+	UpdateCustomerProfile(ctx context.Context, customerID int64, patch ProfilePatch) (CustomerProfile, error)
+	ChangePassword(ctx context.Context, role auth.Role, userID int64, currentPassword, newPassword string) error
+	CreatePasswordReset(ctx context.Context, username string) (PasswordReset, bool, error)
+	ResetPassword(ctx context.Context, token, newPassword string) error
+}
+
+// Wishlists keeps customers' favorites and saved searches and the
+// notifications raised for them.
+type Wishlists interface {
+	FavoritePet(ctx context.Context, storeID int64, customerID int64, petID string) (Pet, error)
+	UnfavoritePet(ctx context.Context, storeID int64, customerID int64, petID string) error
+	ListFavorites(ctx context.Context, storeID int64, customerID int64) ([]Pet, error)
+	CreateSavedSearch(ctx context.Context, storeID int64, customerID int64, name string, filter PetFilter) (SavedSearch, error)
+	ListSavedSearches(ctx context.Context, storeID int64, customerID int64) ([]SavedSearch, error)
+	DeleteSavedSearch(ctx context.Context, storeID int64, customerID int64, id string) error
+	PageNotifications(ctx context.Context, storeID int64, customerID int64, unreadOnly bool, page PageRequest) (NotificationPage, error)
+	CountUnreadNotifications(ctx context.Context, storeID int64, customerID int64) (int, error)
+	MarkNotificationsRead(ctx context.Context, storeID int64, customerID int64, ids []string) (int, error)
+}
+
+type AuditLog interface {
+	PageAuditEvents(ctx context.Context, storeID int64, filter AuditFilter, page PageRequest) (AuditEventPage, error)
+}
+
+// Maintenance is the housekeeping cmd/api runs on a timer.
+type Maintenance interface {
+	ReleaseExpiredHolds(ctx context.Context) (int64, error)
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+	DeleteExpiredPasswordResets(ctx context.Context) (int64, error)
+	DeleteSentOutboxMessages(ctx context.Context) (int64, error)
+	DeleteOldWebhookDeliveries(ctx context.Context) (int64, error)
+}
+
+// Outbox is the mail queued by CreatePet and purchases, for the dispatcher
+// to deliver.
+type Outbox interface {
+	ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error)
+	CompleteOutboxMessage(ctx context.Context, id string, attempts int) error
+	FailOutboxMessage(ctx context.Context, id string, attempts int, cause string, retryAt *time.Time) error
+}
+
+// Webhooks lets merchants register endpoints for pet events and inspect
+// what was sent to them.
+type Webhooks interface {
+	CreateWebhookEndpoint(ctx context.Context, storeID int64, input WebhookEndpointInput) (WebhookEndpoint, string, error)
+	UpdateWebhookEndpoint(ctx context.Context, storeID int64, id string, patch WebhookEndpointPatch) (WebhookEndpoint, error)
+	DeleteWebhookEndpoint(ctx context.Context, storeID int64, id string) error
+	ListWebhookEndpoints(ctx context.Context, storeID int64) ([]WebhookEndpoint, error)
+	PageWebhookDeliveries(ctx context.Context, storeID int64, filter WebhookDeliveryFilter, page PageRequest) (WebhookDeliveryPage, error)
+	RedeliverWebhook(ctx context.Context, storeID int64, deliveryID string) (WebhookDelivery, error)
+}
+
+// WebhookQueue is the deliveries queued by pet changes, for the webhook
+// dispatcher to send.
+type WebhookQueue interface {
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookJob, error)
+	CompleteWebhookDelivery(ctx context.Context, id string, attempts int, responseStatus int) error
+	FailWebhookDelivery(ctx context.Context, id string, attempts int, responseStatus *int, cause string, retryAt *time.Time) error
+}
+
+type StoreLookup interface {
+	StoreIDBySlug(ctx context.Context, slug string) (int64, error)
+	MerchantStores(ctx context.Context, merchantID int64) ([]StoreInfo, error)
+	GetStore(ctx context.Context, slug string) (StoreInfo, error)
+}
+
+// StoreAdmin is what platform admins use to run stores.
+type StoreAdmin interface {
+	CreateStore(ctx context.Context, input StoreInput) (StoreInfo, error)
+	UpdateStore(ctx context.Context, slug string, patch StorePatch) (StoreInfo, error)
+	SetStoreActive(ctx context.Context, slug string, active bool) (StoreInfo, error)
+}
+
+// Exporter streams rows to fn instead of returning them, so large stores
+// can be exported without holding everything in memory.
+type Exporter interface {
+	ExportPets(ctx context.Context, storeID int64, filter PetExportFilter, fn func(Pet) error) error
+	ExportSales(ctx context.Context, storeID int64, filter SaleExportFilter, fn func(Sale) error) error
+}
+
+type Seeder interface {
+	EnsureDemoData(ctx context.Context, storeSlug, storeName, storeCurrency, merchantUser, merchantPass, customerUser, customerPass string) error
+	EnsureAdmin(ctx context.Context, username, password string) error
+}
+
+// Backend is everything the API needs from storage. Store keeps it in
+// Postgres; MemoryStore keeps it in process for tests and demos.
+type Backend interface {
+	Authenticator
+	Accounts
+	PetStore
+	SpeciesCatalog
+	OrderStore
+	CartStore
+	Wishlists
+	AuditLog
+	Outbox
+	Webhooks
+	WebhookQueue
+	Maintenance
+	StoreLookup
+	StoreAdmin
+	Exporter
+	Seeder
+	SetCartHoldTTL(ttl time.Duration)
+	SetCancelWindow(window time.Duration)
+	SetIdempotencyKeyTTL(ttl time.Duration)
+	SetPasswordResetTTL(ttl time.Duration)
+	SetEventPublisher(publisher events.Publisher)
+	Close()
+}
+
+var (
+	_ Backend = (*Store)(nil)
+	_ Backend = (*MemoryStore)(nil)
+)
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	DefaultCartHoldTTL = 15 * time.Minute
+	// MaxCartHolds is how many pets one customer may hold at once, so that
+	// no account can hide a store's catalog from everyone else.
+	MaxCartHolds = 10
+)
+
+var (
+	ErrPetHeld        = errors.New("pet is reserved by another customer")
+	ErrPetUnavailable = errors.New("pet is no longer available")
+	ErrCartFull       = fmt.Errorf("at most %d pets can be held in a cart", MaxCartHolds)
+)
+
+type CartItem struct {
+	Pet       Pet
+	ExpiresAt time.Time
+}
+
+type Cart struct {
+	Currency string
+	Items    []CartItem
+}
+
+func (c Cart) PetIDs() []string {
+	ids := make([]string, 0, len(c.Items))
+	for _, item := range c.Items {
+		ids = append(ids, item.Pet.ID)
+	}
+	return ids
+}
+
+// Subtotal sums current listing prices; the price is only locked at checkout.
+func (c Cart) Subtotal() Money {
+	total := Money{Currency: c.Currency}
+	for _, item := range c.Items {
+		total = total.Add(item.Pet.Price)
+	}
+	return total
+}
+
+// SetCartHoldTTL changes how long AddToCart reserves a pet.
+func (s *Store) SetCartHoldTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.holdTTL = ttl
+	}
+}
+
+// AddToCart reserves an available pet for the customer, or extends the
+// customer's existing hold. Holds owned by others block until they expire,
+// and a customer holding MaxCartHolds pets cannot hold another.
+func (s *Store) AddToCart(ctx context.Context, storeID int64, customerID int64, petID string) (item CartItem, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return CartItem{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	// Locking the customer makes their concurrent adds take turns, so the
+	// count stays true until the hold is committed.
+	var held int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(h.pet_id)
+		FROM customers c
+		LEFT JOIN cart_holds h ON h.customer_id = c.id AND h.pet_id <> $2 AND h.expires_at > NOW()
+		WHERE c.id = $1
+		GROUP BY c.id
+		FOR UPDATE OF c
+	`, customerID, petID).Scan(&held)
+	if err != nil {
+		return CartItem{}, fmt.Errorf("count holds: %w", err)
+	}
+	if held >= MaxCartHolds {
+		err = ErrCartFull
+		return CartItem{}, err
+	}
+
+	var expiresAt time.Time
+	err = tx.QueryRow(ctx, `
+		INSERT INTO cart_holds (pet_id, store_id, customer_id, expires_at)
+		SELECT p.id, p.store_id, $3, NOW() + $4 * INTERVAL '1 second'
+		FROM pets p
+		WHERE p.store_id = $1 AND p.id = $2 AND p.purchased_at IS NULL AND p.archived_at IS NULL
+		ON CONFLICT (pet_id) DO UPDATE
+		SET customer_id = EXCLUDED.customer_id,
+		    expires_at = EXCLUDED.expires_at,
+		    created_at = CASE WHEN cart_holds.customer_id = EXCLUDED.customer_id
+		                      THEN cart_holds.created_at ELSE NOW() END
+		WHERE cart_holds.customer_id = EXCLUDED.customer_id OR cart_holds.expires_at <= NOW()
+		RETURNING expires_at
+	`, storeID, petID, customerID, s.holdTTL.Seconds()).Scan(&expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = s.holdFailure(ctx, tx, storeID, petID)
+		return CartItem{}, err
+	}
+	if err != nil {
+		return CartItem{}, fmt.Errorf("hold pet: %w", err)
+	}
+
+	pet, err := s.scanPet(tx.QueryRow(ctx, `
+		SELECT `+petColumns+`
+		FROM pets
+		WHERE id = $1
+	`, petID))
+	if err != nil {
+		return CartItem{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return CartItem{}, fmt.Errorf("commit: %w", err)
+	}
+	return CartItem{Pet: pet, ExpiresAt: expiresAt}, nil
+}
+
+// holdFailure explains why AddToCart's conditional insert matched nothing.
+func (s *Store) holdFailure(ctx context.Context, tx pgx.Tx, storeID int64, petID string) error {
+	var purchasedAt, archivedAt *time.Time
+	err := tx.QueryRow(ctx, `
+		SELECT purchased_at, archived_at FROM pets WHERE store_id = $1 AND id = $2
+	`, storeID, petID).Scan(&purchasedAt, &archivedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrPetNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("query pet: %w", err)
+	}
+	if purchasedAt != nil || archivedAt != nil {
+		return ErrPetUnavailable
+	}
+	return ErrPetHeld
+}
+
+func (s *Store) RemoveFromCart(ctx context.Context, storeID int64, customerID int64, petID string) error {
+	_, err := s.pool.Exec(ctx, `
+		DELETE FROM cart_holds
+		WHERE store_id = $1 AND customer_id = $2 AND pet_id = $3
+	`, storeID, customerID, petID)
+	if err != nil {
+		return fmt.Errorf("delete hold: %w", err)
+	}
+	return nil
+}
+
+// GetCart returns the customer's unexpired holds, oldest first.
+func (s *Store) GetCart(ctx context.Context, storeID int64, customerID int64) (Cart, error) {
+	var cart Cart
+	if err := s.pool.QueryRow(ctx, `SELECT currency FROM stores WHERE id = $1`, storeID).Scan(&cart.Currency); err != nil {
+		return Cart{}, fmt.Errorf("query store currency: %w", err)
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+petColumns+`, h.expires_at
+		FROM cart_holds h
+		JOIN pets ON pets.id = h.pet_id
+		WHERE h.store_id = $1 AND h.customer_id = $2 AND h.expires_at > NOW()
+		  AND pets.purchased_at IS NULL AND pets.archived_at IS NULL
+		ORDER BY h.created_at, pets.id
+	`, storeID, customerID)
+	if err != nil {
+		return Cart{}, fmt.Errorf("query cart: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item CartItem
+		item.Pet, err = s.scanPet(rows, &item.ExpiresAt)
+		if err != nil {
+			return Cart{}, err
+		}
+		cart.Items = append(cart.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return Cart{}, fmt.Errorf("iterate cart: %w", err)
+	}
+	return cart, nil
+}
+
+// CheckoutCart purchases every pet currently held in the customer's cart.
+// With an idempotency key, a retry after a successful checkout returns the
+// original result even though the cart is now empty.
+func (s *Store) CheckoutCart(ctx context.Context, storeID int64, customerID int64, idempotencyKey string) (PurchaseResult, error) {
+	key, err := newPurchaseKey(idempotencyKey, cartFingerprint)
+	if err != nil {
+		return PurchaseResult{}, err
+	}
+	if key.value != "" {
+		result, found, err := s.replayPurchase(ctx, storeID, customerID, key)
+		if found || err != nil {
+			return result, err
+		}
+	}
+	cart, err := s.GetCart(ctx, storeID, customerID)
+	if err != nil {
+		return PurchaseResult{}, err
+	}
+	if len(cart.Items) == 0 {
+		return PurchaseResult{}, errors.New("no pets in cart")
+	}
+	return s.purchase(ctx, storeID, customerID, cart.PetIDs(), key)
+}
+
+// ReleaseExpiredHolds deletes lapsed holds. Reads already ignore them, so
+// this only keeps the table small.
+func (s *Store) ReleaseExpiredHolds(ctx context.Context) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM cart_holds WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("release holds: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
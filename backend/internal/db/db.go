@@ -6,11 +6,19 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"nimble-challenge/backend/internal/events"
 )
 
 type Store struct {
-	pool   *pgxpool.Pool
-	crypto Crypto
+	pool           *pgxpool.Pool
+	crypto         Crypto
+	holdTTL        time.Duration
+	cancelWindow   time.Duration
+	idempotencyTTL time.Duration
+	resetTTL       time.Duration
+	events         events.Publisher
+	logins         loginRecorder
 }
 
 type Crypto interface {
@@ -32,7 +40,14 @@ func NewStore(ctx context.Context, dsn string, crypto Crypto) (*Store, error) {
 	if err := pool.Ping(ctx); err != nil {
 		return nil, fmt.Errorf("ping: %w", err)
 	}
-	return &Store{pool: pool, crypto: crypto}, nil
+	return &Store{
+		pool:           pool,
+		crypto:         crypto,
+		holdTTL:        DefaultCartHoldTTL,
+		cancelWindow:   DefaultCancelWindow,
+		idempotencyTTL: DefaultIdempotencyKeyTTL,
+		resetTTL:       DefaultPasswordResetTTL,
+	}, nil
 }
 
 func (s *Store) Close() {
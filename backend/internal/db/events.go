@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"nimble-challenge/backend/internal/events"
+)
+
+// SetEventPublisher makes the store publish pet changes after they commit.
+// Without a publisher nothing is published.
+func (s *Store) SetEventPublisher(publisher events.Publisher) {
+	s.events = publisher
+}
+
+// publish tells subscribers about a committed change. A failure is only
+// logged, since the change itself has been made.
+func (s *Store) publish(ctx context.Context, kind events.Kind, storeID int64, orderID string, petIDs []string) {
+	publishEvent(ctx, s.events, kind, storeID, orderID, petIDs)
+}
+
+func (m *MemoryStore) SetEventPublisher(publisher events.Publisher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = publisher
+}
+
+// publish is Store.publish; callers hold m.mu, which is fine since
+// publishers must not block.
+func (m *MemoryStore) publish(ctx context.Context, kind events.Kind, storeID int64, orderID string, petIDs []string) {
+	publishEvent(ctx, m.events, kind, storeID, orderID, petIDs)
+}
+
+func publishEvent(ctx context.Context, publisher events.Publisher, kind events.Kind, storeID int64, orderID string, petIDs []string) {
+	if publisher == nil || len(petIDs) == 0 {
+		return
+	}
+	err := publisher.Publish(ctx, events.Event{
+		Kind:       kind,
+		StoreID:    storeID,
+		PetIDs:     petIDs,
+		OrderID:    orderID,
+		OccurredAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("publish %s event: %v", kind, err)
+	}
+}
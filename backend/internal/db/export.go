@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type PetStatus string
+
+const (
+	PetAvailable PetStatus = "AVAILABLE"
+	PetSold      PetStatus = "SOLD"
+	PetArchived  PetStatus = "ARCHIVED"
+)
+
+func (p Pet) Status() PetStatus {
+	switch {
+	case p.PurchasedAt != nil:
+		return PetSold
+	case p.ArchivedAt != nil:
+		return PetArchived
+	default:
+		return PetAvailable
+	}
+}
+
+// PetExportFilter bounds pets by created_at (Since inclusive, Until
+// exclusive) and status. Breeder emails are left empty unless asked for.
+type PetExportFilter struct {
+	Since                *time.Time
+	Until                *time.Time
+	Statuses             []PetStatus
+	IncludeBreederEmails bool
+}
+
+// SaleExportFilter bounds order items by the order's placed_at and the
+// item's status.
+type SaleExportFilter struct {
+	Since    *time.Time
+	Until    *time.Time
+	Statuses []OrderItemStatus
+}
+
+// Sale is one pet of an order, flattened for export.
+type Sale struct {
+	OrderID          string
+	PlacedAt         time.Time
+	CustomerUsername string
+	OrderStatus      OrderStatus
+	PetID            string
+	PetName          string
+	Species          Species
+	UnitPrice        Money
+	Status           OrderItemStatus
+	ReversedAt       *time.Time
+	ReversalReason   string
+}
+
+func (f PetExportFilter) Validate() error {
+	for _, status := range f.Statuses {
+		switch status {
+		case PetAvailable, PetSold, PetArchived:
+		default:
+			return fmt.Errorf("unknown pet status %q", status)
+		}
+	}
+	return nil
+}
+
+func (f PetExportFilter) matches(pet Pet) bool {
+	if f.Since != nil && pet.CreatedAt.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && !pet.CreatedAt.Before(*f.Until) {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, status := range f.Statuses {
+		if pet.Status() == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (f SaleExportFilter) Validate() error {
+	for _, status := range f.Statuses {
+		switch status {
+		case OrderItemPurchased, OrderItemCancelled, OrderItemRefunded:
+		default:
+			return fmt.Errorf("unknown sale status %q", status)
+		}
+	}
+	return nil
+}
+
+func (f SaleExportFilter) matches(sale Sale) bool {
+	if f.Since != nil && sale.PlacedAt.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && !sale.PlacedAt.Before(*f.Until) {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, status := range f.Statuses {
+		if sale.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+func exportAuditEvent(ctx context.Context, storeID int64, detail string) AuditEvent {
+	event := storeAuditEvent(ctx, AuditExport, storeID)
+	event.Detail = detail
+	return event
+}
+
+func (f PetExportFilter) auditDetail() string {
+	if f.IncludeBreederEmails {
+		return "pets with breeder emails"
+	}
+	return "pets"
+}
+
+// ExportPets streams the store's pets, oldest first, to fn without loading
+// them all. The export is audited before the first row is read.
+func (s *Store) ExportPets(ctx context.Context, storeID int64, filter PetExportFilter, fn func(Pet) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	if err := insertAuditEvent(ctx, s.pool, exportAuditEvent(ctx, storeID, filter.auditDetail())); err != nil {
+		return err
+	}
+
+	var q listQuery
+	q.where("store_id = %s", storeID)
+	if filter.Since != nil {
+		q.where("created_at >= %s", *filter.Since)
+	}
+	if filter.Until != nil {
+		q.where("created_at < %s", *filter.Until)
+	}
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = string(status)
+		}
+		q.where(`(CASE
+			WHEN purchased_at IS NOT NULL THEN 'SOLD'
+			WHEN archived_at IS NOT NULL THEN 'ARCHIVED'
+			ELSE 'AVAILABLE'
+		END) = ANY(%s)`, statuses)
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+petColumns+`
+		FROM pets
+		WHERE `+q.clause()+`
+		ORDER BY created_at, id
+	`, q.args...)
+	if err != nil {
+		return fmt.Errorf("query pets: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		pet, err := s.scanPet(rows)
+		if err != nil {
+			return err
+		}
+		if !filter.IncludeBreederEmails {
+			pet.BreederEmail = ""
+		}
+		if err := fn(pet); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate pets: %w", err)
+	}
+	return nil
+}
+
+// ExportSales streams every order item of the store, oldest order first.
+func (s *Store) ExportSales(ctx context.Context, storeID int64, filter SaleExportFilter, fn func(Sale) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	if err := insertAuditEvent(ctx, s.pool, exportAuditEvent(ctx, storeID, "sales")); err != nil {
+		return err
+	}
+
+	var q listQuery
+	q.where("o.store_id = %s", storeID)
+	if filter.Since != nil {
+		q.where("o.placed_at >= %s", *filter.Since)
+	}
+	if filter.Until != nil {
+		q.where("o.placed_at < %s", *filter.Until)
+	}
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = string(status)
+		}
+		q.where("oi.status = ANY(%s)", statuses)
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT o.id::text, o.placed_at, c.username, o.status,
+		       p.id::text, p.name, `+speciesColumns+`,
+		       oi.unit_price_minor, oi.currency, oi.status, oi.reversed_at, COALESCE(oi.reversal_reason, '')
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		JOIN customers c ON c.id = o.customer_id
+		JOIN pets p ON p.id = oi.pet_id
+		JOIN species sp ON sp.store_id = p.store_id AND sp.slug = p.species
+		LEFT JOIN species parent ON parent.id = sp.parent_id
+		WHERE `+q.clause()+`
+		ORDER BY o.placed_at, o.id, p.name, p.id
+	`, q.args...)
+	if err != nil {
+		return fmt.Errorf("query sales: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sale Sale
+		var parentSlug, parentName *string
+		err := rows.Scan(&sale.OrderID, &sale.PlacedAt, &sale.CustomerUsername, &sale.OrderStatus,
+			&sale.PetID, &sale.PetName, &sale.Species.Slug, &sale.Species.Name, &parentSlug, &parentName,
+			&sale.UnitPrice.Amount, &sale.UnitPrice.Currency, &sale.Status, &sale.ReversedAt, &sale.ReversalReason)
+		if err != nil {
+			return fmt.Errorf("scan sale: %w", err)
+		}
+		sale.Species.setParent(parentSlug, parentName)
+		if err := fn(sale); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sales: %w", err)
+	}
+	return nil
+}
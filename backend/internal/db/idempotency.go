@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	DefaultIdempotencyKeyTTL = 24 * time.Hour
+	maxIdempotencyKeyLength  = 200
+	cartFingerprint          = "cart"
+)
+
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different purchase")
+
+// purchaseKey identifies a purchase attempt so a retry can be answered with
+// the original result. An empty value disables the check.
+type purchaseKey struct {
+	value       string
+	fingerprint string
+}
+
+// SetIdempotencyKeyTTL changes how long purchase idempotency keys are kept.
+func (s *Store) SetIdempotencyKeyTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.idempotencyTTL = ttl
+	}
+}
+
+func newPurchaseKey(key string, fingerprint string) (purchaseKey, error) {
+	key = strings.TrimSpace(key)
+	if len(key) > maxIdempotencyKeyLength {
+		return purchaseKey{}, errors.New("idempotency key is too long")
+	}
+	return purchaseKey{value: key, fingerprint: fingerprint}, nil
+}
+
+// petsFingerprint ignores order and duplicates so a retry that sends the
+// same pets in a different order still matches.
+func petsFingerprint(petIDs []string) string {
+	ids := make([]string, 0, len(petIDs))
+	seen := make(map[string]bool, len(petIDs))
+	for _, id := range petIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// claim records the key inside the purchase transaction. It returns false if
+// the key is already taken; a concurrent attempt with the same key blocks
+// on the insert until the first one commits or rolls back.
+func (k purchaseKey) claim(ctx context.Context, tx pgx.Tx, customerID int64, ttl time.Duration) (bool, error) {
+	_, err := tx.Exec(ctx, `
+		DELETE FROM purchase_idempotency_keys
+		WHERE customer_id = $1 AND key = $2 AND expires_at <= NOW()
+	`, customerID, k.value)
+	if err != nil {
+		return false, fmt.Errorf("expire idempotency key: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO purchase_idempotency_keys (customer_id, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (customer_id, key) DO NOTHING
+	`, customerID, k.value, k.fingerprint, ttl.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("claim idempotency key: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (k purchaseKey) record(ctx context.Context, tx pgx.Tx, customerID int64, result PurchaseResult) error {
+	errs, err := json.Marshal(result.Errors)
+	if err != nil {
+		return fmt.Errorf("encode purchase errors: %w", err)
+	}
+	var orderID *string
+	if result.Order != nil {
+		orderID = &result.Order.ID
+	}
+	purchased := result.PurchasedIDs
+	if purchased == nil {
+		purchased = []string{}
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE purchase_idempotency_keys
+		SET order_id = $3, purchased_ids = $4::uuid[], errors = $5
+		WHERE customer_id = $1 AND key = $2
+	`, customerID, k.value, orderID, purchased, errs)
+	if err != nil {
+		return fmt.Errorf("record idempotency key: %w", err)
+	}
+	return nil
+}
+
+// replay rebuilds the result stored under the key. found is false when the
+// key is unknown or expired.
+func (s *Store) replayPurchase(ctx context.Context, storeID int64, customerID int64, key purchaseKey) (result PurchaseResult, found bool, err error) {
+	var fingerprint string
+	var orderID *string
+	var errs []byte
+	err = s.pool.QueryRow(ctx, `
+		SELECT fingerprint, order_id::text, purchased_ids::text[], errors
+		FROM purchase_idempotency_keys
+		WHERE customer_id = $1 AND key = $2 AND expires_at > NOW()
+	`, customerID, key.value).Scan(&fingerprint, &orderID, &result.PurchasedIDs, &errs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return PurchaseResult{}, false, nil
+	}
+	if err != nil {
+		return PurchaseResult{}, false, fmt.Errorf("query idempotency key: %w", err)
+	}
+	if fingerprint != key.fingerprint {
+		return PurchaseResult{}, true, ErrIdempotencyKeyReused
+	}
+	if err := json.Unmarshal(errs, &result.Errors); err != nil {
+		return PurchaseResult{}, true, fmt.Errorf("decode purchase errors: %w", err)
+	}
+	if orderID != nil {
+		order, err := s.GetOrder(ctx, storeID, *orderID)
+		if err != nil {
+			return PurchaseResult{}, true, err
+		}
+		result.Order = &order
+	}
+	return result, true, nil
+}
+
+// DeleteExpiredIdempotencyKeys removes keys past their TTL.
+func (s *Store) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM purchase_idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("delete idempotency keys: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
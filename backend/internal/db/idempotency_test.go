@@ -0,0 +1,30 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPetsFingerprintIgnoresOrderAndDuplicates(t *testing.T) {
+	a := petsFingerprint([]string{"b", "a", "b"})
+	b := petsFingerprint([]string{"a", "b"})
+	if a != b {
+		t.Fatalf("expected equal fingerprints, got %q and %q", a, b)
+	}
+	if petsFingerprint([]string{"a"}) == b {
+		t.Fatal("expected different pets to produce a different fingerprint")
+	}
+}
+
+func TestNewPurchaseKey(t *testing.T) {
+	key, err := newPurchaseKey("  retry-1 ", "x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key.value != "retry-1" {
+		t.Fatalf("expected trimmed key, got %q", key.value)
+	}
+	if _, err := newPurchaseKey(strings.Repeat("k", maxIdempotencyKeyLength+1), "x"); err == nil {
+		t.Fatal("expected an overlong key to be rejected")
+	}
+}
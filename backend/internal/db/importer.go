@@ -0,0 +1,326 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"nimble-challenge/backend/internal/events"
+)
+
+type ImportFormat string
+
+const (
+	ImportCSV    ImportFormat = "CSV"
+	ImportNDJSON ImportFormat = "NDJSON"
+)
+
+const MaxImportRows = 5000
+
+// ImportFormatFromFilename picks the format from the file extension.
+func ImportFormatFromFilename(name string) (ImportFormat, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return ImportCSV, nil
+	case ".ndjson", ".jsonl":
+		return ImportNDJSON, nil
+	default:
+		return "", fmt.Errorf("cannot tell the format of %q; pass it explicitly", name)
+	}
+}
+
+// importColumns are the CSV headers and NDJSON keys, named after the
+// fields of CreatePetInput.
+var importColumns = []string{
+	"name", "species", "ageYears", "pictureUrl", "description",
+	"breederName", "breederEmail", "priceMinorUnits",
+}
+
+// ImportRow is one record of an import file. Err is set when the record
+// could not be turned into a pet at all.
+type ImportRow struct {
+	Line int
+	Pet  Pet
+	Err  error
+}
+
+type ImportRowResult struct {
+	Line  int
+	Name  string
+	PetID string
+	Error string
+}
+
+type ImportReport struct {
+	DryRun   bool
+	Created  int
+	Rejected int
+	Rows     []ImportRowResult
+}
+
+// ParsePetImport reads a CSV file with a header row or newline-delimited
+// JSON objects. Problems with a single record are reported on its row;
+// only an unreadable file is an error.
+func ParsePetImport(r io.Reader, format ImportFormat) ([]ImportRow, error) {
+	var rows []ImportRow
+	var err error
+	switch format {
+	case ImportCSV:
+		rows, err = parseImportCSV(r)
+	case ImportNDJSON:
+		rows, err = parseImportNDJSON(r)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("import file has no rows")
+	}
+	if len(rows) > MaxImportRows {
+		return nil, fmt.Errorf("import file has more than %d rows", MaxImportRows)
+	}
+	return rows, nil
+}
+
+func parseImportCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("import file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		column, ok := importColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", strings.TrimSpace(name))
+		}
+		index[column] = i
+	}
+	for _, column := range importColumns {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("missing column %q", column)
+		}
+	}
+
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(rows) >= MaxImportRows {
+			return nil, fmt.Errorf("import file has more than %d rows", MaxImportRows)
+		}
+		if len(record) != len(header) {
+			rows = append(rows, ImportRow{Line: line, Err: fmt.Errorf("expected %d fields, got %d", len(header), len(record))})
+			continue
+		}
+		field := func(column string) string {
+			if i, ok := index[column]; ok {
+				return record[i]
+			}
+			return ""
+		}
+		row := ImportRow{Line: line}
+		row.Pet, row.Err = importPet(field("name"), field("species"), field("pictureUrl"), field("description"),
+			field("breederName"), field("breederEmail"), field("ageYears"), field("priceMinorUnits"))
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+type importRecord struct {
+	Name            string `json:"name"`
+	Species         string `json:"species"`
+	AgeYears        *int   `json:"ageYears"`
+	PictureURL      string `json:"pictureUrl"`
+	Description     string `json:"description"`
+	BreederName     string `json:"breederName"`
+	BreederEmail    string `json:"breederEmail"`
+	PriceMinorUnits *int64 `json:"priceMinorUnits"`
+}
+
+func parseImportNDJSON(r io.Reader) ([]ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	var rows []ImportRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if len(rows) >= MaxImportRows {
+			return nil, fmt.Errorf("import file has more than %d rows", MaxImportRows)
+		}
+		var rec importRecord
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			rows = append(rows, ImportRow{Line: line, Err: fmt.Errorf("invalid json: %w", err)})
+			continue
+		}
+		var age, price string
+		if rec.AgeYears != nil {
+			age = strconv.Itoa(*rec.AgeYears)
+		}
+		if rec.PriceMinorUnits != nil {
+			price = strconv.FormatInt(*rec.PriceMinorUnits, 10)
+		}
+		row := ImportRow{Line: line}
+		row.Pet, row.Err = importPet(rec.Name, rec.Species, rec.PictureURL, rec.Description,
+			rec.BreederName, rec.BreederEmail, age, price)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read ndjson: %w", err)
+	}
+	return rows, nil
+}
+
+func importColumn(header string) (string, bool) {
+	header = strings.TrimSpace(header)
+	for _, column := range importColumns {
+		if strings.EqualFold(header, column) {
+			return column, true
+		}
+	}
+	return "", false
+}
+
+func importPet(name, species, pictureURL, description, breederName, breederEmail, age, price string) (Pet, error) {
+	pet := Pet{
+		Name:         strings.TrimSpace(name),
+		Species:      Species{Slug: strings.ToLower(strings.TrimSpace(species))},
+		PictureURL:   strings.TrimSpace(pictureURL),
+		Description:  strings.TrimSpace(description),
+		BreederName:  strings.TrimSpace(breederName),
+		BreederEmail: strings.TrimSpace(breederEmail),
+	}
+	// Both are required like in CreatePetInput; a missing price must not
+	// list the pet for free.
+	age = strings.TrimSpace(age)
+	if age == "" {
+		return Pet{}, errors.New("ageYears is required")
+	}
+	n, err := strconv.Atoi(age)
+	if err != nil {
+		return Pet{}, fmt.Errorf("invalid ageYears %q", age)
+	}
+	pet.AgeYears = n
+	price = strings.TrimSpace(price)
+	if price == "" {
+		return Pet{}, errors.New("priceMinorUnits is required")
+	}
+	pet.Price.Amount, err = strconv.ParseInt(price, 10, 64)
+	if err != nil {
+		return Pet{}, fmt.Errorf("invalid priceMinorUnits %q", price)
+	}
+	return pet, nil
+}
+
+// checkImport validates every row like CreatePet does against the store's
+// species and returns the report skeleton together with the indexes of the
+// rows to insert.
+func checkImport(rows []ImportRow, dryRun bool, species map[string]bool) (ImportReport, []int) {
+	report := ImportReport{DryRun: dryRun, Rows: make([]ImportRowResult, len(rows))}
+	var valid []int
+	for i, row := range rows {
+		report.Rows[i] = ImportRowResult{Line: row.Line, Name: row.Pet.Name}
+		err := row.Err
+		if err == nil {
+			err = validatePet(row.Pet)
+		}
+		if err == nil && !species[row.Pet.Species.Slug] {
+			err = unknownSpecies(row.Pet.Species.Slug)
+		}
+		if err != nil {
+			report.Rows[i].Error = err.Error()
+			report.Rejected++
+			continue
+		}
+		valid = append(valid, i)
+	}
+	return report, valid
+}
+
+// ImportPets creates every valid row in a single transaction and reports
+// the outcome per row. With dryRun nothing is written.
+func (s *Store) ImportPets(ctx context.Context, storeID int64, rows []ImportRow, dryRun bool) (ImportReport, error) {
+	report, err := s.importPets(ctx, storeID, rows, dryRun)
+	if err != nil {
+		s.recordAuditFailure(ctx, storeAuditEvent(ctx, AuditImportPets, storeID), err)
+	}
+	return report, err
+}
+
+func (s *Store) importPets(ctx context.Context, storeID int64, rows []ImportRow, dryRun bool) (ImportReport, error) {
+	species, err := s.storeSpeciesSlugs(ctx, storeID)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	report, valid := checkImport(rows, dryRun, species)
+	if dryRun || len(valid) == 0 {
+		return report, nil
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	created := make([]string, 0, len(valid))
+	for _, i := range valid {
+		var pet Pet
+		pet, err = s.insertPet(ctx, tx, storeID, rows[i].Pet)
+		if err != nil {
+			return ImportReport{}, fmt.Errorf("line %d: %w", rows[i].Line, err)
+		}
+		if err = notifySavedSearches(ctx, tx, storeID, pet.ID); err != nil {
+			return ImportReport{}, err
+		}
+		report.Rows[i].PetID = pet.ID
+		created = append(created, pet.ID)
+	}
+	if err = enqueueBreederMail(ctx, tx, OutboxPetListed, storeID, created); err != nil {
+		return ImportReport{}, err
+	}
+	if err = s.enqueueWebhooks(ctx, tx, WebhookPetCreated, storeID, created); err != nil {
+		return ImportReport{}, err
+	}
+	if err = insertAuditEvent(ctx, tx, storeAuditEvent(ctx, AuditImportPets, storeID, created...)); err != nil {
+		return ImportReport{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return ImportReport{}, fmt.Errorf("commit: %w", err)
+	}
+	s.publish(ctx, events.PetCreated, storeID, "", created)
+	report.Created = len(created)
+	return report, nil
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePetImportCSV(t *testing.T) {
+	input := "Name,species,ageYears,pictureUrl,description,breederName,breederEmail,priceMinorUnits\n" +
+		"Pip,frog,1,https://example.com/pip.jpg,Small.,Ann,ann@example.com,1200\n" +
+		"Rex,dog,3,https://example.com/rex.jpg,Loud.,Bob,bob@example.com,lots\n" +
+		"Tom,cat,2,https://example.com/tom.jpg,,Cy,cy@example.com,300\n"
+	rows, err := ParsePetImport(strings.NewReader(input), ImportCSV)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(rows))
+	}
+	if rows[0].Err != nil || rows[0].Pet.Species.Slug != "frog" || rows[0].Pet.Price.Amount != 1200 || rows[0].Line != 2 {
+		t.Fatalf("unexpected first row %+v", rows[0])
+	}
+	if rows[1].Err == nil {
+		t.Fatal("expected an invalid price to be reported on its row")
+	}
+
+	species := map[string]bool{"cat": true, "dog": true, "frog": true}
+	report, valid := checkImport(rows, true, species)
+	if len(valid) != 1 || report.Rejected != 2 || report.Rows[2].Error == "" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	delete(species, "frog")
+	report, valid = checkImport(rows, true, species)
+	if len(valid) != 0 || report.Rows[0].Error != "species not found: frog" {
+		t.Fatalf("expected the store's species to be enforced, got %+v", report)
+	}
+}
+
+func TestParsePetImportRejectsUnknownColumns(t *testing.T) {
+	if _, err := ParsePetImport(strings.NewReader("name,colour\nPip,green\n"), ImportCSV); err == nil {
+		t.Fatal("expected an unknown column to fail the file")
+	}
+	header := "name,species,ageYears,pictureUrl,description,breederName,breederEmail\n"
+	if _, err := ParsePetImport(strings.NewReader(header), ImportCSV); err == nil {
+		t.Fatal("expected a missing price column to fail the file")
+	}
+}
+
+func TestParsePetImportNDJSON(t *testing.T) {
+	input := `{"name":"Pip","species":"FROG","ageYears":1,"pictureUrl":"https://example.com/pip.jpg","description":"Small.","breederName":"Ann","breederEmail":"ann@example.com","priceMinorUnits":1200}
+
+{"name":"Rex","colour":"brown"}
+{"name":"Tom","species":"cat","ageYears":2,"pictureUrl":"https://example.com/tom.jpg","description":"Calm.","breederName":"Cy","breederEmail":"cy@example.com"}
+`
+	rows, err := ParsePetImport(strings.NewReader(input), ImportNDJSON)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rows) != 3 || rows[0].Err != nil || rows[0].Pet.AgeYears != 1 {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+	if rows[1].Err == nil || rows[1].Line != 3 {
+		t.Fatalf("expected line 3 to be rejected, got %+v", rows[1])
+	}
+	if rows[2].Err == nil || rows[2].Err.Error() != "priceMinorUnits is required" {
+		t.Fatalf("expected a record without a price to be rejected, got %+v", rows[2])
+	}
+}
@@ -0,0 +1,923 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/crypto"
+	"nimble-challenge/backend/internal/events"
+)
+
+// MemoryStore is an in-process Backend with the same semantics as Store.
+// A single lock stands in for Postgres transactions, so every operation is
+// atomic together with its audit event. Breeder emails are kept encrypted
+// exactly as in the pets table.
+type MemoryStore struct {
+	mu             sync.RWMutex
+	crypto         Crypto
+	holdTTL        time.Duration
+	cancelWindow   time.Duration
+	idempotencyTTL time.Duration
+	resetTTL       time.Duration
+	events         events.Publisher
+	logins         loginRecorder
+
+	nextID            int64
+	stores            map[int64]*memStore
+	merchants         map[string]*memUser
+	customers         map[string]*memUser
+	admins            map[string]*memUser
+	pets              map[string]*memPet
+	holds             map[string]*memHold
+	orders            map[string]*memOrder
+	idempotency       map[memKey]*memIdempotency
+	resets            map[string]*memReset
+	favorites         []*memFavorite
+	searches          []*memSavedSearch
+	notices           []*memNotification
+	outbox            []*memOutbox
+	webhookEndpoints  []*memWebhookEndpoint
+	webhookDeliveries []*memWebhookDelivery
+	audit             []AuditEvent
+}
+
+type memStore struct {
+	id            int64
+	slug          string
+	name          string
+	currency      string
+	deactivatedAt *time.Time
+	species       map[string]*memSpecies
+}
+
+func (s *memStore) info() StoreInfo {
+	return StoreInfo{ID: s.id, Slug: s.slug, Name: s.name, Currency: s.currency, Active: s.deactivatedAt == nil}
+}
+
+type memUser struct {
+	id           int64
+	storeID      int64
+	username     string
+	passwordHash string
+	permissions  []auth.Permission
+	memberOf     []int64
+	emailEnc     []byte
+	emailNonce   []byte
+	phoneEnc     []byte
+	phoneNonce   []byte
+	createdAt    time.Time
+}
+
+type memPet struct {
+	pet         Pet
+	emailEnc    []byte
+	emailNonce  []byte
+	purchasedBy *int64
+}
+
+type memHold struct {
+	storeID    int64
+	customerID int64
+	createdAt  time.Time
+	expiresAt  time.Time
+}
+
+type memOrder struct {
+	order Order
+	items []*memOrderItem
+}
+
+type memOrderItem struct {
+	petID     string
+	unitPrice Money
+	status    OrderItemStatus
+	reversal  *Reversal
+}
+
+type memKey struct {
+	customerID int64
+	key        string
+}
+
+type memIdempotency struct {
+	fingerprint  string
+	orderID      string
+	purchasedIDs []string
+	errors       []PurchaseError
+	expiresAt    time.Time
+}
+
+func NewMemoryStore(crypto Crypto) *MemoryStore {
+	return &MemoryStore{
+		crypto:         crypto,
+		holdTTL:        DefaultCartHoldTTL,
+		cancelWindow:   DefaultCancelWindow,
+		idempotencyTTL: DefaultIdempotencyKeyTTL,
+		resetTTL:       DefaultPasswordResetTTL,
+		stores:         make(map[int64]*memStore),
+		merchants:      make(map[string]*memUser),
+		customers:      make(map[string]*memUser),
+		admins:         make(map[string]*memUser),
+		pets:           make(map[string]*memPet),
+		holds:          make(map[string]*memHold),
+		orders:         make(map[string]*memOrder),
+		idempotency:    make(map[memKey]*memIdempotency),
+		resets:         make(map[string]*memReset),
+	}
+}
+
+func (m *MemoryStore) Close() {}
+
+func (m *MemoryStore) SetCartHoldTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ttl > 0 {
+		m.holdTTL = ttl
+	}
+}
+
+func (m *MemoryStore) SetCancelWindow(window time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if window > 0 {
+		m.cancelWindow = window
+	}
+}
+
+func (m *MemoryStore) SetIdempotencyKeyTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ttl > 0 {
+		m.idempotencyTTL = ttl
+	}
+}
+
+func (m *MemoryStore) EnsureDemoData(ctx context.Context, storeSlug, storeName, storeCurrency, merchantUser, merchantPass, customerUser, customerPass string) error {
+	if err := ValidateCurrency(storeCurrency); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	store := m.storeBySlug(storeSlug)
+	if store == nil {
+		store = &memStore{id: m.newID(), slug: storeSlug, name: storeName, currency: storeCurrency}
+		m.stores[store.id] = store
+	}
+
+	for _, u := range []struct {
+		users              map[string]*memUser
+		username, password string
+	}{
+		{m.merchants, merchantUser, merchantPass},
+		{m.customers, customerUser, customerPass},
+	} {
+		if _, ok := u.users[u.username]; ok {
+			continue
+		}
+		hash, err := crypto.HashPassword(u.password)
+		if err != nil {
+			m.mu.Unlock()
+			return fmt.Errorf("hash password: %w", err)
+		}
+		u.users[u.username] = &memUser{id: m.newID(), storeID: store.id, username: u.username, passwordHash: hash, createdAt: time.Now()}
+	}
+	if merchant := m.merchants[merchantUser]; !slices.Contains(merchant.memberOf, store.id) {
+		merchant.memberOf = append(merchant.memberOf, store.id)
+	}
+
+	petCount := 0
+	for _, p := range m.pets {
+		if p.pet.StoreID == store.id {
+			petCount++
+		}
+	}
+	if petCount == 0 {
+		if store.species == nil {
+			store.species = make(map[string]*memSpecies)
+		}
+		for _, sp := range demoSpecies() {
+			if _, ok := store.species[sp.Slug]; !ok {
+				store.species[sp.Slug] = &memSpecies{slug: sp.Slug, name: sp.Name}
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	if petCount == 0 {
+		for _, pet := range demoPets() {
+			if _, err := m.CreatePet(ctx, store.id, pet); err != nil {
+				return fmt.Errorf("seed pet: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) EnsureAdmin(ctx context.Context, username, password string) error {
+	if username == "" {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.admins[username]; ok {
+		return nil
+	}
+	if m.merchants[username] != nil || m.customers[username] != nil {
+		return fmt.Errorf("admin username %q is already used by a store account", username)
+	}
+	hash, err := crypto.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	m.admins[username] = &memUser{id: m.newID(), username: username, passwordHash: hash, createdAt: time.Now()}
+	return nil
+}
+
+func (m *MemoryStore) Authenticate(ctx context.Context, username, password string) (*auth.Principal, error) {
+	event := newAuditEvent(ctx, AuditLogin)
+	principal, storeID, err := m.authenticate(username, password)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		event.ActorUsername = strings.TrimSpace(username)
+		if storeID != 0 {
+			event.StoreID = &storeID
+		}
+		m.recordAuditFailure(event, err)
+		return nil, err
+	}
+	if now := time.Now(); m.logins.due(principal, now) {
+		event.setActor(principal)
+		m.appendAudit(event)
+		m.logins.recorded(principal, now)
+	}
+	return principal, nil
+}
+
+// authenticate verifies the password without holding m.mu, since hashing
+// is slow enough to hold up every other request.
+func (m *MemoryStore) authenticate(username, password string) (*auth.Principal, int64, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, 0, errors.New("empty username")
+	}
+	m.mu.RLock()
+	_, user, ok := m.lookupUser(username)
+	var hash string
+	var storeID int64
+	if ok {
+		hash, storeID = user.passwordHash, user.storeID
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return nil, 0, errors.New("invalid credentials")
+	}
+	valid, err := crypto.VerifyPassword(password, hash)
+	if err != nil || !valid {
+		return nil, storeID, errors.New("invalid credentials")
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	role, user, ok := m.lookupUser(username)
+	// The password may have changed while it was being checked.
+	if !ok || user.passwordHash != hash {
+		return nil, storeID, errors.New("invalid credentials")
+	}
+	principal := &auth.Principal{
+		Role:        role,
+		UserID:      user.id,
+		StoreID:     user.storeID,
+		Username:    username,
+		Permissions: append([]auth.Permission(nil), user.permissions...),
+	}
+	if store := m.stores[user.storeID]; store != nil {
+		principal.StoreSlug = store.slug
+	}
+	switch role {
+	case auth.RoleCustomer:
+		if m.stores[user.storeID].deactivatedAt != nil {
+			return nil, user.storeID, ErrStoreInactive
+		}
+		principal.Memberships = []auth.Membership{{StoreID: user.storeID, StoreSlug: principal.StoreSlug}}
+	case auth.RoleMerchant:
+		for _, store := range m.merchantStores(user) {
+			principal.Memberships = append(principal.Memberships, auth.Membership{StoreID: store.ID, StoreSlug: store.Slug})
+		}
+	}
+	return principal, user.storeID, nil
+}
+
+func (m *MemoryStore) lookupUser(username string) (auth.Role, *memUser, bool) {
+	if user, ok := m.merchants[username]; ok {
+		return auth.RoleMerchant, user, true
+	}
+	if user, ok := m.customers[username]; ok {
+		return auth.RoleCustomer, user, true
+	}
+	user, ok := m.admins[username]
+	return auth.RoleAdmin, user, ok
+}
+
+func (m *MemoryStore) MerchantStores(ctx context.Context, merchantID int64) ([]StoreInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.merchants {
+		if u.id == merchantID {
+			return m.merchantStores(u), nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) merchantStores(u *memUser) []StoreInfo {
+	stores := make([]StoreInfo, 0, len(u.memberOf))
+	for _, id := range u.memberOf {
+		stores = append(stores, m.stores[id].info())
+	}
+	sort.Slice(stores, func(i, j int) bool {
+		if stores[i].Name != stores[j].Name {
+			return stores[i].Name < stores[j].Name
+		}
+		return stores[i].ID < stores[j].ID
+	})
+	return stores
+}
+
+func (m *MemoryStore) CreatePet(ctx context.Context, storeID int64, input Pet) (Pet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pet, err := m.createPet(ctx, storeID, input)
+	if err != nil {
+		m.recordAuditFailure(storeAuditEvent(ctx, AuditCreatePet, storeID), err)
+		return pet, err
+	}
+	m.publish(ctx, events.PetCreated, storeID, "", []string{pet.ID})
+	return pet, nil
+}
+
+func (m *MemoryStore) createPet(ctx context.Context, storeID int64, input Pet) (Pet, error) {
+	if err := validatePet(input); err != nil {
+		return Pet{}, err
+	}
+	if err := m.checkSpecies(storeID, input.Species.Slug); err != nil {
+		return Pet{}, err
+	}
+	pet, err := m.insertPet(storeID, input)
+	if err != nil {
+		return Pet{}, err
+	}
+	m.notifySavedSearches(storeID, pet.ID)
+	m.enqueueBreederMail(OutboxPetListed, storeID, []string{pet.ID})
+	m.enqueueWebhooks(WebhookPetCreated, storeID, []string{pet.ID})
+	m.appendAudit(storeAuditEvent(ctx, AuditCreatePet, storeID, pet.ID))
+	return pet, nil
+}
+
+func (m *MemoryStore) insertPet(storeID int64, input Pet) (Pet, error) {
+	if _, ok := m.stores[storeID]; !ok {
+		return Pet{}, fmt.Errorf("insert pet: store %d does not exist", storeID)
+	}
+	encEmail, nonce, err := m.crypto.Encrypt(input.BreederEmail)
+	if err != nil {
+		return Pet{}, fmt.Errorf("encrypt email: %w", err)
+	}
+	input.ID = newUUID()
+	input.StoreID = storeID
+	input.CreatedAt = time.Now()
+	input.PurchasedAt = nil
+	input.ArchivedAt = nil
+	input.Version = 1
+	stored := input
+	stored.BreederEmail = ""
+	stored.Species = Species{Slug: input.Species.Slug}
+	m.pets[input.ID] = &memPet{pet: stored, emailEnc: encEmail, emailNonce: nonce}
+	return m.readPet(m.pets[input.ID])
+}
+
+func (m *MemoryStore) ImportPets(ctx context.Context, storeID int64, rows []ImportRow, dryRun bool) (ImportReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report, valid := checkImport(rows, dryRun, m.speciesSlugs(storeID))
+	if dryRun || len(valid) == 0 {
+		return report, nil
+	}
+	created := make([]string, 0, len(valid))
+	for _, i := range valid {
+		pet, err := m.insertPet(storeID, rows[i].Pet)
+		if err != nil {
+			for _, id := range created {
+				delete(m.pets, id)
+			}
+			err = fmt.Errorf("line %d: %w", rows[i].Line, err)
+			m.recordAuditFailure(storeAuditEvent(ctx, AuditImportPets, storeID), err)
+			return ImportReport{}, err
+		}
+		report.Rows[i].PetID = pet.ID
+		created = append(created, pet.ID)
+	}
+	for _, id := range created {
+		m.notifySavedSearches(storeID, id)
+	}
+	m.enqueueBreederMail(OutboxPetListed, storeID, created)
+	m.enqueueWebhooks(WebhookPetCreated, storeID, created)
+	m.publish(ctx, events.PetCreated, storeID, "", created)
+	m.appendAudit(storeAuditEvent(ctx, AuditImportPets, storeID, created...))
+	report.Created = len(created)
+	return report, nil
+}
+
+func (m *MemoryStore) StoreIDBySlug(ctx context.Context, slug string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if s := m.storeBySlug(slug); s != nil {
+		return s.id, nil
+	}
+	return 0, ErrStoreNotFound
+}
+
+func (m *MemoryStore) storeBySlug(slug string) *memStore {
+	for _, s := range m.stores {
+		if s.slug == slug {
+			return s
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetStore(ctx context.Context, slug string) (StoreInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if s := m.storeBySlug(slug); s != nil {
+		return s.info(), nil
+	}
+	return StoreInfo{}, ErrStoreNotFound
+}
+
+func (m *MemoryStore) CreateStore(ctx context.Context, input StoreInput) (StoreInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	store, err := m.createStore(ctx, input)
+	if err != nil {
+		m.recordAuditFailure(newAuditEvent(ctx, AuditCreateStore, input.Slug), err)
+	}
+	return store, err
+}
+
+func (m *MemoryStore) createStore(ctx context.Context, input StoreInput) (StoreInfo, error) {
+	if err := input.validate(); err != nil {
+		return StoreInfo{}, err
+	}
+	if m.storeBySlug(input.Slug) != nil {
+		return StoreInfo{}, ErrStoreSlugTaken
+	}
+	var owner *memUser
+	if input.OwnerUsername != "" {
+		if owner = m.merchants[input.OwnerUsername]; owner == nil {
+			return StoreInfo{}, fmt.Errorf("merchant %q not found", input.OwnerUsername)
+		}
+	}
+	store := &memStore{id: m.newID(), slug: input.Slug, name: input.Name, currency: input.Currency}
+	m.stores[store.id] = store
+	if owner != nil {
+		owner.memberOf = append(owner.memberOf, store.id)
+	}
+	m.appendAudit(storeAuditEvent(ctx, AuditCreateStore, store.id, store.slug))
+	return store.info(), nil
+}
+
+func (m *MemoryStore) UpdateStore(ctx context.Context, slug string, patch StorePatch) (StoreInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	store, err := m.updateStore(ctx, slug, patch)
+	if err != nil {
+		m.recordAuditFailure(newAuditEvent(ctx, AuditUpdateStore, slug), err)
+	}
+	return store, err
+}
+
+func (m *MemoryStore) updateStore(ctx context.Context, slug string, patch StorePatch) (StoreInfo, error) {
+	if err := patch.validate(); err != nil {
+		return StoreInfo{}, err
+	}
+	s := m.storeBySlug(slug)
+	if s == nil {
+		return StoreInfo{}, ErrStoreNotFound
+	}
+	before := s.info()
+	after := patch.apply(before)
+	if after.Currency != before.Currency {
+		for _, p := range m.pets {
+			if p.pet.StoreID == s.id {
+				return StoreInfo{}, ErrCurrencyLocked
+			}
+		}
+	}
+	s.name, s.currency = after.Name, after.Currency
+	event := storeAuditEvent(ctx, AuditUpdateStore, s.id, s.slug)
+	event.Detail = patch.auditDetail(before)
+	m.appendAudit(event)
+	return s.info(), nil
+}
+
+func (m *MemoryStore) SetStoreActive(ctx context.Context, slug string, active bool) (StoreInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.storeBySlug(slug)
+	if s == nil {
+		err := fmt.Errorf("%s: %w", activeAuditDetail(active), ErrStoreNotFound)
+		m.recordAuditFailure(newAuditEvent(ctx, AuditUpdateStore, slug), err)
+		return StoreInfo{}, ErrStoreNotFound
+	}
+	switch {
+	case active:
+		s.deactivatedAt = nil
+	case s.deactivatedAt == nil:
+		now := time.Now()
+		s.deactivatedAt = &now
+	}
+	event := storeAuditEvent(ctx, AuditUpdateStore, s.id, s.slug)
+	event.Detail = activeAuditDetail(active)
+	m.appendAudit(event)
+	return s.info(), nil
+}
+
+func (m *MemoryStore) UpdatePet(ctx context.Context, storeID int64, petID string, expectedVersion int, patch PetPatch) (Pet, error) {
+	return m.withPetLock(ctx, storeID, petID, expectedVersion, WebhookPetUpdated, events.PetUpdated, func(p *memPet, pet Pet) error {
+		if pet.PurchasedAt != nil {
+			return ErrPetSold
+		}
+		oldEmail, oldSpecies := pet.BreederEmail, pet.Species.Slug
+		pet = patch.apply(pet)
+		if err := validatePet(pet); err != nil {
+			return err
+		}
+		if pet.Species.Slug != oldSpecies {
+			if err := m.checkSpecies(storeID, pet.Species.Slug); err != nil {
+				return err
+			}
+		}
+		if pet.BreederEmail != oldEmail {
+			encEmail, nonce, err := m.crypto.Encrypt(pet.BreederEmail)
+			if err != nil {
+				return fmt.Errorf("encrypt email: %w", err)
+			}
+			p.emailEnc, p.emailNonce = encEmail, nonce
+		}
+		p.pet.Name = pet.Name
+		p.pet.Species = Species{Slug: pet.Species.Slug}
+		p.pet.AgeYears = pet.AgeYears
+		p.pet.PictureURL = pet.PictureURL
+		p.pet.Picture = pet.Picture
+		p.pet.Description = pet.Description
+		p.pet.BreederName = pet.BreederName
+		p.pet.Price.Amount = pet.Price.Amount
+		return nil
+	})
+}
+
+func (m *MemoryStore) ArchivePet(ctx context.Context, storeID int64, petID string, expectedVersion int) (Pet, error) {
+	return m.setArchived(ctx, storeID, petID, expectedVersion, true)
+}
+
+func (m *MemoryStore) RestorePet(ctx context.Context, storeID int64, petID string, expectedVersion int) (Pet, error) {
+	return m.setArchived(ctx, storeID, petID, expectedVersion, false)
+}
+
+func (m *MemoryStore) setArchived(ctx context.Context, storeID int64, petID string, expectedVersion int, archived bool) (Pet, error) {
+	webhook, kind := WebhookPetRestored, events.PetRestored
+	if archived {
+		webhook, kind = WebhookPetArchived, events.PetArchived
+	}
+	return m.withPetLock(ctx, storeID, petID, expectedVersion, webhook, kind, func(p *memPet, pet Pet) error {
+		if pet.PurchasedAt != nil {
+			return ErrPetSold
+		}
+		switch {
+		case !archived:
+			p.pet.ArchivedAt = nil
+		case p.pet.ArchivedAt == nil:
+			now := time.Now()
+			p.pet.ArchivedAt = &now
+		}
+		return nil
+	})
+}
+
+// withPetLock mirrors Store.withPetLock: fn sees the current pet and may
+// change p; the version is bumped and the change announced only if fn
+// succeeds.
+func (m *MemoryStore) withPetLock(ctx context.Context, storeID int64, petID string, expectedVersion int, webhook WebhookEvent, kind events.Kind, fn func(*memPet, Pet) error) (Pet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pets[petID]
+	if !ok || p.pet.StoreID != storeID {
+		return Pet{}, ErrPetNotFound
+	}
+	pet, err := m.readPet(p)
+	if err != nil {
+		return Pet{}, err
+	}
+	if pet.Version != expectedVersion {
+		return Pet{}, &VersionConflictError{PetID: petID, CurrentVersion: pet.Version}
+	}
+	backup := *p
+	if err := fn(p, pet); err != nil {
+		*p = backup
+		return Pet{}, err
+	}
+	p.pet.Version++
+	m.enqueueWebhooks(webhook, storeID, []string{petID})
+	m.publish(ctx, kind, storeID, "", []string{petID})
+	return m.readPet(p)
+}
+
+func (m *MemoryStore) ListMerchantPets(ctx context.Context, storeID int64, archived ArchiveFilter) ([]Pet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	match, err := archivedMatcher(archived)
+	if err != nil {
+		return nil, err
+	}
+	return m.listPets(func(p *memPet) bool { return p.pet.StoreID == storeID && match(p) }, byCreatedDesc)
+}
+
+func (m *MemoryStore) GetPets(ctx context.Context, storeID int64, petIDs []string) ([]Pet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	wanted := make(map[string]bool, len(petIDs))
+	for _, id := range petIDs {
+		wanted[id] = true
+	}
+	return m.listPets(func(p *memPet) bool { return p.pet.StoreID == storeID && wanted[p.pet.ID] }, func(a, b Pet) bool {
+		return timeKey(&a.CreatedAt) < timeKey(&b.CreatedAt)
+	})
+}
+
+func (m *MemoryStore) ListAvailablePets(ctx context.Context, storeID int64, customerID int64) ([]Pet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.listPets(func(p *memPet) bool { return m.available(p, storeID, customerID) }, byCreatedDesc)
+}
+
+func (m *MemoryStore) ListPurchasedPets(ctx context.Context, storeID int64, customerID int64) ([]Pet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bought := m.purchasedBy(storeID, customerID)
+	return m.listPets(func(p *memPet) bool { return bought[p.pet.ID] }, func(a, b Pet) bool {
+		return timeKey(a.PurchasedAt) > timeKey(b.PurchasedAt)
+	})
+}
+
+func (m *MemoryStore) PageMerchantPets(ctx context.Context, storeID int64, archived ArchiveFilter, page PageRequest) (PetPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	match, err := archivedMatcher(archived)
+	if err != nil {
+		return PetPage{}, err
+	}
+	return m.pagePets(func(p *memPet) bool { return p.pet.StoreID == storeID && match(p) },
+		func(p Pet) string { return timeKey(&p.CreatedAt) }, true, page)
+}
+
+func (m *MemoryStore) PagePurchasedPets(ctx context.Context, storeID int64, customerID int64, page PageRequest) (PetPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bought := m.purchasedBy(storeID, customerID)
+	return m.pagePets(func(p *memPet) bool { return bought[p.pet.ID] },
+		func(p Pet) string { return timeKey(p.PurchasedAt) }, true, page)
+}
+
+func (m *MemoryStore) SearchAvailablePets(ctx context.Context, storeID int64, customerID int64, search PetSearch) (PetPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	match, err := m.availableMatcher(storeID, customerID, search.Filter, true)
+	if err != nil {
+		return PetPage{}, err
+	}
+	key, desc, err := search.memorySortKey()
+	if err != nil {
+		return PetPage{}, err
+	}
+	return m.pagePets(match, key, desc, search.Page)
+}
+
+func (m *MemoryStore) AvailableSpeciesFacets(ctx context.Context, storeID int64, customerID int64, filter PetFilter) ([]SpeciesFacet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	match, err := m.availableMatcher(storeID, customerID, filter, false)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, p := range m.pets {
+		if match(p) {
+			counts[p.pet.Species.Slug]++
+		}
+	}
+	store := m.stores[storeID]
+	facets := make([]SpeciesFacet, 0, len(counts))
+	for slug, count := range counts {
+		facets = append(facets, SpeciesFacet{Species: store.speciesInfo(slug), Count: count})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		a, b := facets[i].Species, facets[j].Species
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Slug < b.Slug
+	})
+	return facets, nil
+}
+
+// available matches availablePetsQuery without a filter.
+func (m *MemoryStore) available(p *memPet, storeID int64, customerID int64) bool {
+	if p.pet.StoreID != storeID || p.pet.PurchasedAt != nil || p.pet.ArchivedAt != nil {
+		return false
+	}
+	hold, ok := m.holds[p.pet.ID]
+	return !ok || !hold.expiresAt.After(time.Now()) || hold.customerID == customerID
+}
+
+func (m *MemoryStore) availableMatcher(storeID int64, customerID int64, filter PetFilter, withSpecies bool) (func(*memPet) bool, error) {
+	if filter.MinAge != nil && filter.MaxAge != nil && *filter.MinAge > *filter.MaxAge {
+		return nil, errors.New("min age cannot exceed max age")
+	}
+	query := parseMemoryQuery(filter.Query)
+	store := m.stores[storeID]
+	return func(p *memPet) bool {
+		if !m.available(p, storeID, customerID) {
+			return false
+		}
+		if withSpecies && len(filter.Species) > 0 && !inSpecies(filter.Species, store.speciesInfo(p.pet.Species.Slug)) {
+			return false
+		}
+		if filter.MinAge != nil && p.pet.AgeYears < *filter.MinAge {
+			return false
+		}
+		if filter.MaxAge != nil && p.pet.AgeYears > *filter.MaxAge {
+			return false
+		}
+		if filter.CreatedAfter != nil && !p.pet.CreatedAt.After(*filter.CreatedAfter) {
+			return false
+		}
+		return query == nil || query.matches(p.pet)
+	}, nil
+}
+
+// purchasedBy returns the pets the customer still owns through an order,
+// like purchasedPetsQuery.
+func (m *MemoryStore) purchasedBy(storeID int64, customerID int64) map[string]bool {
+	bought := make(map[string]bool)
+	for _, o := range m.orders {
+		if o.order.StoreID != storeID || o.order.CustomerID != customerID {
+			continue
+		}
+		for _, item := range o.items {
+			if item.status == OrderItemPurchased {
+				bought[item.petID] = true
+			}
+		}
+	}
+	return bought
+}
+
+func archivedMatcher(f ArchiveFilter) (func(*memPet) bool, error) {
+	switch f {
+	case "", ArchiveFilterActive:
+		return func(p *memPet) bool { return p.pet.ArchivedAt == nil }, nil
+	case ArchiveFilterArchived:
+		return func(p *memPet) bool { return p.pet.ArchivedAt != nil }, nil
+	case ArchiveFilterAll:
+		return func(*memPet) bool { return true }, nil
+	default:
+		return nil, fmt.Errorf("unknown archive filter %q", f)
+	}
+}
+
+func byCreatedDesc(a, b Pet) bool { return a.CreatedAt.After(b.CreatedAt) }
+
+func (m *MemoryStore) listPets(match func(*memPet) bool, less func(a, b Pet) bool) ([]Pet, error) {
+	var pets []Pet
+	for _, p := range m.pets {
+		if !match(p) {
+			continue
+		}
+		pet, err := m.readPet(p)
+		if err != nil {
+			return nil, err
+		}
+		pets = append(pets, pet)
+	}
+	sort.SliceStable(pets, func(i, j int) bool { return less(pets[i], pets[j]) })
+	return pets, nil
+}
+
+func (m *MemoryStore) pagePets(match func(*memPet) bool, key func(Pet) string, desc bool, page PageRequest) (PetPage, error) {
+	var rows []memRow[Pet]
+	for _, p := range m.pets {
+		if !match(p) {
+			continue
+		}
+		pet, err := m.readPet(p)
+		if err != nil {
+			return PetPage{}, err
+		}
+		rows = append(rows, memRow[Pet]{key: key(pet), id: pet.ID, item: pet})
+	}
+	rows, info, err := pageMemory(rows, desc, page)
+	if err != nil {
+		return PetPage{}, err
+	}
+	result := PetPage{PageInfo: info}
+	for _, r := range rows {
+		result.Edges = append(result.Edges, PetEdge{Cursor: encodeCursor(r.key, r.id), Pet: r.item})
+	}
+	return result, nil
+}
+
+// readPet is the in-memory scanPet: it fills in the store currency and
+// species and decrypts the breeder email.
+func (m *MemoryStore) readPet(p *memPet) (Pet, error) {
+	pet := p.pet
+	if store, ok := m.stores[pet.StoreID]; ok {
+		pet.Price.Currency = store.currency
+		pet.Species = store.speciesInfo(pet.Species.Slug)
+	}
+	if pet.Picture != nil {
+		picture := *pet.Picture
+		pet.Picture = &picture
+	}
+	email, err := m.crypto.Decrypt(p.emailEnc, p.emailNonce)
+	if err != nil {
+		return Pet{}, fmt.Errorf("decrypt email: %w", err)
+	}
+	pet.BreederEmail = email
+	return pet, nil
+}
+
+func (m *MemoryStore) newID() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+func (m *MemoryStore) appendAudit(e AuditEvent) {
+	e.ID = newUUID()
+	e.OccurredAt = time.Now()
+	m.audit = append(m.audit, e)
+}
+
+func (m *MemoryStore) recordAuditFailure(e AuditEvent, cause error) {
+	e.Outcome = AuditFailure
+	e.Detail = cause.Error()
+	m.appendAudit(e)
+}
+
+// inSpecies matches the species filter of availablePetsQuery: a pet
+// matches its own slug and its category's.
+func inSpecies(slugs []string, sp Species) bool {
+	return slices.Contains(slugs, sp.Slug) || sp.Parent != nil && slices.Contains(slugs, sp.Parent.Slug)
+}
+
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("uuid: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// ExportPets copies the matching pets under the lock and streams them to fn
+// after releasing it.
+func (m *MemoryStore) ExportPets(ctx context.Context, storeID int64, filter PetExportFilter, fn func(Pet) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.appendAudit(exportAuditEvent(ctx, storeID, filter.auditDetail()))
+	pets, err := m.listPets(func(p *memPet) bool {
+		return p.pet.StoreID == storeID && filter.matches(p.pet)
+	}, func(a, b Pet) bool { return a.CreatedAt.Before(b.CreatedAt) })
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, pet := range pets {
+		if !filter.IncludeBreederEmails {
+			pet.BreederEmail = ""
+		}
+		if err := fn(pet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/crypto"
+)
+
+func (m *MemoryStore) RegisterCustomer(ctx context.Context, storeSlug string, reg Registration) (CustomerProfile, error) {
+	reg = reg.normalize()
+	if err := reg.validate(); err != nil {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.recordRegisterFailure(ctx, reg, 0, err)
+		return CustomerProfile{}, err
+	}
+	hash, err := crypto.HashPassword(reg.Password)
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("hash password: %w", err)
+	}
+	emailEnc, emailNonce, err := m.crypto.Encrypt(reg.Email)
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("encrypt email: %w", err)
+	}
+	phoneEnc, phoneNonce, err := m.crypto.Encrypt(reg.Phone)
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("encrypt phone: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	store := m.storeBySlug(storeSlug)
+	switch {
+	case store == nil:
+		err = ErrStoreNotFound
+	case store.deactivatedAt != nil:
+		err = ErrStoreInactive
+	case m.usernameTaken(reg.Username):
+		err = ErrUsernameTaken
+	}
+	if err != nil {
+		var storeID int64
+		if store != nil {
+			storeID = store.id
+		}
+		m.recordRegisterFailure(ctx, reg, storeID, err)
+		return CustomerProfile{}, err
+	}
+	user := &memUser{
+		id:           m.newID(),
+		storeID:      store.id,
+		username:     reg.Username,
+		passwordHash: hash,
+		emailEnc:     emailEnc,
+		emailNonce:   emailNonce,
+		phoneEnc:     phoneEnc,
+		phoneNonce:   phoneNonce,
+		createdAt:    time.Now(),
+	}
+	m.customers[user.username] = user
+	profile, err := m.customerProfile(user)
+	if err != nil {
+		return CustomerProfile{}, err
+	}
+	m.appendAudit(accountAuditEvent(ctx, AuditRegister, profile))
+	return profile, nil
+}
+
+func (m *MemoryStore) recordRegisterFailure(ctx context.Context, reg Registration, storeID int64, err error) {
+	event := newAuditEvent(ctx, AuditRegister)
+	event.ActorUsername = reg.Username
+	if storeID != 0 {
+		event.StoreID = &storeID
+	}
+	m.recordAuditFailure(event, err)
+}
+
+func (m *MemoryStore) usernameTaken(username string) bool {
+	for _, users := range []map[string]*memUser{m.merchants, m.customers, m.admins} {
+		for name := range users {
+			if strings.EqualFold(name, username) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) customerByID(customerID int64) *memUser {
+	for _, u := range m.customers {
+		if u.id == customerID {
+			return u
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) customerProfile(u *memUser) (CustomerProfile, error) {
+	profile := CustomerProfile{ID: u.id, StoreID: u.storeID, Username: u.username, CreatedAt: u.createdAt}
+	var err error
+	if u.emailEnc != nil {
+		if profile.Email, err = m.crypto.Decrypt(u.emailEnc, u.emailNonce); err != nil {
+			return CustomerProfile{}, fmt.Errorf("decrypt email: %w", err)
+		}
+	}
+	if u.phoneEnc != nil {
+		if profile.Phone, err = m.crypto.Decrypt(u.phoneEnc, u.phoneNonce); err != nil {
+			return CustomerProfile{}, fmt.Errorf("decrypt phone: %w", err)
+		}
+	}
+	return profile, nil
+}
+
+func (m *MemoryStore) CustomerProfile(ctx context.Context, customerID int64) (CustomerProfile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u := m.customerByID(customerID)
+	if u == nil {
+		return CustomerProfile{}, errors.New("customer not found")
+	}
+	return m.customerProfile(u)
+}
+
+func (m *MemoryStore) UpdateCustomerProfile(ctx context.Context, customerID int64, patch ProfilePatch) (CustomerProfile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, err := m.updateCustomerProfile(ctx, customerID, patch.normalize())
+	if err != nil {
+		m.recordAuditFailure(newAuditEvent(ctx, AuditUpdateProfile), err)
+	}
+	return profile, err
+}
+
+func (m *MemoryStore) updateCustomerProfile(ctx context.Context, customerID int64, patch ProfilePatch) (CustomerProfile, error) {
+	if err := patch.validate(); err != nil {
+		return CustomerProfile{}, err
+	}
+	u := m.customerByID(customerID)
+	if u == nil {
+		return CustomerProfile{}, errors.New("customer not found")
+	}
+	profile, err := m.customerProfile(u)
+	if err != nil {
+		return CustomerProfile{}, err
+	}
+	profile = patch.apply(profile)
+	emailEnc, emailNonce, err := m.crypto.Encrypt(profile.Email)
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("encrypt email: %w", err)
+	}
+	phoneEnc, phoneNonce, err := m.crypto.Encrypt(profile.Phone)
+	if err != nil {
+		return CustomerProfile{}, fmt.Errorf("encrypt phone: %w", err)
+	}
+	u.emailEnc, u.emailNonce, u.phoneEnc, u.phoneNonce = emailEnc, emailNonce, phoneEnc, phoneNonce
+	event := newAuditEvent(ctx, AuditUpdateProfile)
+	event.Detail = patch.auditDetail()
+	m.appendAudit(event)
+	return profile, nil
+}
+
+func (m *MemoryStore) ChangePassword(ctx context.Context, role auth.Role, userID int64, currentPassword, newPassword string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.changePassword(role, userID, currentPassword, newPassword)
+	if err != nil {
+		m.recordAuditFailure(newAuditEvent(ctx, AuditChangePassword), err)
+		return err
+	}
+	m.appendAudit(newAuditEvent(ctx, AuditChangePassword))
+	return nil
+}
+
+func (m *MemoryStore) changePassword(role auth.Role, userID int64, currentPassword, newPassword string) error {
+	users := map[auth.Role]map[string]*memUser{
+		auth.RoleMerchant: m.merchants,
+		auth.RoleCustomer: m.customers,
+		auth.RoleAdmin:    m.admins,
+	}[role]
+	var user *memUser
+	for _, u := range users {
+		if u.id == userID {
+			user = u
+		}
+	}
+	if user == nil {
+		return fmt.Errorf("unknown %s account %d", role, userID)
+	}
+	if err := crypto.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+	if ok, err := crypto.VerifyPassword(currentPassword, user.passwordHash); err != nil || !ok {
+		return ErrWrongPassword
+	}
+	hash, err := crypto.HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	user.passwordHash = hash
+	if role == auth.RoleCustomer {
+		m.spendResetTokens(user.id)
+	}
+	return nil
+}
+
+type memReset struct {
+	customerID int64
+	expiresAt  time.Time
+	used       bool
+}
+
+func (m *MemoryStore) SetPasswordResetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ttl > 0 {
+		m.resetTTL = ttl
+	}
+}
+
+func (m *MemoryStore) CreatePasswordReset(ctx context.Context, username string) (PasswordReset, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.customers[username]
+	if !ok {
+		event := newAuditEvent(ctx, AuditRequestPasswordReset)
+		event.ActorUsername = username
+		m.recordAuditFailure(event, errors.New("unknown customer"))
+		return PasswordReset{}, false, nil
+	}
+	profile, err := m.customerProfile(u)
+	if err != nil {
+		return PasswordReset{}, false, err
+	}
+	store := m.stores[u.storeID]
+	if u.emailEnc == nil || store.deactivatedAt != nil {
+		m.recordAuditFailure(accountAuditEvent(ctx, AuditRequestPasswordReset, profile), errors.New("no email or store inactive"))
+		return PasswordReset{}, false, nil
+	}
+	token, hash, err := crypto.NewToken()
+	if err != nil {
+		return PasswordReset{}, false, err
+	}
+	reset := PasswordReset{
+		Token:     token,
+		Username:  username,
+		Email:     profile.Email,
+		StoreSlug: store.slug,
+		StoreName: store.name,
+		ExpiresAt: time.Now().Add(m.resetTTL),
+	}
+	m.resets[string(hash)] = &memReset{customerID: u.id, expiresAt: reset.ExpiresAt}
+	m.appendAudit(accountAuditEvent(ctx, AuditRequestPasswordReset, profile))
+	return reset, true, nil
+}
+
+func (m *MemoryStore) ResetPassword(ctx context.Context, token, newPassword string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.resetPassword(ctx, token, newPassword)
+	if err != nil {
+		m.recordAuditFailure(newAuditEvent(ctx, AuditResetPassword), err)
+	}
+	return err
+}
+
+func (m *MemoryStore) resetPassword(ctx context.Context, token, newPassword string) error {
+	if err := crypto.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+	reset, ok := m.resets[string(crypto.HashToken(token))]
+	if !ok || reset.used || !reset.expiresAt.After(time.Now()) {
+		return ErrInvalidResetToken
+	}
+	u := m.customerByID(reset.customerID)
+	if u == nil {
+		return ErrInvalidResetToken
+	}
+	hash, err := crypto.HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	u.passwordHash = hash
+	m.spendResetTokens(u.id)
+	profile, err := m.customerProfile(u)
+	if err != nil {
+		return err
+	}
+	m.appendAudit(accountAuditEvent(ctx, AuditResetPassword, profile))
+	return nil
+}
+
+func (m *MemoryStore) spendResetTokens(customerID int64) {
+	for _, r := range m.resets {
+		if r.customerID == customerID {
+			r.used = true
+		}
+	}
+}
+
+func (m *MemoryStore) DeleteExpiredPasswordResets(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var n int64
+	for hash, r := range m.resets {
+		if !r.expiresAt.After(now) {
+			delete(m.resets, hash)
+			n++
+		}
+	}
+	return n, nil
+}
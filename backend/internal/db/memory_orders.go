@@ -0,0 +1,603 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/events"
+)
+
+func (m *MemoryStore) PurchasePets(ctx context.Context, storeID int64, customerID int64, petIDs []string) (PurchaseResult, error) {
+	return m.purchase(ctx, storeID, customerID, petIDs, purchaseKey{})
+}
+
+func (m *MemoryStore) PurchasePetsWithKey(ctx context.Context, storeID int64, customerID int64, key string, petIDs []string) (PurchaseResult, error) {
+	pk, err := newPurchaseKey(key, petsFingerprint(petIDs))
+	if err != nil {
+		return PurchaseResult{}, err
+	}
+	return m.purchase(ctx, storeID, customerID, petIDs, pk)
+}
+
+func (m *MemoryStore) purchase(ctx context.Context, storeID int64, customerID int64, petIDs []string, key purchaseKey) (PurchaseResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, err := m.runPurchase(ctx, storeID, customerID, petIDs, key)
+	if err != nil {
+		m.recordAuditFailure(newAuditEvent(ctx, AuditPurchasePets, petIDs...), err)
+	}
+	return result, err
+}
+
+// runPurchase follows Store.runPurchase: unavailable pets are reported in
+// Errors and the rest are bought in a single order.
+func (m *MemoryStore) runPurchase(ctx context.Context, storeID int64, customerID int64, petIDs []string, key purchaseKey) (PurchaseResult, error) {
+	if len(petIDs) == 0 {
+		return PurchaseResult{}, errors.New("no pets in cart")
+	}
+	store, ok := m.stores[storeID]
+	if !ok {
+		return PurchaseResult{}, ErrStoreNotFound
+	}
+	if store.deactivatedAt != nil {
+		return PurchaseResult{}, ErrStoreInactive
+	}
+	if key.value != "" {
+		if result, found, err := m.replayPurchase(storeID, customerID, key); found || err != nil {
+			return result, err
+		}
+	}
+
+	now := time.Now()
+	result := PurchaseResult{}
+	var available []*memPet
+	seen := make(map[string]bool)
+	for _, id := range petIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		p, ok := m.pets[id]
+		if !ok || p.pet.StoreID != storeID {
+			result.Errors = append(result.Errors, PurchaseError{PetName: id, Message: "not found"})
+			continue
+		}
+		hold, held := m.holds[id]
+		switch {
+		case p.pet.PurchasedAt != nil:
+			result.Errors = append(result.Errors, PurchaseError{PetName: p.pet.Name, Message: "already purchased"})
+		case p.pet.ArchivedAt != nil:
+			result.Errors = append(result.Errors, PurchaseError{PetName: p.pet.Name, Message: "no longer available"})
+		case held && hold.expiresAt.After(now) && hold.customerID != customerID:
+			result.Errors = append(result.Errors, PurchaseError{PetName: p.pet.Name, Message: "reserved by another customer"})
+		default:
+			available = append(available, p)
+		}
+	}
+
+	var subtotal int64
+	for _, p := range available {
+		subtotal += p.pet.Price.Amount
+	}
+	if subtotal > math.MaxInt32 {
+		return PurchaseResult{}, ErrOrderTooLarge
+	}
+	if len(available) > 0 {
+		order := &memOrder{order: Order{
+			ID:               newUUID(),
+			StoreID:          storeID,
+			CustomerID:       customerID,
+			CustomerUsername: m.customerName(customerID),
+			Status:           OrderStatusPlaced,
+			PlacedAt:         now,
+			Subtotal:         Money{Currency: store.currency},
+		}}
+		for _, p := range available {
+			price := Money{Amount: p.pet.Price.Amount, Currency: store.currency}
+			order.order.Subtotal = order.order.Subtotal.Add(price)
+			order.items = append(order.items, &memOrderItem{petID: p.pet.ID, unitPrice: price, status: OrderItemPurchased})
+
+			purchasedAt := now
+			buyer := customerID
+			p.pet.PurchasedAt = &purchasedAt
+			p.purchasedBy = &buyer
+			p.pet.Version++
+			delete(m.holds, p.pet.ID)
+			result.PurchasedIDs = append(result.PurchasedIDs, p.pet.ID)
+		}
+		order.order.Total = order.order.Subtotal
+		m.orders[order.order.ID] = order
+		m.notifyFavoritesSold(storeID, customerID, result.PurchasedIDs)
+		m.enqueueBreederMail(OutboxPetSold, storeID, result.PurchasedIDs)
+		m.enqueueWebhooks(WebhookPetPurchased, storeID, result.PurchasedIDs)
+		m.publish(ctx, events.PetPurchased, storeID, order.order.ID, result.PurchasedIDs)
+
+		placed, err := m.readOrder(order)
+		if err != nil {
+			return PurchaseResult{}, err
+		}
+		result.Order = &placed
+	}
+
+	if key.value != "" {
+		stored := &memIdempotency{
+			fingerprint:  key.fingerprint,
+			purchasedIDs: result.PurchasedIDs,
+			errors:       result.Errors,
+			expiresAt:    now.Add(m.idempotencyTTL),
+		}
+		if result.Order != nil {
+			stored.orderID = result.Order.ID
+		}
+		m.idempotency[memKey{customerID, key.value}] = stored
+	}
+	m.appendAudit(result.auditEvent(ctx, petIDs))
+	return result, nil
+}
+
+func (m *MemoryStore) replayPurchase(storeID int64, customerID int64, key purchaseKey) (PurchaseResult, bool, error) {
+	stored, ok := m.idempotency[memKey{customerID, key.value}]
+	if !ok || !stored.expiresAt.After(time.Now()) {
+		return PurchaseResult{}, false, nil
+	}
+	if stored.fingerprint != key.fingerprint {
+		return PurchaseResult{}, true, ErrIdempotencyKeyReused
+	}
+	result := PurchaseResult{PurchasedIDs: stored.purchasedIDs, Errors: stored.errors}
+	if stored.orderID != "" {
+		order, err := m.getOrder(storeID, stored.orderID)
+		if err != nil {
+			return PurchaseResult{}, true, err
+		}
+		result.Order = &order
+	}
+	return result, true, nil
+}
+
+func (m *MemoryStore) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	now := time.Now()
+	for key, stored := range m.idempotency {
+		if !stored.expiresAt.After(now) {
+			delete(m.idempotency, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) AddToCart(ctx context.Context, storeID int64, customerID int64, petID string) (CartItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pets[petID]
+	if !ok || p.pet.StoreID != storeID {
+		return CartItem{}, ErrPetNotFound
+	}
+	if p.pet.PurchasedAt != nil || p.pet.ArchivedAt != nil {
+		return CartItem{}, ErrPetUnavailable
+	}
+	now := time.Now()
+	if m.heldBy(customerID, petID, now) >= MaxCartHolds {
+		return CartItem{}, ErrCartFull
+	}
+	hold, ok := m.holds[petID]
+	switch {
+	case !ok || (hold.customerID != customerID && !hold.expiresAt.After(now)):
+		hold = &memHold{storeID: storeID, customerID: customerID, createdAt: now}
+		m.holds[petID] = hold
+	case hold.customerID != customerID:
+		return CartItem{}, ErrPetHeld
+	}
+	hold.expiresAt = now.Add(m.holdTTL)
+
+	pet, err := m.readPet(p)
+	if err != nil {
+		return CartItem{}, err
+	}
+	return CartItem{Pet: pet, ExpiresAt: hold.expiresAt}, nil
+}
+
+// heldBy counts the customer's unexpired holds on pets other than petID.
+func (m *MemoryStore) heldBy(customerID int64, petID string, now time.Time) int {
+	n := 0
+	for id, hold := range m.holds {
+		if id != petID && hold.customerID == customerID && hold.expiresAt.After(now) {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *MemoryStore) RemoveFromCart(ctx context.Context, storeID int64, customerID int64, petID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hold, ok := m.holds[petID]; ok && hold.storeID == storeID && hold.customerID == customerID {
+		delete(m.holds, petID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetCart(ctx context.Context, storeID int64, customerID int64) (Cart, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getCart(storeID, customerID)
+}
+
+func (m *MemoryStore) getCart(storeID int64, customerID int64) (Cart, error) {
+	store, ok := m.stores[storeID]
+	if !ok {
+		return Cart{}, fmt.Errorf("query store currency: store %d does not exist", storeID)
+	}
+	cart := Cart{Currency: store.currency}
+	now := time.Now()
+	type held struct {
+		item      CartItem
+		createdAt time.Time
+	}
+	var items []held
+	for petID, hold := range m.holds {
+		if hold.storeID != storeID || hold.customerID != customerID || !hold.expiresAt.After(now) {
+			continue
+		}
+		p := m.pets[petID]
+		if p.pet.PurchasedAt != nil || p.pet.ArchivedAt != nil {
+			continue
+		}
+		pet, err := m.readPet(p)
+		if err != nil {
+			return Cart{}, err
+		}
+		items = append(items, held{item: CartItem{Pet: pet, ExpiresAt: hold.expiresAt}, createdAt: hold.createdAt})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].createdAt.Equal(items[j].createdAt) {
+			return items[i].createdAt.Before(items[j].createdAt)
+		}
+		return items[i].item.Pet.ID < items[j].item.Pet.ID
+	})
+	for _, h := range items {
+		cart.Items = append(cart.Items, h.item)
+	}
+	return cart, nil
+}
+
+func (m *MemoryStore) CheckoutCart(ctx context.Context, storeID int64, customerID int64, idempotencyKey string) (PurchaseResult, error) {
+	key, err := newPurchaseKey(idempotencyKey, cartFingerprint)
+	if err != nil {
+		return PurchaseResult{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key.value != "" {
+		if result, found, err := m.replayPurchase(storeID, customerID, key); found || err != nil {
+			return result, err
+		}
+	}
+	cart, err := m.getCart(storeID, customerID)
+	if err != nil {
+		return PurchaseResult{}, err
+	}
+	if len(cart.Items) == 0 {
+		return PurchaseResult{}, errors.New("no pets in cart")
+	}
+	result, err := m.runPurchase(ctx, storeID, customerID, cart.PetIDs(), key)
+	if err != nil {
+		m.recordAuditFailure(newAuditEvent(ctx, AuditPurchasePets, cart.PetIDs()...), err)
+	}
+	return result, err
+}
+
+func (m *MemoryStore) ReleaseExpiredHolds(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	now := time.Now()
+	for petID, hold := range m.holds {
+		if !hold.expiresAt.After(now) {
+			delete(m.holds, petID)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) GetOrder(ctx context.Context, storeID int64, orderID string) (Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getOrder(storeID, orderID)
+}
+
+func (m *MemoryStore) getOrder(storeID int64, orderID string) (Order, error) {
+	o, ok := m.orders[orderID]
+	if !ok || o.order.StoreID != storeID {
+		return Order{}, ErrOrderNotFound
+	}
+	return m.readOrder(o)
+}
+
+func (m *MemoryStore) PageCustomerOrders(ctx context.Context, storeID int64, customerID int64, page PageRequest) (OrderPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pageOrders(func(o *memOrder) bool {
+		return o.order.StoreID == storeID && o.order.CustomerID == customerID
+	}, page)
+}
+
+func (m *MemoryStore) PageStoreOrders(ctx context.Context, storeID int64, page PageRequest) (OrderPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pageOrders(func(o *memOrder) bool { return o.order.StoreID == storeID }, page)
+}
+
+func (m *MemoryStore) pageOrders(match func(*memOrder) bool, page PageRequest) (OrderPage, error) {
+	var rows []memRow[*memOrder]
+	for _, o := range m.orders {
+		if match(o) {
+			rows = append(rows, memRow[*memOrder]{key: timeKey(&o.order.PlacedAt), id: o.order.ID, item: o})
+		}
+	}
+	rows, info, err := pageMemory(rows, true, page)
+	if err != nil {
+		return OrderPage{}, err
+	}
+	result := OrderPage{PageInfo: info}
+	for _, r := range rows {
+		order, err := m.readOrder(r.item)
+		if err != nil {
+			return OrderPage{}, err
+		}
+		result.Edges = append(result.Edges, OrderEdge{Cursor: encodeCursor(r.key, r.id), Order: order})
+	}
+	return result, nil
+}
+
+// readOrder copies an order with its items in the order loadOrderItems
+// returns them.
+func (m *MemoryStore) readOrder(o *memOrder) (Order, error) {
+	order := o.order
+	order.Items = nil
+	for _, item := range o.items {
+		pet, err := m.readPet(m.pets[item.petID])
+		if err != nil {
+			return Order{}, err
+		}
+		out := OrderItem{Pet: pet, UnitPrice: item.unitPrice, Status: item.status}
+		if item.reversal != nil {
+			reversal := *item.reversal
+			out.Reversal = &reversal
+		}
+		order.Items = append(order.Items, out)
+	}
+	sort.Slice(order.Items, func(i, j int) bool {
+		a, b := order.Items[i].Pet, order.Items[j].Pet
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+	return order, nil
+}
+
+func (m *MemoryStore) customerName(customerID int64) string {
+	for _, c := range m.customers {
+		if c.id == customerID {
+			return c.username
+		}
+	}
+	return ""
+}
+
+func (m *MemoryStore) merchantName(merchantID int64) string {
+	for _, u := range m.merchants {
+		if u.id == merchantID {
+			return u.username
+		}
+	}
+	return ""
+}
+
+func (m *MemoryStore) CancelPurchase(ctx context.Context, storeID int64, customerID int64, orderID string, petIDs []string, reason string) (Order, error) {
+	return m.reversePurchase(ctx, storeID, orderID, petIDs, reversalRequest{
+		status:     OrderItemCancelled,
+		actorRole:  auth.RoleCustomer,
+		actorID:    customerID,
+		reason:     reason,
+		customerID: &customerID,
+	})
+}
+
+func (m *MemoryStore) RefundPurchase(ctx context.Context, storeID int64, merchantID int64, orderID string, petIDs []string, reason string) (Order, error) {
+	return m.reversePurchase(ctx, storeID, orderID, petIDs, reversalRequest{
+		status:    OrderItemRefunded,
+		actorRole: auth.RoleMerchant,
+		actorID:   merchantID,
+		reason:    reason,
+	})
+}
+
+func (m *MemoryStore) reversePurchase(ctx context.Context, storeID int64, orderID string, petIDs []string, req reversalRequest) (Order, error) {
+	req.reason = strings.TrimSpace(req.reason)
+	if req.reason == "" {
+		return Order{}, errors.New("reason is required")
+	}
+	if len(req.reason) > 500 {
+		return Order{}, errors.New("reason is too long")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[orderID]
+	if !ok || o.order.StoreID != storeID {
+		return Order{}, ErrOrderNotFound
+	}
+	if req.customerID != nil {
+		if o.order.CustomerID != *req.customerID {
+			return Order{}, ErrOrderNotFound
+		}
+		if time.Since(o.order.PlacedAt) > m.cancelWindow {
+			return Order{}, ErrCancelWindowClosed
+		}
+	}
+
+	items, err := reversibleItems(o, petIDs)
+	if err != nil {
+		return Order{}, err
+	}
+	username := m.customerName(req.actorID)
+	if req.actorRole == auth.RoleMerchant {
+		username = m.merchantName(req.actorID)
+	}
+	reversal := Reversal{
+		At:            time.Now(),
+		ActorRole:     string(req.actorRole),
+		ActorID:       req.actorID,
+		ActorUsername: username,
+		Reason:        req.reason,
+	}
+	released := make([]string, 0, len(items))
+	for _, item := range items {
+		item.status = req.status
+		r := reversal
+		item.reversal = &r
+		if p, ok := m.pets[item.petID]; ok {
+			p.pet.PurchasedAt = nil
+			p.purchasedBy = nil
+			p.pet.Version++
+			released = append(released, p.pet.ID)
+		}
+	}
+	m.enqueueWebhooks(WebhookPetReturned, storeID, released)
+	m.publish(ctx, events.PetReturned, storeID, orderID, released)
+
+	full, partial := OrderStatusCancelled, OrderStatusPartiallyCancelled
+	if req.status == OrderItemRefunded {
+		full, partial = OrderStatusRefunded, OrderStatusPartiallyRefunded
+	}
+	o.order.Status = full
+	for _, item := range o.items {
+		if item.status == OrderItemPurchased {
+			o.order.Status = partial
+		}
+	}
+	return m.readOrder(o)
+}
+
+// reversibleItems is lockReversibleItems for MemoryStore.
+func reversibleItems(o *memOrder, petIDs []string) ([]*memOrderItem, error) {
+	byPet := make(map[string]*memOrderItem, len(o.items))
+	var active []*memOrderItem
+	for _, item := range o.items {
+		byPet[item.petID] = item
+		if item.status == OrderItemPurchased {
+			active = append(active, item)
+		}
+	}
+	if len(petIDs) == 0 {
+		if len(active) == 0 {
+			return nil, errors.New("order has no purchased items left")
+		}
+		return active, nil
+	}
+	items := make([]*memOrderItem, 0, len(petIDs))
+	for _, id := range petIDs {
+		item, ok := byPet[id]
+		if !ok {
+			return nil, fmt.Errorf("pet %s is not in this order", id)
+		}
+		if item.status != OrderItemPurchased {
+			return nil, fmt.Errorf("pet %s is already %s", id, strings.ToLower(string(item.status)))
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) PageAuditEvents(ctx context.Context, storeID int64, filter AuditFilter, page PageRequest) (AuditEventPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	username := strings.TrimSpace(filter.ActorUsername)
+	var rows []memRow[AuditEvent]
+	for _, e := range m.audit {
+		switch {
+		case e.StoreID == nil || *e.StoreID != storeID,
+			filter.Action != nil && e.Action != *filter.Action,
+			filter.Outcome != nil && e.Outcome != *filter.Outcome,
+			username != "" && e.ActorUsername != username,
+			filter.Since != nil && e.OccurredAt.Before(*filter.Since),
+			filter.Until != nil && !e.OccurredAt.Before(*filter.Until):
+			continue
+		}
+		rows = append(rows, memRow[AuditEvent]{key: timeKey(&e.OccurredAt), id: e.ID, item: e})
+	}
+	rows, info, err := pageMemory(rows, true, page)
+	if err != nil {
+		return AuditEventPage{}, err
+	}
+	result := AuditEventPage{PageInfo: info}
+	for _, r := range rows {
+		result.Edges = append(result.Edges, AuditEventEdge{Cursor: encodeCursor(r.key, r.id), Event: r.item})
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) ExportSales(ctx context.Context, storeID int64, filter SaleExportFilter, fn func(Sale) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.appendAudit(exportAuditEvent(ctx, storeID, "sales"))
+	var sales []Sale
+	for _, o := range m.orders {
+		if o.order.StoreID != storeID {
+			continue
+		}
+		order, err := m.readOrder(o)
+		if err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		for _, item := range order.Items {
+			sale := Sale{
+				OrderID:          order.ID,
+				PlacedAt:         order.PlacedAt,
+				CustomerUsername: order.CustomerUsername,
+				OrderStatus:      order.Status,
+				PetID:            item.Pet.ID,
+				PetName:          item.Pet.Name,
+				Species:          item.Pet.Species,
+				UnitPrice:        item.UnitPrice,
+				Status:           item.Status,
+			}
+			if item.Reversal != nil {
+				at := item.Reversal.At
+				sale.ReversedAt = &at
+				sale.ReversalReason = item.Reversal.Reason
+			}
+			if filter.matches(sale) {
+				sales = append(sales, sale)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	sort.SliceStable(sales, func(i, j int) bool {
+		if !sales[i].PlacedAt.Equal(sales[j].PlacedAt) {
+			return sales[i].PlacedAt.Before(sales[j].PlacedAt)
+		}
+		return sales[i].OrderID < sales[j].OrderID
+	})
+	for _, sale := range sales {
+		if err := fn(sale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+type memOutbox struct {
+	msg           OutboxMessage
+	status        OutboxStatus
+	nextAttemptAt time.Time
+	lastError     string
+	sentAt        *time.Time
+}
+
+func (m *MemoryStore) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var due []*memOutbox
+	for _, o := range m.outbox {
+		if o.status == OutboxPending && !o.nextAttemptAt.After(now) {
+			due = append(due, o)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].nextAttemptAt.Before(due[j].nextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	messages := make([]OutboxMessage, 0, len(due))
+	for _, o := range due {
+		o.msg.Attempts++
+		o.nextAttemptAt = now.Add(lease)
+		messages = append(messages, o.msg)
+	}
+	return messages, nil
+}
+
+func (m *MemoryStore) CompleteOutboxMessage(ctx context.Context, id string, attempts int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := m.pendingOutbox(id, attempts)
+	if o == nil {
+		return ErrLeaseExpired
+	}
+	now := time.Now()
+	o.status = OutboxSent
+	o.sentAt = &now
+	o.lastError = ""
+	return nil
+}
+
+func (m *MemoryStore) FailOutboxMessage(ctx context.Context, id string, attempts int, cause string, retryAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := m.pendingOutbox(id, attempts)
+	if o == nil {
+		return ErrLeaseExpired
+	}
+	o.lastError = cause
+	if retryAt == nil {
+		o.status = OutboxDead
+		return nil
+	}
+	o.nextAttemptAt = *retryAt
+	return nil
+}
+
+func (m *MemoryStore) DeleteSentOutboxMessages(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoff := time.Now().Add(-outboxRetention)
+	kept := m.outbox[:0]
+	var n int64
+	for _, o := range m.outbox {
+		if o.status == OutboxSent && !o.sentAt.After(cutoff) {
+			n++
+			continue
+		}
+		kept = append(kept, o)
+	}
+	m.outbox = kept
+	return n, nil
+}
+
+// pendingOutbox finds the message if it is still pending under the claim
+// that made attempts.
+func (m *MemoryStore) pendingOutbox(id string, attempts int) *memOutbox {
+	for _, o := range m.outbox {
+		if o.msg.ID == id && o.status == OutboxPending && o.msg.Attempts == attempts {
+			return o
+		}
+	}
+	return nil
+}
+
+// enqueueBreederMail is the in-memory enqueueBreederMail.
+func (m *MemoryStore) enqueueBreederMail(kind OutboxKind, storeID int64, petIDs []string) {
+	store := m.stores[storeID]
+	now := time.Now()
+	for _, id := range petIDs {
+		p := m.pets[id]
+		m.outbox = append(m.outbox, &memOutbox{
+			msg: OutboxMessage{
+				ID:             newUUID(),
+				StoreID:        storeID,
+				Kind:           kind,
+				RecipientEnc:   p.emailEnc,
+				RecipientNonce: p.emailNonce,
+				Payload: OutboxPayload{
+					PetID:       p.pet.ID,
+					PetName:     p.pet.Name,
+					BreederName: p.pet.BreederName,
+					StoreName:   store.name,
+					PriceMinor:  p.pet.Price.Amount,
+					Currency:    store.currency,
+				},
+				CreatedAt: now,
+			},
+			status:        OutboxPending,
+			nextAttemptAt: now,
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// memRow is a row of an in-memory keyset page. key must sort, as a string,
+// in the same order as the value it encodes.
+type memRow[T any] struct {
+	key  string
+	id   string
+	item T
+}
+
+// pageMemory is listQuery.keyset and trimPage for rows held in memory.
+func pageMemory[T any](rows []memRow[T], desc bool, page PageRequest) ([]memRow[T], PageInfo, error) {
+	limit, backward, err := page.validate()
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	compare := func(a, b memRow[T]) int {
+		c := strings.Compare(a.key, b.key)
+		if c == 0 {
+			c = strings.Compare(a.id, b.id)
+		}
+		if desc {
+			c = -c
+		}
+		return c
+	}
+	sort.Slice(rows, func(i, j int) bool { return compare(rows[i], rows[j]) < 0 })
+
+	bound := func(encoded string, keep func(int) bool) error {
+		c, err := decodeCursor(encoded)
+		if err != nil {
+			return err
+		}
+		pivot := memRow[T]{key: c.Key, id: c.ID}
+		kept := rows[:0]
+		for _, r := range rows {
+			if keep(compare(r, pivot)) {
+				kept = append(kept, r)
+			}
+		}
+		rows = kept
+		return nil
+	}
+	if page.After != "" {
+		if err := bound(page.After, func(c int) bool { return c > 0 }); err != nil {
+			return nil, PageInfo{}, err
+		}
+	}
+	if page.Before != "" {
+		if err := bound(page.Before, func(c int) bool { return c < 0 }); err != nil {
+			return nil, PageInfo{}, err
+		}
+	}
+
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if len(rows) > limit+1 {
+		rows = rows[:limit+1]
+	}
+	rows, info := trimPage(rows, limit, backward, page, func(r memRow[T]) string { return encodeCursor(r.key, r.id) })
+	return rows, info, nil
+}
+
+func timeKey(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+func intKey(n int) string {
+	return fmt.Sprintf("%020d", n)
+}
+
+// memorySortKey is PetSearch.sortKey for MemoryStore.
+func (search PetSearch) memorySortKey() (key func(Pet) string, desc bool, err error) {
+	text := strings.TrimSpace(search.Filter.Query)
+	order := search.Sort
+	if order == "" {
+		order = PetSortNewest
+		if text != "" {
+			order = PetSortRelevance
+		}
+	}
+	created := func(p Pet) string { return timeKey(&p.CreatedAt) }
+	age := func(p Pet) string { return intKey(p.AgeYears) }
+	name := func(p Pet) string { return p.Name }
+	switch order {
+	case PetSortNewest:
+		return created, true, nil
+	case PetSortOldest:
+		return created, false, nil
+	case PetSortAgeAsc:
+		return age, false, nil
+	case PetSortAgeDesc:
+		return age, true, nil
+	case PetSortNameAsc:
+		return name, false, nil
+	case PetSortNameDesc:
+		return name, true, nil
+	case PetSortRelevance:
+		if text == "" {
+			return nil, false, errors.New("relevance sort requires a search query")
+		}
+		query := parseMemoryQuery(text)
+		return func(p Pet) string { return fmt.Sprintf("%020.6f", query.rank(p)) }, true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown sort %q", order)
+	}
+}
+
+// memoryQuery approximates websearch_to_tsquery: words are ANDed, "or"
+// separates alternatives and a leading "-" excludes a word. Words match by
+// prefix in either direction, a rough stand-in for stemming.
+type memoryQuery struct {
+	groups [][]memoryTerm
+}
+
+type memoryTerm struct {
+	word   string
+	negate bool
+}
+
+var memoryStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "of": true, "to": true,
+	"in": true, "is": true, "with": true, "for": true, "who": true,
+}
+
+func parseMemoryQuery(text string) *memoryQuery {
+	var q memoryQuery
+	var group []memoryTerm
+	for _, field := range strings.Fields(strings.ToLower(text)) {
+		if field == "or" {
+			if len(group) > 0 {
+				q.groups = append(q.groups, group)
+			}
+			group = nil
+			continue
+		}
+		negate := strings.HasPrefix(field, "-")
+		for _, word := range memoryWords(field) {
+			if !memoryStopWords[word] {
+				group = append(group, memoryTerm{word: word, negate: negate})
+			}
+		}
+	}
+	if len(group) > 0 {
+		q.groups = append(q.groups, group)
+	}
+	if len(q.groups) == 0 {
+		return nil
+	}
+	return &q
+}
+
+func (q *memoryQuery) matches(pet Pet) bool {
+	words := append(memoryWords(pet.Name), memoryWords(pet.Description)...)
+	for _, group := range q.groups {
+		ok := true
+		for _, term := range group {
+			if term.matchesAny(words) == term.negate {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rank weights name matches above description matches, as the A and B
+// weights of search_vector do.
+func (q *memoryQuery) rank(pet Pet) float64 {
+	name, description := memoryWords(pet.Name), memoryWords(pet.Description)
+	var score float64
+	for _, group := range q.groups {
+		for _, term := range group {
+			if term.negate {
+				continue
+			}
+			if term.matchesAny(name) {
+				score += 1
+			}
+			if term.matchesAny(description) {
+				score += 0.4
+			}
+		}
+	}
+	return score
+}
+
+func (t memoryTerm) matchesAny(words []string) bool {
+	for _, w := range words {
+		if strings.HasPrefix(w, t.word) || (len(w) >= 3 && strings.HasPrefix(t.word, w)) {
+			return true
+		}
+	}
+	return false
+}
+
+func memoryWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+type memSpecies struct {
+	slug   string
+	name   string
+	parent string
+}
+
+// speciesInfo joins a species with its parent like petSpeciesColumns.
+func (s *memStore) speciesInfo(slug string) Species {
+	sp := Species{Slug: slug}
+	e, ok := s.species[slug]
+	if !ok {
+		return sp
+	}
+	sp.Name = e.name
+	if parent, ok := s.species[e.parent]; ok {
+		sp.Parent = &Species{Slug: parent.slug, Name: parent.name}
+	}
+	return sp
+}
+
+func (m *MemoryStore) ListSpecies(ctx context.Context, storeID int64) ([]Species, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	store, ok := m.stores[storeID]
+	if !ok {
+		return nil, nil
+	}
+	var species []Species
+	for slug := range store.species {
+		species = append(species, store.speciesInfo(slug))
+	}
+	// Same order as ListSpecies in Postgres: categories by name, each
+	// followed by its sub-species.
+	group := func(sp Species) Species {
+		if sp.Parent != nil {
+			return *sp.Parent
+		}
+		return sp
+	}
+	sort.Slice(species, func(i, j int) bool {
+		a, b := species[i], species[j]
+		ga, gb := group(a), group(b)
+		if ga.Name != gb.Name {
+			return ga.Name < gb.Name
+		}
+		if ga.Slug != gb.Slug {
+			return ga.Slug < gb.Slug
+		}
+		if (a.Parent != nil) != (b.Parent != nil) {
+			return a.Parent == nil
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Slug < b.Slug
+	})
+	return species, nil
+}
+
+func (m *MemoryStore) CreateSpecies(ctx context.Context, storeID int64, input SpeciesInput) (Species, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sp, err := m.createSpecies(ctx, storeID, input)
+	if err != nil {
+		m.recordAuditFailure(storeAuditEvent(ctx, AuditCreateSpecies, storeID, input.Slug), err)
+	}
+	return sp, err
+}
+
+func (m *MemoryStore) createSpecies(ctx context.Context, storeID int64, input SpeciesInput) (Species, error) {
+	if err := input.validate(); err != nil {
+		return Species{}, err
+	}
+	store, ok := m.stores[storeID]
+	if !ok {
+		return Species{}, ErrStoreNotFound
+	}
+	if input.ParentSlug != "" {
+		if err := store.checkParentSpecies(input.ParentSlug); err != nil {
+			return Species{}, err
+		}
+	}
+	if _, ok := store.species[input.Slug]; ok {
+		return Species{}, ErrSpeciesSlugTaken
+	}
+	if store.species == nil {
+		store.species = make(map[string]*memSpecies)
+	}
+	store.species[input.Slug] = &memSpecies{slug: input.Slug, name: input.Name, parent: input.ParentSlug}
+	m.appendAudit(storeAuditEvent(ctx, AuditCreateSpecies, storeID, input.Slug))
+	return store.speciesInfo(input.Slug), nil
+}
+
+func (m *MemoryStore) UpdateSpecies(ctx context.Context, storeID int64, slug string, patch SpeciesPatch) (Species, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sp, err := m.updateSpecies(ctx, storeID, slug, patch)
+	if err != nil {
+		m.recordAuditFailure(storeAuditEvent(ctx, AuditUpdateSpecies, storeID, slug), err)
+	}
+	return sp, err
+}
+
+func (m *MemoryStore) updateSpecies(ctx context.Context, storeID int64, slug string, patch SpeciesPatch) (Species, error) {
+	if err := patch.validate(slug); err != nil {
+		return Species{}, err
+	}
+	store, ok := m.stores[storeID]
+	if !ok {
+		return Species{}, ErrSpeciesNotFound
+	}
+	e, ok := store.species[slug]
+	if !ok {
+		return Species{}, ErrSpeciesNotFound
+	}
+	before := store.speciesInfo(slug)
+	parent := e.parent
+	if patch.ParentSlug != nil && *patch.ParentSlug != e.parent {
+		parent = *patch.ParentSlug
+		if parent != "" {
+			if store.hasSubSpecies(slug) {
+				return Species{}, errSpeciesHasChildren
+			}
+			if err := store.checkParentSpecies(parent); err != nil {
+				return Species{}, err
+			}
+		}
+	}
+	if patch.Name != nil {
+		e.name = *patch.Name
+	}
+	e.parent = parent
+	event := storeAuditEvent(ctx, AuditUpdateSpecies, storeID, slug)
+	event.Detail = patch.auditDetail(before)
+	m.appendAudit(event)
+	return store.speciesInfo(slug), nil
+}
+
+func (m *MemoryStore) DeleteSpecies(ctx context.Context, storeID int64, slug string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.deleteSpecies(ctx, storeID, slug)
+	if err != nil {
+		m.recordAuditFailure(storeAuditEvent(ctx, AuditDeleteSpecies, storeID, slug), err)
+	}
+	return err
+}
+
+func (m *MemoryStore) deleteSpecies(ctx context.Context, storeID int64, slug string) error {
+	store, ok := m.stores[storeID]
+	if !ok {
+		return ErrSpeciesNotFound
+	}
+	if _, ok := store.species[slug]; !ok {
+		return ErrSpeciesNotFound
+	}
+	if store.hasSubSpecies(slug) {
+		return ErrSpeciesInUse
+	}
+	for _, p := range m.pets {
+		if p.pet.StoreID == storeID && p.pet.Species.Slug == slug {
+			return ErrSpeciesInUse
+		}
+	}
+	delete(store.species, slug)
+	m.appendAudit(storeAuditEvent(ctx, AuditDeleteSpecies, storeID, slug))
+	return nil
+}
+
+// checkParentSpecies mirrors lockParentSpecies.
+func (s *memStore) checkParentSpecies(slug string) error {
+	parent, ok := s.species[slug]
+	if !ok {
+		return fmt.Errorf("parent: %w", unknownSpecies(slug))
+	}
+	if parent.parent != "" {
+		return errSpeciesNestedOnce
+	}
+	return nil
+}
+
+func (s *memStore) hasSubSpecies(slug string) bool {
+	for _, e := range s.species {
+		if e.parent == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSpecies mirrors the Postgres checkSpecies.
+func (m *MemoryStore) checkSpecies(storeID int64, slug string) error {
+	if store, ok := m.stores[storeID]; ok {
+		if _, ok := store.species[slug]; ok {
+			return nil
+		}
+	}
+	return unknownSpecies(slug)
+}
+
+// speciesSlugs is the in-memory storeSpeciesSlugs.
+func (m *MemoryStore) speciesSlugs(storeID int64) map[string]bool {
+	known := make(map[string]bool)
+	if store, ok := m.stores[storeID]; ok {
+		for slug := range store.species {
+			known[slug] = true
+		}
+	}
+	return known
+}
@@ -0,0 +1,642 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/crypto"
+)
+
+func newTestMemoryStore(t *testing.T) (*MemoryStore, *auth.Principal, *auth.Principal) {
+	t.Helper()
+	cipher, err := crypto.NewCipherFromBase64(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatalf("cipher: %v", err)
+	}
+	store := NewMemoryStore(cipher)
+	ctx := context.Background()
+	if err := store.EnsureDemoData(ctx, "demo", "Demo", "USD", "merchant", "merchant_pw", "customer", "customer_pw"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	merchant, err := store.Authenticate(ctx, "merchant", "merchant_pw")
+	if err != nil {
+		t.Fatalf("authenticate merchant: %v", err)
+	}
+	customer, err := store.Authenticate(ctx, "customer", "customer_pw")
+	if err != nil {
+		t.Fatalf("authenticate customer: %v", err)
+	}
+	return store, merchant, customer
+}
+
+func TestMemoryStorePurchaseReportsUnavailablePets(t *testing.T) {
+	store, _, customer := newTestMemoryStore(t)
+	ctx := context.Background()
+	pets, err := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	if err != nil || len(pets) != 3 {
+		t.Fatalf("expected 3 available pets, got %d (%v)", len(pets), err)
+	}
+
+	if _, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, []string{pets[0].ID}); err != nil {
+		t.Fatalf("first purchase: %v", err)
+	}
+	result, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, []string{pets[0].ID, pets[1].ID, "missing"})
+	if err != nil {
+		t.Fatalf("second purchase: %v", err)
+	}
+	if len(result.PurchasedIDs) != 1 || result.PurchasedIDs[0] != pets[1].ID {
+		t.Fatalf("expected only %s to be purchased, got %v", pets[1].ID, result.PurchasedIDs)
+	}
+	want := []PurchaseError{
+		{PetName: pets[0].Name, Message: "already purchased"},
+		{PetName: "missing", Message: "not found"},
+	}
+	if len(result.Errors) != len(want) || result.Errors[0] != want[0] || result.Errors[1] != want[1] {
+		t.Fatalf("expected errors %v, got %v", want, result.Errors)
+	}
+	if result.Order == nil || result.Order.Total.Amount != pets[1].Price.Amount {
+		t.Fatalf("expected an order for %d, got %+v", pets[1].Price.Amount, result.Order)
+	}
+
+	purchased, err := store.ListPurchasedPets(ctx, customer.StoreID, customer.UserID)
+	if err != nil || len(purchased) != 2 {
+		t.Fatalf("expected 2 purchased pets, got %d (%v)", len(purchased), err)
+	}
+}
+
+func TestMemoryStoreRejectsOversizedOrder(t *testing.T) {
+	store, merchant, customer := newTestMemoryStore(t)
+	ctx := context.Background()
+	var ids []string
+	for _, name := range []string{"Goldie", "Silver"} {
+		pet, err := store.CreatePet(ctx, merchant.StoreID, Pet{
+			Name: name, Species: Species{Slug: "cat"}, AgeYears: 1, PictureURL: "https://example.com/cat.jpg", Description: "Rare.",
+			BreederName: "Ann", BreederEmail: "ann@example.com", Price: Money{Amount: math.MaxInt32},
+		})
+		if err != nil {
+			t.Fatalf("create pet: %v", err)
+		}
+		ids = append(ids, pet.ID)
+	}
+	if _, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, ids); !errors.Is(err, ErrOrderTooLarge) {
+		t.Fatalf("expected ErrOrderTooLarge, got %v", err)
+	}
+	result, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, ids[:1])
+	if err != nil || len(result.PurchasedIDs) != 1 {
+		t.Fatalf("expected a single expensive pet to be bought, got %+v (%v)", result, err)
+	}
+}
+
+func TestMemoryStoreEncryptsBreederEmail(t *testing.T) {
+	store, merchant, _ := newTestMemoryStore(t)
+	ctx := context.Background()
+	pet, err := store.CreatePet(ctx, merchant.StoreID, Pet{
+		Name: "Pip", Species: Species{Slug: "frog"}, AgeYears: 1, PictureURL: "https://example.com/pip.jpg", Description: "Small.",
+		BreederName: "Ann", BreederEmail: "ann@example.com", Price: Money{Amount: 100},
+	})
+	if err != nil {
+		t.Fatalf("create pet: %v", err)
+	}
+	stored := store.pets[pet.ID]
+	if stored.pet.BreederEmail != "" || bytes.Contains(stored.emailEnc, []byte("ann@example.com")) {
+		t.Fatal("expected breeder email to be stored encrypted")
+	}
+	if pet.BreederEmail != "ann@example.com" || pet.Price.Currency != "USD" || pet.Version != 1 {
+		t.Fatalf("unexpected pet %+v", pet)
+	}
+}
+
+func TestMemoryStoreVersionConflict(t *testing.T) {
+	store, merchant, _ := newTestMemoryStore(t)
+	ctx := context.Background()
+	pets, _ := store.ListMerchantPets(ctx, merchant.StoreID, ArchiveFilterActive)
+	name := "Renamed"
+	updated, err := store.UpdatePet(ctx, merchant.StoreID, pets[0].ID, 1, PetPatch{Name: &name})
+	if err != nil || updated.Version != 2 {
+		t.Fatalf("expected version 2, got %d (%v)", updated.Version, err)
+	}
+	_, err = store.ArchivePet(ctx, merchant.StoreID, pets[0].ID, 1)
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) || conflict.CurrentVersion != 2 {
+		t.Fatalf("expected a conflict at version 2, got %v", err)
+	}
+}
+
+func TestMemoryStoreIdempotentPurchase(t *testing.T) {
+	store, _, customer := newTestMemoryStore(t)
+	ctx := context.Background()
+	pets, _ := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+
+	first, err := store.PurchasePetsWithKey(ctx, customer.StoreID, customer.UserID, "k1", []string{pets[0].ID})
+	if err != nil {
+		t.Fatalf("purchase: %v", err)
+	}
+	retry, err := store.PurchasePetsWithKey(ctx, customer.StoreID, customer.UserID, "k1", []string{pets[0].ID})
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if len(retry.Errors) != 0 || retry.Order == nil || retry.Order.ID != first.Order.ID {
+		t.Fatalf("expected the original result, got %+v", retry)
+	}
+	if _, err := store.PurchasePetsWithKey(ctx, customer.StoreID, customer.UserID, "k1", []string{pets[1].ID}); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+}
+
+func TestMemoryStorePagesMerchantPets(t *testing.T) {
+	store, merchant, _ := newTestMemoryStore(t)
+	ctx := context.Background()
+	first, err := store.PageMerchantPets(ctx, merchant.StoreID, ArchiveFilterActive, PageRequest{First: 2})
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if len(first.Edges) != 2 || !first.HasNextPage {
+		t.Fatalf("expected 2 pets and a next page, got %d %+v", len(first.Edges), first.PageInfo)
+	}
+	second, err := store.PageMerchantPets(ctx, merchant.StoreID, ArchiveFilterActive, PageRequest{First: 2, After: first.EndCursor})
+	if err != nil {
+		t.Fatalf("second page: %v", err)
+	}
+	if len(second.Edges) != 1 || second.HasNextPage || !second.HasPreviousPage {
+		t.Fatalf("expected the last pet, got %d %+v", len(second.Edges), second.PageInfo)
+	}
+	back, err := store.PageMerchantPets(ctx, merchant.StoreID, ArchiveFilterActive, PageRequest{Last: 2, Before: second.StartCursor})
+	if err != nil {
+		t.Fatalf("backward page: %v", err)
+	}
+	if len(back.Edges) != 2 || back.Edges[0].Pet.ID != first.Edges[0].Pet.ID {
+		t.Fatalf("expected the first page again, got %+v", back.Edges)
+	}
+}
+
+func TestMemoryStoreImportPets(t *testing.T) {
+	store, merchant, _ := newTestMemoryStore(t)
+	ctx := context.Background()
+	rows := []ImportRow{
+		{Line: 2, Pet: Pet{Name: "Pip", Species: Species{Slug: "frog"}, PictureURL: "https://example.com/pip.jpg", Description: "Small.",
+			BreederName: "Ann", BreederEmail: "ann@example.com", Price: Money{Amount: 100}}},
+		{Line: 3, Pet: Pet{Name: "Nope"}},
+	}
+	report, err := store.ImportPets(ctx, merchant.StoreID, rows, false)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if report.Created != 1 || report.Rejected != 1 || report.Rows[0].PetID == "" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	pets, _ := store.ListMerchantPets(ctx, merchant.StoreID, ArchiveFilterActive)
+	if len(pets) != 4 {
+		t.Fatalf("expected 4 pets, got %d", len(pets))
+	}
+	action := AuditImportPets
+	events, err := store.PageAuditEvents(ctx, merchant.StoreID, AuditFilter{Action: &action}, PageRequest{First: 10})
+	if err != nil || len(events.Edges) != 1 {
+		t.Fatalf("expected one import audit event, got %d (%v)", len(events.Edges), err)
+	}
+	listed := 0
+	for _, o := range store.outbox {
+		if o.msg.Kind == OutboxPetListed && o.msg.Payload.PetID == report.Rows[0].PetID {
+			listed++
+		}
+	}
+	if listed != 1 {
+		t.Fatalf("expected the breeder to be told about the imported pet, got %d messages", listed)
+	}
+}
+
+func TestMemoryStoreDeactivatedStore(t *testing.T) {
+	store, _, customer := newTestMemoryStore(t)
+	ctx := context.Background()
+	pets, err := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	if err != nil || len(pets) == 0 {
+		t.Fatalf("list pets: %v", err)
+	}
+	currency := "EUR"
+	if _, err := store.UpdateStore(ctx, "demo", StorePatch{Currency: &currency}); !errors.Is(err, ErrCurrencyLocked) {
+		t.Fatalf("expected ErrCurrencyLocked, got %v", err)
+	}
+
+	info, err := store.SetStoreActive(ctx, "demo", false)
+	if err != nil || info.Active {
+		t.Fatalf("deactivate: %+v, %v", info, err)
+	}
+	if _, err := store.Authenticate(ctx, "customer", "customer_pw"); !errors.Is(err, ErrStoreInactive) {
+		t.Fatalf("expected customer login to fail with ErrStoreInactive, got %v", err)
+	}
+	if _, err := store.Authenticate(ctx, "merchant", "merchant_pw"); err != nil {
+		t.Fatalf("merchant login: %v", err)
+	}
+	if _, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, []string{pets[0].ID}); !errors.Is(err, ErrStoreInactive) {
+		t.Fatalf("expected purchase to fail with ErrStoreInactive, got %v", err)
+	}
+	after, err := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	if err != nil || len(after) != len(pets) {
+		t.Fatalf("expected pets to be kept, got %d (%v)", len(after), err)
+	}
+
+	if _, err := store.SetStoreActive(ctx, "demo", true); err != nil {
+		t.Fatalf("reactivate: %v", err)
+	}
+	if _, err := store.Authenticate(ctx, "customer", "customer_pw"); err != nil {
+		t.Fatalf("customer login after reactivation: %v", err)
+	}
+}
+
+func TestMemoryStoreRegisterCustomer(t *testing.T) {
+	store, _, _ := newTestMemoryStore(t)
+	ctx := context.Background()
+	reg := Registration{Username: "Merchant", Password: "long_enough_pw", Email: "m@example.com"}
+	if _, err := store.RegisterCustomer(ctx, "demo", reg); !errors.Is(err, ErrUsernameTaken) {
+		t.Fatalf("expected ErrUsernameTaken for a merchant's name, got %v", err)
+	}
+
+	reg.Username = "newbie"
+	profile, err := store.RegisterCustomer(ctx, "demo", reg)
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if profile.Email != "m@example.com" || bytes.Contains(store.customers["newbie"].emailEnc, []byte("m@example.com")) {
+		t.Fatalf("expected the email to be stored encrypted, got %+v", profile)
+	}
+	customer, err := store.Authenticate(ctx, "newbie", "long_enough_pw")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if err := store.ChangePassword(ctx, customer.Role, customer.UserID, "wrong", "another_long_pw"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("expected ErrWrongPassword, got %v", err)
+	}
+	if err := store.ChangePassword(ctx, customer.Role, customer.UserID, "long_enough_pw", "another_long_pw"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if _, err := store.Authenticate(ctx, "newbie", "long_enough_pw"); err == nil {
+		t.Fatal("old password still accepted")
+	}
+}
+
+func TestMemoryStoreSpecies(t *testing.T) {
+	store, merchant, customer := newTestMemoryStore(t)
+	ctx := context.Background()
+	if _, err := store.CreateSpecies(ctx, merchant.StoreID, SpeciesInput{Slug: "reptiles", Name: "Reptiles"}); err != nil {
+		t.Fatalf("create category: %v", err)
+	}
+	gecko, err := store.CreateSpecies(ctx, merchant.StoreID, SpeciesInput{Slug: "gecko", Name: "Gecko", ParentSlug: "reptiles"})
+	if err != nil || gecko.Parent == nil || gecko.Parent.Name != "Reptiles" {
+		t.Fatalf("unexpected species %+v (%v)", gecko, err)
+	}
+	if _, err := store.CreateSpecies(ctx, merchant.StoreID, SpeciesInput{Slug: "tokay", Name: "Tokay", ParentSlug: "gecko"}); err == nil {
+		t.Fatal("expected a sub-species to be rejected as a parent")
+	}
+	if _, err := store.CreateSpecies(ctx, merchant.StoreID, SpeciesInput{Slug: "gecko", Name: "Gecko"}); !errors.Is(err, ErrSpeciesSlugTaken) {
+		t.Fatalf("expected ErrSpeciesSlugTaken, got %v", err)
+	}
+
+	pet := Pet{
+		Name: "Ziggy", Species: Species{Slug: "gecko"}, AgeYears: 1, PictureURL: "https://example.com/ziggy.jpg", Description: "Sticky.",
+		BreederName: "Ann", BreederEmail: "ann@example.com", Price: Money{Amount: 100},
+	}
+	created, err := store.CreatePet(ctx, merchant.StoreID, pet)
+	if err != nil || created.Species.Name != "Gecko" || created.Species.Parent == nil {
+		t.Fatalf("unexpected pet %+v (%v)", created.Species, err)
+	}
+	pet.Species = Species{Slug: "rabbit"}
+	if _, err := store.CreatePet(ctx, merchant.StoreID, pet); !errors.Is(err, ErrSpeciesNotFound) {
+		t.Fatalf("expected ErrSpeciesNotFound, got %v", err)
+	}
+
+	page, err := store.SearchAvailablePets(ctx, customer.StoreID, customer.UserID, PetSearch{Filter: PetFilter{Species: []string{"reptiles"}}})
+	if err != nil || len(page.Edges) != 1 || page.Edges[0].Pet.ID != created.ID {
+		t.Fatalf("expected the category to match the gecko, got %+v (%v)", page.Edges, err)
+	}
+	if err := store.DeleteSpecies(ctx, merchant.StoreID, "reptiles"); !errors.Is(err, ErrSpeciesInUse) {
+		t.Fatalf("expected ErrSpeciesInUse, got %v", err)
+	}
+
+	species, err := store.ListSpecies(ctx, merchant.StoreID)
+	if err != nil {
+		t.Fatalf("list species: %v", err)
+	}
+	var slugs []string
+	for _, sp := range species {
+		slugs = append(slugs, sp.Slug)
+	}
+	if strings.Join(slugs, ",") != "cat,dog,frog,reptiles,gecko" {
+		t.Fatalf("unexpected species order %v", slugs)
+	}
+
+	top := ""
+	updated, err := store.UpdateSpecies(ctx, merchant.StoreID, "gecko", SpeciesPatch{ParentSlug: &top})
+	if err != nil || updated.Parent != nil {
+		t.Fatalf("expected gecko to become top-level, got %+v (%v)", updated, err)
+	}
+	if err := store.DeleteSpecies(ctx, merchant.StoreID, "reptiles"); err != nil {
+		t.Fatalf("delete unused category: %v", err)
+	}
+}
+
+func TestMemoryStoreWishlists(t *testing.T) {
+	store, merchant, customer := newTestMemoryStore(t)
+	ctx := context.Background()
+	pets, err := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	if err != nil || len(pets) != 3 {
+		t.Fatalf("expected 3 available pets, got %d (%v)", len(pets), err)
+	}
+	if _, err := store.FavoritePet(ctx, customer.StoreID, customer.UserID, pets[0].ID); err != nil {
+		t.Fatalf("favorite: %v", err)
+	}
+	if _, err := store.CreateSavedSearch(ctx, customer.StoreID, customer.UserID, "Anything", PetFilter{Query: " "}); err == nil {
+		t.Fatal("expected an empty saved search to be rejected")
+	}
+	maxAge := 2
+	saved, err := store.CreateSavedSearch(ctx, customer.StoreID, customer.UserID, "Young frogs", PetFilter{Species: []string{"frog"}, MaxAge: &maxAge})
+	if err != nil {
+		t.Fatalf("save search: %v", err)
+	}
+
+	pet := Pet{
+		Name: "Pip", Species: Species{Slug: "frog"}, AgeYears: 1, PictureURL: "https://example.com/pip.jpg", Description: "Small.",
+		BreederName: "Ann", BreederEmail: "ann@example.com", Price: Money{Amount: 100},
+	}
+	pip, err := store.CreatePet(ctx, merchant.StoreID, pet)
+	if err != nil {
+		t.Fatalf("create pet: %v", err)
+	}
+	pet.Name, pet.AgeYears = "Old Pip", 5
+	if _, err := store.CreatePet(ctx, merchant.StoreID, pet); err != nil {
+		t.Fatalf("create pet: %v", err)
+	}
+
+	reg := Registration{Username: "buyer", Password: "long_enough_pw", Email: "buyer@example.com"}
+	if _, err := store.RegisterCustomer(ctx, "demo", reg); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	buyer, err := store.Authenticate(ctx, "buyer", "long_enough_pw")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if _, err := store.PurchasePets(ctx, buyer.StoreID, buyer.UserID, []string{pets[0].ID}); err != nil {
+		t.Fatalf("purchase: %v", err)
+	}
+	if _, err := store.FavoritePet(ctx, customer.StoreID, customer.UserID, pets[0].ID); !errors.Is(err, ErrFavoriteUnavailable) {
+		t.Fatalf("expected ErrFavoriteUnavailable, got %v", err)
+	}
+
+	page, err := store.PageNotifications(ctx, customer.StoreID, customer.UserID, true, PageRequest{})
+	if err != nil || len(page.Edges) != 2 {
+		t.Fatalf("expected 2 notifications, got %+v (%v)", page.Edges, err)
+	}
+	sold, match := page.Edges[0].Notification, page.Edges[1].Notification
+	if sold.Kind != NotificationFavoriteSold || sold.Pet.ID != pets[0].ID {
+		t.Fatalf("unexpected notification %+v", sold)
+	}
+	if match.Kind != NotificationSavedSearchMatch || match.Pet.ID != pip.ID || match.SavedSearchID != saved.ID ||
+		match.Message != `Pip matches your saved search "Young frogs".` {
+		t.Fatalf("unexpected notification %+v", match)
+	}
+
+	if marked, err := store.MarkNotificationsRead(ctx, customer.StoreID, customer.UserID, []string{match.ID}); err != nil || marked != 1 {
+		t.Fatalf("expected 1 notification marked read, got %d (%v)", marked, err)
+	}
+	if unread, err := store.CountUnreadNotifications(ctx, customer.StoreID, customer.UserID); err != nil || unread != 1 {
+		t.Fatalf("expected 1 unread notification, got %d (%v)", unread, err)
+	}
+	if err := store.DeleteSavedSearch(ctx, customer.StoreID, customer.UserID, saved.ID); err != nil {
+		t.Fatalf("delete saved search: %v", err)
+	}
+	if err := store.DeleteSavedSearch(ctx, customer.StoreID, customer.UserID, saved.ID); !errors.Is(err, ErrSavedSearchNotFound) {
+		t.Fatalf("expected ErrSavedSearchNotFound, got %v", err)
+	}
+}
+
+func TestMemoryStoreCancelPurchase(t *testing.T) {
+	store, _, customer := newTestMemoryStore(t)
+	ctx := context.Background()
+	pets, err := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	if err != nil || len(pets) != 3 {
+		t.Fatalf("expected 3 available pets, got %d (%v)", len(pets), err)
+	}
+	result, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, []string{pets[0].ID, pets[1].ID})
+	if err != nil || result.Order == nil {
+		t.Fatalf("purchase: %+v (%v)", result, err)
+	}
+	orderID := result.Order.ID
+
+	if _, err := store.RegisterCustomer(ctx, "demo", Registration{Username: "other", Password: "long_enough_pw", Email: "o@example.com"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	other, err := store.Authenticate(ctx, "other", "long_enough_pw")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if _, err := store.CancelPurchase(ctx, other.StoreID, other.UserID, orderID, nil, "not mine"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected another customer's order to be hidden, got %v", err)
+	}
+	if _, err := store.CancelPurchase(ctx, customer.StoreID, customer.UserID, orderID, nil, "  "); err == nil {
+		t.Fatal("expected a reason to be required")
+	}
+
+	order, err := store.CancelPurchase(ctx, customer.StoreID, customer.UserID, orderID, []string{pets[0].ID}, "changed my mind")
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if order.Status != OrderStatusPartiallyCancelled {
+		t.Fatalf("expected a partially cancelled order, got %s", order.Status)
+	}
+	if _, err := store.CancelPurchase(ctx, customer.StoreID, customer.UserID, orderID, []string{pets[0].ID}, "again"); err == nil {
+		t.Fatal("expected a cancelled item to stay cancelled")
+	}
+
+	available, err := store.ListAvailablePets(ctx, other.StoreID, other.UserID)
+	if err != nil || len(available) != 2 {
+		t.Fatalf("expected the cancelled pet to be listed again, got %d (%v)", len(available), err)
+	}
+	rebought, err := store.PurchasePets(ctx, other.StoreID, other.UserID, []string{pets[0].ID})
+	if err != nil || len(rebought.PurchasedIDs) != 1 {
+		t.Fatalf("expected the cancelled pet to be bought again, got %+v (%v)", rebought, err)
+	}
+}
+
+func TestMemoryStoreRefundAfterCancelWindow(t *testing.T) {
+	store, merchant, customer := newTestMemoryStore(t)
+	ctx := context.Background()
+	pets, err := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	if err != nil || len(pets) == 0 {
+		t.Fatalf("list pets: %v", err)
+	}
+	result, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, []string{pets[0].ID})
+	if err != nil || result.Order == nil {
+		t.Fatalf("purchase: %+v (%v)", result, err)
+	}
+	orderID := result.Order.ID
+	store.orders[orderID].order.PlacedAt = time.Now().Add(-DefaultCancelWindow - time.Minute)
+
+	if _, err := store.CancelPurchase(ctx, customer.StoreID, customer.UserID, orderID, nil, "too late"); !errors.Is(err, ErrCancelWindowClosed) {
+		t.Fatalf("expected ErrCancelWindowClosed, got %v", err)
+	}
+	if _, err := store.RefundPurchase(ctx, merchant.StoreID+1, merchant.UserID, orderID, nil, "wrong store"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected another store's order to be hidden, got %v", err)
+	}
+	order, err := store.RefundPurchase(ctx, merchant.StoreID, merchant.UserID, orderID, nil, "damaged in transit")
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if order.Status != OrderStatusRefunded || order.Items[0].Status != OrderItemRefunded {
+		t.Fatalf("expected a refunded order, got %+v", order)
+	}
+	available, err := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	if err != nil || len(available) != len(pets) {
+		t.Fatalf("expected the refunded pet to be listed again, got %d (%v)", len(available), err)
+	}
+}
+
+func TestMemoryStoreLimitsCartHolds(t *testing.T) {
+	store, merchant, customer := newTestMemoryStore(t)
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i <= MaxCartHolds; i++ {
+		pet, err := store.CreatePet(ctx, merchant.StoreID, Pet{
+			Name: "Pip", Species: Species{Slug: "frog"}, AgeYears: 1, PictureURL: "https://example.com/pip.jpg", Description: "Small.",
+			BreederName: "Ann", BreederEmail: "ann@example.com", Price: Money{Amount: 100},
+		})
+		if err != nil {
+			t.Fatalf("create pet: %v", err)
+		}
+		ids = append(ids, pet.ID)
+	}
+	for _, id := range ids[:MaxCartHolds] {
+		if _, err := store.AddToCart(ctx, customer.StoreID, customer.UserID, id); err != nil {
+			t.Fatalf("add to cart: %v", err)
+		}
+	}
+	if _, err := store.AddToCart(ctx, customer.StoreID, customer.UserID, ids[MaxCartHolds]); !errors.Is(err, ErrCartFull) {
+		t.Fatalf("expected ErrCartFull, got %v", err)
+	}
+	if _, err := store.AddToCart(ctx, customer.StoreID, customer.UserID, ids[0]); err != nil {
+		t.Fatalf("expected a held pet's hold to be extended, got %v", err)
+	}
+	if err := store.RemoveFromCart(ctx, customer.StoreID, customer.UserID, ids[0]); err != nil {
+		t.Fatalf("remove from cart: %v", err)
+	}
+	if _, err := store.AddToCart(ctx, customer.StoreID, customer.UserID, ids[MaxCartHolds]); err != nil {
+		t.Fatalf("expected room after removing a pet, got %v", err)
+	}
+}
+
+// newTestCustomer registers another customer of the demo store.
+func newTestCustomer(t *testing.T, store *MemoryStore, username string) *auth.Principal {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := store.RegisterCustomer(ctx, "demo", Registration{Username: username, Password: "long_enough_pw", Email: username + "@example.com"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	customer, err := store.Authenticate(ctx, username, "long_enough_pw")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	return customer
+}
+
+func TestMemoryStoreCartHolds(t *testing.T) {
+	store, _, customer := newTestMemoryStore(t)
+	other := newTestCustomer(t, store, "other")
+	ctx := context.Background()
+	pets, _ := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+
+	item, err := store.AddToCart(ctx, customer.StoreID, customer.UserID, pets[0].ID)
+	if err != nil || item.Pet.ID != pets[0].ID || !item.ExpiresAt.After(time.Now()) {
+		t.Fatalf("unexpected hold %+v (%v)", item, err)
+	}
+	if _, err := store.AddToCart(ctx, other.StoreID, other.UserID, pets[0].ID); !errors.Is(err, ErrPetHeld) {
+		t.Fatalf("expected ErrPetHeld for another customer, got %v", err)
+	}
+	result, err := store.PurchasePets(ctx, other.StoreID, other.UserID, []string{pets[0].ID})
+	if err != nil || len(result.PurchasedIDs) != 0 || len(result.Errors) != 1 {
+		t.Fatalf("expected the held pet to be refused to another buyer, got %+v (%v)", result, err)
+	}
+
+	visible, err := store.ListAvailablePets(ctx, other.StoreID, other.UserID)
+	if err != nil || len(visible) != len(pets)-1 {
+		t.Fatalf("expected the held pet to be hidden from others, got %d pets (%v)", len(visible), err)
+	}
+	for _, pet := range visible {
+		if pet.ID == pets[0].ID {
+			t.Fatal("held pet listed for another customer")
+		}
+	}
+	if visible, _ := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID); len(visible) != len(pets) {
+		t.Fatalf("expected the holder to still see every pet, got %d", len(visible))
+	}
+
+	// Let the hold lapse; the other customer can take it over.
+	store.holds[pets[0].ID].expiresAt = time.Now().Add(-time.Second)
+	if cart, err := store.GetCart(ctx, customer.StoreID, customer.UserID); err != nil || len(cart.Items) != 0 {
+		t.Fatalf("expected an expired hold to leave the cart, got %+v (%v)", cart, err)
+	}
+	if _, err := store.AddToCart(ctx, other.StoreID, other.UserID, pets[0].ID); err != nil {
+		t.Fatalf("expected the expired hold to be taken over, got %v", err)
+	}
+	if _, err := store.AddToCart(ctx, customer.StoreID, customer.UserID, pets[0].ID); !errors.Is(err, ErrPetHeld) {
+		t.Fatalf("expected the original holder to be refused now, got %v", err)
+	}
+	if err := store.RemoveFromCart(ctx, customer.StoreID, customer.UserID, pets[0].ID); err != nil {
+		t.Fatalf("remove another's hold: %v", err)
+	}
+	if cart, _ := store.GetCart(ctx, other.StoreID, other.UserID); len(cart.Items) != 1 {
+		t.Fatalf("expected removing someone else's hold to do nothing, got %+v", cart)
+	}
+
+	store.holds[pets[0].ID].expiresAt = time.Now().Add(-time.Second)
+	if n, err := store.ReleaseExpiredHolds(ctx); err != nil || n != 1 {
+		t.Fatalf("expected one hold released, got %d (%v)", n, err)
+	}
+	if _, ok := store.holds[pets[0].ID]; ok {
+		t.Fatal("expired hold still stored")
+	}
+}
+
+func TestMemoryStoreCheckoutCart(t *testing.T) {
+	store, _, customer := newTestMemoryStore(t)
+	other := newTestCustomer(t, store, "other")
+	ctx := context.Background()
+	pets, _ := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+
+	if _, err := store.CheckoutCart(ctx, customer.StoreID, customer.UserID, "c1"); err == nil {
+		t.Fatal("expected checking out an empty cart to fail")
+	}
+	for _, pet := range pets[:2] {
+		if _, err := store.AddToCart(ctx, customer.StoreID, customer.UserID, pet.ID); err != nil {
+			t.Fatalf("add to cart: %v", err)
+		}
+	}
+	cart, err := store.GetCart(ctx, customer.StoreID, customer.UserID)
+	if err != nil || len(cart.Items) != 2 || cart.Items[0].Pet.ID != pets[0].ID {
+		t.Fatalf("unexpected cart %+v (%v)", cart, err)
+	}
+	if want := pets[0].Price.Amount + pets[1].Price.Amount; cart.Subtotal().Amount != want {
+		t.Fatalf("subtotal = %d, want %d", cart.Subtotal().Amount, want)
+	}
+
+	first, err := store.CheckoutCart(ctx, customer.StoreID, customer.UserID, "c1")
+	if err != nil || len(first.PurchasedIDs) != 2 || first.Order == nil {
+		t.Fatalf("unexpected checkout %+v (%v)", first, err)
+	}
+	if cart, _ := store.GetCart(ctx, customer.StoreID, customer.UserID); len(cart.Items) != 0 {
+		t.Fatalf("expected checkout to empty the cart, got %+v", cart)
+	}
+	replay, err := store.CheckoutCart(ctx, customer.StoreID, customer.UserID, "c1")
+	if err != nil || replay.Order == nil || replay.Order.ID != first.Order.ID || len(replay.PurchasedIDs) != 2 {
+		t.Fatalf("expected the replay to return the original order, got %+v (%v)", replay, err)
+	}
+	if orders, _ := store.PageCustomerOrders(ctx, customer.StoreID, customer.UserID, PageRequest{}); len(orders.Edges) != 1 {
+		t.Fatalf("expected one order after the replay, got %d", len(orders.Edges))
+	}
+	if _, err := store.AddToCart(ctx, other.StoreID, other.UserID, pets[0].ID); !errors.Is(err, ErrPetUnavailable) {
+		t.Fatalf("expected a sold pet to be unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+)
+
+type memWebhookEndpoint struct {
+	endpoint    WebhookEndpoint
+	secretEnc   []byte
+	secretNonce []byte
+}
+
+type memWebhookDelivery struct {
+	delivery      WebhookDelivery
+	storeID       int64
+	nextAttemptAt time.Time
+}
+
+func (m *MemoryStore) CreateWebhookEndpoint(ctx context.Context, storeID int64, input WebhookEndpointInput) (WebhookEndpoint, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	endpoint, secret, err := m.createWebhookEndpoint(ctx, storeID, input)
+	if err != nil {
+		m.recordAuditFailure(storeAuditEvent(ctx, AuditCreateWebhook, storeID), err)
+	}
+	return endpoint, secret, err
+}
+
+func (m *MemoryStore) createWebhookEndpoint(ctx context.Context, storeID int64, input WebhookEndpointInput) (WebhookEndpoint, string, error) {
+	input, err := input.normalize()
+	if err != nil {
+		return WebhookEndpoint{}, "", err
+	}
+	count := 0
+	for _, e := range m.webhookEndpoints {
+		if e.endpoint.StoreID == storeID {
+			count++
+		}
+	}
+	if count >= MaxWebhookEndpoints {
+		return WebhookEndpoint{}, "", ErrTooManyWebhookEndpoints
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return WebhookEndpoint{}, "", err
+	}
+	secretEnc, nonce, err := m.crypto.Encrypt(secret)
+	if err != nil {
+		return WebhookEndpoint{}, "", err
+	}
+	endpoint := WebhookEndpoint{
+		ID:          newUUID(),
+		StoreID:     storeID,
+		URL:         input.URL,
+		Description: input.Description,
+		Events:      input.Events,
+		Active:      true,
+		CreatedAt:   time.Now(),
+	}
+	m.webhookEndpoints = append(m.webhookEndpoints, &memWebhookEndpoint{endpoint: endpoint, secretEnc: secretEnc, secretNonce: nonce})
+	m.appendAudit(storeAuditEvent(ctx, AuditCreateWebhook, storeID, endpoint.ID))
+	return endpoint, secret, nil
+}
+
+func (m *MemoryStore) UpdateWebhookEndpoint(ctx context.Context, storeID int64, id string, patch WebhookEndpointPatch) (WebhookEndpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	endpoint, err := m.updateWebhookEndpoint(ctx, storeID, id, patch)
+	if err != nil {
+		m.recordAuditFailure(storeAuditEvent(ctx, AuditUpdateWebhook, storeID, id), err)
+	}
+	return endpoint, err
+}
+
+func (m *MemoryStore) updateWebhookEndpoint(ctx context.Context, storeID int64, id string, patch WebhookEndpointPatch) (WebhookEndpoint, error) {
+	e := m.webhookEndpoint(storeID, id)
+	if e == nil {
+		return WebhookEndpoint{}, ErrWebhookEndpointNotFound
+	}
+	endpoint, detail, err := patch.apply(e.endpoint)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	e.endpoint = endpoint
+	event := storeAuditEvent(ctx, AuditUpdateWebhook, storeID, id)
+	event.Detail = detail
+	m.appendAudit(event)
+	return endpoint, nil
+}
+
+func (m *MemoryStore) DeleteWebhookEndpoint(ctx context.Context, storeID int64, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.webhookEndpoint(storeID, id) == nil {
+		err := ErrWebhookEndpointNotFound
+		m.recordAuditFailure(storeAuditEvent(ctx, AuditDeleteWebhook, storeID, id), err)
+		return err
+	}
+	m.webhookEndpoints = slices.DeleteFunc(m.webhookEndpoints, func(e *memWebhookEndpoint) bool {
+		return e.endpoint.ID == id
+	})
+	m.webhookDeliveries = slices.DeleteFunc(m.webhookDeliveries, func(d *memWebhookDelivery) bool {
+		return d.delivery.EndpointID == id
+	})
+	m.appendAudit(storeAuditEvent(ctx, AuditDeleteWebhook, storeID, id))
+	return nil
+}
+
+func (m *MemoryStore) ListWebhookEndpoints(ctx context.Context, storeID int64) ([]WebhookEndpoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var endpoints []WebhookEndpoint
+	for _, e := range m.webhookEndpoints {
+		if e.endpoint.StoreID == storeID {
+			endpoints = append(endpoints, e.endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+func (m *MemoryStore) PageWebhookDeliveries(ctx context.Context, storeID int64, filter WebhookDeliveryFilter, page PageRequest) (WebhookDeliveryPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []memRow[WebhookDelivery]
+	for _, d := range m.webhookDeliveries {
+		switch {
+		case d.storeID != storeID,
+			filter.EndpointID != "" && d.delivery.EndpointID != filter.EndpointID,
+			filter.Status != nil && d.delivery.Status != *filter.Status:
+			continue
+		}
+		rows = append(rows, memRow[WebhookDelivery]{key: timeKey(&d.delivery.CreatedAt), id: d.delivery.ID, item: d.delivery})
+	}
+	rows, info, err := pageMemory(rows, true, page)
+	if err != nil {
+		return WebhookDeliveryPage{}, err
+	}
+	result := WebhookDeliveryPage{PageInfo: info}
+	for _, r := range rows {
+		result.Edges = append(result.Edges, WebhookDeliveryEdge{Cursor: encodeCursor(r.key, r.id), Delivery: r.item})
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) RedeliverWebhook(ctx context.Context, storeID int64, deliveryID string) (WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var original *memWebhookDelivery
+	for _, d := range m.webhookDeliveries {
+		if d.storeID == storeID && d.delivery.ID == deliveryID {
+			original = d
+		}
+	}
+	if original == nil {
+		err := ErrWebhookDeliveryNotFound
+		m.recordAuditFailure(storeAuditEvent(ctx, AuditRedeliverWebhook, storeID, deliveryID), err)
+		return WebhookDelivery{}, err
+	}
+	if !m.webhookEndpoint(storeID, original.delivery.EndpointID).endpoint.Active {
+		err := ErrWebhookEndpointInactive
+		m.recordAuditFailure(storeAuditEvent(ctx, AuditRedeliverWebhook, storeID, deliveryID), err)
+		return WebhookDelivery{}, err
+	}
+	d := m.addWebhookDelivery(storeID, original.delivery.EndpointID, original.delivery.EventID,
+		original.delivery.Event, original.delivery.Payload)
+	m.appendAudit(storeAuditEvent(ctx, AuditRedeliverWebhook, storeID, deliveryID, d.ID))
+	return d, nil
+}
+
+func (m *MemoryStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var due []*memWebhookDelivery
+	for _, d := range m.webhookDeliveries {
+		if d.delivery.Status == WebhookPending && !d.nextAttemptAt.After(now) &&
+			m.webhookEndpoint(d.storeID, d.delivery.EndpointID).endpoint.Active {
+			due = append(due, d)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].nextAttemptAt.Before(due[j].nextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	jobs := make([]WebhookJob, 0, len(due))
+	for _, d := range due {
+		e := m.webhookEndpoint(d.storeID, d.delivery.EndpointID)
+		d.delivery.Attempts++
+		d.nextAttemptAt = now.Add(lease)
+		jobs = append(jobs, WebhookJob{
+			DeliveryID:  d.delivery.ID,
+			EventID:     d.delivery.EventID,
+			Event:       d.delivery.Event,
+			URL:         e.endpoint.URL,
+			SecretEnc:   e.secretEnc,
+			SecretNonce: e.secretNonce,
+			Payload:     d.delivery.Payload,
+			Attempts:    d.delivery.Attempts,
+		})
+	}
+	return jobs, nil
+}
+
+func (m *MemoryStore) CompleteWebhookDelivery(ctx context.Context, id string, attempts int, responseStatus int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.pendingWebhookDelivery(id, attempts)
+	if d == nil {
+		return ErrLeaseExpired
+	}
+	now := time.Now()
+	d.delivery.Status = WebhookSucceeded
+	d.delivery.ResponseStatus = &responseStatus
+	d.delivery.LastError = ""
+	d.delivery.CompletedAt = &now
+	return nil
+}
+
+func (m *MemoryStore) FailWebhookDelivery(ctx context.Context, id string, attempts int, responseStatus *int, cause string, retryAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.pendingWebhookDelivery(id, attempts)
+	if d == nil {
+		return ErrLeaseExpired
+	}
+	d.delivery.ResponseStatus = responseStatus
+	d.delivery.LastError = cause
+	if retryAt == nil {
+		now := time.Now()
+		d.delivery.Status = WebhookFailed
+		d.delivery.CompletedAt = &now
+		return nil
+	}
+	d.nextAttemptAt = *retryAt
+	return nil
+}
+
+func (m *MemoryStore) DeleteOldWebhookDeliveries(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoff := time.Now().Add(-webhookRetention)
+	kept := m.webhookDeliveries[:0]
+	var n int64
+	for _, d := range m.webhookDeliveries {
+		if d.delivery.Status != WebhookPending && !d.delivery.CompletedAt.After(cutoff) {
+			n++
+			continue
+		}
+		kept = append(kept, d)
+	}
+	m.webhookDeliveries = kept
+	return n, nil
+}
+
+func (m *MemoryStore) webhookEndpoint(storeID int64, id string) *memWebhookEndpoint {
+	for _, e := range m.webhookEndpoints {
+		if e.endpoint.StoreID == storeID && e.endpoint.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// pendingWebhookDelivery finds the delivery if it is still pending under
+// the claim that made attempts.
+func (m *MemoryStore) pendingWebhookDelivery(id string, attempts int) *memWebhookDelivery {
+	for _, d := range m.webhookDeliveries {
+		if d.delivery.ID == id && d.delivery.Status == WebhookPending && d.delivery.Attempts == attempts {
+			return d
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) addWebhookDelivery(storeID int64, endpointID, eventID string, event WebhookEvent, payload []byte) WebhookDelivery {
+	now := time.Now()
+	d := &memWebhookDelivery{
+		delivery: WebhookDelivery{
+			ID:         newUUID(),
+			EndpointID: endpointID,
+			EventID:    eventID,
+			Event:      event,
+			Payload:    payload,
+			Status:     WebhookPending,
+			CreatedAt:  now,
+		},
+		storeID:       storeID,
+		nextAttemptAt: now,
+	}
+	m.webhookDeliveries = append(m.webhookDeliveries, d)
+	return d.delivery
+}
+
+// enqueueWebhooks is the in-memory enqueueWebhooks.
+func (m *MemoryStore) enqueueWebhooks(event WebhookEvent, storeID int64, petIDs []string) {
+	var endpoints []string
+	for _, e := range m.webhookEndpoints {
+		if e.endpoint.StoreID == storeID && e.endpoint.Active && slices.Contains(e.endpoint.Events, event) {
+			endpoints = append(endpoints, e.endpoint.ID)
+		}
+	}
+	if len(endpoints) == 0 {
+		return
+	}
+	store := m.stores[storeID]
+	for _, id := range petIDs {
+		// The payload has no breeder email, so there is nothing to decrypt.
+		pet := m.pets[id].pet
+		pet.Price.Currency = store.currency
+		pet.Species = store.speciesInfo(pet.Species.Slug)
+		eventID, payload := newWebhookPayload(event, pet)
+		for _, endpointID := range endpoints {
+			m.addWebhookDelivery(storeID, endpointID, eventID, event, payload)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+)
+
+type memFavorite struct {
+	customerID  int64
+	petID       string
+	favoritedAt time.Time
+}
+
+type memSavedSearch struct {
+	storeID    int64
+	customerID int64
+	search     SavedSearch
+}
+
+type memNotification struct {
+	storeID    int64
+	customerID int64
+	petID      string
+	notice     Notification
+}
+
+func (m *MemoryStore) FavoritePet(ctx context.Context, storeID int64, customerID int64, petID string) (Pet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pets[petID]
+	if !ok || p.pet.StoreID != storeID {
+		return Pet{}, ErrPetNotFound
+	}
+	if p.pet.PurchasedAt != nil || p.pet.ArchivedAt != nil {
+		return Pet{}, ErrFavoriteUnavailable
+	}
+	if !slices.ContainsFunc(m.favorites, func(f *memFavorite) bool {
+		return f.customerID == customerID && f.petID == petID
+	}) {
+		m.favorites = append(m.favorites, &memFavorite{customerID: customerID, petID: petID, favoritedAt: time.Now()})
+	}
+	return m.readPet(p)
+}
+
+func (m *MemoryStore) UnfavoritePet(ctx context.Context, storeID int64, customerID int64, petID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.pets[petID]; !ok || p.pet.StoreID != storeID {
+		return nil
+	}
+	m.favorites = slices.DeleteFunc(m.favorites, func(f *memFavorite) bool {
+		return f.customerID == customerID && f.petID == petID
+	})
+	return nil
+}
+
+func (m *MemoryStore) ListFavorites(ctx context.Context, storeID int64, customerID int64) ([]Pet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var favorites []*memFavorite
+	for _, f := range m.favorites {
+		if p := m.pets[f.petID]; f.customerID == customerID && p != nil && p.pet.StoreID == storeID {
+			favorites = append(favorites, f)
+		}
+	}
+	sort.SliceStable(favorites, func(i, j int) bool {
+		a, b := favorites[i], favorites[j]
+		if !a.favoritedAt.Equal(b.favoritedAt) {
+			return a.favoritedAt.After(b.favoritedAt)
+		}
+		return a.petID < b.petID
+	})
+	pets := make([]Pet, 0, len(favorites))
+	for _, f := range favorites {
+		pet, err := m.readPet(m.pets[f.petID])
+		if err != nil {
+			return nil, err
+		}
+		pets = append(pets, pet)
+	}
+	return pets, nil
+}
+
+func (m *MemoryStore) CreateSavedSearch(ctx context.Context, storeID int64, customerID int64, name string, filter PetFilter) (SavedSearch, error) {
+	filter = filter.normalize()
+	if err := validateSavedSearch(name, filter); err != nil {
+		return SavedSearch{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, ss := range m.searches {
+		if ss.customerID == customerID {
+			count++
+		}
+	}
+	if count >= MaxSavedSearches {
+		return SavedSearch{}, ErrTooManySavedSearches
+	}
+	saved := SavedSearch{ID: newUUID(), Name: name, Filter: filter.clone(), CreatedAt: time.Now()}
+	m.searches = append(m.searches, &memSavedSearch{storeID: storeID, customerID: customerID, search: saved})
+	saved.Filter = saved.Filter.clone()
+	return saved, nil
+}
+
+func (m *MemoryStore) ListSavedSearches(ctx context.Context, storeID int64, customerID int64) ([]SavedSearch, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var searches []SavedSearch
+	for _, ss := range m.searches {
+		if ss.storeID == storeID && ss.customerID == customerID {
+			saved := ss.search
+			saved.Filter = saved.Filter.clone()
+			searches = append(searches, saved)
+		}
+	}
+	return searches, nil
+}
+
+func (m *MemoryStore) DeleteSavedSearch(ctx context.Context, storeID int64, customerID int64, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.searches, func(ss *memSavedSearch) bool {
+		return ss.storeID == storeID && ss.customerID == customerID && ss.search.ID == id
+	})
+	if i < 0 {
+		return ErrSavedSearchNotFound
+	}
+	m.searches = slices.Delete(m.searches, i, i+1)
+	for _, n := range m.notices {
+		if n.notice.SavedSearchID == id {
+			n.notice.SavedSearchID = ""
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) PageNotifications(ctx context.Context, storeID int64, customerID int64, unreadOnly bool, page PageRequest) (NotificationPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []memRow[*memNotification]
+	for _, n := range m.notices {
+		if n.storeID != storeID || n.customerID != customerID || unreadOnly && n.notice.ReadAt != nil {
+			continue
+		}
+		rows = append(rows, memRow[*memNotification]{key: timeKey(&n.notice.CreatedAt), id: n.notice.ID, item: n})
+	}
+	rows, info, err := pageMemory(rows, true, page)
+	if err != nil {
+		return NotificationPage{}, err
+	}
+	result := NotificationPage{PageInfo: info}
+	for _, r := range rows {
+		n := r.item.notice
+		if n.Pet, err = m.readPet(m.pets[r.item.petID]); err != nil {
+			return NotificationPage{}, err
+		}
+		result.Edges = append(result.Edges, NotificationEdge{Cursor: encodeCursor(r.key, r.id), Notification: n})
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) CountUnreadNotifications(ctx context.Context, storeID int64, customerID int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	count := 0
+	for _, n := range m.notices {
+		if n.storeID == storeID && n.customerID == customerID && n.notice.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) MarkNotificationsRead(ctx context.Context, storeID int64, customerID int64, ids []string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	marked := 0
+	for _, n := range m.notices {
+		if n.storeID != storeID || n.customerID != customerID || n.notice.ReadAt != nil {
+			continue
+		}
+		if ids != nil && !slices.Contains(ids, n.notice.ID) {
+			continue
+		}
+		readAt := now
+		n.notice.ReadAt = &readAt
+		marked++
+	}
+	return marked, nil
+}
+
+// notifySavedSearches is the in-memory notifySavedSearches.
+func (m *MemoryStore) notifySavedSearches(storeID int64, petID string) {
+	p := m.pets[petID]
+	for _, ss := range m.searches {
+		if ss.storeID != storeID {
+			continue
+		}
+		match, err := m.availableMatcher(storeID, ss.customerID, ss.search.Filter, true)
+		if err != nil || !match(p) {
+			continue
+		}
+		m.addNotification(storeID, ss.customerID, petID, Notification{
+			Kind:          NotificationSavedSearchMatch,
+			Message:       savedSearchMessage(p.pet.Name, ss.search.Name),
+			SavedSearchID: ss.search.ID,
+		})
+	}
+}
+
+// notifyFavoritesSold is the in-memory notifyFavoritesSold.
+func (m *MemoryStore) notifyFavoritesSold(storeID int64, buyerID int64, petIDs []string) {
+	for _, f := range m.favorites {
+		if f.customerID == buyerID || !slices.Contains(petIDs, f.petID) {
+			continue
+		}
+		p := m.pets[f.petID]
+		m.addNotification(storeID, f.customerID, f.petID, Notification{
+			Kind:    NotificationFavoriteSold,
+			Message: favoriteSoldMessage(p.pet.Name),
+		})
+	}
+}
+
+func (m *MemoryStore) addNotification(storeID int64, customerID int64, petID string, n Notification) {
+	n.ID = newUUID()
+	n.CreatedAt = time.Now()
+	m.notices = append(m.notices, &memNotification{storeID: storeID, customerID: customerID, petID: petID, notice: n})
+}
+
+// clone copies the filter so that a saved search does not share its
+// species or bounds with the caller.
+func (f PetFilter) clone() PetFilter {
+	f.Species = slices.Clone(f.Species)
+	if f.MinAge != nil {
+		minAge := *f.MinAge
+		f.MinAge = &minAge
+	}
+	if f.MaxAge != nil {
+		maxAge := *f.MaxAge
+		f.MaxAge = &maxAge
+	}
+	if f.CreatedAfter != nil {
+		after := *f.CreatedAfter
+		f.CreatedAfter = &after
+	}
+	return f
+}
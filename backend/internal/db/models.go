@@ -2,28 +2,74 @@ package db
 
 import "time"
 
-type Species string
+// StoreInfo is a store's public metadata.
+type StoreInfo struct {
+	ID       int64
+	Slug     string
+	Name     string
+	Currency string
+	// Active is false once a platform admin deactivates the store. Its data
+	// is kept, but customers can no longer sign in or buy.
+	Active bool
+}
 
-const (
-	SpeciesCat  Species = "CAT"
-	SpeciesDog  Species = "DOG"
-	SpeciesFrog Species = "FROG"
-)
+// Species is a kind of pet a store sells. Every store keeps its own list,
+// and Parent optionally groups a species under a category such as
+// Reptiles. Pets refer to their species by Slug.
+type Species struct {
+	Slug   string
+	Name   string
+	Parent *Species
+}
+
+// Picture is a photo uploaded for a pet. The image and its thumbnail are
+// kept in blob storage; Key names the full-size image.
+type Picture struct {
+	Key    string
+	Width  int
+	Height int
+}
 
 type Pet struct {
-	ID           string
-	StoreID      int64
-	Name         string
-	Species      Species
-	AgeYears     int
+	ID       string
+	StoreID  int64
+	Name     string
+	Species  Species
+	AgeYears int
+	// A pet shows either an external PictureURL or an uploaded Picture.
 	PictureURL   string
+	Picture      *Picture
 	Description  string
 	BreederName  string
 	BreederEmail string
+	Price        Money
 	CreatedAt    time.Time
 	PurchasedAt  *time.Time
+	ArchivedAt   *time.Time
+	Version      int
+}
+
+// PetPatch holds a partial update; nil fields are left unchanged.
+type PetPatch struct {
+	Name         *string
+	Species      *string
+	AgeYears     *int
+	PictureURL   *string
+	Picture      *Picture
+	Description  *string
+	BreederName  *string
+	BreederEmail *string
+	PriceMinor   *int64
 }
 
+type ArchiveFilter string
+
+const (
+	ArchiveFilterActive   ArchiveFilter = "ACTIVE"
+	ArchiveFilterArchived ArchiveFilter = "ARCHIVED"
+	ArchiveFilterAll      ArchiveFilter = "ALL"
+)
+
 type PurchaseError struct {
 	PetName string
 	Message string
@@ -32,4 +78,67 @@ type PurchaseError struct {
 type PurchaseResult struct {
 	PurchasedIDs []string
 	Errors       []PurchaseError
+	Order        *Order
+}
+
+// Subtotal is the sum of the locked prices of the pets actually bought.
+func (r PurchaseResult) Subtotal() *Money {
+	if r.Order == nil {
+		return nil
+	}
+	return &r.Order.Subtotal
+}
+
+func (r PurchaseResult) Total() *Money {
+	if r.Order == nil {
+		return nil
+	}
+	return &r.Order.Total
+}
+
+type OrderStatus string
+
+const (
+	OrderStatusPlaced             OrderStatus = "PLACED"
+	OrderStatusPartiallyCancelled OrderStatus = "PARTIALLY_CANCELLED"
+	OrderStatusCancelled          OrderStatus = "CANCELLED"
+	OrderStatusPartiallyRefunded  OrderStatus = "PARTIALLY_REFUNDED"
+	OrderStatusRefunded           OrderStatus = "REFUNDED"
+)
+
+type OrderItemStatus string
+
+const (
+	OrderItemPurchased OrderItemStatus = "PURCHASED"
+	OrderItemCancelled OrderItemStatus = "CANCELLED"
+	OrderItemRefunded  OrderItemStatus = "REFUNDED"
+)
+
+type Order struct {
+	ID               string
+	StoreID          int64
+	CustomerID       int64
+	CustomerUsername string
+	Status           OrderStatus
+	PlacedAt         time.Time
+	Subtotal         Money
+	Total            Money
+	Items            []OrderItem
+}
+
+// OrderItem keeps the price the pet sold for, independent of later edits.
+// A cancelled or refunded item stays in the order with its Reversal.
+type OrderItem struct {
+	Pet       Pet
+	UnitPrice Money
+	Status    OrderItemStatus
+	Reversal  *Reversal
+}
+
+type Reversal struct {
+	At            time.Time
+	ActorRole     string
+	ActorID       int64
+	ActorUsername string
+	Reason        string
 }
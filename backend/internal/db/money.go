@@ -0,0 +1,56 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Money is an amount in the currency's minor units (cents for USD).
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// zeroDecimalCurrencies have no minor unit, so Amount is whole units.
+var zeroDecimalCurrencies = map[string]bool{
+	"CLP": true, "ISK": true, "JPY": true, "KRW": true, "VND": true,
+}
+
+func (m Money) exponent() int {
+	if zeroDecimalCurrencies[m.Currency] {
+		return 0
+	}
+	return 2
+}
+
+// Decimal renders the amount in major units, e.g. "12.50".
+func (m Money) Decimal() string {
+	exp := m.exponent()
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}
+}
+
+func ValidateCurrency(code string) error {
+	if len(code) != 3 || strings.ToUpper(code) != code {
+		return errors.New("currency must be a three-letter ISO 4217 code")
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return errors.New("currency must be a three-letter ISO 4217 code")
+		}
+	}
+	return nil
+}
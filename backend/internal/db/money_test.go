@@ -0,0 +1,31 @@
+package db
+
+import "testing"
+
+func TestMoneyDecimal(t *testing.T) {
+	cases := []struct {
+		money Money
+		want  string
+	}{
+		{Money{Amount: 1250, Currency: "USD"}, "12.50"},
+		{Money{Amount: 5, Currency: "EUR"}, "0.05"},
+		{Money{Amount: -199, Currency: "USD"}, "-1.99"},
+		{Money{Amount: 1500, Currency: "JPY"}, "1500"},
+	}
+	for _, tc := range cases {
+		if got := tc.money.Decimal(); got != tc.want {
+			t.Fatalf("%+v: expected %q, got %q", tc.money, tc.want, got)
+		}
+	}
+}
+
+func TestValidateCurrency(t *testing.T) {
+	if err := ValidateCurrency("USD"); err != nil {
+		t.Fatalf("expected USD to be valid: %v", err)
+	}
+	for _, code := range []string{"", "usd", "US", "US1", "EURO"} {
+		if err := ValidateCurrency(code); err == nil {
+			t.Fatalf("expected %q to be rejected", code)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"nimble-challenge/backend/internal/events"
+)
+
+const (
+	eventChannel = "pet_events"
+	// maxNotifyPayload keeps notifications under Postgres's limit of 8000
+	// bytes.
+	maxNotifyPayload = 7900
+	// listenCheckInterval is how long the listener waits for a
+	// notification before making sure its connection is still alive.
+	listenCheckInterval = time.Minute
+	listenMinBackoff    = time.Second
+	listenMaxBackoff    = 30 * time.Second
+)
+
+// PostgresBus is an events.Bus shared by every instance on the database:
+// events are sent with NOTIFY and each instance hands what its LISTEN
+// connection receives to its own subscribers, its own events included.
+// Nothing is delivered until Listen runs.
+type PostgresBus struct {
+	pool *pgxpool.Pool
+	hub  *events.Hub
+}
+
+func (s *Store) NewEventBus() *PostgresBus {
+	return &PostgresBus{pool: s.pool, hub: events.NewHub()}
+}
+
+// notification is an event on the wire, kept short since the payload
+// limit caps how many pets one notification can name.
+type notification struct {
+	Kind       events.Kind `json:"k"`
+	StoreID    int64       `json:"s"`
+	PetIDs     []string    `json:"p"`
+	OrderID    string      `json:"o,omitempty"`
+	OccurredAt time.Time   `json:"t"`
+}
+
+func (b *PostgresBus) Publish(ctx context.Context, e events.Event) error {
+	payloads, err := notifyPayloads(e)
+	if err != nil {
+		return err
+	}
+	for _, payload := range payloads {
+		if _, err := b.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, eventChannel, payload); err != nil {
+			return fmt.Errorf("notify: %w", err)
+		}
+	}
+	return nil
+}
+
+func (b *PostgresBus) Subscribe(ctx context.Context, storeID int64) <-chan events.Event {
+	return b.hub.Subscribe(ctx, storeID)
+}
+
+// notifyPayloads encodes e, split into as many events as it takes to fit
+// each in a notification.
+func notifyPayloads(e events.Event) ([]string, error) {
+	payload, err := json.Marshal(notification{
+		Kind:       e.Kind,
+		StoreID:    e.StoreID,
+		PetIDs:     e.PetIDs,
+		OrderID:    e.OrderID,
+		OccurredAt: e.OccurredAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode event: %w", err)
+	}
+	if len(payload) <= maxNotifyPayload {
+		return []string{string(payload)}, nil
+	}
+	if len(e.PetIDs) < 2 {
+		return nil, errors.New("event too large to notify")
+	}
+	half := len(e.PetIDs) / 2
+	first, second := e, e
+	first.PetIDs, second.PetIDs = e.PetIDs[:half], e.PetIDs[half:]
+	payloads, err := notifyPayloads(first)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := notifyPayloads(second)
+	if err != nil {
+		return nil, err
+	}
+	return append(payloads, rest...), nil
+}
+
+func decodeNotification(payload string) (events.Event, error) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		return events.Event{}, fmt.Errorf("decode event: %w", err)
+	}
+	return events.Event{
+		Kind:       n.Kind,
+		StoreID:    n.StoreID,
+		PetIDs:     n.PetIDs,
+		OrderID:    n.OrderID,
+		OccurredAt: n.OccurredAt,
+	}, nil
+}
+
+// Listen receives events until ctx is done, reconnecting with backoff when
+// the connection drops. Notifications sent while it was disconnected are
+// lost, so every subscription is ended on reconnect for subscribers to
+// reread.
+func (b *PostgresBus) Listen(ctx context.Context) {
+	wait := listenMinBackoff
+	connected := false
+	for {
+		err := b.listen(ctx, func() {
+			if connected {
+				b.hub.Reset()
+			}
+			connected = true
+			wait = listenMinBackoff
+		})
+		if ctx.Err() != nil {
+			b.hub.Reset()
+			return
+		}
+		log.Printf("listen for events: %v; retrying in %s", err, wait)
+		select {
+		case <-ctx.Done():
+			b.hub.Reset()
+			return
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, listenMaxBackoff)
+	}
+}
+
+// listen runs one LISTEN session on a connection of its own, taken out of
+// the pool so it neither holds a pool slot nor gets recycled.
+func (b *PostgresBus) listen(ctx context.Context, started func()) error {
+	pooled, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire: %w", err)
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{eventChannel}.Sanitize()); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	started()
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, listenCheckInterval)
+		n, err := conn.WaitForNotification(waitCtx)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.Is(err, context.DeadlineExceeded):
+			if err := conn.Ping(ctx); err != nil {
+				return fmt.Errorf("ping: %w", err)
+			}
+			continue
+		case err != nil:
+			return fmt.Errorf("wait: %w", err)
+		}
+		e, err := decodeNotification(n.Payload)
+		if err != nil {
+			log.Printf("listen for events: %v", err)
+			continue
+		}
+		_ = b.hub.Publish(ctx, e)
+	}
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"nimble-challenge/backend/internal/events"
+)
+
+func TestNotifyPayloadsSplitLargeEvents(t *testing.T) {
+	e := events.Event{
+		Kind:       events.PetCreated,
+		StoreID:    7,
+		OrderID:    "order-1",
+		OccurredAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	for i := 0; i < 1000; i++ {
+		e.PetIDs = append(e.PetIDs, newUUID())
+	}
+	payloads, err := notifyPayloads(e)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if len(payloads) < 2 {
+		t.Fatalf("expected the event to be split, got %d payload", len(payloads))
+	}
+	var ids []string
+	for _, payload := range payloads {
+		if len(payload) > maxNotifyPayload {
+			t.Fatalf("payload of %d bytes", len(payload))
+		}
+		decoded, err := decodeNotification(payload)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if decoded.Kind != e.Kind || decoded.StoreID != e.StoreID || decoded.OrderID != e.OrderID || !decoded.OccurredAt.Equal(e.OccurredAt) {
+			t.Fatalf("unexpected event %+v", decoded)
+		}
+		ids = append(ids, decoded.PetIDs...)
+	}
+	if !slices.Equal(ids, e.PetIDs) {
+		t.Fatal("pet IDs were lost or reordered")
+	}
+}
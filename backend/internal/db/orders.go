@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrOrderNotFound = errors.New("order not found")
+
+type OrderEdge struct {
+	Cursor string
+	Order  Order
+}
+
+type OrderPage struct {
+	Edges []OrderEdge
+	PageInfo
+}
+
+var sortPlacedDesc = sortKey{expr: "o.placed_at", cast: "timestamptz", desc: true}
+
+const orderColumns = `o.id, o.store_id, o.customer_id, c.username, o.status, o.placed_at,
+		       o.currency, o.subtotal_minor, o.total_minor`
+
+func (s *Store) PageCustomerOrders(ctx context.Context, storeID int64, customerID int64, page PageRequest) (OrderPage, error) {
+	var q listQuery
+	q.where("o.store_id = %s AND o.customer_id = %s", storeID, customerID)
+	return s.pageOrders(ctx, q, page)
+}
+
+func (s *Store) PageStoreOrders(ctx context.Context, storeID int64, page PageRequest) (OrderPage, error) {
+	var q listQuery
+	q.where("o.store_id = %s", storeID)
+	return s.pageOrders(ctx, q, page)
+}
+
+func (s *Store) GetOrder(ctx context.Context, storeID int64, orderID string) (Order, error) {
+	var order Order
+	err := s.pool.QueryRow(ctx, `
+		SELECT `+orderColumns+`
+		FROM orders o
+		JOIN customers c ON c.id = o.customer_id
+		WHERE o.store_id = $1 AND o.id = $2
+	`, storeID, orderID).Scan(order.scanDest()...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Order{}, ErrOrderNotFound
+	}
+	if err != nil {
+		return Order{}, fmt.Errorf("query order: %w", err)
+	}
+	order.Total.Currency = order.Subtotal.Currency
+	if err := s.loadOrderItems(ctx, []*Order{&order}); err != nil {
+		return Order{}, err
+	}
+	return order, nil
+}
+
+// scanDest returns scan targets matching orderColumns.
+func (o *Order) scanDest() []any {
+	return []any{
+		&o.ID, &o.StoreID, &o.CustomerID, &o.CustomerUsername, &o.Status, &o.PlacedAt,
+		&o.Subtotal.Currency, &o.Subtotal.Amount, &o.Total.Amount,
+	}
+}
+
+func (s *Store) pageOrders(ctx context.Context, q listQuery, page PageRequest) (OrderPage, error) {
+	tail, limit, backward, err := q.keyset(sortPlacedDesc, "o.id", page)
+	if err != nil {
+		return OrderPage{}, err
+	}
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+		SELECT %s, (%s)::text
+		FROM orders o
+		JOIN customers c ON c.id = o.customer_id
+		WHERE %s
+		%s
+	`, orderColumns, sortPlacedDesc.expr, q.clause(), tail), q.args...)
+	if err != nil {
+		return OrderPage{}, fmt.Errorf("query orders: %w", err)
+	}
+	defer rows.Close()
+
+	var edges []OrderEdge
+	for rows.Next() {
+		var order Order
+		var key string
+		if err := rows.Scan(append(order.scanDest(), &key)...); err != nil {
+			return OrderPage{}, fmt.Errorf("scan order: %w", err)
+		}
+		order.Total.Currency = order.Subtotal.Currency
+		edges = append(edges, OrderEdge{Cursor: encodeCursor(key, order.ID), Order: order})
+	}
+	if err := rows.Err(); err != nil {
+		return OrderPage{}, fmt.Errorf("iterate orders: %w", err)
+	}
+
+	var result OrderPage
+	result.Edges, result.PageInfo = trimPage(edges, limit, backward, page, func(e OrderEdge) string { return e.Cursor })
+
+	orders := make([]*Order, 0, len(result.Edges))
+	for i := range result.Edges {
+		orders = append(orders, &result.Edges[i].Order)
+	}
+	if err := s.loadOrderItems(ctx, orders); err != nil {
+		return OrderPage{}, err
+	}
+	return result, nil
+}
+
+func (s *Store) loadOrderItems(ctx context.Context, orders []*Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Order, len(orders))
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		byID[order.ID] = order
+		ids = append(ids, order.ID)
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+petColumns+`, oi.order_id::text, oi.unit_price_minor, oi.currency, oi.status,
+		       oi.reversed_at, oi.reversed_by_role, oi.reversed_by_user_id, oi.reversal_reason,
+		       COALESCE(rm.username, rc.username)
+		FROM order_items oi
+		JOIN pets ON pets.id = oi.pet_id
+		LEFT JOIN merchants rm ON oi.reversed_by_role = 'merchant' AND rm.id = oi.reversed_by_user_id
+		LEFT JOIN customers rc ON oi.reversed_by_role = 'customer' AND rc.id = oi.reversed_by_user_id
+		WHERE oi.order_id = ANY($1::uuid[])
+		ORDER BY pets.name, pets.id
+	`, ids)
+	if err != nil {
+		return fmt.Errorf("query order items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderID string
+		var item OrderItem
+		var reversedAt *time.Time
+		var actorRole, reason, actorUsername *string
+		var actorID *int64
+		item.Pet, err = s.scanPet(rows, &orderID, &item.UnitPrice.Amount, &item.UnitPrice.Currency, &item.Status,
+			&reversedAt, &actorRole, &actorID, &reason, &actorUsername)
+		if err != nil {
+			return err
+		}
+		if reversedAt != nil {
+			item.Reversal = &Reversal{
+				At:            *reversedAt,
+				ActorRole:     deref(actorRole),
+				ActorID:       deref(actorID),
+				ActorUsername: deref(actorUsername),
+				Reason:        deref(reason),
+			}
+		}
+		if order, ok := byID[orderID]; ok {
+			order.Items = append(order.Items, item)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate order items: %w", err)
+	}
+	return nil
+}
+
+func deref[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// outboxRetention is how long sent messages are kept before
+// DeleteSentOutboxMessages removes them. Dead ones are kept until someone
+// looks at them.
+const outboxRetention = 7 * 24 * time.Hour
+
+// ErrLeaseExpired is an attempt recorded after its claim ran out: the job
+// is no longer pending, or has been claimed again and the newer claim
+// records the outcome.
+var ErrLeaseExpired = errors.New("claim has expired")
+
+type OutboxKind string
+
+const (
+	OutboxPetListed OutboxKind = "PET_LISTED"
+	OutboxPetSold   OutboxKind = "PET_SOLD"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "PENDING"
+	OutboxSent    OutboxStatus = "SENT"
+	OutboxDead    OutboxStatus = "DEAD"
+)
+
+// OutboxPayload is what a message's template is filled in with, captured
+// when the message is queued.
+type OutboxPayload struct {
+	PetID       string `json:"petId"`
+	PetName     string `json:"petName"`
+	BreederName string `json:"breederName"`
+	StoreName   string `json:"storeName"`
+	PriceMinor  int64  `json:"priceMinor"`
+	Currency    string `json:"currency"`
+}
+
+func (p OutboxPayload) Price() Money {
+	return Money{Amount: p.PriceMinor, Currency: p.Currency}
+}
+
+// OutboxMessage is a queued mail claimed for delivery. The recipient is
+// encrypted with the same Crypto as breeder emails. Attempts counts this
+// delivery.
+type OutboxMessage struct {
+	ID             string
+	StoreID        int64
+	Kind           OutboxKind
+	RecipientEnc   []byte
+	RecipientNonce []byte
+	Payload        OutboxPayload
+	Attempts       int
+	CreatedAt      time.Time
+}
+
+// ClaimOutboxMessages takes up to limit messages that are due and hides
+// them from other dispatchers for lease. A message whose dispatcher dies
+// before reporting back is claimed again once the lease runs out.
+func (s *Store) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	rows, err := s.pool.Query(ctx, `
+		WITH due AS (
+			SELECT id FROM outbox
+			WHERE status = 'PENDING' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE outbox o
+		SET attempts = o.attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM due
+		WHERE o.id = due.id
+		RETURNING o.id, o.store_id, o.kind, o.recipient_enc, o.recipient_nonce, o.payload, o.attempts, o.created_at
+	`, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var msg OutboxMessage
+		if err := rows.Scan(&msg.ID, &msg.StoreID, &msg.Kind, &msg.RecipientEnc, &msg.RecipientNonce,
+			&msg.Payload, &msg.Attempts, &msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan outbox message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate outbox: %w", err)
+	}
+	return messages, nil
+}
+
+// CompleteOutboxMessage records a delivery made under the claim that
+// brought the message's attempts to attempts.
+func (s *Store) CompleteOutboxMessage(ctx context.Context, id string, attempts int) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE outbox SET status = 'SENT', sent_at = NOW(), last_error = ''
+		WHERE id = $1 AND status = 'PENDING' AND attempts = $2
+	`, id, attempts)
+	if err != nil {
+		return fmt.Errorf("complete outbox message: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// FailOutboxMessage records a failed delivery. The message is retried at
+// retryAt, or moved to the dead-letter state when retryAt is nil. Like
+// CompleteOutboxMessage, it must come from the latest claim.
+func (s *Store) FailOutboxMessage(ctx context.Context, id string, attempts int, cause string, retryAt *time.Time) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE outbox
+		SET last_error = $3,
+		    status = CASE WHEN $4::timestamptz IS NULL THEN 'DEAD' ELSE 'PENDING' END,
+		    next_attempt_at = COALESCE($4, next_attempt_at)
+		WHERE id = $1 AND status = 'PENDING' AND attempts = $2
+	`, id, attempts, cause, retryAt)
+	if err != nil {
+		return fmt.Errorf("fail outbox message: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// DeleteSentOutboxMessages removes messages sent more than a week ago.
+func (s *Store) DeleteSentOutboxMessages(ctx context.Context) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `
+		DELETE FROM outbox WHERE status = 'SENT' AND sent_at <= NOW() - make_interval(secs => $1)
+	`, outboxRetention.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("delete sent outbox messages: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// enqueueBreederMail queues a message of the kind to the breeder of each
+// of the pets. The recipient is copied still encrypted.
+func enqueueBreederMail(ctx context.Context, tx pgx.Tx, kind OutboxKind, storeID int64, petIDs []string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO outbox (store_id, kind, recipient_enc, recipient_nonce, payload)
+		SELECT p.store_id, $3, p.breeder_email_enc, p.breeder_email_nonce,
+		       jsonb_build_object(
+		         'petId', p.id, 'petName', p.name, 'breederName', p.breeder_name,
+		         'storeName', s.name, 'priceMinor', p.price_minor, 'currency', s.currency
+		       )
+		FROM pets p
+		JOIN stores s ON s.id = p.store_id
+		WHERE p.store_id = $1 AND p.id = ANY($2::uuid[])
+	`, storeID, petIDs, kind)
+	if err != nil {
+		return fmt.Errorf("enqueue %s mail: %w", kind, err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest selects a window of a keyset-ordered list. First/After page
+// forward from the head of the ordering; Last/Before page backward.
+type PageRequest struct {
+	First  int
+	After  string
+	Last   int
+	Before string
+}
+
+type PageInfo struct {
+	HasNextPage     bool
+	HasPreviousPage bool
+	StartCursor     string
+	EndCursor       string
+}
+
+type PetEdge struct {
+	Cursor string
+	Pet    Pet
+}
+
+type PetPage struct {
+	Edges []PetEdge
+	PageInfo
+}
+
+// sortKey is the primary ordering of a keyset page; id is always the
+// tie-breaker. expr is trusted SQL and cast names the Postgres type its
+// text form is converted back to when a cursor is used.
+type sortKey struct {
+	expr string
+	cast string
+	desc bool
+}
+
+var (
+	sortCreatedDesc   = sortKey{expr: "created_at", cast: "timestamptz", desc: true}
+	sortPurchasedDesc = sortKey{expr: "purchased_at", cast: "timestamptz", desc: true}
+)
+
+type cursor struct {
+	Key string
+	ID  string
+}
+
+func encodeCursor(key, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key + "|" + id))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	i := strings.LastIndexByte(string(raw), '|')
+	if i < 0 || i == len(raw)-1 {
+		return cursor{}, ErrInvalidCursor
+	}
+	return cursor{Key: string(raw[:i]), ID: string(raw[i+1:])}, nil
+}
+
+func (r PageRequest) validate() (limit int, backward bool, err error) {
+	if r.First != 0 && r.Last != 0 {
+		return 0, false, errors.New("first and last cannot be combined")
+	}
+	if r.First < 0 || r.Last < 0 {
+		return 0, false, errors.New("page size must be positive")
+	}
+	backward = r.Last > 0 || (r.First == 0 && r.Before != "")
+	limit = r.First
+	if backward {
+		limit = r.Last
+	}
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	return limit, backward, nil
+}
+
+// listQuery accumulates trusted WHERE fragments and their bind arguments.
+type listQuery struct {
+	conds []string
+	args  []any
+}
+
+func (q *listQuery) arg(v any) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *listQuery) where(format string, values ...any) {
+	placeholders := make([]any, 0, len(values))
+	for _, v := range values {
+		placeholders = append(placeholders, q.arg(v))
+	}
+	q.conds = append(q.conds, fmt.Sprintf(format, placeholders...))
+}
+
+func (q *listQuery) clause() string {
+	if len(q.conds) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(q.conds, " AND ")
+}
+
+// keyset bounds q by the cursors in page and returns the ORDER BY / LIMIT
+// tail for a query ordered by sort with idExpr as the tie-breaker. One row
+// beyond limit is requested so trimPage can tell whether more exist.
+func (q *listQuery) keyset(sort sortKey, idExpr string, page PageRequest) (tail string, limit int, backward bool, err error) {
+	limit, backward, err = page.validate()
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	forwardOp, backwardOp := ">", "<"
+	if sort.desc {
+		forwardOp, backwardOp = "<", ">"
+	}
+	bound := func(encoded, op string) error {
+		c, err := decodeCursor(encoded)
+		if err != nil {
+			return err
+		}
+		q.where(fmt.Sprintf("(%s, %s) %s (%%s::%s, %%s::uuid)", sort.expr, idExpr, op, sort.cast), c.Key, c.ID)
+		return nil
+	}
+	if page.After != "" {
+		if err := bound(page.After, forwardOp); err != nil {
+			return "", 0, false, err
+		}
+	}
+	if page.Before != "" {
+		if err := bound(page.Before, backwardOp); err != nil {
+			return "", 0, false, err
+		}
+	}
+
+	direction := "ASC"
+	if sort.desc != backward {
+		direction = "DESC"
+	}
+	tail = fmt.Sprintf("ORDER BY %s %s, %s %s LIMIT %s", sort.expr, direction, idExpr, direction, q.arg(limit+1))
+	return tail, limit, backward, nil
+}
+
+// trimPage drops the look-ahead row, restores the requested order for
+// backward pages and fills in PageInfo.
+func trimPage[E any](edges []E, limit int, backward bool, page PageRequest, cursorOf func(E) string) ([]E, PageInfo) {
+	hasMore := len(edges) > limit
+	if hasMore {
+		edges = edges[:limit]
+	}
+	if backward {
+		for i, j := 0, len(edges)-1; i < j; i, j = i+1, j-1 {
+			edges[i], edges[j] = edges[j], edges[i]
+		}
+	}
+
+	var info PageInfo
+	if backward {
+		info.HasPreviousPage = hasMore
+		info.HasNextPage = page.Before != ""
+	} else {
+		info.HasNextPage = hasMore
+		info.HasPreviousPage = page.After != ""
+	}
+	if len(edges) > 0 {
+		info.StartCursor = cursorOf(edges[0])
+		info.EndCursor = cursorOf(edges[len(edges)-1])
+	}
+	return edges, info
+}
+
+// pagePets runs a keyset query over pets matching q, ordered by sort.
+func (s *Store) pagePets(ctx context.Context, q listQuery, sort sortKey, page PageRequest) (PetPage, error) {
+	tail, limit, backward, err := q.keyset(sort, "id", page)
+	if err != nil {
+		return PetPage{}, err
+	}
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+		SELECT %s, (%s)::text
+		FROM pets
+		WHERE %s
+		%s
+	`, petColumns, sort.expr, q.clause(), tail), q.args...)
+	if err != nil {
+		return PetPage{}, fmt.Errorf("query pets: %w", err)
+	}
+	defer rows.Close()
+
+	var edges []PetEdge
+	for rows.Next() {
+		var key string
+		pet, err := s.scanPet(rows, &key)
+		if err != nil {
+			return PetPage{}, err
+		}
+		edges = append(edges, PetEdge{Cursor: encodeCursor(key, pet.ID), Pet: pet})
+	}
+	if err := rows.Err(); err != nil {
+		return PetPage{}, fmt.Errorf("iterate pets: %w", err)
+	}
+
+	var result PetPage
+	result.Edges, result.PageInfo = trimPage(edges, limit, backward, page, func(e PetEdge) string { return e.Cursor })
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := "2024-05-01 12:30:00.123456+00"
+	encoded := encodeCursor(key, "2b1f0c1e-2f7a-4c43-9a0b-0d1a6a0e1f11")
+	decoded, err := decodeCursor(encoded)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded.Key != key || decoded.ID != "2b1f0c1e-2f7a-4c43-9a0b-0d1a6a0e1f11" {
+		t.Fatalf("unexpected cursor %+v", decoded)
+	}
+}
+
+func TestCursorKeyMayContainSeparator(t *testing.T) {
+	decoded, err := decodeCursor(encodeCursor("Salt|Pepper", "pet-1"))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded.Key != "Salt|Pepper" || decoded.ID != "pet-1" {
+		t.Fatalf("unexpected cursor %+v", decoded)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, input := range []string{"", "not-base64!", encodeCursor("key", "")} {
+		if _, err := decodeCursor(input); err == nil {
+			t.Fatalf("expected error for %q", input)
+		}
+	}
+}
+
+func TestPageRequestValidate(t *testing.T) {
+	if _, _, err := (PageRequest{First: 1, Last: 1}).validate(); err == nil {
+		t.Fatalf("expected error when combining first and last")
+	}
+	limit, backward, err := (PageRequest{}).validate()
+	if err != nil || limit != DefaultPageSize || backward {
+		t.Fatalf("unexpected defaults: %d %v %v", limit, backward, err)
+	}
+	limit, backward, err = (PageRequest{Last: 500}).validate()
+	if err != nil || limit != MaxPageSize || !backward {
+		t.Fatalf("unexpected clamp: %d %v %v", limit, backward, err)
+	}
+}
+
+func TestAvailablePetsQueryFacetsIgnoreSpecies(t *testing.T) {
+	minAge := 1
+	filter := PetFilter{Species: []string{"cat"}, MinAge: &minAge, Query: "kitten"}
+
+	q, err := availablePetsQuery(1, 2, filter, true)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if !strings.Contains(q.clause(), "sp.slug = ANY($3) OR parent.slug = ANY($4)") || len(q.args) != 6 {
+		t.Fatalf("unexpected search clause %q with %d args", q.clause(), len(q.args))
+	}
+
+	q, err = availablePetsQuery(1, 2, filter, false)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if strings.Contains(q.clause(), "species") || len(q.args) != 4 {
+		t.Fatalf("facet clause should not filter species: %q", q.clause())
+	}
+}
+
+func TestRelevanceSortRequiresQuery(t *testing.T) {
+	var q listQuery
+	if _, err := (PetSearch{Sort: PetSortRelevance}).sortKey(&q); err == nil {
+		t.Fatalf("expected error for relevance sort without query")
+	}
+}
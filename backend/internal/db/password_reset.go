@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"nimble-challenge/backend/internal/crypto"
+)
+
+const DefaultPasswordResetTTL = time.Hour
+
+var ErrInvalidResetToken = errors.New("reset token is invalid or has expired")
+
+// PasswordReset is a token to mail to a customer. Only the token's hash is
+// stored, so it cannot be read back later.
+type PasswordReset struct {
+	Token     string
+	Username  string
+	Email     string
+	StoreSlug string
+	StoreName string
+	ExpiresAt time.Time
+}
+
+// SetPasswordResetTTL changes how long reset tokens can be redeemed.
+func (s *Store) SetPasswordResetTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.resetTTL = ttl
+	}
+}
+
+// CreatePasswordReset issues a reset token for the customer with the
+// username. It reports false, without an error, if there is no such
+// customer, the customer has no email or the store is inactive, and
+// callers must not reveal which.
+func (s *Store) CreatePasswordReset(ctx context.Context, username string) (PasswordReset, bool, error) {
+	var (
+		profile              CustomerProfile
+		reset                = PasswordReset{Username: username}
+		emailEnc, emailNonce []byte
+		active               bool
+	)
+	err := s.pool.QueryRow(ctx, `
+		SELECT c.id, c.store_id, c.email_enc, c.email_nonce, s.slug, s.name, s.deactivated_at IS NULL
+		FROM customers c
+		JOIN stores s ON s.id = c.store_id
+		WHERE c.username = $1
+	`, username).Scan(&profile.ID, &profile.StoreID, &emailEnc, &emailNonce, &reset.StoreSlug, &reset.StoreName, &active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		event := newAuditEvent(ctx, AuditRequestPasswordReset)
+		event.ActorUsername = username
+		s.recordAuditFailure(ctx, event, errors.New("unknown customer"))
+		return PasswordReset{}, false, nil
+	}
+	if err != nil {
+		return PasswordReset{}, false, fmt.Errorf("query customer: %w", err)
+	}
+	profile.Username = username
+	if emailEnc == nil || !active {
+		s.recordAuditFailure(ctx, accountAuditEvent(ctx, AuditRequestPasswordReset, profile), errors.New("no email or store inactive"))
+		return PasswordReset{}, false, nil
+	}
+	if reset.Email, err = s.crypto.Decrypt(emailEnc, emailNonce); err != nil {
+		return PasswordReset{}, false, fmt.Errorf("decrypt email: %w", err)
+	}
+
+	token, hash, err := crypto.NewToken()
+	if err != nil {
+		return PasswordReset{}, false, err
+	}
+	reset.Token = token
+	reset.ExpiresAt = time.Now().Add(s.resetTTL)
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return PasswordReset{}, false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO password_reset_tokens (token_hash, customer_id, expires_at)
+		VALUES ($1, $2, $3)
+	`, hash, profile.ID, reset.ExpiresAt)
+	if err != nil {
+		return PasswordReset{}, false, fmt.Errorf("insert reset token: %w", err)
+	}
+	if err = insertAuditEvent(ctx, tx, accountAuditEvent(ctx, AuditRequestPasswordReset, profile)); err != nil {
+		return PasswordReset{}, false, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return PasswordReset{}, false, fmt.Errorf("commit: %w", err)
+	}
+	return reset, true, nil
+}
+
+// ResetPassword redeems a reset token. Every outstanding token of the
+// customer is spent with it. Basic Auth keeps no server-side sessions, so
+// the old password stops working with the next request.
+func (s *Store) ResetPassword(ctx context.Context, token, newPassword string) error {
+	err := s.resetPassword(ctx, token, newPassword)
+	if err != nil {
+		s.recordAuditFailure(ctx, newAuditEvent(ctx, AuditResetPassword), err)
+	}
+	return err
+}
+
+func (s *Store) resetPassword(ctx context.Context, token, newPassword string) (err error) {
+	if err := crypto.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+	hash, err := crypto.HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	var profile CustomerProfile
+	err = tx.QueryRow(ctx, `
+		SELECT c.id, c.store_id, c.username
+		FROM password_reset_tokens t
+		JOIN customers c ON c.id = t.customer_id
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > NOW()
+		FOR UPDATE OF t
+	`, crypto.HashToken(token)).Scan(&profile.ID, &profile.StoreID, &profile.Username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return fmt.Errorf("select reset token: %w", err)
+	}
+	if _, err = tx.Exec(ctx, `UPDATE customers SET password_hash = $2 WHERE id = $1`, profile.ID, hash); err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	if err = spendResetTokens(ctx, tx, profile.ID); err != nil {
+		return err
+	}
+	if err = insertAuditEvent(ctx, tx, accountAuditEvent(ctx, AuditResetPassword, profile)); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// spendResetTokens invalidates a customer's outstanding reset tokens once
+// the password has changed by any means.
+func spendResetTokens(ctx context.Context, db execer, customerID int64) error {
+	_, err := db.Exec(ctx, `
+		UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE customer_id = $1 AND used_at IS NULL
+	`, customerID)
+	if err != nil {
+		return fmt.Errorf("spend reset tokens: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredPasswordResets removes expired tokens, spent or not.
+func (s *Store) DeleteExpiredPasswordResets(ctx context.Context) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM password_reset_tokens WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("delete reset tokens: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/events"
+)
+
+const DefaultCancelWindow = 30 * time.Minute
+
+var ErrCancelWindowClosed = errors.New("cancellation window has passed")
+
+// SetCancelWindow changes how long after checkout a customer may cancel.
+func (s *Store) SetCancelWindow(window time.Duration) {
+	if window > 0 {
+		s.cancelWindow = window
+	}
+}
+
+// CancelPurchase lets a customer undo their own purchase within the cancel
+// window. petIDs narrows the cancellation to some items; empty means all.
+func (s *Store) CancelPurchase(ctx context.Context, storeID int64, customerID int64, orderID string, petIDs []string, reason string) (Order, error) {
+	return s.reversePurchase(ctx, storeID, orderID, petIDs, reversalRequest{
+		status:     OrderItemCancelled,
+		actorRole:  auth.RoleCustomer,
+		actorID:    customerID,
+		reason:     reason,
+		customerID: &customerID,
+	})
+}
+
+// RefundPurchase lets a merchant void any sale in their store.
+func (s *Store) RefundPurchase(ctx context.Context, storeID int64, merchantID int64, orderID string, petIDs []string, reason string) (Order, error) {
+	return s.reversePurchase(ctx, storeID, orderID, petIDs, reversalRequest{
+		status:    OrderItemRefunded,
+		actorRole: auth.RoleMerchant,
+		actorID:   merchantID,
+		reason:    reason,
+	})
+}
+
+type reversalRequest struct {
+	status    OrderItemStatus
+	actorRole auth.Role
+	actorID   int64
+	reason    string
+	// customerID restricts the reversal to the customer's own orders and
+	// applies the cancel window.
+	customerID *int64
+}
+
+func (s *Store) reversePurchase(ctx context.Context, storeID int64, orderID string, petIDs []string, req reversalRequest) (Order, error) {
+	req.reason = strings.TrimSpace(req.reason)
+	if req.reason == "" {
+		return Order{}, errors.New("reason is required")
+	}
+	if len(req.reason) > 500 {
+		return Order{}, errors.New("reason is too long")
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Order{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	var ownerID int64
+	var placedAt time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT customer_id, placed_at FROM orders
+		WHERE store_id = $1 AND id = $2
+		FOR UPDATE
+	`, storeID, orderID).Scan(&ownerID, &placedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrOrderNotFound
+		return Order{}, err
+	}
+	if err != nil {
+		return Order{}, fmt.Errorf("select order: %w", err)
+	}
+	if req.customerID != nil {
+		if ownerID != *req.customerID {
+			err = ErrOrderNotFound
+			return Order{}, err
+		}
+		if time.Since(placedAt) > s.cancelWindow {
+			err = ErrCancelWindowClosed
+			return Order{}, err
+		}
+	}
+
+	ids, err := lockReversibleItems(ctx, tx, orderID, petIDs)
+	if err != nil {
+		return Order{}, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE order_items
+		SET status = $3, reversed_at = NOW(), reversed_by_role = $4,
+		    reversed_by_user_id = $5, reversal_reason = $6
+		WHERE order_id = $1 AND pet_id = ANY($2::uuid[])
+	`, orderID, ids, string(req.status), string(req.actorRole), req.actorID, req.reason)
+	if err != nil {
+		return Order{}, fmt.Errorf("update order items: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE pets
+		SET purchased_at = NULL, purchased_by_customer_id = NULL, version = version + 1
+		WHERE store_id = $1 AND id = ANY($2::uuid[])
+	`, storeID, ids)
+	if err != nil {
+		return Order{}, fmt.Errorf("release pets: %w", err)
+	}
+	if err = s.enqueueWebhooks(ctx, tx, WebhookPetReturned, storeID, ids); err != nil {
+		return Order{}, err
+	}
+
+	full, partial := OrderStatusCancelled, OrderStatusPartiallyCancelled
+	if req.status == OrderItemRefunded {
+		full, partial = OrderStatusRefunded, OrderStatusPartiallyRefunded
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE orders
+		SET status = CASE
+		  WHEN EXISTS (SELECT 1 FROM order_items WHERE order_id = $1 AND status = 'PURCHASED') THEN $2
+		  ELSE $3
+		END
+		WHERE id = $1
+	`, orderID, string(partial), string(full))
+	if err != nil {
+		return Order{}, fmt.Errorf("update order: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return Order{}, fmt.Errorf("commit: %w", err)
+	}
+	s.publish(ctx, events.PetReturned, storeID, orderID, ids)
+	return s.GetOrder(ctx, storeID, orderID)
+}
+
+// lockReversibleItems locks the order's still-purchased items and returns
+// the pet IDs to reverse, rejecting requested pets that are not reversible.
+func lockReversibleItems(ctx context.Context, tx pgx.Tx, orderID string, petIDs []string) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT pet_id::text, status FROM order_items
+		WHERE order_id = $1
+		FOR UPDATE
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("select order items: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]OrderItemStatus)
+	var active []string
+	for rows.Next() {
+		var petID string
+		var status OrderItemStatus
+		if err := rows.Scan(&petID, &status); err != nil {
+			return nil, fmt.Errorf("scan order item: %w", err)
+		}
+		statuses[petID] = status
+		if status == OrderItemPurchased {
+			active = append(active, petID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate order items: %w", err)
+	}
+
+	if len(petIDs) == 0 {
+		if len(active) == 0 {
+			return nil, errors.New("order has no purchased items left")
+		}
+		return active, nil
+	}
+	for _, id := range petIDs {
+		status, ok := statuses[id]
+		if !ok {
+			return nil, fmt.Errorf("pet %s is not in this order", id)
+		}
+		if status != OrderItemPurchased {
+			return nil, fmt.Errorf("pet %s is already %s", id, strings.ToLower(string(status)))
+		}
+	}
+	return petIDs, nil
+}
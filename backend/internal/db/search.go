@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type PetSort string
+
+const (
+	PetSortNewest    PetSort = "NEWEST"
+	PetSortOldest    PetSort = "OLDEST"
+	PetSortAgeAsc    PetSort = "AGE_ASC"
+	PetSortAgeDesc   PetSort = "AGE_DESC"
+	PetSortNameAsc   PetSort = "NAME_ASC"
+	PetSortNameDesc  PetSort = "NAME_DESC"
+	PetSortRelevance PetSort = "RELEVANCE"
+)
+
+// PetFilter narrows the catalog. Species holds slugs; a category's slug
+// also matches its sub-species.
+type PetFilter struct {
+	Species      []string
+	MinAge       *int
+	MaxAge       *int
+	CreatedAfter *time.Time
+	Query        string
+}
+
+// PetSearch describes a catalog query. An empty Sort means RELEVANCE when
+// Filter.Query is set and NEWEST otherwise.
+type PetSearch struct {
+	Filter PetFilter
+	Sort   PetSort
+	Page   PageRequest
+}
+
+type SpeciesFacet struct {
+	Species Species
+	Count   int
+}
+
+const searchConfig = "english"
+
+// SearchAvailablePets pages the catalog as seen by customerID: pets held in
+// other customers' carts are hidden, the customer's own holds are not.
+func (s *Store) SearchAvailablePets(ctx context.Context, storeID int64, customerID int64, search PetSearch) (PetPage, error) {
+	q, err := availablePetsQuery(storeID, customerID, search.Filter, true)
+	if err != nil {
+		return PetPage{}, err
+	}
+	sort, err := search.sortKey(&q)
+	if err != nil {
+		return PetPage{}, err
+	}
+	return s.pagePets(ctx, q, sort, search.Page)
+}
+
+// AvailableSpeciesFacets counts available pets per species for the filter,
+// ignoring the filter's own species list so the UI can offer every option.
+// Facets are ordered by species name.
+func (s *Store) AvailableSpeciesFacets(ctx context.Context, storeID int64, customerID int64, filter PetFilter) ([]SpeciesFacet, error) {
+	q, err := availablePetsQuery(storeID, customerID, filter, false)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+		SELECT `+speciesColumns+`, f.count
+		FROM (
+			SELECT store_id, species, COUNT(1) AS count
+			FROM pets
+			WHERE %s
+			GROUP BY store_id, species
+		) f
+		JOIN species sp ON sp.store_id = f.store_id AND sp.slug = f.species
+		LEFT JOIN species parent ON parent.id = sp.parent_id
+		ORDER BY sp.name, sp.slug
+	`, q.clause()), q.args...)
+	if err != nil {
+		return nil, fmt.Errorf("query facets: %w", err)
+	}
+	defer rows.Close()
+
+	var facets []SpeciesFacet
+	for rows.Next() {
+		var facet SpeciesFacet
+		facet.Species, err = scanSpecies(rows, &facet.Count)
+		if err != nil {
+			return nil, fmt.Errorf("scan facet: %w", err)
+		}
+		facets = append(facets, facet)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate facets: %w", err)
+	}
+	return facets, nil
+}
+
+func availablePetsQuery(storeID int64, customerID int64, filter PetFilter, withSpecies bool) (listQuery, error) {
+	var q listQuery
+	q.where("store_id = %s AND purchased_at IS NULL AND archived_at IS NULL", storeID)
+	q.where(`NOT EXISTS (
+			SELECT 1 FROM cart_holds h
+			WHERE h.pet_id = pets.id AND h.expires_at > NOW() AND h.customer_id <> %s
+		)`, customerID)
+
+	if withSpecies && len(filter.Species) > 0 {
+		q.where(`species IN (
+			SELECT sp.slug FROM `+speciesFrom+`
+			WHERE sp.store_id = pets.store_id AND (sp.slug = ANY(%s) OR parent.slug = ANY(%s))
+		)`, filter.Species, filter.Species)
+	}
+	if filter.MinAge != nil && filter.MaxAge != nil && *filter.MinAge > *filter.MaxAge {
+		return listQuery{}, errors.New("min age cannot exceed max age")
+	}
+	if filter.MinAge != nil {
+		q.where("age_years >= %s", *filter.MinAge)
+	}
+	if filter.MaxAge != nil {
+		q.where("age_years <= %s", *filter.MaxAge)
+	}
+	if filter.CreatedAfter != nil {
+		q.where("created_at > %s", *filter.CreatedAfter)
+	}
+	if text := strings.TrimSpace(filter.Query); text != "" {
+		q.where("search_vector @@ websearch_to_tsquery('"+searchConfig+"', %s)", text)
+	}
+	return q, nil
+}
+
+func (search PetSearch) sortKey(q *listQuery) (sortKey, error) {
+	text := strings.TrimSpace(search.Filter.Query)
+	order := search.Sort
+	if order == "" {
+		order = PetSortNewest
+		if text != "" {
+			order = PetSortRelevance
+		}
+	}
+	switch order {
+	case PetSortNewest:
+		return sortCreatedDesc, nil
+	case PetSortOldest:
+		return sortKey{expr: "created_at", cast: "timestamptz"}, nil
+	case PetSortAgeAsc:
+		return sortKey{expr: "age_years", cast: "int"}, nil
+	case PetSortAgeDesc:
+		return sortKey{expr: "age_years", cast: "int", desc: true}, nil
+	case PetSortNameAsc:
+		return sortKey{expr: "name", cast: "text"}, nil
+	case PetSortNameDesc:
+		return sortKey{expr: "name", cast: "text", desc: true}, nil
+	case PetSortRelevance:
+		if text == "" {
+			return sortKey{}, errors.New("relevance sort requires a search query")
+		}
+		expr := fmt.Sprintf("ts_rank(search_vector, websearch_to_tsquery('%s', %s))::float8", searchConfig, q.arg(text))
+		return sortKey{expr: expr, cast: "float8", desc: true}, nil
+	default:
+		return sortKey{}, fmt.Errorf("unknown sort %q", order)
+	}
+}
@@ -7,21 +7,35 @@ import (
 	"nimble-challenge/backend/internal/crypto"
 )
 
-func (s *Store) EnsureDemoData(ctx context.Context, storeSlug, storeName, merchantUser, merchantPass, customerUser, customerPass string) error {
-	var storeID int64
-	err := s.pool.QueryRow(ctx, `
-		INSERT INTO stores (slug, name)
-		VALUES ($1, $2)
-		ON CONFLICT (slug) DO UPDATE SET name = EXCLUDED.name
-		RETURNING id
-	`, storeSlug, storeName).Scan(&storeID)
+func (s *Store) EnsureDemoData(ctx context.Context, storeSlug, storeName, storeCurrency, merchantUser, merchantPass, customerUser, customerPass string) error {
+	if err := ValidateCurrency(storeCurrency); err != nil {
+		return err
+	}
+	// An existing store keeps its settings: admins may have changed them.
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO stores (slug, name, currency)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (slug) DO NOTHING
+	`, storeSlug, storeName, storeCurrency)
 	if err != nil {
-		return fmt.Errorf("upsert store: %w", err)
+		return fmt.Errorf("insert store: %w", err)
+	}
+	storeID, err := s.StoreIDBySlug(ctx, storeSlug)
+	if err != nil {
+		return err
 	}
 
 	if err := ensureUser(ctx, s, storeID, "merchants", merchantUser, merchantPass); err != nil {
 		return err
 	}
+	_, err = s.pool.Exec(ctx, `
+		INSERT INTO merchant_stores (merchant_id, store_id)
+		SELECT id, $2 FROM merchants WHERE username = $1
+		ON CONFLICT DO NOTHING
+	`, merchantUser, storeID)
+	if err != nil {
+		return fmt.Errorf("add merchant to store: %w", err)
+	}
 	if err := ensureUser(ctx, s, storeID, "customers", customerUser, customerPass); err != nil {
 		return err
 	}
@@ -31,36 +45,17 @@ func (s *Store) EnsureDemoData(ctx context.Context, storeSlug, storeName, mercha
 		return fmt.Errorf("count pets: %w", err)
 	}
 	if petCount == 0 {
-		seedPets := []Pet{
-			{
-				Name:         "Miso",
-				Species:      SpeciesCat,
-				AgeYears:     2,
-				PictureURL:   "https://images.unsplash.com/photo-1518791841217-8f162f1e1131?auto=format&fit=crop&w=900&q=80",
-				Description:  "Playful kitten who loves strings and sunbeams.",
-				BreederName:  "Jane Doe",
-				BreederEmail: "jane@example.com",
-			},
-			{
-				Name:         "Barkley",
-				Species:      SpeciesDog,
-				AgeYears:     4,
-				PictureURL:   "https://images.unsplash.com/photo-1507146426996-ef05306b995a?auto=format&fit=crop&w=900&q=80",
-				Description:  "Friendly golden retriever who enjoys long walks.",
-				BreederName:  "Tom Rivers",
-				BreederEmail: "tom@example.com",
-			},
-			{
-				Name:         "Sprout",
-				Species:      SpeciesFrog,
-				AgeYears:     1,
-				PictureURL:   "https://images.unsplash.com/photo-1502786129293-79981df4e689?auto=format&fit=crop&w=900&q=80",
-				Description:  "Tiny tree frog with a calm personality.",
-				BreederName:  "Lena Moss",
-				BreederEmail: "lena@example.com",
-			},
+		for _, sp := range demoSpecies() {
+			_, err := s.pool.Exec(ctx, `
+				INSERT INTO species (store_id, slug, name)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (store_id, slug) DO NOTHING
+			`, storeID, sp.Slug, sp.Name)
+			if err != nil {
+				return fmt.Errorf("seed species: %w", err)
+			}
 		}
-		for _, pet := range seedPets {
+		for _, pet := range demoPets() {
 			if _, err := s.CreatePet(ctx, storeID, pet); err != nil {
 				return fmt.Errorf("seed pet: %w", err)
 			}
@@ -69,6 +64,38 @@ func (s *Store) EnsureDemoData(ctx context.Context, storeSlug, storeName, mercha
 	return nil
 }
 
+// EnsureAdmin creates the platform admin account if a username is
+// configured. An existing admin keeps its password.
+func (s *Store) EnsureAdmin(ctx context.Context, username, password string) error {
+	if username == "" {
+		return nil
+	}
+	var taken bool
+	err := s.pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM merchants WHERE username = $1)
+		    OR EXISTS (SELECT 1 FROM customers WHERE username = $1)
+	`, username).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("check username: %w", err)
+	}
+	if taken {
+		return fmt.Errorf("admin username %q is already used by a store account", username)
+	}
+	hash, err := crypto.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	_, err = s.pool.Exec(ctx, `
+		INSERT INTO platform_admins (username, password_hash)
+		VALUES ($1, $2)
+		ON CONFLICT (username) DO NOTHING
+	`, username, hash)
+	if err != nil {
+		return fmt.Errorf("insert admin: %w", err)
+	}
+	return nil
+}
+
 func ensureUser(ctx context.Context, s *Store, storeID int64, table, username, password string) error {
 	var count int
 	err := s.pool.QueryRow(ctx, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE username = $1`, table), username).Scan(&count)
@@ -91,3 +118,48 @@ func ensureUser(ctx context.Context, s *Store, storeID int64, table, username, p
 	}
 	return nil
 }
+
+// demoSpecies are the species of the demo pets, which used to be the only
+// ones allowed.
+func demoSpecies() []Species {
+	return []Species{
+		{Slug: "cat", Name: "Cat"},
+		{Slug: "dog", Name: "Dog"},
+		{Slug: "frog", Name: "Frog"},
+	}
+}
+
+func demoPets() []Pet {
+	return []Pet{
+		{
+			Name:         "Miso",
+			Species:      Species{Slug: "cat"},
+			AgeYears:     2,
+			PictureURL:   "https://images.unsplash.com/photo-1518791841217-8f162f1e1131?auto=format&fit=crop&w=900&q=80",
+			Description:  "Playful kitten who loves strings and sunbeams.",
+			BreederName:  "Jane Doe",
+			BreederEmail: "jane@example.com",
+			Price:        Money{Amount: 25000},
+		},
+		{
+			Name:         "Barkley",
+			Species:      Species{Slug: "dog"},
+			AgeYears:     4,
+			PictureURL:   "https://images.unsplash.com/photo-1507146426996-ef05306b995a?auto=format&fit=crop&w=900&q=80",
+			Description:  "Friendly golden retriever who enjoys long walks.",
+			BreederName:  "Tom Rivers",
+			BreederEmail: "tom@example.com",
+			Price:        Money{Amount: 60000},
+		},
+		{
+			Name:         "Sprout",
+			Species:      Species{Slug: "frog"},
+			AgeYears:     1,
+			PictureURL:   "https://images.unsplash.com/photo-1502786129293-79981df4e689?auto=format&fit=crop&w=900&q=80",
+			Description:  "Tiny tree frog with a calm personality.",
+			BreederName:  "Lena Moss",
+			BreederEmail: "lena@example.com",
+			Price:        Money{Amount: 4500},
+		},
+	}
+}
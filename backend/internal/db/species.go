@@ -0,0 +1,377 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrSpeciesNotFound  = errors.New("species not found")
+	ErrSpeciesSlugTaken = errors.New("species slug is already taken")
+	ErrSpeciesInUse     = errors.New("species still has pets or sub-species")
+)
+
+var (
+	errSpeciesOwnParent   = errors.New("a species cannot be its own parent")
+	errSpeciesNestedOnce  = errors.New("a parent species cannot itself have a parent")
+	errSpeciesHasChildren = errors.New("a species with sub-species cannot have a parent")
+)
+
+// SpeciesInput is a species a merchant adds to a store. ParentSlug, if set,
+// names the top-level species it is grouped under.
+type SpeciesInput struct {
+	Slug       string
+	Name       string
+	ParentSlug string
+}
+
+// SpeciesPatch holds changes to a species; nil fields are left alone and
+// an empty ParentSlug makes the species top-level. Slugs cannot change,
+// since pets and import files refer to them.
+type SpeciesPatch struct {
+	Name       *string
+	ParentSlug *string
+}
+
+func (in SpeciesInput) validate() error {
+	if err := validateSlug(in.Slug); err != nil {
+		return err
+	}
+	if in.ParentSlug == in.Slug {
+		return errSpeciesOwnParent
+	}
+	return validateName(in.Name)
+}
+
+func (p SpeciesPatch) validate(slug string) error {
+	if p.ParentSlug != nil && *p.ParentSlug == slug {
+		return errSpeciesOwnParent
+	}
+	if p.Name != nil {
+		return validateName(*p.Name)
+	}
+	return nil
+}
+
+// auditDetail names the fields the patch changes.
+func (p SpeciesPatch) auditDetail(before Species) string {
+	var changed []string
+	if p.Name != nil && *p.Name != before.Name {
+		changed = append(changed, "name")
+	}
+	if p.ParentSlug != nil && *p.ParentSlug != before.parentSlug() {
+		changed = append(changed, "parent")
+	}
+	return strings.Join(changed, ", ")
+}
+
+func (sp Species) parentSlug() string {
+	if sp.Parent == nil {
+		return ""
+	}
+	return sp.Parent.Slug
+}
+
+func (sp *Species) setParent(slug, name *string) {
+	sp.Parent = nil
+	if slug != nil {
+		sp.Parent = &Species{Slug: *slug, Name: *name}
+	}
+}
+
+func unknownSpecies(slug string) error {
+	return fmt.Errorf("%w: %s", ErrSpeciesNotFound, slug)
+}
+
+const speciesColumns = `sp.slug, sp.name, parent.slug, parent.name`
+
+const speciesFrom = `species sp LEFT JOIN species parent ON parent.id = sp.parent_id`
+
+// petSpeciesColumns resolve a pet's species and its parent inline, so
+// petColumns works in RETURNING clauses as well as in joins.
+const petSpeciesColumns = `
+		       (SELECT sp.name FROM ` + speciesFrom + ` WHERE sp.store_id = pets.store_id AND sp.slug = pets.species),
+		       (SELECT parent.slug FROM ` + speciesFrom + ` WHERE sp.store_id = pets.store_id AND sp.slug = pets.species),
+		       (SELECT parent.name FROM ` + speciesFrom + ` WHERE sp.store_id = pets.store_id AND sp.slug = pets.species)`
+
+func scanSpecies(row pgx.Row, extra ...any) (Species, error) {
+	var sp Species
+	var parentSlug, parentName *string
+	if err := row.Scan(append([]any{&sp.Slug, &sp.Name, &parentSlug, &parentName}, extra...)...); err != nil {
+		return Species{}, err
+	}
+	sp.setParent(parentSlug, parentName)
+	return sp, nil
+}
+
+// ListSpecies returns the store's species with every category followed by
+// its sub-species, each group ordered by name.
+func (s *Store) ListSpecies(ctx context.Context, storeID int64) ([]Species, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+speciesColumns+`
+		FROM `+speciesFrom+`
+		WHERE sp.store_id = $1
+		ORDER BY COALESCE(parent.name, sp.name), COALESCE(parent.slug, sp.slug), parent.id IS NOT NULL, sp.name, sp.slug
+	`, storeID)
+	if err != nil {
+		return nil, fmt.Errorf("query species: %w", err)
+	}
+	defer rows.Close()
+	var species []Species
+	for rows.Next() {
+		sp, err := scanSpecies(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan species: %w", err)
+		}
+		species = append(species, sp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate species: %w", err)
+	}
+	return species, nil
+}
+
+func (s *Store) CreateSpecies(ctx context.Context, storeID int64, input SpeciesInput) (Species, error) {
+	sp, err := s.createSpecies(ctx, storeID, input)
+	if err != nil {
+		s.recordAuditFailure(ctx, storeAuditEvent(ctx, AuditCreateSpecies, storeID, input.Slug), err)
+	}
+	return sp, err
+}
+
+func (s *Store) createSpecies(ctx context.Context, storeID int64, input SpeciesInput) (sp Species, err error) {
+	if err := input.validate(); err != nil {
+		return Species{}, err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Species{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	sp = Species{Slug: input.Slug, Name: input.Name}
+	var parentID *int64
+	if input.ParentSlug != "" {
+		var id int64
+		var parent Species
+		id, parent, err = lockParentSpecies(ctx, tx, storeID, input.ParentSlug)
+		if err != nil {
+			return Species{}, err
+		}
+		parentID, sp.Parent = &id, &parent
+	}
+	var inserted bool
+	err = tx.QueryRow(ctx, `
+		INSERT INTO species (store_id, slug, name, parent_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (store_id, slug) DO NOTHING
+		RETURNING TRUE
+	`, storeID, input.Slug, input.Name, parentID).Scan(&inserted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Species{}, ErrSpeciesSlugTaken
+	}
+	if err != nil {
+		return Species{}, fmt.Errorf("insert species: %w", err)
+	}
+	if err = insertAuditEvent(ctx, tx, storeAuditEvent(ctx, AuditCreateSpecies, storeID, sp.Slug)); err != nil {
+		return Species{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return Species{}, fmt.Errorf("commit: %w", err)
+	}
+	return sp, nil
+}
+
+// UpdateSpecies renames a species or moves it under another category.
+func (s *Store) UpdateSpecies(ctx context.Context, storeID int64, slug string, patch SpeciesPatch) (Species, error) {
+	sp, err := s.updateSpecies(ctx, storeID, slug, patch)
+	if err != nil {
+		s.recordAuditFailure(ctx, storeAuditEvent(ctx, AuditUpdateSpecies, storeID, slug), err)
+	}
+	return sp, err
+}
+
+func (s *Store) updateSpecies(ctx context.Context, storeID int64, slug string, patch SpeciesPatch) (sp Species, err error) {
+	if err := patch.validate(slug); err != nil {
+		return Species{}, err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Species{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	var id int64
+	var parentID *int64
+	before, err := scanSpecies(tx.QueryRow(ctx, `
+		SELECT `+speciesColumns+`, sp.id, sp.parent_id
+		FROM `+speciesFrom+`
+		WHERE sp.store_id = $1 AND sp.slug = $2
+		FOR UPDATE OF sp
+	`, storeID, slug), &id, &parentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Species{}, ErrSpeciesNotFound
+	}
+	if err != nil {
+		return Species{}, fmt.Errorf("select species: %w", err)
+	}
+
+	sp = before
+	if patch.Name != nil {
+		sp.Name = *patch.Name
+	}
+	if patch.ParentSlug != nil && *patch.ParentSlug != before.parentSlug() {
+		parentID, sp.Parent = nil, nil
+		if *patch.ParentSlug != "" {
+			var hasChildren bool
+			if err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM species WHERE parent_id = $1)`, id).Scan(&hasChildren); err != nil {
+				return Species{}, fmt.Errorf("count sub-species: %w", err)
+			}
+			if hasChildren {
+				return Species{}, errSpeciesHasChildren
+			}
+			var pid int64
+			var parent Species
+			pid, parent, err = lockParentSpecies(ctx, tx, storeID, *patch.ParentSlug)
+			if err != nil {
+				return Species{}, err
+			}
+			parentID, sp.Parent = &pid, &parent
+		}
+	}
+	if _, err = tx.Exec(ctx, `UPDATE species SET name = $2, parent_id = $3 WHERE id = $1`, id, sp.Name, parentID); err != nil {
+		return Species{}, fmt.Errorf("update species: %w", err)
+	}
+	event := storeAuditEvent(ctx, AuditUpdateSpecies, storeID, slug)
+	event.Detail = patch.auditDetail(before)
+	if err = insertAuditEvent(ctx, tx, event); err != nil {
+		return Species{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return Species{}, fmt.Errorf("commit: %w", err)
+	}
+	return sp, nil
+}
+
+// DeleteSpecies removes a species no pet, sold or not, and no sub-species
+// refers to.
+func (s *Store) DeleteSpecies(ctx context.Context, storeID int64, slug string) error {
+	err := s.deleteSpecies(ctx, storeID, slug)
+	if err != nil {
+		s.recordAuditFailure(ctx, storeAuditEvent(ctx, AuditDeleteSpecies, storeID, slug), err)
+	}
+	return err
+}
+
+func (s *Store) deleteSpecies(ctx context.Context, storeID int64, slug string) (err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	var id int64
+	err = tx.QueryRow(ctx, `SELECT id FROM species WHERE store_id = $1 AND slug = $2 FOR UPDATE`, storeID, slug).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrSpeciesNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("select species: %w", err)
+	}
+	var inUse bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pets WHERE store_id = $1 AND species = $2)
+		    OR EXISTS (SELECT 1 FROM species WHERE parent_id = $3)
+	`, storeID, slug, id).Scan(&inUse)
+	if err != nil {
+		return fmt.Errorf("check species use: %w", err)
+	}
+	if inUse {
+		return ErrSpeciesInUse
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM species WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("delete species: %w", err)
+	}
+	if err = insertAuditEvent(ctx, tx, storeAuditEvent(ctx, AuditDeleteSpecies, storeID, slug)); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// lockParentSpecies loads the species a child is being grouped under and
+// keeps it from being moved under another category until tx ends.
+func lockParentSpecies(ctx context.Context, tx pgx.Tx, storeID int64, slug string) (int64, Species, error) {
+	var id int64
+	var nested bool
+	parent := Species{Slug: slug}
+	err := tx.QueryRow(ctx, `
+		SELECT id, name, parent_id IS NOT NULL
+		FROM species
+		WHERE store_id = $1 AND slug = $2
+		FOR SHARE
+	`, storeID, slug).Scan(&id, &parent.Name, &nested)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, Species{}, fmt.Errorf("parent: %w", unknownSpecies(slug))
+	}
+	if err != nil {
+		return 0, Species{}, fmt.Errorf("select parent species: %w", err)
+	}
+	if nested {
+		return 0, Species{}, errSpeciesNestedOnce
+	}
+	return id, parent, nil
+}
+
+// checkSpecies reports whether the store has the species a pet refers to.
+func checkSpecies(ctx context.Context, tx pgx.Tx, storeID int64, slug string) error {
+	var exists bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM species WHERE store_id = $1 AND slug = $2)`, storeID, slug).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("check species: %w", err)
+	}
+	if !exists {
+		return unknownSpecies(slug)
+	}
+	return nil
+}
+
+// storeSpeciesSlugs returns the set of the store's species slugs, for
+// validating imports before anything is written.
+func (s *Store) storeSpeciesSlugs(ctx context.Context, storeID int64) (map[string]bool, error) {
+	rows, err := s.pool.Query(ctx, `SELECT slug FROM species WHERE store_id = $1`, storeID)
+	if err != nil {
+		return nil, fmt.Errorf("query species: %w", err)
+	}
+	defer rows.Close()
+	known := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, fmt.Errorf("scan species: %w", err)
+		}
+		known[slug] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate species: %w", err)
+	}
+	return known, nil
+}
@@ -4,230 +4,598 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"math"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+
 	"nimble-challenge/backend/internal/auth"
 	"nimble-challenge/backend/internal/crypto"
+	"nimble-challenge/backend/internal/events"
 )
 
+// Authenticate checks Basic Auth credentials. Every failed attempt is
+// audited, successes at most once per loginAuditInterval; a login never
+// fails because it could not be recorded.
 func (s *Store) Authenticate(ctx context.Context, username, password string) (*auth.Principal, error) {
+	event := newAuditEvent(ctx, AuditLogin)
+	principal, storeID, err := s.authenticate(ctx, username, password)
+	if err != nil {
+		event.ActorUsername = strings.TrimSpace(username)
+		if storeID != 0 {
+			event.StoreID = &storeID
+		}
+		s.recordAuditFailure(ctx, event, err)
+		return nil, err
+	}
+	if now := time.Now(); s.logins.due(principal, now) {
+		event.setActor(principal)
+		if err := insertAuditEvent(ctx, s.pool, event); err != nil {
+			log.Printf("audit: %v", err)
+		} else {
+			s.logins.recorded(principal, now)
+		}
+	}
+	return principal, nil
+}
+
+// authenticate also returns the store of a matched username when the
+// password is wrong, so failed attempts show up in that store's audit log.
+func (s *Store) authenticate(ctx context.Context, username, password string) (*auth.Principal, int64, error) {
 	username = strings.TrimSpace(username)
 	if username == "" {
-		return nil, errors.New("empty username")
+		return nil, 0, errors.New("empty username")
 	}
 
 	var (
-		userID     int64
-		storeID    int64
-		storeSlug  string
-		passHash   string
-		isMerchant bool
+		userID      int64
+		storeID     int64
+		storeSlug   string
+		passHash    string
+		storeActive bool
+		perms       []string
+		role        = auth.RoleMerchant
 	)
 
 	err := s.pool.QueryRow(ctx, `
-		SELECT m.id, m.store_id, s.slug, m.password_hash
+		SELECT m.id, m.store_id, s.slug, m.password_hash, m.permissions
 		FROM merchants m
 		JOIN stores s ON s.id = m.store_id
 		WHERE m.username = $1
-	`, username).Scan(&userID, &storeID, &storeSlug, &passHash)
-	if err == nil {
-		ok, err := crypto.VerifyPassword(password, passHash)
-		if err != nil || !ok {
-			return nil, errors.New("invalid credentials")
-		}
-		isMerchant = true
-	} else {
+	`, username).Scan(&userID, &storeID, &storeSlug, &passHash, &perms)
+	if errors.Is(err, pgx.ErrNoRows) {
+		role = auth.RoleCustomer
 		err = s.pool.QueryRow(ctx, `
-			SELECT c.id, c.store_id, s.slug, c.password_hash
+			SELECT c.id, c.store_id, s.slug, c.password_hash, s.deactivated_at IS NULL
 			FROM customers c
 			JOIN stores s ON s.id = c.store_id
 			WHERE c.username = $1
-		`, username).Scan(&userID, &storeID, &storeSlug, &passHash)
+		`, username).Scan(&userID, &storeID, &storeSlug, &passHash, &storeActive)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		role = auth.RoleAdmin
+		err = s.pool.QueryRow(ctx, `
+			SELECT id, password_hash FROM platform_admins WHERE username = $1
+		`, username).Scan(&userID, &passHash)
+	}
+	if err != nil {
+		return nil, 0, errors.New("invalid credentials")
+	}
+	ok, err := crypto.VerifyPassword(password, passHash)
+	if err != nil || !ok {
+		return nil, storeID, errors.New("invalid credentials")
+	}
+	if role == auth.RoleCustomer && !storeActive {
+		return nil, storeID, ErrStoreInactive
+	}
+
+	permissions := make([]auth.Permission, len(perms))
+	for i, p := range perms {
+		permissions[i] = auth.Permission(p)
+	}
+	var memberships []auth.Membership
+	switch role {
+	case auth.RoleCustomer:
+		memberships = []auth.Membership{{StoreID: storeID, StoreSlug: storeSlug}}
+	case auth.RoleMerchant:
+		stores, err := s.MerchantStores(ctx, userID)
 		if err != nil {
-			return nil, errors.New("invalid credentials")
+			return nil, storeID, err
 		}
-		ok, err := crypto.VerifyPassword(password, passHash)
-		if err != nil || !ok {
-			return nil, errors.New("invalid credentials")
+		for _, store := range stores {
+			memberships = append(memberships, auth.Membership{StoreID: store.ID, StoreSlug: store.Slug})
 		}
 	}
 
-	role := auth.RoleCustomer
-	if isMerchant {
-		role = auth.RoleMerchant
-	}
-
 	return &auth.Principal{
-		Role:      role,
-		UserID:    userID,
-		StoreID:   storeID,
-		StoreSlug: storeSlug,
-		Username:  username,
-	}, nil
+		Role:        role,
+		UserID:      userID,
+		StoreID:     storeID,
+		StoreSlug:   storeSlug,
+		Username:    username,
+		Permissions: permissions,
+		Memberships: memberships,
+	}, storeID, nil
+}
+
+func validatePet(pet Pet) error {
+	if pet.Name == "" {
+		return errors.New("name is required")
+	}
+	if pet.AgeYears < 0 {
+		return errors.New("age must be positive")
+	}
+	if pet.PictureURL == "" && pet.Picture == nil {
+		return errors.New("picture is required")
+	}
+	if pet.Description == "" {
+		return errors.New("description is required")
+	}
+	if pet.BreederName == "" {
+		return errors.New("breeder name is required")
+	}
+	if pet.Species.Slug == "" {
+		return errors.New("species is required")
+	}
+	if pet.BreederEmail == "" {
+		return errors.New("breeder email is required")
+	}
+	if !strings.Contains(pet.BreederEmail, "@") {
+		return errors.New("breeder email is invalid")
+	}
+	if pet.Price.Amount < 0 {
+		return errors.New("price must not be negative")
+	}
+	if pet.Price.Amount > math.MaxInt32 {
+		return errors.New("price is too large")
+	}
+	return nil
 }
 
 func (s *Store) CreatePet(ctx context.Context, storeID int64, input Pet) (Pet, error) {
-	if input.Name == "" {
-		return Pet{}, errors.New("name is required")
+	pet, err := s.createPet(ctx, storeID, input)
+	if err != nil {
+		s.recordAuditFailure(ctx, storeAuditEvent(ctx, AuditCreatePet, storeID), err)
+		return pet, err
 	}
-	if input.AgeYears < 0 {
-		return Pet{}, errors.New("age must be positive")
+	s.publish(ctx, events.PetCreated, storeID, "", []string{pet.ID})
+	return pet, nil
+}
+
+func (s *Store) createPet(ctx context.Context, storeID int64, input Pet) (Pet, error) {
+	if err := validatePet(input); err != nil {
+		return Pet{}, err
 	}
-	if input.PictureURL == "" {
-		return Pet{}, errors.New("picture url is required")
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Pet{}, fmt.Errorf("begin tx: %w", err)
 	}
-	if input.Description == "" {
-		return Pet{}, errors.New("description is required")
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	if err = checkSpecies(ctx, tx, storeID, input.Species.Slug); err != nil {
+		return Pet{}, err
 	}
-	if input.BreederName == "" {
-		return Pet{}, errors.New("breeder name is required")
+	pet, err := s.insertPet(ctx, tx, storeID, input)
+	if err != nil {
+		return Pet{}, err
+	}
+	if err = notifySavedSearches(ctx, tx, storeID, pet.ID); err != nil {
+		return Pet{}, err
 	}
-	if input.Species != SpeciesCat && input.Species != SpeciesDog && input.Species != SpeciesFrog {
-		return Pet{}, errors.New("invalid species")
+	if err = enqueueBreederMail(ctx, tx, OutboxPetListed, storeID, []string{pet.ID}); err != nil {
+		return Pet{}, err
 	}
-	if input.BreederEmail == "" {
-		return Pet{}, errors.New("breeder email is required")
+	if err = s.enqueueWebhooks(ctx, tx, WebhookPetCreated, storeID, []string{pet.ID}); err != nil {
+		return Pet{}, err
 	}
-	if !strings.Contains(input.BreederEmail, "@") {
-		return Pet{}, errors.New("breeder email is invalid")
+	if err = insertAuditEvent(ctx, tx, storeAuditEvent(ctx, AuditCreatePet, storeID, pet.ID)); err != nil {
+		return Pet{}, err
 	}
+	if err = tx.Commit(ctx); err != nil {
+		return Pet{}, fmt.Errorf("commit: %w", err)
+	}
+	return pet, nil
+}
 
+// insertPet encrypts the breeder email and inserts an already validated pet
+// of one of the store's species.
+func (s *Store) insertPet(ctx context.Context, tx pgx.Tx, storeID int64, input Pet) (Pet, error) {
 	encEmail, nonce, err := s.crypto.Encrypt(input.BreederEmail)
 	if err != nil {
 		return Pet{}, fmt.Errorf("encrypt email: %w", err)
 	}
-
-	var petID string
-	var createdAt time.Time
-	err = s.pool.QueryRow(ctx, `
+	pet, err := s.scanPet(tx.QueryRow(ctx, `
 		INSERT INTO pets (
 			store_id, name, species, age_years, picture_url, description,
-			breeder_name, breeder_email_enc, breeder_email_nonce
+			breeder_name, breeder_email_enc, breeder_email_nonce, price_minor,
+			picture_key, picture_width, picture_height
 		)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
-		RETURNING id, created_at
-	`, storeID, input.Name, input.Species, input.AgeYears, input.PictureURL, input.Description,
-		input.BreederName, encEmail, nonce).Scan(&petID, &createdAt)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+		RETURNING `+petColumns+`
+	`, append([]any{storeID, input.Name, input.Species.Slug, input.AgeYears, input.PictureURL, input.Description,
+		input.BreederName, encEmail, nonce, input.Price.Amount}, pictureArgs(input.Picture)...)...))
 	if err != nil {
 		return Pet{}, fmt.Errorf("insert pet: %w", err)
 	}
+	return pet, nil
+}
+
+var (
+	ErrStoreNotFound = errors.New("store not found")
+	ErrPetNotFound   = errors.New("pet not found")
+	ErrPetSold       = errors.New("sold pets cannot be changed")
+	// ErrOrderTooLarge keeps order totals within the range every client
+	// can read as minor units.
+	ErrOrderTooLarge = errors.New("order total is too large; buy fewer pets at once")
+)
+
+// VersionConflictError reports that a pet changed since the caller read it.
+type VersionConflictError struct {
+	PetID          string
+	CurrentVersion int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("pet %s was modified; current version is %d", e.PetID, e.CurrentVersion)
+}
+
+// UpdatePet applies a partial update to an unsold pet. The breeder email is
+// re-encrypted only when the patch changes it.
+func (s *Store) UpdatePet(ctx context.Context, storeID int64, petID string, expectedVersion int, patch PetPatch) (Pet, error) {
+	var updated Pet
+	err := s.withPetLock(ctx, storeID, petID, expectedVersion, func(tx pgx.Tx, pet Pet) error {
+		if pet.PurchasedAt != nil {
+			return ErrPetSold
+		}
+		oldEmail, oldSpecies := pet.BreederEmail, pet.Species.Slug
+		pet = patch.apply(pet)
+		if err := validatePet(pet); err != nil {
+			return err
+		}
+		if pet.Species.Slug != oldSpecies {
+			if err := checkSpecies(ctx, tx, storeID, pet.Species.Slug); err != nil {
+				return err
+			}
+		}
+
+		var encEmail, nonce []byte
+		if pet.BreederEmail != oldEmail {
+			var err error
+			encEmail, nonce, err = s.crypto.Encrypt(pet.BreederEmail)
+			if err != nil {
+				return fmt.Errorf("encrypt email: %w", err)
+			}
+		}
+
+		row := tx.QueryRow(ctx, `
+			UPDATE pets
+			SET name = $3, species = $4, age_years = $5, picture_url = $6,
+			    description = $7, breeder_name = $8,
+			    breeder_email_enc = COALESCE($9, breeder_email_enc),
+			    breeder_email_nonce = COALESCE($10, breeder_email_nonce),
+			    price_minor = $11, picture_key = $12, picture_width = $13,
+			    picture_height = $14, version = version + 1
+			WHERE store_id = $1 AND id = $2
+			RETURNING `+petColumns+`
+		`, append([]any{storeID, petID, pet.Name, pet.Species.Slug, pet.AgeYears, pet.PictureURL,
+			pet.Description, pet.BreederName, encEmail, nonce, pet.Price.Amount}, pictureArgs(pet.Picture)...)...)
+		var err error
+		if updated, err = s.scanPet(row); err != nil {
+			return err
+		}
+		return s.enqueueWebhooks(ctx, tx, WebhookPetUpdated, storeID, []string{petID})
+	})
+	if err == nil {
+		s.publish(ctx, events.PetUpdated, storeID, "", []string{petID})
+	}
+	return updated, err
+}
 
-	input.ID = petID
-	input.StoreID = storeID
-	input.CreatedAt = createdAt
-	return input, nil
+// ArchivePet hides an unsold pet from the catalog without deleting it.
+func (s *Store) ArchivePet(ctx context.Context, storeID int64, petID string, expectedVersion int) (Pet, error) {
+	return s.setArchived(ctx, storeID, petID, expectedVersion, true)
 }
 
-func (s *Store) ListMerchantPets(ctx context.Context, storeID int64) ([]Pet, error) {
-	rows, err := s.pool.Query(ctx, `
-		SELECT id, store_id, name, species, age_years, picture_url,
+func (s *Store) RestorePet(ctx context.Context, storeID int64, petID string, expectedVersion int) (Pet, error) {
+	return s.setArchived(ctx, storeID, petID, expectedVersion, false)
+}
+
+func (s *Store) setArchived(ctx context.Context, storeID int64, petID string, expectedVersion int, archived bool) (Pet, error) {
+	var updated Pet
+	err := s.withPetLock(ctx, storeID, petID, expectedVersion, func(tx pgx.Tx, pet Pet) error {
+		if pet.PurchasedAt != nil {
+			return ErrPetSold
+		}
+		row := tx.QueryRow(ctx, `
+			UPDATE pets
+			SET archived_at = CASE WHEN $3 THEN COALESCE(archived_at, NOW()) END,
+			    version = version + 1
+			WHERE store_id = $1 AND id = $2
+			RETURNING `+petColumns+`
+		`, storeID, petID, archived)
+		var err error
+		if updated, err = s.scanPet(row); err != nil {
+			return err
+		}
+		event := WebhookPetRestored
+		if archived {
+			event = WebhookPetArchived
+		}
+		return s.enqueueWebhooks(ctx, tx, event, storeID, []string{petID})
+	})
+	if err == nil {
+		kind := events.PetRestored
+		if archived {
+			kind = events.PetArchived
+		}
+		s.publish(ctx, kind, storeID, "", []string{petID})
+	}
+	return updated, err
+}
+
+// withPetLock loads a pet with FOR UPDATE and runs fn in the same
+// transaction, committing only if fn succeeds. The pet must still be at
+// expectedVersion, otherwise a *VersionConflictError is returned.
+func (s *Store) withPetLock(ctx context.Context, storeID int64, petID string, expectedVersion int, fn func(pgx.Tx, Pet) error) (err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	pet, err := s.scanPet(tx.QueryRow(ctx, `
+		SELECT `+petColumns+`
+		FROM pets
+		WHERE store_id = $1 AND id = $2
+		FOR UPDATE
+	`, storeID, petID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrPetNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if pet.Version != expectedVersion {
+		return &VersionConflictError{PetID: petID, CurrentVersion: pet.Version}
+	}
+	if err = fn(tx, pet); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// pictureArgs are the picture_key, picture_width and picture_height
+// values stored for pic, all NULL for a pet with an external picture.
+func pictureArgs(pic *Picture) []any {
+	if pic == nil {
+		return []any{nil, nil, nil}
+	}
+	return []any{pic.Key, pic.Width, pic.Height}
+}
+
+func (p PetPatch) apply(pet Pet) Pet {
+	if p.Name != nil {
+		pet.Name = *p.Name
+	}
+	if p.Species != nil {
+		pet.Species = Species{Slug: *p.Species}
+	}
+	if p.AgeYears != nil {
+		pet.AgeYears = *p.AgeYears
+	}
+	if p.PictureURL != nil {
+		pet.PictureURL, pet.Picture = *p.PictureURL, nil
+	}
+	if p.Picture != nil {
+		pet.PictureURL, pet.Picture = "", p.Picture
+	}
+	if p.Description != nil {
+		pet.Description = *p.Description
+	}
+	if p.BreederName != nil {
+		pet.BreederName = *p.BreederName
+	}
+	if p.BreederEmail != nil {
+		pet.BreederEmail = *p.BreederEmail
+	}
+	if p.PriceMinor != nil {
+		pet.Price.Amount = *p.PriceMinor
+	}
+	return pet
+}
+
+func (f ArchiveFilter) apply(q *listQuery) error {
+	switch f {
+	case "", ArchiveFilterActive:
+		q.where("archived_at IS NULL")
+	case ArchiveFilterArchived:
+		q.where("archived_at IS NOT NULL")
+	case ArchiveFilterAll:
+	default:
+		return fmt.Errorf("unknown archive filter %q", f)
+	}
+	return nil
+}
+
+const petColumns = `id, store_id, name, species,` + petSpeciesColumns + `, age_years, picture_url,
+		       picture_key, picture_width, picture_height,
 		       description, breeder_name, breeder_email_enc, breeder_email_nonce,
-		       created_at, purchased_at
+		       price_minor, (SELECT currency FROM stores WHERE stores.id = pets.store_id),
+		       created_at, purchased_at, archived_at, version`
+
+func (s *Store) ListMerchantPets(ctx context.Context, storeID int64, archived ArchiveFilter) ([]Pet, error) {
+	var q listQuery
+	q.where("store_id = %s", storeID)
+	if err := archived.apply(&q); err != nil {
+		return nil, err
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+petColumns+`
 		FROM pets
-		WHERE store_id = $1
+		WHERE `+q.clause()+`
 		ORDER BY created_at DESC
-	`, storeID)
+	`, q.args...)
 	if err != nil {
 		return nil, fmt.Errorf("query pets: %w", err)
 	}
-	defer rows.Close()
+	return s.scanPets(rows)
+}
 
-	var pets []Pet
-	for rows.Next() {
-		var pet Pet
-		var emailEnc []byte
-		var emailNonce []byte
-		if err := rows.Scan(
-			&pet.ID, &pet.StoreID, &pet.Name, &pet.Species, &pet.AgeYears,
-			&pet.PictureURL, &pet.Description, &pet.BreederName,
-			&emailEnc, &emailNonce, &pet.CreatedAt, &pet.PurchasedAt,
-		); err != nil {
-			return nil, fmt.Errorf("scan pet: %w", err)
-		}
-		email, err := s.crypto.Decrypt(emailEnc, emailNonce)
-		if err != nil {
-			return nil, fmt.Errorf("decrypt email: %w", err)
-		}
-		pet.BreederEmail = email
-		pets = append(pets, pet)
+func (s *Store) GetPets(ctx context.Context, storeID int64, petIDs []string) ([]Pet, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+petColumns+`
+		FROM pets
+		WHERE store_id = $1 AND id = ANY($2::uuid[])
+		ORDER BY created_at, id
+	`, storeID, petIDs)
+	if err != nil {
+		return nil, fmt.Errorf("query pets: %w", err)
 	}
-	return pets, nil
+	return s.scanPets(rows)
 }
 
-func (s *Store) ListAvailablePets(ctx context.Context, storeID int64) ([]Pet, error) {
+func (s *Store) ListAvailablePets(ctx context.Context, storeID int64, customerID int64) ([]Pet, error) {
+	q, err := availablePetsQuery(storeID, customerID, PetFilter{}, false)
+	if err != nil {
+		return nil, err
+	}
 	rows, err := s.pool.Query(ctx, `
-		SELECT id, store_id, name, species, age_years, picture_url,
-		       description, breeder_name, breeder_email_enc, breeder_email_nonce,
-		       created_at, purchased_at
+		SELECT `+petColumns+`
 		FROM pets
-		WHERE store_id = $1 AND purchased_at IS NULL
+		WHERE `+q.clause()+`
 		ORDER BY created_at DESC
-	`, storeID)
+	`, q.args...)
 	if err != nil {
 		return nil, fmt.Errorf("query pets: %w", err)
 	}
-	defer rows.Close()
-
-	var pets []Pet
-	for rows.Next() {
-		var pet Pet
-		var emailEnc []byte
-		var emailNonce []byte
-		if err := rows.Scan(
-			&pet.ID, &pet.StoreID, &pet.Name, &pet.Species, &pet.AgeYears,
-			&pet.PictureURL, &pet.Description, &pet.BreederName,
-			&emailEnc, &emailNonce, &pet.CreatedAt, &pet.PurchasedAt,
-		); err != nil {
-			return nil, fmt.Errorf("scan pet: %w", err)
-		}
-		email, err := s.crypto.Decrypt(emailEnc, emailNonce)
-		if err != nil {
-			return nil, fmt.Errorf("decrypt email: %w", err)
-		}
-		pet.BreederEmail = email
-		pets = append(pets, pet)
-	}
-	return pets, nil
+	return s.scanPets(rows)
 }
 
 func (s *Store) ListPurchasedPets(ctx context.Context, storeID int64, customerID int64) ([]Pet, error) {
+	q := purchasedPetsQuery(storeID, customerID)
 	rows, err := s.pool.Query(ctx, `
-		SELECT id, store_id, name, species, age_years, picture_url,
-		       description, breeder_name, breeder_email_enc, breeder_email_nonce,
-		       created_at, purchased_at
+		SELECT `+petColumns+`
 		FROM pets
-		WHERE store_id = $1 AND purchased_by_customer_id = $2
+		WHERE `+q.clause()+`
 		ORDER BY purchased_at DESC
-	`, storeID, customerID)
+	`, q.args...)
 	if err != nil {
 		return nil, fmt.Errorf("query pets: %w", err)
 	}
+	return s.scanPets(rows)
+}
+
+// purchasedPetsQuery matches the pets in a customer's orders.
+func purchasedPetsQuery(storeID, customerID int64) listQuery {
+	var q listQuery
+	q.where(`store_id = %[1]s AND id IN (
+			SELECT oi.pet_id
+			FROM order_items oi
+			JOIN orders o ON o.id = oi.order_id
+			WHERE o.store_id = %[1]s AND o.customer_id = %[2]s AND oi.status = 'PURCHASED'
+		)`, storeID, customerID)
+	return q
+}
+
+func (s *Store) PageMerchantPets(ctx context.Context, storeID int64, archived ArchiveFilter, page PageRequest) (PetPage, error) {
+	var q listQuery
+	q.where("store_id = %s", storeID)
+	if err := archived.apply(&q); err != nil {
+		return PetPage{}, err
+	}
+	return s.pagePets(ctx, q, sortCreatedDesc, page)
+}
+
+func (s *Store) PagePurchasedPets(ctx context.Context, storeID int64, customerID int64, page PageRequest) (PetPage, error) {
+	return s.pagePets(ctx, purchasedPetsQuery(storeID, customerID), sortPurchasedDesc, page)
+}
+
+func (s *Store) scanPets(rows pgx.Rows) ([]Pet, error) {
 	defer rows.Close()
 
 	var pets []Pet
 	for rows.Next() {
-		var pet Pet
-		var emailEnc []byte
-		var emailNonce []byte
-		if err := rows.Scan(
-			&pet.ID, &pet.StoreID, &pet.Name, &pet.Species, &pet.AgeYears,
-			&pet.PictureURL, &pet.Description, &pet.BreederName,
-			&emailEnc, &emailNonce, &pet.CreatedAt, &pet.PurchasedAt,
-		); err != nil {
-			return nil, fmt.Errorf("scan pet: %w", err)
-		}
-		email, err := s.crypto.Decrypt(emailEnc, emailNonce)
+		pet, err := s.scanPet(rows)
 		if err != nil {
-			return nil, fmt.Errorf("decrypt email: %w", err)
+			return nil, err
 		}
-		pet.BreederEmail = email
 		pets = append(pets, pet)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate pets: %w", err)
+	}
 	return pets, nil
 }
 
+// scanPet reads a row selected with petColumns, followed by any extra
+// columns into extra.
+func (s *Store) scanPet(row pgx.Row, extra ...any) (Pet, error) {
+	var pet Pet
+	var emailEnc []byte
+	var emailNonce []byte
+	var parentSlug, parentName *string
+	var pictureKey *string
+	var pictureWidth, pictureHeight *int
+	dest := []any{
+		&pet.ID, &pet.StoreID, &pet.Name,
+		&pet.Species.Slug, &pet.Species.Name, &parentSlug, &parentName,
+		&pet.AgeYears, &pet.PictureURL, &pictureKey, &pictureWidth, &pictureHeight,
+		&pet.Description, &pet.BreederName,
+		&emailEnc, &emailNonce, &pet.Price.Amount, &pet.Price.Currency,
+		&pet.CreatedAt, &pet.PurchasedAt, &pet.ArchivedAt, &pet.Version,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return Pet{}, fmt.Errorf("scan pet: %w", err)
+	}
+	pet.Species.setParent(parentSlug, parentName)
+	if pictureKey != nil && pictureWidth != nil && pictureHeight != nil {
+		pet.Picture = &Picture{Key: *pictureKey, Width: *pictureWidth, Height: *pictureHeight}
+	}
+	email, err := s.crypto.Decrypt(emailEnc, emailNonce)
+	if err != nil {
+		return Pet{}, fmt.Errorf("decrypt email: %w", err)
+	}
+	pet.BreederEmail = email
+	return pet, nil
+}
+
 func (s *Store) PurchasePets(ctx context.Context, storeID int64, customerID int64, petIDs []string) (PurchaseResult, error) {
+	return s.purchase(ctx, storeID, customerID, petIDs, purchaseKey{})
+}
+
+// PurchasePetsWithKey is PurchasePets made safe to retry: a repeated call
+// with the same key and pets returns the first call's result unchanged.
+func (s *Store) PurchasePetsWithKey(ctx context.Context, storeID int64, customerID int64, key string, petIDs []string) (PurchaseResult, error) {
+	pk, err := newPurchaseKey(key, petsFingerprint(petIDs))
+	if err != nil {
+		return PurchaseResult{}, err
+	}
+	return s.purchase(ctx, storeID, customerID, petIDs, pk)
+}
+
+func (s *Store) purchase(ctx context.Context, storeID int64, customerID int64, petIDs []string, key purchaseKey) (PurchaseResult, error) {
+	result, err := s.runPurchase(ctx, storeID, customerID, petIDs, key)
+	if err != nil {
+		s.recordAuditFailure(ctx, newAuditEvent(ctx, AuditPurchasePets, petIDs...), err)
+	}
+	return result, err
+}
+
+func (s *Store) runPurchase(ctx context.Context, storeID int64, customerID int64, petIDs []string, key purchaseKey) (PurchaseResult, error) {
 	if len(petIDs) == 0 {
 		return PurchaseResult{}, errors.New("no pets in cart")
 	}
@@ -243,24 +611,57 @@ func (s *Store) PurchasePets(ctx context.Context, storeID int64, customerID int6
 		}
 	}()
 
+	var active bool
+	err = tx.QueryRow(ctx, `SELECT deactivated_at IS NULL FROM stores WHERE id = $1 FOR SHARE`, storeID).Scan(&active)
+	if err != nil {
+		return result, fmt.Errorf("check store: %w", err)
+	}
+	if !active {
+		err = ErrStoreInactive
+		return result, err
+	}
+
+	if key.value != "" {
+		var claimed bool
+		claimed, err = key.claim(ctx, tx, customerID, s.idempotencyTTL)
+		if err != nil {
+			return result, err
+		}
+		if !claimed {
+			_ = tx.Rollback(ctx)
+			replayed, found, replayErr := s.replayPurchase(ctx, storeID, customerID, key)
+			if replayErr == nil && !found {
+				replayErr = errors.New("idempotency key expired during retry")
+			}
+			return replayed, replayErr
+		}
+	}
+
 	rows, err := tx.Query(ctx, `
-		SELECT id, name, purchased_at
-		FROM pets
-		WHERE store_id = $1 AND id = ANY($2)
-		FOR UPDATE
-	`, storeID, petIDs)
+		SELECT p.id, p.name, p.purchased_at, p.archived_at, p.price_minor,
+		       EXISTS (
+		         SELECT 1 FROM cart_holds h
+		         WHERE h.pet_id = p.id AND h.expires_at > NOW() AND h.customer_id <> $3
+		       )
+		FROM pets p
+		WHERE p.store_id = $1 AND p.id = ANY($2)
+		FOR UPDATE OF p
+	`, storeID, petIDs, customerID)
 	if err != nil {
 		return result, fmt.Errorf("select pets: %w", err)
 	}
 	defer rows.Close()
 
-	available := make(map[string]string)
+	available := make(map[string]int64)
 	seen := make(map[string]bool)
 	for rows.Next() {
 		var id string
 		var name string
 		var purchasedAt *time.Time
-		if err := rows.Scan(&id, &name, &purchasedAt); err != nil {
+		var archivedAt *time.Time
+		var priceMinor int64
+		var heldByOther bool
+		if err := rows.Scan(&id, &name, &purchasedAt, &archivedAt, &priceMinor, &heldByOther); err != nil {
 			return result, fmt.Errorf("scan pet: %w", err)
 		}
 		seen[id] = true
@@ -271,7 +672,21 @@ func (s *Store) PurchasePets(ctx context.Context, storeID int64, customerID int6
 			})
 			continue
 		}
-		available[id] = name
+		if archivedAt != nil {
+			result.Errors = append(result.Errors, PurchaseError{
+				PetName: name,
+				Message: "no longer available",
+			})
+			continue
+		}
+		if heldByOther {
+			result.Errors = append(result.Errors, PurchaseError{
+				PetName: name,
+				Message: "reserved by another customer",
+			})
+			continue
+		}
+		available[id] = priceMinor
 	}
 
 	for _, id := range petIDs {
@@ -285,22 +700,92 @@ func (s *Store) PurchasePets(ctx context.Context, storeID int64, customerID int6
 
 	if len(available) > 0 {
 		ids := make([]string, 0, len(available))
-		for id := range available {
+		prices := make([]int64, 0, len(available))
+		var subtotal int64
+		for id, price := range available {
 			ids = append(ids, id)
+			prices = append(prices, price)
+			subtotal += price
 		}
+		if subtotal > math.MaxInt32 {
+			err = ErrOrderTooLarge
+			return result, err
+		}
+		// Prices are read under the row locks above, so the order records
+		// exactly what the customer paid even if the listing is edited later.
+		order := Order{StoreID: storeID, CustomerID: customerID, Status: OrderStatusPlaced}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO orders (store_id, customer_id, status, currency, subtotal_minor, total_minor)
+			SELECT $1, $2, $3, s.currency, $4, $4
+			FROM stores s
+			WHERE s.id = $1
+			RETURNING id, placed_at, currency, (SELECT username FROM customers WHERE id = $2)
+		`, storeID, customerID, order.Status, subtotal).Scan(&order.ID, &order.PlacedAt, &order.Subtotal.Currency, &order.CustomerUsername)
+		if err != nil {
+			return result, fmt.Errorf("insert order: %w", err)
+		}
+		order.Subtotal.Amount = subtotal
+		order.Total = order.Subtotal
 		_, err = tx.Exec(ctx, `
+			INSERT INTO order_items (order_id, pet_id, unit_price_minor, currency)
+			SELECT $1, item.pet_id, item.price, $4
+			FROM unnest($2::uuid[], $3::bigint[]) AS item(pet_id, price)
+		`, order.ID, ids, prices, order.Subtotal.Currency)
+		if err != nil {
+			return result, fmt.Errorf("insert order items: %w", err)
+		}
+		var purchased pgx.Rows
+		purchased, err = tx.Query(ctx, `
 			UPDATE pets
-			SET purchased_at = NOW(), purchased_by_customer_id = $1
-			WHERE store_id = $2 AND id = ANY($3) AND purchased_at IS NULL
-		`, customerID, storeID, ids)
+			SET purchased_at = $4, purchased_by_customer_id = $1, version = version + 1
+			WHERE store_id = $2 AND id = ANY($3) AND purchased_at IS NULL AND archived_at IS NULL
+			RETURNING `+petColumns+`
+		`, customerID, storeID, ids, order.PlacedAt)
 		if err != nil {
 			return result, fmt.Errorf("update pets: %w", err)
 		}
+		var pets []Pet
+		pets, err = s.scanPets(purchased)
+		if err != nil {
+			return result, err
+		}
+		for _, pet := range pets {
+			order.Items = append(order.Items, OrderItem{
+				Pet:       pet,
+				UnitPrice: Money{Amount: available[pet.ID], Currency: order.Subtotal.Currency},
+				Status:    OrderItemPurchased,
+			})
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM cart_holds WHERE pet_id = ANY($1::uuid[])`, ids)
+		if err != nil {
+			return result, fmt.Errorf("release holds: %w", err)
+		}
+		if err = notifyFavoritesSold(ctx, tx, storeID, customerID, ids); err != nil {
+			return result, err
+		}
+		if err = enqueueBreederMail(ctx, tx, OutboxPetSold, storeID, ids); err != nil {
+			return result, err
+		}
+		if err = s.enqueueWebhooks(ctx, tx, WebhookPetPurchased, storeID, ids); err != nil {
+			return result, err
+		}
 		result.PurchasedIDs = ids
+		result.Order = &order
 	}
 
+	if key.value != "" {
+		if err = key.record(ctx, tx, customerID, result); err != nil {
+			return result, err
+		}
+	}
+	if err = insertAuditEvent(ctx, tx, result.auditEvent(ctx, petIDs)); err != nil {
+		return result, err
+	}
 	if err = tx.Commit(ctx); err != nil {
 		return result, fmt.Errorf("commit: %w", err)
 	}
+	if result.Order != nil {
+		s.publish(ctx, events.PetPurchased, storeID, result.Order.ID, result.PurchasedIDs)
+	}
 	return result, nil
 }
@@ -0,0 +1,322 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrStoreInactive  = errors.New("store is inactive")
+	ErrStoreSlugTaken = errors.New("store slug is already taken")
+	ErrCurrencyLocked = errors.New("currency cannot change once the store has pets")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,62}[a-z0-9])?$`)
+
+const maxNameLength = 100
+
+// StoreInput is a store created by a platform admin. OwnerUsername, if set,
+// names an existing merchant who becomes a member of the store.
+type StoreInput struct {
+	Slug          string
+	Name          string
+	Currency      string
+	OwnerUsername string
+}
+
+// StorePatch holds store settings to change; nil fields are left alone.
+type StorePatch struct {
+	Name     *string
+	Currency *string
+}
+
+func (in StoreInput) validate() error {
+	if err := validateSlug(in.Slug); err != nil {
+		return err
+	}
+	if err := validateName(in.Name); err != nil {
+		return err
+	}
+	return ValidateCurrency(in.Currency)
+}
+
+func (p StorePatch) validate() error {
+	if p.Name != nil {
+		if err := validateName(*p.Name); err != nil {
+			return err
+		}
+	}
+	if p.Currency != nil {
+		return ValidateCurrency(*p.Currency)
+	}
+	return nil
+}
+
+func validateSlug(slug string) error {
+	if !slugPattern.MatchString(slug) {
+		return errors.New("slug must be 1-64 lowercase letters, digits or inner hyphens")
+	}
+	return nil
+}
+
+// validateName checks a display name such as a store's or a species'.
+func validateName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("name is required")
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxNameLength)
+	}
+	return nil
+}
+
+// auditDetail names the settings the patch changes.
+func (p StorePatch) auditDetail(before StoreInfo) string {
+	var changed []string
+	if p.Name != nil && *p.Name != before.Name {
+		changed = append(changed, "name")
+	}
+	if p.Currency != nil && *p.Currency != before.Currency {
+		changed = append(changed, "currency")
+	}
+	return strings.Join(changed, ", ")
+}
+
+func (p StorePatch) apply(store StoreInfo) StoreInfo {
+	if p.Name != nil {
+		store.Name = *p.Name
+	}
+	if p.Currency != nil {
+		store.Currency = *p.Currency
+	}
+	return store
+}
+
+func activeAuditDetail(active bool) string {
+	if active {
+		return "reactivated"
+	}
+	return "deactivated"
+}
+
+const storeColumns = `id, slug, name, currency, deactivated_at IS NULL`
+
+func scanStore(row pgx.Row) (StoreInfo, error) {
+	var store StoreInfo
+	err := row.Scan(&store.ID, &store.Slug, &store.Name, &store.Currency, &store.Active)
+	return store, err
+}
+
+// MerchantStores lists the stores a merchant is a member of.
+func (s *Store) MerchantStores(ctx context.Context, merchantID int64) ([]StoreInfo, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT s.id, s.slug, s.name, s.currency, s.deactivated_at IS NULL
+		FROM merchant_stores ms
+		JOIN stores s ON s.id = ms.store_id
+		WHERE ms.merchant_id = $1
+		ORDER BY s.name, s.id
+	`, merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("query merchant stores: %w", err)
+	}
+	defer rows.Close()
+	var stores []StoreInfo
+	for rows.Next() {
+		store, err := scanStore(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan store: %w", err)
+		}
+		stores = append(stores, store)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate stores: %w", err)
+	}
+	return stores, nil
+}
+
+// StoreIDBySlug resolves a store for callers without a principal, such as
+// the CLI.
+func (s *Store) StoreIDBySlug(ctx context.Context, slug string) (int64, error) {
+	var id int64
+	err := s.pool.QueryRow(ctx, `SELECT id FROM stores WHERE slug = $1`, slug).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrStoreNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("query store: %w", err)
+	}
+	return id, nil
+}
+
+// GetStore returns a store's public metadata, including deactivated
+// stores.
+func (s *Store) GetStore(ctx context.Context, slug string) (StoreInfo, error) {
+	store, err := scanStore(s.pool.QueryRow(ctx, `SELECT `+storeColumns+` FROM stores WHERE slug = $1`, slug))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return StoreInfo{}, ErrStoreNotFound
+	}
+	if err != nil {
+		return StoreInfo{}, fmt.Errorf("query store: %w", err)
+	}
+	return store, nil
+}
+
+func (s *Store) CreateStore(ctx context.Context, input StoreInput) (StoreInfo, error) {
+	store, err := s.createStore(ctx, input)
+	if err != nil {
+		s.recordAuditFailure(ctx, newAuditEvent(ctx, AuditCreateStore, input.Slug), err)
+	}
+	return store, err
+}
+
+func (s *Store) createStore(ctx context.Context, input StoreInput) (store StoreInfo, err error) {
+	if err := input.validate(); err != nil {
+		return StoreInfo{}, err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return StoreInfo{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	store, err = scanStore(tx.QueryRow(ctx, `
+		INSERT INTO stores (slug, name, currency)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (slug) DO NOTHING
+		RETURNING `+storeColumns,
+		input.Slug, input.Name, input.Currency))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return StoreInfo{}, ErrStoreSlugTaken
+	}
+	if err != nil {
+		return StoreInfo{}, fmt.Errorf("insert store: %w", err)
+	}
+	if input.OwnerUsername != "" {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO merchant_stores (merchant_id, store_id)
+			SELECT id, $2 FROM merchants WHERE username = $1
+		`, input.OwnerUsername, store.ID)
+		if err != nil {
+			return StoreInfo{}, fmt.Errorf("add owner: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return StoreInfo{}, fmt.Errorf("merchant %q not found", input.OwnerUsername)
+		}
+	}
+	if err = insertAuditEvent(ctx, tx, storeAuditEvent(ctx, AuditCreateStore, store.ID, store.Slug)); err != nil {
+		return StoreInfo{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return StoreInfo{}, fmt.Errorf("commit: %w", err)
+	}
+	return store, nil
+}
+
+// UpdateStore changes a store's settings. The currency is fixed once the
+// store has pets, since their prices are kept in it.
+func (s *Store) UpdateStore(ctx context.Context, slug string, patch StorePatch) (StoreInfo, error) {
+	store, err := s.updateStore(ctx, slug, patch)
+	if err != nil {
+		s.recordAuditFailure(ctx, newAuditEvent(ctx, AuditUpdateStore, slug), err)
+	}
+	return store, err
+}
+
+func (s *Store) updateStore(ctx context.Context, slug string, patch StorePatch) (store StoreInfo, err error) {
+	if err := patch.validate(); err != nil {
+		return StoreInfo{}, err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return StoreInfo{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	before, err := scanStore(tx.QueryRow(ctx, `SELECT `+storeColumns+` FROM stores WHERE slug = $1 FOR UPDATE`, slug))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return StoreInfo{}, ErrStoreNotFound
+	}
+	if err != nil {
+		return StoreInfo{}, fmt.Errorf("select store: %w", err)
+	}
+	store = patch.apply(before)
+	if store.Currency != before.Currency {
+		var hasPets bool
+		if err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pets WHERE store_id = $1)`, store.ID).Scan(&hasPets); err != nil {
+			return StoreInfo{}, fmt.Errorf("count pets: %w", err)
+		}
+		if hasPets {
+			return StoreInfo{}, ErrCurrencyLocked
+		}
+	}
+	if _, err = tx.Exec(ctx, `UPDATE stores SET name = $2, currency = $3 WHERE id = $1`, store.ID, store.Name, store.Currency); err != nil {
+		return StoreInfo{}, fmt.Errorf("update store: %w", err)
+	}
+	event := storeAuditEvent(ctx, AuditUpdateStore, store.ID, store.Slug)
+	event.Detail = patch.auditDetail(before)
+	if err = insertAuditEvent(ctx, tx, event); err != nil {
+		return StoreInfo{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return StoreInfo{}, fmt.Errorf("commit: %w", err)
+	}
+	return store, nil
+}
+
+// SetStoreActive deactivates or reactivates a store. Deactivating keeps all
+// of the store's data and can be undone.
+func (s *Store) SetStoreActive(ctx context.Context, slug string, active bool) (StoreInfo, error) {
+	store, err := s.setStoreActive(ctx, slug, active)
+	if err != nil {
+		s.recordAuditFailure(ctx, newAuditEvent(ctx, AuditUpdateStore, slug), fmt.Errorf("%s: %w", activeAuditDetail(active), err))
+	}
+	return store, err
+}
+
+func (s *Store) setStoreActive(ctx context.Context, slug string, active bool) (store StoreInfo, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return StoreInfo{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	store, err = scanStore(tx.QueryRow(ctx, `
+		UPDATE stores
+		SET deactivated_at = CASE WHEN $2 THEN NULL ELSE COALESCE(deactivated_at, NOW()) END
+		WHERE slug = $1
+		RETURNING `+storeColumns,
+		slug, active))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return StoreInfo{}, ErrStoreNotFound
+	}
+	if err != nil {
+		return StoreInfo{}, fmt.Errorf("update store: %w", err)
+	}
+	event := storeAuditEvent(ctx, AuditUpdateStore, store.ID, store.Slug)
+	event.Detail = activeAuditDetail(active)
+	if err = insertAuditEvent(ctx, tx, event); err != nil {
+		return StoreInfo{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return StoreInfo{}, fmt.Errorf("commit: %w", err)
+	}
+	return store, nil
+}
@@ -0,0 +1,751 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+
+	"nimble-challenge/backend/internal/crypto"
+)
+
+const (
+	// MaxWebhookEndpoints is how many endpoints one store may register.
+	MaxWebhookEndpoints = 10
+	// webhookRetention is how long finished deliveries stay in the log.
+	webhookRetention = 30 * 24 * time.Hour
+
+	maxWebhookURLLength         = 2048
+	maxWebhookDescriptionLength = 200
+)
+
+var (
+	ErrWebhookEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrWebhookEndpointInactive = errors.New("webhook endpoint is inactive")
+	ErrTooManyWebhookEndpoints = fmt.Errorf("at most %d webhook endpoints are allowed", MaxWebhookEndpoints)
+)
+
+type WebhookEvent string
+
+const (
+	WebhookPetCreated   WebhookEvent = "PET_CREATED"
+	WebhookPetUpdated   WebhookEvent = "PET_UPDATED"
+	WebhookPetArchived  WebhookEvent = "PET_ARCHIVED"
+	WebhookPetRestored  WebhookEvent = "PET_RESTORED"
+	WebhookPetPurchased WebhookEvent = "PET_PURCHASED"
+	// WebhookPetReturned is sent when a cancellation or refund puts a sold
+	// pet back in the catalog.
+	WebhookPetReturned WebhookEvent = "PET_RETURNED"
+)
+
+var webhookEvents = []WebhookEvent{
+	WebhookPetCreated, WebhookPetUpdated, WebhookPetArchived,
+	WebhookPetRestored, WebhookPetPurchased, WebhookPetReturned,
+}
+
+// Name is how the event is called in payloads and headers, such as
+// pet.created.
+func (e WebhookEvent) Name() string {
+	return strings.ToLower(strings.Replace(string(e), "_", ".", 1))
+}
+
+type WebhookEndpoint struct {
+	ID          string
+	StoreID     int64
+	URL         string
+	Description string
+	Events      []WebhookEvent
+	Active      bool
+	CreatedAt   time.Time
+}
+
+type WebhookEndpointInput struct {
+	URL         string
+	Description string
+	Events      []WebhookEvent
+}
+
+// WebhookEndpointPatch holds changes to an endpoint; nil fields are left
+// alone. The secret cannot change; replace the endpoint instead.
+type WebhookEndpointPatch struct {
+	URL         *string
+	Description *string
+	Events      *[]WebhookEvent
+	Active      *bool
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookPending   WebhookDeliveryStatus = "PENDING"
+	WebhookSucceeded WebhookDeliveryStatus = "SUCCEEDED"
+	// WebhookFailed is final: the delivery ran out of attempts and is only
+	// sent again by a manual redelivery.
+	WebhookFailed WebhookDeliveryStatus = "FAILED"
+)
+
+// WebhookDelivery is one event sent, or to be sent, to one endpoint.
+// EventID is the same for every endpoint and for redeliveries, so that
+// receivers can drop duplicates.
+type WebhookDelivery struct {
+	ID             string
+	EndpointID     string
+	EventID        string
+	Event          WebhookEvent
+	Payload        json.RawMessage
+	Status         WebhookDeliveryStatus
+	Attempts       int
+	ResponseStatus *int
+	LastError      string
+	CreatedAt      time.Time
+	CompletedAt    *time.Time
+}
+
+type WebhookDeliveryFilter struct {
+	EndpointID string
+	Status     *WebhookDeliveryStatus
+}
+
+type WebhookDeliveryEdge struct {
+	Cursor   string
+	Delivery WebhookDelivery
+}
+
+type WebhookDeliveryPage struct {
+	Edges []WebhookDeliveryEdge
+	PageInfo
+}
+
+// WebhookJob is a delivery claimed for sending. Attempts counts this one.
+type WebhookJob struct {
+	DeliveryID  string
+	EventID     string
+	Event       WebhookEvent
+	URL         string
+	SecretEnc   []byte
+	SecretNonce []byte
+	Payload     json.RawMessage
+	Attempts    int
+}
+
+// webhookPayload is the JSON body of a delivery.
+type webhookPayload struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	OccurredAt time.Time  `json:"occurredAt"`
+	Pet        webhookPet `json:"pet"`
+}
+
+// webhookPet is the part of a pet sent to endpoints; breeder contact
+// details stay in the store.
+type webhookPet struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Species         string     `json:"species"`
+	AgeYears        int        `json:"ageYears"`
+	Description     string     `json:"description"`
+	PriceMinorUnits int64      `json:"priceMinorUnits"`
+	Currency        string     `json:"currency"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	PurchasedAt     *time.Time `json:"purchasedAt"`
+	ArchivedAt      *time.Time `json:"archivedAt"`
+	Version         int        `json:"version"`
+}
+
+func newWebhookPayload(event WebhookEvent, pet Pet) (string, json.RawMessage) {
+	payload := webhookPayload{
+		ID:         newUUID(),
+		Type:       event.Name(),
+		OccurredAt: time.Now().UTC(),
+		Pet: webhookPet{
+			ID:              pet.ID,
+			Name:            pet.Name,
+			Species:         pet.Species.Slug,
+			AgeYears:        pet.AgeYears,
+			Description:     pet.Description,
+			PriceMinorUnits: pet.Price.Amount,
+			Currency:        pet.Price.Currency,
+			CreatedAt:       pet.CreatedAt,
+			PurchasedAt:     pet.PurchasedAt,
+			ArchivedAt:      pet.ArchivedAt,
+			Version:         pet.Version,
+		},
+	}
+	// Marshal cannot fail on these field types.
+	data, _ := json.Marshal(payload)
+	return payload.ID, data
+}
+
+func validateWebhookURL(raw string) error {
+	if len(raw) > maxWebhookURLLength {
+		return fmt.Errorf("url must be at most %d characters", maxWebhookURLLength)
+	}
+	u, err := url.Parse(raw)
+	// Deliveries also refuse private and loopback addresses when they
+	// connect, since a public hostname can resolve to one.
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return errors.New("url must be an absolute https URL")
+	}
+	if u.User != nil {
+		return errors.New("url must not contain credentials")
+	}
+	return nil
+}
+
+func validateWebhookDescription(description string) error {
+	if utf8.RuneCountInString(description) > maxWebhookDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters", maxWebhookDescriptionLength)
+	}
+	return nil
+}
+
+// normalizeWebhookEvents checks the events and drops repeats.
+func normalizeWebhookEvents(events []WebhookEvent) ([]WebhookEvent, error) {
+	if len(events) == 0 {
+		return nil, errors.New("choose at least one event")
+	}
+	var unique []WebhookEvent
+	for _, e := range events {
+		if !slices.Contains(webhookEvents, e) {
+			return nil, fmt.Errorf("unknown webhook event %q", e)
+		}
+		if !slices.Contains(unique, e) {
+			unique = append(unique, e)
+		}
+	}
+	return unique, nil
+}
+
+func (in WebhookEndpointInput) normalize() (WebhookEndpointInput, error) {
+	in.URL = strings.TrimSpace(in.URL)
+	in.Description = strings.TrimSpace(in.Description)
+	if err := validateWebhookURL(in.URL); err != nil {
+		return in, err
+	}
+	if err := validateWebhookDescription(in.Description); err != nil {
+		return in, err
+	}
+	var err error
+	in.Events, err = normalizeWebhookEvents(in.Events)
+	return in, err
+}
+
+// apply returns the endpoint with the patch applied and checked, and the
+// names of the settings it changes.
+func (p WebhookEndpointPatch) apply(e WebhookEndpoint) (WebhookEndpoint, string, error) {
+	var changed []string
+	if p.URL != nil {
+		u := strings.TrimSpace(*p.URL)
+		if err := validateWebhookURL(u); err != nil {
+			return e, "", err
+		}
+		if u != e.URL {
+			e.URL = u
+			changed = append(changed, "url")
+		}
+	}
+	if p.Description != nil {
+		d := strings.TrimSpace(*p.Description)
+		if err := validateWebhookDescription(d); err != nil {
+			return e, "", err
+		}
+		if d != e.Description {
+			e.Description = d
+			changed = append(changed, "description")
+		}
+	}
+	if p.Events != nil {
+		events, err := normalizeWebhookEvents(*p.Events)
+		if err != nil {
+			return e, "", err
+		}
+		if !slices.Equal(events, e.Events) {
+			e.Events = events
+			changed = append(changed, "events")
+		}
+	}
+	if p.Active != nil && *p.Active != e.Active {
+		e.Active = *p.Active
+		changed = append(changed, "active")
+	}
+	return e, strings.Join(changed, ", "), nil
+}
+
+// newWebhookSecret returns a signing secret for a new endpoint.
+func newWebhookSecret() (string, error) {
+	token, _, err := crypto.NewToken()
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + token, nil
+}
+
+func eventStrings(events []WebhookEvent) []string {
+	out := make([]string, 0, len(events))
+	for _, e := range events {
+		out = append(out, string(e))
+	}
+	return out
+}
+
+const webhookEndpointColumns = `id, store_id, url, description, events, active, created_at`
+
+func scanWebhookEndpoint(row pgx.Row) (WebhookEndpoint, error) {
+	var e WebhookEndpoint
+	var events []string
+	if err := row.Scan(&e.ID, &e.StoreID, &e.URL, &e.Description, &events, &e.Active, &e.CreatedAt); err != nil {
+		return WebhookEndpoint{}, err
+	}
+	for _, ev := range events {
+		e.Events = append(e.Events, WebhookEvent(ev))
+	}
+	return e, nil
+}
+
+// CreateWebhookEndpoint registers an endpoint and returns it with its
+// signing secret, which is not shown again.
+func (s *Store) CreateWebhookEndpoint(ctx context.Context, storeID int64, input WebhookEndpointInput) (WebhookEndpoint, string, error) {
+	endpoint, secret, err := s.createWebhookEndpoint(ctx, storeID, input)
+	if err != nil {
+		s.recordAuditFailure(ctx, storeAuditEvent(ctx, AuditCreateWebhook, storeID), err)
+	}
+	return endpoint, secret, err
+}
+
+func (s *Store) createWebhookEndpoint(ctx context.Context, storeID int64, input WebhookEndpointInput) (endpoint WebhookEndpoint, secret string, err error) {
+	if input, err = input.normalize(); err != nil {
+		return WebhookEndpoint{}, "", err
+	}
+	if secret, err = newWebhookSecret(); err != nil {
+		return WebhookEndpoint{}, "", err
+	}
+	secretEnc, nonce, err := s.crypto.Encrypt(secret)
+	if err != nil {
+		return WebhookEndpoint{}, "", fmt.Errorf("encrypt secret: %w", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return WebhookEndpoint{}, "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	// Locking the store serializes concurrent registrations, so the limit
+	// holds.
+	var count int
+	err = tx.QueryRow(ctx, `
+		WITH store AS (SELECT id FROM stores WHERE id = $1 FOR UPDATE)
+		SELECT COUNT(e.id) FROM store s LEFT JOIN webhook_endpoints e ON e.store_id = s.id
+	`, storeID).Scan(&count)
+	if err != nil {
+		return WebhookEndpoint{}, "", fmt.Errorf("count webhook endpoints: %w", err)
+	}
+	if count >= MaxWebhookEndpoints {
+		err = ErrTooManyWebhookEndpoints
+		return WebhookEndpoint{}, "", err
+	}
+	endpoint, err = scanWebhookEndpoint(tx.QueryRow(ctx, `
+		INSERT INTO webhook_endpoints (store_id, url, description, events, secret_enc, secret_nonce)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+webhookEndpointColumns,
+		storeID, input.URL, input.Description, eventStrings(input.Events), secretEnc, nonce))
+	if err != nil {
+		return WebhookEndpoint{}, "", fmt.Errorf("insert webhook endpoint: %w", err)
+	}
+	if err = insertAuditEvent(ctx, tx, storeAuditEvent(ctx, AuditCreateWebhook, storeID, endpoint.ID)); err != nil {
+		return WebhookEndpoint{}, "", err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return WebhookEndpoint{}, "", fmt.Errorf("commit: %w", err)
+	}
+	return endpoint, secret, nil
+}
+
+func (s *Store) UpdateWebhookEndpoint(ctx context.Context, storeID int64, id string, patch WebhookEndpointPatch) (WebhookEndpoint, error) {
+	endpoint, err := s.updateWebhookEndpoint(ctx, storeID, id, patch)
+	if err != nil {
+		s.recordAuditFailure(ctx, storeAuditEvent(ctx, AuditUpdateWebhook, storeID, id), err)
+	}
+	return endpoint, err
+}
+
+func (s *Store) updateWebhookEndpoint(ctx context.Context, storeID int64, id string, patch WebhookEndpointPatch) (endpoint WebhookEndpoint, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return WebhookEndpoint{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	endpoint, err = scanWebhookEndpoint(tx.QueryRow(ctx, `
+		SELECT `+webhookEndpointColumns+`
+		FROM webhook_endpoints
+		WHERE store_id = $1 AND id = $2
+		FOR UPDATE
+	`, storeID, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrWebhookEndpointNotFound
+		return WebhookEndpoint{}, err
+	}
+	if err != nil {
+		return WebhookEndpoint{}, fmt.Errorf("select webhook endpoint: %w", err)
+	}
+	var detail string
+	if endpoint, detail, err = patch.apply(endpoint); err != nil {
+		return WebhookEndpoint{}, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE webhook_endpoints
+		SET url = $3, description = $4, events = $5, active = $6
+		WHERE store_id = $1 AND id = $2
+	`, storeID, id, endpoint.URL, endpoint.Description, eventStrings(endpoint.Events), endpoint.Active)
+	if err != nil {
+		return WebhookEndpoint{}, fmt.Errorf("update webhook endpoint: %w", err)
+	}
+	event := storeAuditEvent(ctx, AuditUpdateWebhook, storeID, id)
+	event.Detail = detail
+	if err = insertAuditEvent(ctx, tx, event); err != nil {
+		return WebhookEndpoint{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return WebhookEndpoint{}, fmt.Errorf("commit: %w", err)
+	}
+	return endpoint, nil
+}
+
+// DeleteWebhookEndpoint removes an endpoint along with its deliveries.
+func (s *Store) DeleteWebhookEndpoint(ctx context.Context, storeID int64, id string) error {
+	err := s.deleteWebhookEndpoint(ctx, storeID, id)
+	if err != nil {
+		s.recordAuditFailure(ctx, storeAuditEvent(ctx, AuditDeleteWebhook, storeID, id), err)
+	}
+	return err
+}
+
+func (s *Store) deleteWebhookEndpoint(ctx context.Context, storeID int64, id string) (err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	tag, err := tx.Exec(ctx, `DELETE FROM webhook_endpoints WHERE store_id = $1 AND id = $2`, storeID, id)
+	if err != nil {
+		return fmt.Errorf("delete webhook endpoint: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		err = ErrWebhookEndpointNotFound
+		return err
+	}
+	if err = insertAuditEvent(ctx, tx, storeAuditEvent(ctx, AuditDeleteWebhook, storeID, id)); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) ListWebhookEndpoints(ctx context.Context, storeID int64) ([]WebhookEndpoint, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+webhookEndpointColumns+`
+		FROM webhook_endpoints
+		WHERE store_id = $1
+		ORDER BY created_at, id
+	`, storeID)
+	if err != nil {
+		return nil, fmt.Errorf("query webhook endpoints: %w", err)
+	}
+	defer rows.Close()
+
+	var endpoints []WebhookEndpoint
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan webhook endpoint: %w", err)
+		}
+		endpoints = append(endpoints, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate webhook endpoints: %w", err)
+	}
+	return endpoints, nil
+}
+
+const webhookDeliveryColumns = `id, endpoint_id, event_id, event, payload, status, attempts,
+	response_status, last_error, created_at, completed_at`
+
+func scanWebhookDelivery(row pgx.Row, extra ...any) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	dest := []any{&d.ID, &d.EndpointID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+		&d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.CompletedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return WebhookDelivery{}, err
+	}
+	return d, nil
+}
+
+var sortDeliveredDesc = sortKey{expr: "created_at", cast: "timestamptz", desc: true}
+
+// PageWebhookDeliveries pages the store's delivery log, newest first.
+func (s *Store) PageWebhookDeliveries(ctx context.Context, storeID int64, filter WebhookDeliveryFilter, page PageRequest) (WebhookDeliveryPage, error) {
+	var q listQuery
+	q.where("store_id = %s", storeID)
+	if filter.EndpointID != "" {
+		q.where("endpoint_id = %s", filter.EndpointID)
+	}
+	if filter.Status != nil {
+		q.where("status = %s", string(*filter.Status))
+	}
+	tail, limit, backward, err := q.keyset(sortDeliveredDesc, "id", page)
+	if err != nil {
+		return WebhookDeliveryPage{}, err
+	}
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+		SELECT %s, (%s)::text
+		FROM webhook_deliveries
+		WHERE %s
+		%s
+	`, webhookDeliveryColumns, sortDeliveredDesc.expr, q.clause(), tail), q.args...)
+	if err != nil {
+		return WebhookDeliveryPage{}, fmt.Errorf("query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var edges []WebhookDeliveryEdge
+	for rows.Next() {
+		var key string
+		d, err := scanWebhookDelivery(rows, &key)
+		if err != nil {
+			return WebhookDeliveryPage{}, fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		edges = append(edges, WebhookDeliveryEdge{Cursor: encodeCursor(key, d.ID), Delivery: d})
+	}
+	if err := rows.Err(); err != nil {
+		return WebhookDeliveryPage{}, fmt.Errorf("iterate webhook deliveries: %w", err)
+	}
+
+	var result WebhookDeliveryPage
+	result.Edges, result.PageInfo = trimPage(edges, limit, backward, page, func(e WebhookDeliveryEdge) string { return e.Cursor })
+	return result, nil
+}
+
+// RedeliverWebhook queues the delivery's payload to its endpoint again as
+// a new delivery, whatever became of the original. The endpoint must be
+// active.
+func (s *Store) RedeliverWebhook(ctx context.Context, storeID int64, deliveryID string) (WebhookDelivery, error) {
+	delivery, err := s.redeliverWebhook(ctx, storeID, deliveryID)
+	if err != nil {
+		s.recordAuditFailure(ctx, storeAuditEvent(ctx, AuditRedeliverWebhook, storeID, deliveryID), err)
+	}
+	return delivery, err
+}
+
+func (s *Store) redeliverWebhook(ctx context.Context, storeID int64, deliveryID string) (delivery WebhookDelivery, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return WebhookDelivery{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	var active bool
+	err = tx.QueryRow(ctx, `
+		SELECT e.active
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		WHERE d.store_id = $1 AND d.id = $2
+		FOR SHARE OF e
+	`, storeID, deliveryID).Scan(&active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrWebhookDeliveryNotFound
+		return WebhookDelivery{}, err
+	}
+	if err != nil {
+		return WebhookDelivery{}, fmt.Errorf("find webhook delivery: %w", err)
+	}
+	if !active {
+		err = ErrWebhookEndpointInactive
+		return WebhookDelivery{}, err
+	}
+
+	delivery, err = scanWebhookDelivery(tx.QueryRow(ctx, `
+		INSERT INTO webhook_deliveries (store_id, endpoint_id, event_id, event, payload)
+		SELECT store_id, endpoint_id, event_id, event, payload
+		FROM webhook_deliveries
+		WHERE store_id = $1 AND id = $2
+		RETURNING `+webhookDeliveryColumns,
+		storeID, deliveryID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrWebhookDeliveryNotFound
+		return WebhookDelivery{}, err
+	}
+	if err != nil {
+		return WebhookDelivery{}, fmt.Errorf("redeliver webhook: %w", err)
+	}
+	if err = insertAuditEvent(ctx, tx, storeAuditEvent(ctx, AuditRedeliverWebhook, storeID, deliveryID, delivery.ID)); err != nil {
+		return WebhookDelivery{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return WebhookDelivery{}, fmt.Errorf("commit: %w", err)
+	}
+	return delivery, nil
+}
+
+// ClaimWebhookDeliveries takes up to limit deliveries that are due and
+// hides them from other dispatchers for lease, like ClaimOutboxMessages.
+// Deliveries to inactive endpoints stay pending until the endpoint is
+// activated again.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookJob, error) {
+	rows, err := s.pool.Query(ctx, `
+		WITH due AS (
+			SELECT d.id, e.url, e.secret_enc, e.secret_nonce
+			FROM webhook_deliveries d
+			JOIN webhook_endpoints e ON e.id = d.endpoint_id
+			WHERE d.status = 'PENDING' AND d.next_attempt_at <= NOW() AND e.active
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM due
+		WHERE d.id = due.id
+		RETURNING d.id, d.event_id, d.event, due.url, due.secret_enc, due.secret_nonce, d.payload, d.attempts
+	`, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []WebhookJob
+	for rows.Next() {
+		var job WebhookJob
+		if err := rows.Scan(&job.DeliveryID, &job.EventID, &job.Event, &job.URL, &job.SecretEnc, &job.SecretNonce,
+			&job.Payload, &job.Attempts); err != nil {
+			return nil, fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate webhook deliveries: %w", err)
+	}
+	return jobs, nil
+}
+
+// CompleteWebhookDelivery records a request made under the claim that
+// brought the delivery's attempts to attempts, like CompleteOutboxMessage.
+func (s *Store) CompleteWebhookDelivery(ctx context.Context, id string, attempts int, responseStatus int) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = 'SUCCEEDED', response_status = $3, last_error = '', completed_at = NOW()
+		WHERE id = $1 AND status = 'PENDING' AND attempts = $2
+	`, id, attempts, responseStatus)
+	if err != nil {
+		return fmt.Errorf("complete webhook delivery: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// FailWebhookDelivery records a failed attempt. responseStatus is nil when
+// the endpoint could not be reached. The delivery is retried at retryAt, or
+// marked FAILED when retryAt is nil. It must come from the latest claim.
+func (s *Store) FailWebhookDelivery(ctx context.Context, id string, attempts int, responseStatus *int, cause string, retryAt *time.Time) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET response_status = $3, last_error = $4,
+		    status = CASE WHEN $5::timestamptz IS NULL THEN 'FAILED' ELSE 'PENDING' END,
+		    next_attempt_at = COALESCE($5, next_attempt_at),
+		    completed_at = CASE WHEN $5::timestamptz IS NULL THEN NOW() END
+		WHERE id = $1 AND status = 'PENDING' AND attempts = $2
+	`, id, attempts, responseStatus, cause, retryAt)
+	if err != nil {
+		return fmt.Errorf("fail webhook delivery: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// DeleteOldWebhookDeliveries trims the delivery log to the last 30 days.
+// Pending deliveries are kept however old they are.
+func (s *Store) DeleteOldWebhookDeliveries(ctx context.Context) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE status <> 'PENDING' AND completed_at <= NOW() - make_interval(secs => $1)
+	`, webhookRetention.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("delete webhook deliveries: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// enqueueWebhooks queues the event for each of the pets to every active
+// endpoint of the store that subscribed to it.
+func (s *Store) enqueueWebhooks(ctx context.Context, tx pgx.Tx, event WebhookEvent, storeID int64, petIDs []string) error {
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM webhook_endpoints
+		WHERE store_id = $1 AND active AND $2 = ANY(events)
+	`, storeID, string(event))
+	if err != nil {
+		return fmt.Errorf("select webhook endpoints: %w", err)
+	}
+	endpoints, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("select webhook endpoints: %w", err)
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+	rows, err = tx.Query(ctx, `
+		SELECT `+petColumns+`
+		FROM pets
+		WHERE store_id = $1 AND id = ANY($2::uuid[])
+		ORDER BY id
+	`, storeID, petIDs)
+	if err != nil {
+		return fmt.Errorf("select webhook pets: %w", err)
+	}
+	pets, err := s.scanPets(rows)
+	if err != nil {
+		return err
+	}
+	for _, pet := range pets {
+		eventID, payload := newWebhookPayload(event, pet)
+		_, err = tx.Exec(ctx, `
+			INSERT INTO webhook_deliveries (store_id, endpoint_id, event_id, event, payload)
+			SELECT $1, endpoint_id, $3, $4, $5
+			FROM unnest($2::uuid[]) AS endpoint_id
+		`, storeID, endpoints, eventID, string(event), payload)
+		if err != nil {
+			return fmt.Errorf("enqueue webhook: %w", err)
+		}
+	}
+	return nil
+}
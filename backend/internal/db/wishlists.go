@@ -0,0 +1,362 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxSavedSearches is how many saved searches one customer may keep.
+const MaxSavedSearches = 20
+
+var (
+	ErrFavoriteUnavailable  = errors.New("only available pets can be favorited")
+	ErrSavedSearchNotFound  = errors.New("saved search not found")
+	ErrTooManySavedSearches = fmt.Errorf("at most %d saved searches are allowed", MaxSavedSearches)
+)
+
+var errEmptySavedSearch = errors.New("a saved search needs at least one filter")
+
+// SavedSearch is a catalog filter a customer is alerted about whenever a
+// new listing matches it.
+type SavedSearch struct {
+	ID        string
+	Name      string
+	Filter    PetFilter
+	CreatedAt time.Time
+}
+
+type NotificationKind string
+
+const (
+	NotificationSavedSearchMatch NotificationKind = "SAVED_SEARCH_MATCH"
+	NotificationFavoriteSold     NotificationKind = "FAVORITE_SOLD"
+)
+
+// Notification is an in-app alert for a customer. Message is written when
+// the alert is raised; SavedSearchID is empty for favorites and once the
+// saved search is deleted.
+type Notification struct {
+	ID            string
+	Kind          NotificationKind
+	Message       string
+	Pet           Pet
+	SavedSearchID string
+	CreatedAt     time.Time
+	ReadAt        *time.Time
+}
+
+type NotificationEdge struct {
+	Cursor       string
+	Notification Notification
+}
+
+type NotificationPage struct {
+	Edges []NotificationEdge
+	PageInfo
+}
+
+// normalize trims the query and drops repeated species so that saved
+// filters compare and match the same way the catalog does.
+func (f PetFilter) normalize() PetFilter {
+	f.Query = strings.TrimSpace(f.Query)
+	var species []string
+	for _, slug := range f.Species {
+		if !slices.Contains(species, slug) {
+			species = append(species, slug)
+		}
+	}
+	f.Species = species
+	return f
+}
+
+func validateSavedSearch(name string, filter PetFilter) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	if filter.MinAge != nil && filter.MaxAge != nil && *filter.MinAge > *filter.MaxAge {
+		return errors.New("min age cannot exceed max age")
+	}
+	if len(filter.Species) == 0 && filter.MinAge == nil && filter.MaxAge == nil &&
+		filter.CreatedAfter == nil && filter.Query == "" {
+		return errEmptySavedSearch
+	}
+	return nil
+}
+
+func savedSearchMessage(petName, searchName string) string {
+	return fmt.Sprintf("%s matches your saved search \"%s\".", petName, searchName)
+}
+
+func favoriteSoldMessage(petName string) string {
+	return fmt.Sprintf("%s from your favorites has been sold.", petName)
+}
+
+// FavoritePet adds an available pet to the customer's favorites. Adding a
+// favorite twice is not an error.
+func (s *Store) FavoritePet(ctx context.Context, storeID int64, customerID int64, petID string) (Pet, error) {
+	pet, err := s.scanPet(s.pool.QueryRow(ctx, `
+		SELECT `+petColumns+`
+		FROM pets
+		WHERE store_id = $1 AND id = $2
+	`, storeID, petID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Pet{}, ErrPetNotFound
+	}
+	if err != nil {
+		return Pet{}, err
+	}
+	if pet.PurchasedAt != nil || pet.ArchivedAt != nil {
+		return Pet{}, ErrFavoriteUnavailable
+	}
+	_, err = s.pool.Exec(ctx, `
+		INSERT INTO favorites (customer_id, pet_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, customerID, petID)
+	if err != nil {
+		return Pet{}, fmt.Errorf("insert favorite: %w", err)
+	}
+	return pet, nil
+}
+
+func (s *Store) UnfavoritePet(ctx context.Context, storeID int64, customerID int64, petID string) error {
+	_, err := s.pool.Exec(ctx, `
+		DELETE FROM favorites f
+		USING pets p
+		WHERE p.id = f.pet_id AND p.store_id = $1 AND f.customer_id = $2 AND f.pet_id = $3
+	`, storeID, customerID, petID)
+	if err != nil {
+		return fmt.Errorf("delete favorite: %w", err)
+	}
+	return nil
+}
+
+// ListFavorites returns the customer's favorites, most recently added
+// first. Pets sold since are kept, so the customer can see what happened.
+func (s *Store) ListFavorites(ctx context.Context, storeID int64, customerID int64) ([]Pet, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+petColumns+`
+		FROM favorites f
+		JOIN pets ON pets.id = f.pet_id
+		WHERE pets.store_id = $1 AND f.customer_id = $2
+		ORDER BY f.favorited_at DESC, pets.id
+	`, storeID, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("query favorites: %w", err)
+	}
+	return s.scanPets(rows)
+}
+
+func (s *Store) CreateSavedSearch(ctx context.Context, storeID int64, customerID int64, name string, filter PetFilter) (saved SavedSearch, err error) {
+	filter = filter.normalize()
+	if err := validateSavedSearch(name, filter); err != nil {
+		return SavedSearch{}, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return SavedSearch{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	// Locking the customer serializes concurrent saves, so the limit holds.
+	var count int
+	err = tx.QueryRow(ctx, `
+		WITH customer AS (SELECT id FROM customers WHERE id = $1 FOR UPDATE)
+		SELECT COUNT(ss.id) FROM customer c LEFT JOIN saved_searches ss ON ss.customer_id = c.id
+	`, customerID).Scan(&count)
+	if err != nil {
+		return SavedSearch{}, fmt.Errorf("count saved searches: %w", err)
+	}
+	if count >= MaxSavedSearches {
+		err = ErrTooManySavedSearches
+		return SavedSearch{}, err
+	}
+	species := filter.Species
+	if species == nil {
+		species = []string{}
+	}
+	saved = SavedSearch{Name: name, Filter: filter}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO saved_searches (store_id, customer_id, name, species, min_age, max_age, created_after, query)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`, storeID, customerID, name, species, filter.MinAge, filter.MaxAge, filter.CreatedAfter, filter.Query).
+		Scan(&saved.ID, &saved.CreatedAt)
+	if err != nil {
+		return SavedSearch{}, fmt.Errorf("insert saved search: %w", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return SavedSearch{}, fmt.Errorf("commit: %w", err)
+	}
+	return saved, nil
+}
+
+func (s *Store) ListSavedSearches(ctx context.Context, storeID int64, customerID int64) ([]SavedSearch, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, name, species, min_age, max_age, created_after, query, created_at
+		FROM saved_searches
+		WHERE store_id = $1 AND customer_id = $2
+		ORDER BY created_at, id
+	`, storeID, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("query saved searches: %w", err)
+	}
+	defer rows.Close()
+
+	var searches []SavedSearch
+	for rows.Next() {
+		var ss SavedSearch
+		if err := rows.Scan(&ss.ID, &ss.Name, &ss.Filter.Species, &ss.Filter.MinAge, &ss.Filter.MaxAge,
+			&ss.Filter.CreatedAfter, &ss.Filter.Query, &ss.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan saved search: %w", err)
+		}
+		searches = append(searches, ss)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate saved searches: %w", err)
+	}
+	return searches, nil
+}
+
+func (s *Store) DeleteSavedSearch(ctx context.Context, storeID int64, customerID int64, id string) error {
+	tag, err := s.pool.Exec(ctx, `
+		DELETE FROM saved_searches
+		WHERE store_id = $1 AND customer_id = $2 AND id = $3
+	`, storeID, customerID, id)
+	if err != nil {
+		return fmt.Errorf("delete saved search: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+var sortNotifiedDesc = sortKey{expr: "n.created_at", cast: "timestamptz", desc: true}
+
+// PageNotifications pages the customer's notifications, newest first.
+func (s *Store) PageNotifications(ctx context.Context, storeID int64, customerID int64, unreadOnly bool, page PageRequest) (NotificationPage, error) {
+	var q listQuery
+	q.where("n.store_id = %s AND n.customer_id = %s", storeID, customerID)
+	if unreadOnly {
+		q.where("n.read_at IS NULL")
+	}
+	tail, limit, backward, err := q.keyset(sortNotifiedDesc, "n.id", page)
+	if err != nil {
+		return NotificationPage{}, err
+	}
+	// The pet is selected in a lateral subquery because petColumns names
+	// columns such as id that notifications has as well.
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+		SELECT p.*, n.id, n.kind, n.message, COALESCE(n.saved_search_id::text, ''),
+		       n.created_at, n.read_at, (%s)::text
+		FROM notifications n
+		JOIN LATERAL (SELECT %s FROM pets WHERE pets.id = n.pet_id) p ON TRUE
+		WHERE %s
+		%s
+	`, sortNotifiedDesc.expr, petColumns, q.clause(), tail), q.args...)
+	if err != nil {
+		return NotificationPage{}, fmt.Errorf("query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var edges []NotificationEdge
+	for rows.Next() {
+		var n Notification
+		var key string
+		n.Pet, err = s.scanPet(rows, &n.ID, &n.Kind, &n.Message, &n.SavedSearchID, &n.CreatedAt, &n.ReadAt, &key)
+		if err != nil {
+			return NotificationPage{}, err
+		}
+		edges = append(edges, NotificationEdge{Cursor: encodeCursor(key, n.ID), Notification: n})
+	}
+	if err := rows.Err(); err != nil {
+		return NotificationPage{}, fmt.Errorf("iterate notifications: %w", err)
+	}
+
+	var result NotificationPage
+	result.Edges, result.PageInfo = trimPage(edges, limit, backward, page, func(e NotificationEdge) string { return e.Cursor })
+	return result, nil
+}
+
+func (s *Store) CountUnreadNotifications(ctx context.Context, storeID int64, customerID int64) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx, `
+		SELECT COUNT(1) FROM notifications
+		WHERE store_id = $1 AND customer_id = $2 AND read_at IS NULL
+	`, storeID, customerID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count notifications: %w", err)
+	}
+	return count, nil
+}
+
+// MarkNotificationsRead marks the given notifications read, or all of them
+// when ids is nil, and returns how many were unread.
+func (s *Store) MarkNotificationsRead(ctx context.Context, storeID int64, customerID int64, ids []string) (int, error) {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE notifications
+		SET read_at = NOW()
+		WHERE store_id = $1 AND customer_id = $2 AND read_at IS NULL
+		  AND ($3::uuid[] IS NULL OR id = ANY($3::uuid[]))
+	`, storeID, customerID, ids)
+	if err != nil {
+		return 0, fmt.Errorf("mark notifications read: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// notifySavedSearches alerts the owner of every saved search in the store
+// that matches petID, a pet just listed. The conditions follow
+// availablePetsQuery; a new pet cannot be in anyone's cart yet.
+func notifySavedSearches(ctx context.Context, tx pgx.Tx, storeID int64, petID string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO notifications (store_id, customer_id, kind, pet_id, saved_search_id, message)
+		SELECT ss.store_id, ss.customer_id, $3, p.id, ss.id,
+		       format('%s matches your saved search "%s".', p.name, ss.name)
+		FROM saved_searches ss
+		JOIN pets p ON p.store_id = ss.store_id AND p.id = $2
+		WHERE ss.store_id = $1
+		  AND (cardinality(ss.species) = 0 OR EXISTS (
+		    SELECT 1 FROM `+speciesFrom+`
+		    WHERE sp.store_id = p.store_id AND sp.slug = p.species
+		      AND (sp.slug = ANY(ss.species) OR parent.slug = ANY(ss.species))
+		  ))
+		  AND (ss.min_age IS NULL OR p.age_years >= ss.min_age)
+		  AND (ss.max_age IS NULL OR p.age_years <= ss.max_age)
+		  AND (ss.created_after IS NULL OR p.created_at > ss.created_after)
+		  AND (ss.query = '' OR p.search_vector @@ websearch_to_tsquery('`+searchConfig+`', ss.query))
+	`, storeID, petID, NotificationSavedSearchMatch)
+	if err != nil {
+		return fmt.Errorf("notify saved searches: %w", err)
+	}
+	return nil
+}
+
+// notifyFavoritesSold alerts everyone but the buyer who had one of the
+// purchased pets in their favorites.
+func notifyFavoritesSold(ctx context.Context, tx pgx.Tx, storeID int64, buyerID int64, petIDs []string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO notifications (store_id, customer_id, kind, pet_id, message)
+		SELECT p.store_id, f.customer_id, $4, p.id,
+		       format('%s from your favorites has been sold.', p.name)
+		FROM favorites f
+		JOIN pets p ON p.id = f.pet_id
+		WHERE p.store_id = $1 AND f.pet_id = ANY($2::uuid[]) AND f.customer_id <> $3
+	`, storeID, petIDs, buyerID, NotificationFavoriteSold)
+	if err != nil {
+		return fmt.Errorf("notify favorites: %w", err)
+	}
+	return nil
+}
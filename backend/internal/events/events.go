@@ -0,0 +1,129 @@
+// Package events carries pet changes from storage to the subscriptions
+// watching a store.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Kind string
+
+const (
+	PetCreated   Kind = "PET_CREATED"
+	PetUpdated   Kind = "PET_UPDATED"
+	PetArchived  Kind = "PET_ARCHIVED"
+	PetRestored  Kind = "PET_RESTORED"
+	PetPurchased Kind = "PET_PURCHASED"
+	// PetReturned is published when a cancellation or refund puts sold
+	// pets back in the catalog.
+	PetReturned Kind = "PET_RETURNED"
+)
+
+// Event is published once the change it describes has committed. It names
+// the pets instead of carrying them, so subscribers read their current
+// state and nothing private travels with the event.
+type Event struct {
+	Kind    Kind
+	StoreID int64
+	PetIDs  []string
+	// OrderID is set for purchases and returns.
+	OrderID    string
+	OccurredAt time.Time
+}
+
+// ChangesAvailability reports whether the event can add pets to or remove
+// them from the catalog customers see.
+func (e Event) ChangesAvailability() bool {
+	return e.Kind != PetUpdated
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Bus delivers published events to subscribers of the store they happened
+// in. A subscription's channel is closed when its context is done, or early
+// if the subscriber falls too far behind or events may have been lost;
+// subscribers should then resubscribe and reread whatever they display.
+type Bus interface {
+	Publisher
+	Subscribe(ctx context.Context, storeID int64) <-chan Event
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped.
+const subscriberBuffer = 64
+
+// Hub is a Bus within one process.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[int64]map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	ch     chan Event
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[int64]map[*subscriber]struct{})}
+}
+
+// Publish never blocks: subscribers that cannot keep up are dropped
+// instead of holding up the writer.
+func (h *Hub) Publish(ctx context.Context, e Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[e.StoreID] {
+		select {
+		case sub.ch <- e:
+		default:
+			h.remove(e.StoreID, sub)
+		}
+	}
+	return nil
+}
+
+func (h *Hub) Subscribe(ctx context.Context, storeID int64) <-chan Event {
+	sub := &subscriber{ch: make(chan Event, subscriberBuffer)}
+	h.mu.Lock()
+	if h.subs[storeID] == nil {
+		h.subs[storeID] = make(map[*subscriber]struct{})
+	}
+	h.subs[storeID][sub] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(storeID, sub)
+	}()
+	return sub.ch
+}
+
+func (h *Hub) remove(storeID int64, sub *subscriber) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	delete(h.subs[storeID], sub)
+	if len(h.subs[storeID]) == 0 {
+		delete(h.subs, storeID)
+	}
+}
+
+// Reset ends every subscription, for when events may have been missed and
+// subscribers need to reread.
+func (h *Hub) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for storeID, subs := range h.subs {
+		for sub := range subs {
+			h.remove(storeID, sub)
+		}
+	}
+}
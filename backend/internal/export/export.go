@@ -0,0 +1,242 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case "":
+		return FormatCSV, nil
+	case FormatCSV, FormatNDJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", s)
+	}
+}
+
+func (f Format) ContentType() string {
+	if f == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// flushEvery is how many rows are buffered before they are handed to the
+// underlying writer.
+const flushEvery = 100
+
+type rowWriter interface {
+	write(values []any) error
+	flush() error
+}
+
+// newRowWriter writes rows with the given columns: a CSV header line, or
+// the keys of each NDJSON object.
+func newRowWriter(w io.Writer, format Format, columns []string) (rowWriter, error) {
+	if format == FormatNDJSON {
+		keys := make([][]byte, len(columns))
+		for i, column := range columns {
+			keys[i], _ = json.Marshal(column)
+		}
+		return &ndjsonWriter{w: bufio.NewWriter(w), keys: keys}, nil
+	}
+	c := csv.NewWriter(w)
+	if err := c.Write(columns); err != nil {
+		return nil, err
+	}
+	return &csvWriter{w: c}, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) write(values []any) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = csvField(v)
+	}
+	return c.w.Write(fields)
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func csvField(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return escapeFormula(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int:
+		return strconv.Itoa(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// escapeFormula stops spreadsheets from evaluating cells that start like a
+// formula, since names and reasons come from users.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	keys [][]byte
+}
+
+func (n *ndjsonWriter) write(values []any) error {
+	n.w.WriteByte('{')
+	for i, key := range n.keys {
+		if i > 0 {
+			n.w.WriteByte(',')
+		}
+		n.w.Write(key)
+		n.w.WriteByte(':')
+		value, err := json.Marshal(jsonValue(values[i]))
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", key, err)
+		}
+		n.w.Write(value)
+	}
+	n.w.WriteString("}\n")
+	return nil
+}
+
+func (n *ndjsonWriter) flush() error {
+	return n.w.Flush()
+}
+
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return v.UTC().Format(time.RFC3339)
+	case string:
+		if v == "" {
+			return nil
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func petColumns(includeEmails bool) []string {
+	columns := []string{"id", "name", "species", "ageYears", "description", "breederName"}
+	if includeEmails {
+		columns = append(columns, "breederEmail")
+	}
+	return append(columns, "priceMinorUnits", "currency", "status", "createdAt", "purchasedAt", "archivedAt")
+}
+
+func petValues(p db.Pet, includeEmails bool) []any {
+	values := []any{p.ID, p.Name, p.Species.Slug, p.AgeYears, p.Description, p.BreederName}
+	if includeEmails {
+		values = append(values, p.BreederEmail)
+	}
+	return append(values, p.Price.Amount, p.Price.Currency, string(p.Status()), p.CreatedAt, p.PurchasedAt, p.ArchivedAt)
+}
+
+var saleColumns = []string{
+	"orderId", "placedAt", "customerUsername", "orderStatus", "petId", "petName", "species",
+	"unitPriceMinorUnits", "currency", "status", "reversedAt", "reversalReason",
+}
+
+func saleValues(s db.Sale) []any {
+	return []any{
+		s.OrderID, s.PlacedAt, s.CustomerUsername, string(s.OrderStatus), s.PetID, s.PetName, s.Species.Slug,
+		s.UnitPrice.Amount, s.UnitPrice.Currency, string(s.Status), s.ReversedAt, s.ReversalReason,
+	}
+}
+
+// Pets writes the store's pets to w as they are read from the store.
+// flush, if not nil, runs after each batch so HTTP clients see rows early.
+func Pets(ctx context.Context, store db.Exporter, storeID int64, filter db.PetExportFilter, format Format, w io.Writer, flush func()) error {
+	out, err := newRowWriter(w, format, petColumns(filter.IncludeBreederEmails))
+	if err != nil {
+		return err
+	}
+	n := 0
+	err = store.ExportPets(ctx, storeID, filter, func(pet db.Pet) error {
+		if err := out.write(petValues(pet, filter.IncludeBreederEmails)); err != nil {
+			return err
+		}
+		n++
+		return flushBatch(out, n, flush)
+	})
+	if err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// Sales writes the store's order items to w, one row per pet sold.
+func Sales(ctx context.Context, store db.Exporter, storeID int64, filter db.SaleExportFilter, format Format, w io.Writer, flush func()) error {
+	out, err := newRowWriter(w, format, saleColumns)
+	if err != nil {
+		return err
+	}
+	n := 0
+	err = store.ExportSales(ctx, storeID, filter, func(sale db.Sale) error {
+		if err := out.write(saleValues(sale)); err != nil {
+			return err
+		}
+		n++
+		return flushBatch(out, n, flush)
+	})
+	if err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+func flushBatch(out rowWriter, n int, flush func()) error {
+	if n%flushEvery != 0 {
+		return nil
+	}
+	if err := out.flush(); err != nil {
+		return err
+	}
+	if flush != nil {
+		flush()
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package export
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/db"
+)
+
+// NewHandler serves GET /export/pets and GET /export/sales to merchants of
+// the store named by ?store=, which defaults to the merchant's own. It
+// expects auth.Middleware to have run.
+func NewHandler(store db.Exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		principal, err := auth.FromContext(r.Context())
+		if err != nil || principal.Role != auth.RoleMerchant {
+			http.Error(w, "merchant access required", http.StatusForbidden)
+			return
+		}
+		query := r.URL.Query()
+		storeSlug := query.Get("store")
+		if storeSlug == "" {
+			storeSlug = principal.StoreSlug
+		}
+		storeID, ok := principal.Store(storeSlug)
+		if !ok {
+			http.Error(w, "store access denied", http.StatusForbidden)
+			return
+		}
+		format, err := ParseFormat(query.Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since, until, err := ParseRange(query.Get("since"), query.Get("until"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		statuses := splitList(query["status"])
+
+		var run func(*streamWriter) error
+		dataset := strings.TrimPrefix(r.URL.Path, "/export/")
+		switch dataset {
+		case "pets":
+			filter := db.PetExportFilter{Since: since, Until: until}
+			for _, s := range statuses {
+				filter.Statuses = append(filter.Statuses, db.PetStatus(s))
+			}
+			if filter.IncludeBreederEmails, err = parseBool(query.Get("includeBreederEmails")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := filter.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if filter.IncludeBreederEmails && !principal.Has(auth.PermissionExportBreederEmails) {
+				http.Error(w, "not allowed to export breeder emails", http.StatusForbidden)
+				return
+			}
+			run = func(sw *streamWriter) error {
+				return Pets(r.Context(), store, storeID, filter, format, sw, sw.flush)
+			}
+		case "sales":
+			filter := db.SaleExportFilter{Since: since, Until: until}
+			for _, s := range statuses {
+				filter.Statuses = append(filter.Statuses, db.OrderItemStatus(s))
+			}
+			if err := filter.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			run = func(sw *streamWriter) error {
+				return Sales(r.Context(), store, storeID, filter, format, sw, sw.flush)
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s-%s.%s"`,
+			storeSlug, dataset, time.Now().UTC().Format("20060102"), format))
+		sw := &streamWriter{w: w, rc: http.NewResponseController(w)}
+		_ = sw.rc.SetWriteDeadline(time.Now().Add(batchWriteTimeout))
+		if err := run(sw); err != nil {
+			log.Printf("export %s for store %d: %v", dataset, storeID, err)
+			if !sw.started {
+				w.Header().Del("Content-Disposition")
+				http.Error(w, "export failed", http.StatusInternalServerError)
+				return
+			}
+			// The status line is gone; dropping the connection keeps a
+			// truncated export from looking complete.
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+// batchWriteTimeout bounds how long one batch may take to reach the
+// client. Exports outlive the server's WriteTimeout, so the deadline is
+// pushed forward after every batch instead.
+const batchWriteTimeout = 30 * time.Second
+
+// streamWriter remembers whether any bytes reached the client, after which
+// errors can no longer be reported with a status code.
+type streamWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	started bool
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.w.Write(p)
+}
+
+func (s *streamWriter) flush() {
+	_ = s.rc.Flush()
+	_ = s.rc.SetWriteDeadline(time.Now().Add(batchWriteTimeout))
+}
+
+// ParseRange reads RFC 3339 timestamps or YYYY-MM-DD dates. A date as
+// until includes that whole day.
+func ParseRange(since, until string) (*time.Time, *time.Time, error) {
+	from, err := parseBound(since, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid since: %w", err)
+	}
+	to, err := parseBound(until, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid until: %w", err)
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, nil, fmt.Errorf("since must be before until")
+	}
+	return from, to, nil
+}
+
+func parseBound(value string, endOfDay bool) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a date or RFC 3339 time", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// splitList accepts repeated parameters as well as comma-separated values.
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.ToUpper(strings.TrimSpace(part)); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q", value)
+	}
+	return b, nil
+}
@@ -0,0 +1,127 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/crypto"
+	"nimble-challenge/backend/internal/db"
+)
+
+func newTestExport(t *testing.T) (*db.MemoryStore, *auth.Principal, *auth.Principal) {
+	t.Helper()
+	cipher, err := crypto.NewCipherFromBase64(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatalf("cipher: %v", err)
+	}
+	store := db.NewMemoryStore(cipher)
+	ctx := context.Background()
+	if err := store.EnsureDemoData(ctx, "demo", "Demo", "USD", "merchant", "merchant_pw", "customer", "customer_pw"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	merchant, err := store.Authenticate(ctx, "merchant", "merchant_pw")
+	if err != nil {
+		t.Fatalf("authenticate merchant: %v", err)
+	}
+	customer, err := store.Authenticate(ctx, "customer", "customer_pw")
+	if err != nil {
+		t.Fatalf("authenticate customer: %v", err)
+	}
+	return store, merchant, customer
+}
+
+func get(store db.Exporter, principal *auth.Principal, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+	rec := httptest.NewRecorder()
+	NewHandler(store).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestExportPetsCSV(t *testing.T) {
+	store, merchant, customer := newTestExport(t)
+	ctx := context.Background()
+	pets, _ := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	if _, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, []string{pets[0].ID}); err != nil {
+		t.Fatalf("purchase: %v", err)
+	}
+
+	if rec := get(store, customer, "/export/pets"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected customers to be refused, got %d", rec.Code)
+	}
+	if rec := get(store, merchant, "/export/pets?includeBreederEmails=true"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected breeder emails to need a permission, got %d", rec.Code)
+	}
+
+	rec := get(store, merchant, "/export/pets?status=available")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "id" || strings.Contains(rec.Body.String(), "@example.com") {
+		t.Fatalf("expected a header and 2 available pets without emails, got %v", records)
+	}
+
+	merchant.Permissions = []auth.Permission{auth.PermissionExportBreederEmails}
+	rec = get(store, merchant, "/export/pets?includeBreederEmails=true&status=SOLD")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), pets[0].BreederEmail) {
+		t.Fatalf("expected the sold pet's breeder email, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestExportSalesNDJSON(t *testing.T) {
+	store, merchant, customer := newTestExport(t)
+	ctx := context.Background()
+	pets, _ := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	result, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, []string{pets[0].ID, pets[1].ID})
+	if err != nil {
+		t.Fatalf("purchase: %v", err)
+	}
+	if _, err := store.RefundPurchase(ctx, merchant.StoreID, merchant.UserID, result.Order.ID, []string{pets[0].ID}, "damaged"); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+
+	if rec := get(store, merchant, "/export/sales?status=LOST"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown status to be rejected, got %d", rec.Code)
+	}
+	rec := get(store, merchant, "/export/sales?format=ndjson&status=REFUNDED&since=2000-01-01")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var row map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("decode %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 1 || rows[0]["petId"] != pets[0].ID || rows[0]["reversalReason"] != "damaged" || rows[0]["status"] != "REFUNDED" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+}
+
+func TestParseRangeIncludesUntilDay(t *testing.T) {
+	since, until, err := ParseRange("2024-03-01", "2024-03-31")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if since.Day() != 1 || until.Month() != 4 || until.Day() != 1 {
+		t.Fatalf("unexpected range %v - %v", since, until)
+	}
+	if _, _, err := ParseRange("2024-04-01", "2024-03-01"); err == nil {
+		t.Fatal("expected an inverted range to fail")
+	}
+}
@@ -0,0 +1,52 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+
+	"nimble-challenge/backend/internal/auth"
+)
+
+// customerPrincipal returns the caller if they are a customer of the store.
+func customerPrincipal(ctx context.Context, storeSlug string) (*auth.Principal, error) {
+	principal, err := auth.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if principal.Role != auth.RoleCustomer {
+		return nil, errors.New("customer access required")
+	}
+	if _, ok := principal.Store(storeSlug); !ok {
+		return nil, errors.New("store access denied")
+	}
+	return principal, nil
+}
+
+// merchantStore returns the caller and the ID of the store if the caller
+// is one of its merchants.
+func merchantStore(ctx context.Context, storeSlug string) (*auth.Principal, int64, error) {
+	principal, err := auth.FromContext(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if principal.Role != auth.RoleMerchant {
+		return nil, 0, errors.New("merchant access required")
+	}
+	storeID, ok := principal.Store(storeSlug)
+	if !ok {
+		return nil, 0, errors.New("store access denied")
+	}
+	return principal, storeID, nil
+}
+
+// adminPrincipal returns the caller if they are a platform admin.
+func adminPrincipal(ctx context.Context) (*auth.Principal, error) {
+	principal, err := auth.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if principal.Role != auth.RoleAdmin {
+		return nil, errors.New("admin access required")
+	}
+	return principal, nil
+}
@@ -0,0 +1,217 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/mail"
+	"nimble-challenge/backend/internal/ratelimit"
+)
+
+// Registrations and password reset requests need no credentials, so each
+// is limited per client IP on top of the handler's general rate limit.
+const (
+	accountRequestsPerWindow = 5
+	accountRequestWindow     = time.Hour
+)
+
+func newAccountLimiter() *ratelimit.Limiter {
+	return ratelimit.New(accountRequestsPerWindow, accountRequestWindow)
+}
+
+type UpdateProfileInput struct {
+	Email *string
+	Phone *string
+}
+
+func (r *Resolver) RegisterCustomer(ctx context.Context, args struct {
+	StoreSlug string
+	Username  string
+	Password  string
+	Email     string
+	Phone     *string
+}) (*AccountResolver, error) {
+	if r.registrations != nil && !r.registrations.Allow(clientIP(ctx)) {
+		return nil, errors.New("too many registrations, try again later")
+	}
+	reg := db.Registration{Username: args.Username, Password: args.Password, Email: args.Email}
+	if args.Phone != nil {
+		reg.Phone = *args.Phone
+	}
+	profile, err := r.Backend.RegisterCustomer(ctx, args.StoreSlug, reg)
+	if err != nil {
+		return nil, err
+	}
+	store, err := r.Backend.GetStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountResolver{role: auth.RoleCustomer, username: profile.Username, store: &store, profile: &profile}, nil
+}
+
+func (r *Resolver) Me(ctx context.Context) (*AccountResolver, error) {
+	principal, err := auth.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	account := &AccountResolver{role: principal.Role, username: principal.Username}
+	if principal.StoreSlug != "" {
+		store, err := r.Backend.GetStore(ctx, principal.StoreSlug)
+		if err != nil {
+			return nil, err
+		}
+		account.store = &store
+	}
+	if principal.Role == auth.RoleCustomer {
+		profile, err := r.Backend.CustomerProfile(ctx, principal.UserID)
+		if err != nil {
+			return nil, err
+		}
+		account.profile = &profile
+	}
+	return account, nil
+}
+
+func (r *Resolver) UpdateProfile(ctx context.Context, args struct{ Input UpdateProfileInput }) (*AccountResolver, error) {
+	principal, err := auth.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if principal.Role != auth.RoleCustomer {
+		return nil, errors.New("customer access required")
+	}
+	if _, err := r.Backend.UpdateCustomerProfile(ctx, principal.UserID, db.ProfilePatch{Email: args.Input.Email, Phone: args.Input.Phone}); err != nil {
+		return nil, err
+	}
+	return r.Me(ctx)
+}
+
+func (r *Resolver) ChangePassword(ctx context.Context, args struct {
+	CurrentPassword string
+	NewPassword     string
+}) (bool, error) {
+	principal, err := auth.FromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := r.Backend.ChangePassword(ctx, principal.Role, principal.UserID, args.CurrentPassword, args.NewPassword); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RequestPasswordReset mails a reset link to a customer. It answers before
+// looking the username up, so neither its result nor how long it takes can
+// be used to find accounts.
+func (r *Resolver) RequestPasswordReset(ctx context.Context, args struct{ Username string }) (bool, error) {
+	if r.passwordResets != nil && !r.passwordResets.Allow(clientIP(ctx)) {
+		return false, errors.New("too many password reset requests, try again later")
+	}
+	// Keep the request's metadata for the audit log, but not its
+	// cancellation.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), passwordResetTimeout)
+	r.background.Add(1)
+	go func() {
+		defer r.background.Done()
+		defer cancel()
+		if err := r.sendPasswordReset(ctx, strings.TrimSpace(args.Username)); err != nil {
+			log.Printf("password reset: %v", err)
+		}
+	}()
+	return true, nil
+}
+
+func (r *Resolver) sendPasswordReset(ctx context.Context, username string) error {
+	reset, found, err := r.Backend.CreatePasswordReset(ctx, username)
+	if err != nil || !found || r.mailer == nil {
+		return err
+	}
+	link, err := resetLink(r.passwordResetURL, reset.Token)
+	if err != nil {
+		return err
+	}
+	if err := r.mailer.Send(ctx, passwordResetMessage(reset, link)); err != nil {
+		return fmt.Errorf("mail %s: %w", reset.Username, err)
+	}
+	return nil
+}
+
+func (r *Resolver) ResetPassword(ctx context.Context, args struct {
+	Token       string
+	NewPassword string
+}) (bool, error) {
+	if err := r.Backend.ResetPassword(ctx, args.Token, args.NewPassword); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func resetLink(base, token string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("password reset URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func passwordResetMessage(reset db.PasswordReset, link string) mail.Message {
+	return mail.Message{
+		To:      reset.Email,
+		Subject: "Reset your " + reset.StoreName + " password",
+		Body: fmt.Sprintf("Someone asked to reset the password of %s at %s.\n\n"+
+			"Choose a new password here before %s:\n\n%s\n\n"+
+			"If that wasn't you, ignore this email; your password stays the same.\n",
+			reset.Username, reset.StoreName, reset.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"), link),
+	}
+}
+
+// clientIP keys per-client limits on the connecting address.
+func clientIP(ctx context.Context) string {
+	addr := auth.RequestMetaFromContext(ctx).RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+type AccountResolver struct {
+	role     auth.Role
+	username string
+	store    *db.StoreInfo
+	profile  *db.CustomerProfile
+}
+
+func (a *AccountResolver) Username() string { return a.username }
+func (a *AccountResolver) Role() string     { return strings.ToUpper(string(a.role)) }
+
+func (a *AccountResolver) Store() *StoreResolver {
+	if a.store == nil {
+		return nil
+	}
+	return &StoreResolver{store: *a.store}
+}
+
+func (a *AccountResolver) Email() *string {
+	if a.profile == nil || a.profile.Email == "" {
+		return nil
+	}
+	return &a.profile.Email
+}
+
+func (a *AccountResolver) Phone() *string {
+	if a.profile == nil || a.profile.Phone == "" {
+		return nil
+	}
+	return &a.profile.Phone
+}
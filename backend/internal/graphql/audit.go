@@ -0,0 +1,99 @@
+package graphql
+
+import (
+	"context"
+
+	gql "github.com/graph-gophers/graphql-go"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+type AuditEventFilterInput struct {
+	Action        *db.AuditAction
+	Outcome       *db.AuditOutcome
+	ActorUsername *string
+	Since         *gql.Time
+	Until         *gql.Time
+}
+
+func (in *AuditEventFilterInput) filter() db.AuditFilter {
+	var f db.AuditFilter
+	if in == nil {
+		return f
+	}
+	f.Action = in.Action
+	f.Outcome = in.Outcome
+	if in.ActorUsername != nil {
+		f.ActorUsername = *in.ActorUsername
+	}
+	if in.Since != nil {
+		f.Since = &in.Since.Time
+	}
+	if in.Until != nil {
+		f.Until = &in.Until.Time
+	}
+	return f
+}
+
+func (r *Resolver) AuditEvents(ctx context.Context, args struct {
+	StoreSlug string
+	Filter    *AuditEventFilterInput
+	PageArgs
+}) (*AuditEventConnectionResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	page, err := r.Backend.PageAuditEvents(ctx, storeID, args.Filter.filter(), args.pageRequest())
+	if err != nil {
+		return nil, err
+	}
+	return &AuditEventConnectionResolver{page: page}, nil
+}
+
+type AuditEventResolver struct {
+	event db.AuditEvent
+}
+
+func (e *AuditEventResolver) ID() gql.ID               { return gql.ID(e.event.ID) }
+func (e *AuditEventResolver) OccurredAt() gql.Time     { return gql.Time{Time: e.event.OccurredAt} }
+func (e *AuditEventResolver) Action() db.AuditAction   { return e.event.Action }
+func (e *AuditEventResolver) Outcome() db.AuditOutcome { return e.event.Outcome }
+func (e *AuditEventResolver) ActorRole() *string       { return optionalString(e.event.ActorRole) }
+func (e *AuditEventResolver) ActorUsername() *string   { return optionalString(e.event.ActorUsername) }
+func (e *AuditEventResolver) RequestId() *string       { return optionalString(e.event.RequestID) }
+func (e *AuditEventResolver) RemoteAddr() *string      { return optionalString(e.event.RemoteAddr) }
+func (e *AuditEventResolver) Detail() *string          { return optionalString(e.event.Detail) }
+
+func (e *AuditEventResolver) TargetIds() []gql.ID {
+	ids := make([]gql.ID, 0, len(e.event.TargetIDs))
+	for _, id := range e.event.TargetIDs {
+		ids = append(ids, gql.ID(id))
+	}
+	return ids
+}
+
+type AuditEventConnectionResolver struct {
+	page db.AuditEventPage
+}
+
+func (c *AuditEventConnectionResolver) Edges() []*AuditEventEdgeResolver {
+	edges := make([]*AuditEventEdgeResolver, 0, len(c.page.Edges))
+	for _, edge := range c.page.Edges {
+		edges = append(edges, &AuditEventEdgeResolver{edge: edge})
+	}
+	return edges
+}
+
+func (c *AuditEventConnectionResolver) PageInfo() *PageInfoResolver {
+	return &PageInfoResolver{info: c.page.PageInfo}
+}
+
+type AuditEventEdgeResolver struct {
+	edge db.AuditEventEdge
+}
+
+func (e *AuditEventEdgeResolver) Cursor() string { return e.edge.Cursor }
+func (e *AuditEventEdgeResolver) Node() *AuditEventResolver {
+	return &AuditEventResolver{event: e.edge.Event}
+}
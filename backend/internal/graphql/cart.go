@@ -0,0 +1,89 @@
+package graphql
+
+import (
+	"context"
+
+	gql "github.com/graph-gophers/graphql-go"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+func (r *Resolver) Cart(ctx context.Context, args struct{ StoreSlug string }) (*CartResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	cart, err := r.Backend.GetCart(ctx, principal.StoreID, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &CartResolver{cart: cart}, nil
+}
+
+func (r *Resolver) AddToCart(ctx context.Context, args struct {
+	StoreSlug string
+	PetID     gql.ID
+}) (*CartResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Backend.AddToCart(ctx, principal.StoreID, principal.UserID, string(args.PetID)); err != nil {
+		return nil, err
+	}
+	cart, err := r.Backend.GetCart(ctx, principal.StoreID, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &CartResolver{cart: cart}, nil
+}
+
+func (r *Resolver) RemoveFromCart(ctx context.Context, args struct {
+	StoreSlug string
+	PetID     gql.ID
+}) (*CartResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Backend.RemoveFromCart(ctx, principal.StoreID, principal.UserID, string(args.PetID)); err != nil {
+		return nil, err
+	}
+	cart, err := r.Backend.GetCart(ctx, principal.StoreID, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &CartResolver{cart: cart}, nil
+}
+
+type CartResolver struct {
+	cart db.Cart
+}
+
+func (c *CartResolver) Items() []*CartItemResolver {
+	items := make([]*CartItemResolver, 0, len(c.cart.Items))
+	for _, item := range c.cart.Items {
+		items = append(items, &CartItemResolver{item: item})
+	}
+	return items
+}
+
+func (c *CartResolver) Subtotal() *MoneyResolver { return &MoneyResolver{money: c.cart.Subtotal()} }
+
+// ExpiresAt is when the first hold in the cart lapses.
+func (c *CartResolver) ExpiresAt() *gql.Time {
+	var earliest *gql.Time
+	for _, item := range c.cart.Items {
+		if earliest == nil || item.ExpiresAt.Before(earliest.Time) {
+			earliest = &gql.Time{Time: item.ExpiresAt}
+		}
+	}
+	return earliest
+}
+
+type CartItemResolver struct {
+	item db.CartItem
+}
+
+func (i *CartItemResolver) Pet() *PetResolver   { return &PetResolver{pet: i.item.Pet} }
+func (i *CartItemResolver) ExpiresAt() gql.Time { return gql.Time{Time: i.item.ExpiresAt} }
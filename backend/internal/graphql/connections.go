@@ -0,0 +1,101 @@
+package graphql
+
+import (
+	"context"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+type PageArgs struct {
+	First  *int32
+	After  *string
+	Last   *int32
+	Before *string
+}
+
+func (a PageArgs) pageRequest() db.PageRequest {
+	var page db.PageRequest
+	if a.First != nil {
+		page.First = int(*a.First)
+	}
+	if a.After != nil {
+		page.After = *a.After
+	}
+	if a.Last != nil {
+		page.Last = int(*a.Last)
+	}
+	if a.Before != nil {
+		page.Before = *a.Before
+	}
+	return page
+}
+
+type PetConnectionResolver struct {
+	page db.PetPage
+}
+
+func (c *PetConnectionResolver) Edges() []*PetEdgeResolver {
+	edges := make([]*PetEdgeResolver, 0, len(c.page.Edges))
+	for _, edge := range c.page.Edges {
+		edges = append(edges, &PetEdgeResolver{edge: edge})
+	}
+	return edges
+}
+
+func (c *PetConnectionResolver) PageInfo() *PageInfoResolver {
+	return &PageInfoResolver{info: c.page.PageInfo}
+}
+
+// PetSearchConnectionResolver adds catalog facets, computed only when the
+// client selects them.
+type PetSearchConnectionResolver struct {
+	*PetConnectionResolver
+	store      db.Backend
+	storeID    int64
+	customerID int64
+	filter     db.PetFilter
+}
+
+func (c *PetSearchConnectionResolver) SpeciesFacets(ctx context.Context) ([]*SpeciesFacetResolver, error) {
+	facets, err := c.store.AvailableSpeciesFacets(ctx, c.storeID, c.customerID, c.filter)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*SpeciesFacetResolver, 0, len(facets))
+	for _, facet := range facets {
+		resolvers = append(resolvers, &SpeciesFacetResolver{facet: facet})
+	}
+	return resolvers, nil
+}
+
+type SpeciesFacetResolver struct {
+	facet db.SpeciesFacet
+}
+
+func (f *SpeciesFacetResolver) Species() *SpeciesResolver {
+	return &SpeciesResolver{species: f.facet.Species}
+}
+func (f *SpeciesFacetResolver) Count() int32 { return int32(f.facet.Count) }
+
+type PetEdgeResolver struct {
+	edge db.PetEdge
+}
+
+func (e *PetEdgeResolver) Cursor() string     { return e.edge.Cursor }
+func (e *PetEdgeResolver) Node() *PetResolver { return &PetResolver{pet: e.edge.Pet} }
+
+type PageInfoResolver struct {
+	info db.PageInfo
+}
+
+func (p *PageInfoResolver) HasNextPage() bool     { return p.info.HasNextPage }
+func (p *PageInfoResolver) HasPreviousPage() bool { return p.info.HasPreviousPage }
+func (p *PageInfoResolver) StartCursor() *string  { return optionalString(p.info.StartCursor) }
+func (p *PageInfoResolver) EndCursor() *string    { return optionalString(p.info.EndCursor) }
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,29 @@
+package graphql
+
+import (
+	"errors"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+// conflictError exposes a version conflict to clients as error extensions
+// so they can refetch and retry without parsing the message.
+type conflictError struct {
+	*db.VersionConflictError
+}
+
+func (e conflictError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":           "VERSION_CONFLICT",
+		"petId":          e.PetID,
+		"currentVersion": e.CurrentVersion,
+	}
+}
+
+func petMutationError(err error) error {
+	var conflict *db.VersionConflictError
+	if errors.As(err, &conflict) {
+		return conflictError{conflict}
+	}
+	return err
+}
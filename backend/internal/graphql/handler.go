@@ -1,17 +1,65 @@
 package graphql
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	gql "github.com/graph-gophers/graphql-go"
 	"github.com/graph-gophers/graphql-go/relay"
 
 	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/events"
+	"nimble-challenge/backend/internal/mail"
+	"nimble-challenge/backend/internal/pictures"
+	"nimble-challenge/backend/internal/websocket"
 )
 
-func NewHandler(store *db.Store) http.Handler {
-	schema := gql.MustParseSchema(Schema, &Resolver{Store: store})
+const (
+	maxRequestBytes = 2 << 20
+	maxUploadBytes  = 10 << 20
+)
+
+// Options configures the parts of the API that reach outside the process.
+type Options struct {
+	// Mailer sends password reset links. Without one, no mail is sent.
+	Mailer mail.Mailer
+	// PasswordResetURL is the page reset links open; the token is added
+	// as the token query parameter.
+	PasswordResetURL string
+	// Pictures stores uploaded pet pictures. Without it, pets can only
+	// link to external pictures.
+	Pictures *pictures.Library
+	// PublicURL is the API's external base URL, such as
+	// https://api.example.com. Links to uploaded pictures start with it.
+	PublicURL string
+	// Events feeds subscriptions. Without it, subscribing fails.
+	Events events.Bus
+	// ReauthInterval is how often WebSocket connections check their
+	// credentials again; a minute if zero.
+	ReauthInterval time.Duration
+}
+
+func NewHandler(store db.Backend, opts Options) http.Handler {
+	schema := gql.MustParseSchema(Schema, &Resolver{
+		Backend:          store,
+		pictures:         opts.Pictures,
+		mailer:           opts.Mailer,
+		passwordResetURL: opts.PasswordResetURL,
+		registrations:    newAccountLimiter(),
+		passwordResets:   newAccountLimiter(),
+		events:           opts.Events,
+	})
 	base := &relay.Handler{Schema: schema}
+	reauthInterval := opts.ReauthInterval
+	if reauthInterval <= 0 {
+		reauthInterval = defaultReauthInterval
+	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		applySecurityHeaders(w)
@@ -19,11 +67,142 @@ func NewHandler(store *db.Store) http.Handler {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		r.Body = http.MaxBytesReader(w, r.Body, 2<<20)
+		r = r.WithContext(withPublicURL(r.Context(), opts.PublicURL))
+		if websocket.IsUpgrade(r) {
+			serveWebSocket(w, r, schema, store, reauthInterval)
+			return
+		}
+		if isMultipart(r) {
+			serveMultipart(w, r, schema)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
 		base.ServeHTTP(w, r)
 	})
 }
 
+func isMultipart(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// serveMultipart implements the GraphQL multipart request spec: an
+// "operations" field with the usual JSON body, a "map" field naming the
+// variables each file goes into, and one part per file. Browsers can send
+// multipart forms cross-site without a preflight, so a custom header is
+// required to force one.
+func serveMultipart(w http.ResponseWriter, r *http.Request, schema *gql.Schema) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "uploads must be sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("GraphQL-Preflight") == "" {
+		http.Error(w, "GraphQL-Preflight header required for multipart requests", http.StatusBadRequest)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
+		http.Error(w, "invalid multipart request", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.Unmarshal([]byte(r.FormValue("operations")), &params); err != nil {
+		http.Error(w, "invalid operations field", http.StatusBadRequest)
+		return
+	}
+	var fileMap map[string][]string
+	if err := json.Unmarshal([]byte(r.FormValue("map")), &fileMap); err != nil {
+		http.Error(w, "invalid map field", http.StatusBadRequest)
+		return
+	}
+	if params.Variables == nil {
+		params.Variables = map[string]interface{}{}
+	}
+	for field, paths := range fileMap {
+		upload, err := readUpload(r, field)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, path := range paths {
+			if err := setVariable(params.Variables, path, upload); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	response := schema.Exec(r.Context(), params.Query, params.OperationName, params.Variables)
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+func readUpload(r *http.Request, field string) (*Upload, error) {
+	files := r.MultipartForm.File[field]
+	if len(files) != 1 {
+		return nil, fmt.Errorf("expected one file in field %q", field)
+	}
+	f, err := files[0].Open()
+	if err != nil {
+		return nil, fmt.Errorf("open file %q: %w", field, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("read file %q: %w", field, err)
+	}
+	return &Upload{
+		Filename:    files[0].Filename,
+		ContentType: files[0].Header.Get("Content-Type"),
+		Data:        data,
+	}, nil
+}
+
+// setVariable writes value at a path such as "variables.file" or
+// "variables.files.0".
+func setVariable(vars map[string]interface{}, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	if len(parts) < 2 || parts[0] != "variables" {
+		return fmt.Errorf("unsupported map path %q", path)
+	}
+	var container interface{} = vars
+	for i, part := range parts[1:] {
+		last := i == len(parts)-2
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if last {
+				c[part] = value
+				return nil
+			}
+			container = c[part]
+		case []interface{}:
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 || n >= len(c) {
+				return fmt.Errorf("invalid map path %q", path)
+			}
+			if last {
+				c[n] = value
+				return nil
+			}
+			container = c[n]
+		default:
+			return fmt.Errorf("invalid map path %q", path)
+		}
+	}
+	return fmt.Errorf("invalid map path %q", path)
+}
+
 func applySecurityHeaders(w http.ResponseWriter) {
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("X-Frame-Options", "DENY")
@@ -0,0 +1,339 @@
+package graphql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/blob"
+	"nimble-challenge/backend/internal/events"
+	"nimble-challenge/backend/internal/pictures"
+	"nimble-challenge/backend/internal/websocket"
+)
+
+func TestImportPetsMultipartUpload(t *testing.T) {
+	api := newTestAPI(t)
+	store := api.store
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("operations", `{"query":"mutation($file: Upload!) { importPets(storeSlug: \"demo\", file: $file, dryRun: true) { dryRun created rejected rows { line error } } }","variables":{"file":null}}`)
+	_ = form.WriteField("map", `{"0":["variables.file"]}`)
+	part, _ := form.CreateFormFile("0", "pets.csv")
+	_, _ = part.Write([]byte("name,species,ageYears,pictureUrl,description,breederName,breederEmail,priceMinorUnits\n" +
+		"Pip,FROG,1,https://example.com/pip.jpg,Small.,Ann,ann@example.com,1200\n" +
+		"Rex,DOG,3,https://example.com/rex.jpg,,Bob,bob@example.com,4500\n"))
+	_ = form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = req.WithContext(auth.WithPrincipal(req.Context(), api.merchant))
+	rec := httptest.NewRecorder()
+	NewHandler(store, Options{}).ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected the preflight header to be required, got %d", rec.Code)
+	}
+
+	req.Header.Set("GraphQL-Preflight", "1")
+	rec = httptest.NewRecorder()
+	NewHandler(store, Options{}).ServeHTTP(rec, req)
+	var resp struct {
+		Data struct {
+			ImportPets struct {
+				DryRun   bool
+				Created  int
+				Rejected int
+				Rows     []struct {
+					Line  int
+					Error *string
+				}
+			}
+		}
+		Errors []struct{ Message string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	report := resp.Data.ImportPets
+	if len(resp.Errors) > 0 || !report.DryRun || report.Created != 0 || report.Rejected != 1 {
+		t.Fatalf("unexpected response %s", rec.Body.String())
+	}
+	if len(report.Rows) != 2 || report.Rows[0].Error != nil || report.Rows[1].Line != 3 {
+		t.Fatalf("unexpected rows %s", rec.Body.String())
+	}
+}
+
+func TestCreatePetWithUploadedPicture(t *testing.T) {
+	api := newTestAPI(t)
+	blobs, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("blob store: %v", err)
+	}
+	handler := NewHandler(api.store, Options{Pictures: pictures.NewLibrary(blobs), PublicURL: "https://api.example.com/"})
+
+	var img bytes.Buffer
+	_ = png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 640, 480)))
+	post := func(pictureURL string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		_ = form.WriteField("operations", `{"query":"mutation($input: CreatePetInput!) { createPet(input: $input) { picture { url thumbnailUrl width height } } }",`+
+			`"variables":{"input":{"storeSlug":"demo","name":"Pip","species":"frog","ageYears":1,"description":"Small.",`+
+			`"breederName":"Ann","breederEmail":"ann@example.com","priceMinorUnits":1200,"pictureUrl":`+pictureURL+`,"picture":null}}}`)
+		_ = form.WriteField("map", `{"0":["variables.input.picture"]}`)
+		part, _ := form.CreateFormFile("0", "pip.png")
+		_, _ = part.Write(img.Bytes())
+		_ = form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/graphql", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("GraphQL-Preflight", "1")
+		req = req.WithContext(auth.WithPrincipal(req.Context(), api.merchant))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var resp struct {
+		Data struct {
+			CreatePet struct {
+				Picture struct {
+					URL          string `json:"url"`
+					ThumbnailURL string `json:"thumbnailUrl"`
+					Width        int
+					Height       int
+				}
+			}
+		}
+		Errors []struct{ Message string }
+	}
+	rec := post("null")
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	picture := resp.Data.CreatePet.Picture
+	if len(resp.Errors) > 0 || picture.Width != 640 || picture.Height != 480 {
+		t.Fatalf("unexpected response %s", rec.Body.String())
+	}
+	if !strings.HasPrefix(picture.URL, "https://api.example.com/pictures/pets/") || !strings.HasSuffix(picture.ThumbnailURL, ".thumb.png") {
+		t.Fatalf("unexpected picture links %+v", picture)
+	}
+
+	rec = post(`"https://example.com/pip.jpg"`)
+	if !strings.Contains(rec.Body.String(), errPictureConflict.Error()) {
+		t.Fatalf("expected a picture and a pictureUrl together to be refused, got %s", rec.Body.String())
+	}
+}
+
+func TestSubscriptionOverWebSocket(t *testing.T) {
+	api := newTestAPI(t)
+	store := api.store
+	hub := events.NewHub()
+	store.SetEventPublisher(hub)
+	server := httptest.NewServer(auth.OptionalMiddleware(store)(NewHandler(store, Options{Events: hub})))
+	defer server.Close()
+
+	anonymous := dialWebSocket(t, server.URL)
+	anonymous.send(t, `{"type":"connection_init"}`)
+	if code := anonymous.closeCode(t); code != closeForbidden {
+		t.Fatalf("expected close %d without credentials, got %d", closeForbidden, code)
+	}
+
+	client := dialWebSocket(t, server.URL)
+	defer client.conn.Close()
+	credentials := base64.StdEncoding.EncodeToString([]byte("customer:customer_pw"))
+	client.send(t, `{"type":"connection_init","payload":{"authorization":"Basic `+credentials+`"}}`)
+	if msg := client.receive(t); msg.Type != "connection_ack" {
+		t.Fatalf("expected connection_ack, got %+v", msg)
+	}
+	client.send(t, `{"id":"1","type":"subscribe","payload":{"query":"subscription { petAvailabilityChanged(storeSlug: \"demo\") { available reason pet { id } } }"}}`)
+	client.send(t, `{"id":"2","type":"subscribe","payload":{"query":"subscription { storeActivity(storeSlug: \"demo\") { kind } }"}}`)
+	if msg := client.receive(t); msg.Type != "error" || msg.ID != "2" {
+		t.Fatalf("expected the merchant feed to be refused, got %+v", msg)
+	}
+
+	schema, customerCtx := api.schema, api.customerCtx
+	var pets struct {
+		StorePets []struct{ ID string }
+	}
+	run(t, customerCtx, schema, `{ storePets(storeSlug: "demo") { id } }`, nil, &pets)
+	var purchase struct {
+		PurchasePets struct{ PurchasedIds []string }
+	}
+	run(t, customerCtx, schema, `mutation($id: ID!) { purchasePets(input: {storeSlug: "demo", petIds: [$id]}) { purchasedIds } }`,
+		map[string]interface{}{"id": pets.StorePets[0].ID}, &purchase)
+
+	msg := client.receive(t)
+	var next struct {
+		Data struct {
+			PetAvailabilityChanged struct {
+				Available bool
+				Reason    string
+				Pet       struct{ ID string }
+			}
+		}
+	}
+	if msg.Type != "next" || msg.ID != "1" || json.Unmarshal(msg.Payload, &next) != nil {
+		t.Fatalf("expected a next message, got %+v", msg)
+	}
+	change := next.Data.PetAvailabilityChanged
+	if change.Available || change.Reason != "PET_PURCHASED" || change.Pet.ID != pets.StorePets[0].ID {
+		t.Fatalf("unexpected change %s", msg.Payload)
+	}
+
+	client.send(t, `{"id":"1","type":"subscribe","payload":{"query":"subscription { petAvailabilityChanged(storeSlug: \"demo\") { available } }"}}`)
+	if code := client.closeCode(t); code != closeSubscriberExists {
+		t.Fatalf("expected close %d for a reused id, got %d", closeSubscriberExists, code)
+	}
+}
+
+func TestWebSocketEndsWhenCredentialsAreRevoked(t *testing.T) {
+	api := newTestAPI(t)
+	store, ctx := api.store, context.Background()
+	hub := events.NewHub()
+	server := httptest.NewServer(auth.OptionalMiddleware(store)(NewHandler(store, Options{Events: hub, ReauthInterval: 20 * time.Millisecond})))
+	defer server.Close()
+
+	connect := func(password string) *testWSClient {
+		client := dialWebSocket(t, server.URL)
+		credentials := base64.StdEncoding.EncodeToString([]byte("customer:" + password))
+		client.send(t, `{"type":"connection_init","payload":{"authorization":"Basic `+credentials+`"}}`)
+		if msg := client.receive(t); msg.Type != "connection_ack" {
+			t.Fatalf("expected connection_ack, got %+v", msg)
+		}
+		client.send(t, `{"id":"1","type":"subscribe","payload":{"query":"subscription { petAvailabilityChanged(storeSlug: \"demo\") { available } }"}}`)
+		return client
+	}
+
+	client := connect("customer_pw")
+	defer client.conn.Close()
+	if err := store.ChangePassword(ctx, auth.RoleCustomer, api.customer.UserID, "customer_pw", "customer_pw2"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if code := client.closeCode(t); code != closeForbidden {
+		t.Fatalf("expected close %d after a password change, got %d", closeForbidden, code)
+	}
+
+	client = connect("customer_pw2")
+	defer client.conn.Close()
+	if _, err := store.SetStoreActive(ctx, "demo", false); err != nil {
+		t.Fatalf("deactivate: %v", err)
+	}
+	if code := client.closeCode(t); code != closeForbidden {
+		t.Fatalf("expected close %d after the store was deactivated, got %d", closeForbidden, code)
+	}
+}
+
+type testWSClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, serverURL string) *testWSClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, _ := http.NewRequest(http.MethodGet, serverURL+"/graphql", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(make([]byte, 16)))
+	req.Header.Set("Sec-WebSocket-Protocol", graphqlWSProtocol)
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: %v %v", resp, err)
+	}
+	return &testWSClient{conn: conn, br: br}
+}
+
+// send writes a masked text frame, as clients must.
+func (c *testWSClient) send(t *testing.T, text string) {
+	t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | websocket.OpText}
+	if n := len(text); n <= 125 {
+		frame = append(frame, 0x80|byte(n))
+	} else {
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	frame = append(frame, mask[:]...)
+	for i := 0; i < len(text); i++ {
+		frame = append(frame, text[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
+
+// frame reads one unfragmented server frame.
+func (c *testWSClient) frame(t *testing.T) (int, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	n := int(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		_, _ = io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, _ = io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+func (c *testWSClient) receive(t *testing.T) wsMessage {
+	t.Helper()
+	for {
+		opcode, payload := c.frame(t)
+		if opcode != websocket.OpText {
+			if opcode == websocket.OpClose {
+				t.Fatalf("closed: %d %s", binary.BigEndian.Uint16(payload), payload[2:])
+			}
+			continue
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatalf("decode %q: %v", payload, err)
+		}
+		return msg
+	}
+}
+
+func (c *testWSClient) closeCode(t *testing.T) int {
+	t.Helper()
+	for {
+		opcode, payload := c.frame(t)
+		if opcode == websocket.OpClose && len(payload) >= 2 {
+			return int(binary.BigEndian.Uint16(payload))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	gql "github.com/graph-gophers/graphql-go"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+// Upload is a file sent with a multipart request. The handler places it
+// into the variables; it cannot be written inline in a query.
+type Upload struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+func (Upload) ImplementsGraphQLType(name string) bool { return name == "Upload" }
+
+func (u *Upload) UnmarshalGraphQL(input interface{}) error {
+	switch v := input.(type) {
+	case *Upload:
+		*u = *v
+		return nil
+	case Upload:
+		*u = v
+		return nil
+	default:
+		return errors.New("Upload must be sent as a multipart file")
+	}
+}
+
+func (r *Resolver) ImportPets(ctx context.Context, args struct {
+	StoreSlug string
+	File      Upload
+	Format    *db.ImportFormat
+	DryRun    bool
+}) (*ImportReportResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	format, err := importFormat(args.Format, args.File.Filename)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.ParsePetImport(bytes.NewReader(args.File.Data), format)
+	if err != nil {
+		return nil, err
+	}
+	report, err := r.Backend.ImportPets(ctx, storeID, rows, args.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportReportResolver{report: report}, nil
+}
+
+// importFormat falls back to the file extension when no format is given.
+func importFormat(format *db.ImportFormat, filename string) (db.ImportFormat, error) {
+	if format != nil {
+		return *format, nil
+	}
+	return db.ImportFormatFromFilename(filename)
+}
+
+type ImportReportResolver struct {
+	report db.ImportReport
+}
+
+func (r *ImportReportResolver) DryRun() bool    { return r.report.DryRun }
+func (r *ImportReportResolver) Created() int32  { return int32(r.report.Created) }
+func (r *ImportReportResolver) Rejected() int32 { return int32(r.report.Rejected) }
+
+func (r *ImportReportResolver) Rows() []*ImportRowResultResolver {
+	out := make([]*ImportRowResultResolver, len(r.report.Rows))
+	for i := range r.report.Rows {
+		out[i] = &ImportRowResultResolver{row: r.report.Rows[i]}
+	}
+	return out
+}
+
+type ImportRowResultResolver struct {
+	row db.ImportRowResult
+}
+
+func (r *ImportRowResultResolver) Line() int32 { return int32(r.row.Line) }
+
+func (r *ImportRowResultResolver) Name() *string {
+	if r.row.Name == "" {
+		return nil
+	}
+	return &r.row.Name
+}
+
+func (r *ImportRowResultResolver) PetID() *gql.ID {
+	if r.row.PetID == "" {
+		return nil
+	}
+	id := gql.ID(r.row.PetID)
+	return &id
+}
+
+func (r *ImportRowResultResolver) Error() *string {
+	if r.row.Error == "" {
+		return nil
+	}
+	return &r.row.Error
+}
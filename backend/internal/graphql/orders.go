@@ -0,0 +1,190 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	gql "github.com/graph-gophers/graphql-go"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/db"
+)
+
+// Orders lists the caller's own orders for customers and every order in
+// the store for merchants.
+func (r *Resolver) Orders(ctx context.Context, args struct {
+	StoreSlug string
+	PageArgs
+}) (*OrderConnectionResolver, error) {
+	principal, err := auth.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	storeID, ok := principal.Store(args.StoreSlug)
+	if !ok {
+		return nil, errors.New("store access denied")
+	}
+	var page db.OrderPage
+	switch principal.Role {
+	case auth.RoleMerchant:
+		page, err = r.Backend.PageStoreOrders(ctx, storeID, args.pageRequest())
+	case auth.RoleCustomer:
+		page, err = r.Backend.PageCustomerOrders(ctx, storeID, principal.UserID, args.pageRequest())
+	default:
+		return nil, errors.New("access denied")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &OrderConnectionResolver{page: page}, nil
+}
+
+func (r *Resolver) Order(ctx context.Context, args struct {
+	StoreSlug string
+	ID        gql.ID
+}) (*OrderResolver, error) {
+	principal, err := auth.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	storeID, ok := principal.Store(args.StoreSlug)
+	if !ok {
+		return nil, errors.New("store access denied")
+	}
+	order, err := r.Backend.GetOrder(ctx, storeID, string(args.ID))
+	if errors.Is(err, db.ErrOrderNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if principal.Role == auth.RoleCustomer && order.CustomerID != principal.UserID {
+		return nil, nil
+	}
+	return &OrderResolver{order: order}, nil
+}
+
+type ReversePurchaseInput struct {
+	StoreSlug string
+	OrderID   gql.ID
+	PetIDs    *[]gql.ID
+	Reason    string
+}
+
+func (in ReversePurchaseInput) petIDs() []string {
+	if in.PetIDs == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(*in.PetIDs))
+	for _, id := range *in.PetIDs {
+		ids = append(ids, string(id))
+	}
+	return ids
+}
+
+// CancelPurchase lets a customer undo their own recent purchase.
+func (r *Resolver) CancelPurchase(ctx context.Context, args struct{ Input ReversePurchaseInput }) (*OrderResolver, error) {
+	principal, err := customerPrincipal(ctx, args.Input.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	order, err := r.Backend.CancelPurchase(ctx, principal.StoreID, principal.UserID,
+		string(args.Input.OrderID), args.Input.petIDs(), args.Input.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return &OrderResolver{order: order}, nil
+}
+
+func (r *Resolver) RefundPurchase(ctx context.Context, args struct{ Input ReversePurchaseInput }) (*OrderResolver, error) {
+	principal, storeID, err := merchantStore(ctx, args.Input.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	order, err := r.Backend.RefundPurchase(ctx, storeID, principal.UserID,
+		string(args.Input.OrderID), args.Input.petIDs(), args.Input.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return &OrderResolver{order: order}, nil
+}
+
+type OrderResolver struct {
+	order db.Order
+}
+
+func (o *OrderResolver) ID() gql.ID             { return gql.ID(o.order.ID) }
+func (o *OrderResolver) PlacedAt() gql.Time     { return gql.Time{Time: o.order.PlacedAt} }
+func (o *OrderResolver) Status() db.OrderStatus { return o.order.Status }
+func (o *OrderResolver) Customer() *CustomerResolver {
+	return &CustomerResolver{id: o.order.CustomerID, username: o.order.CustomerUsername}
+}
+
+func (o *OrderResolver) Subtotal() *MoneyResolver { return &MoneyResolver{money: o.order.Subtotal} }
+func (o *OrderResolver) Total() *MoneyResolver    { return &MoneyResolver{money: o.order.Total} }
+
+func (o *OrderResolver) Items() []*OrderItemResolver {
+	items := make([]*OrderItemResolver, 0, len(o.order.Items))
+	for _, item := range o.order.Items {
+		items = append(items, &OrderItemResolver{item: item})
+	}
+	return items
+}
+
+type OrderItemResolver struct {
+	item db.OrderItem
+}
+
+func (i *OrderItemResolver) Pet() *PetResolver          { return &PetResolver{pet: i.item.Pet} }
+func (i *OrderItemResolver) Status() db.OrderItemStatus { return i.item.Status }
+func (i *OrderItemResolver) UnitPrice() *MoneyResolver {
+	return &MoneyResolver{money: i.item.UnitPrice}
+}
+
+func (i *OrderItemResolver) Reversal() *ReversalResolver {
+	if i.item.Reversal == nil {
+		return nil
+	}
+	return &ReversalResolver{reversal: *i.item.Reversal}
+}
+
+type ReversalResolver struct {
+	reversal db.Reversal
+}
+
+func (r *ReversalResolver) At() gql.Time          { return gql.Time{Time: r.reversal.At} }
+func (r *ReversalResolver) ActorRole() string     { return r.reversal.ActorRole }
+func (r *ReversalResolver) ActorUsername() string { return r.reversal.ActorUsername }
+func (r *ReversalResolver) Reason() string        { return r.reversal.Reason }
+
+type CustomerResolver struct {
+	id       int64
+	username string
+}
+
+func (c *CustomerResolver) ID() gql.ID       { return gql.ID(strconv.FormatInt(c.id, 10)) }
+func (c *CustomerResolver) Username() string { return c.username }
+
+type OrderConnectionResolver struct {
+	page db.OrderPage
+}
+
+func (c *OrderConnectionResolver) Edges() []*OrderEdgeResolver {
+	edges := make([]*OrderEdgeResolver, 0, len(c.page.Edges))
+	for _, edge := range c.page.Edges {
+		edges = append(edges, &OrderEdgeResolver{edge: edge})
+	}
+	return edges
+}
+
+func (c *OrderConnectionResolver) PageInfo() *PageInfoResolver {
+	return &PageInfoResolver{info: c.page.PageInfo}
+}
+
+type OrderEdgeResolver struct {
+	edge db.OrderEdge
+}
+
+func (e *OrderEdgeResolver) Cursor() string       { return e.edge.Cursor }
+func (e *OrderEdgeResolver) Node() *OrderResolver { return &OrderResolver{order: e.edge.Order} }
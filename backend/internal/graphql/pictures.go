@@ -0,0 +1,94 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/pictures"
+)
+
+var (
+	errPictureConflict    = errors.New("send either pictureUrl or picture, not both")
+	errUploadsUnavailable = errors.New("picture uploads are not enabled")
+)
+
+// savePicture stores an uploaded picture for a pet about to be created or
+// updated. It returns nil when nothing was uploaded.
+func (r *Resolver) savePicture(ctx context.Context, storeID int64, pictureURL *string, upload *Upload) (*db.Picture, error) {
+	if upload == nil {
+		return nil, nil
+	}
+	if pictureURL != nil {
+		return nil, errPictureConflict
+	}
+	if r.pictures == nil {
+		return nil, errUploadsUnavailable
+	}
+	picture, err := r.pictures.Save(ctx, storeID, upload.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &picture, nil
+}
+
+// discardPicture removes a picture saved for a mutation that then failed.
+func (r *Resolver) discardPicture(ctx context.Context, picture *db.Picture) {
+	if picture == nil {
+		return
+	}
+	if err := r.pictures.Delete(ctx, *picture); err != nil {
+		log.Printf("discard picture %s: %v", picture.Key, err)
+	}
+}
+
+type publicURLKey struct{}
+
+// withPublicURL records the API's external base URL, which links to
+// uploaded pictures start with.
+func withPublicURL(ctx context.Context, publicURL string) context.Context {
+	return context.WithValue(ctx, publicURLKey{}, strings.TrimSuffix(publicURL, "/"))
+}
+
+func pictureURL(ctx context.Context, key string) string {
+	base, _ := ctx.Value(publicURLKey{}).(string)
+	return base + pictures.PathPrefix + key
+}
+
+// PictureResolver resolves a pet's uploaded picture, or its external
+// picture URL when nothing was uploaded.
+type PictureResolver struct {
+	pet db.Pet
+}
+
+func (p *PictureResolver) Url(ctx context.Context) string {
+	if p.pet.Picture == nil {
+		return p.pet.PictureURL
+	}
+	return pictureURL(ctx, p.pet.Picture.Key)
+}
+
+func (p *PictureResolver) ThumbnailUrl(ctx context.Context) string {
+	if p.pet.Picture == nil {
+		return p.pet.PictureURL
+	}
+	return pictureURL(ctx, pictures.ThumbnailKey(p.pet.Picture.Key))
+}
+
+func (p *PictureResolver) Width() *int32 {
+	if p.pet.Picture == nil {
+		return nil
+	}
+	width := int32(p.pet.Picture.Width)
+	return &width
+}
+
+func (p *PictureResolver) Height() *int32 {
+	if p.pet.Picture == nil {
+		return nil
+	}
+	height := int32(p.pet.Picture.Height)
+	return &height
+}
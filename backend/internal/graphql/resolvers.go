@@ -3,41 +3,130 @@ package graphql
 import (
 	"context"
 	"errors"
+	"math"
+	"sync"
+	"time"
 
 	gql "github.com/graph-gophers/graphql-go"
 
-	"nimble-challenge/backend/internal/auth"
 	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/events"
+	"nimble-challenge/backend/internal/mail"
+	"nimble-challenge/backend/internal/pictures"
+	"nimble-challenge/backend/internal/ratelimit"
 )
 
 type Resolver struct {
-	Store *db.Store
+	Backend db.Backend
+
+	pictures         *pictures.Library
+	mailer           mail.Mailer
+	passwordResetURL string
+	registrations    *ratelimit.Limiter
+	passwordResets   *ratelimit.Limiter
+	events           events.Bus
+	// background tracks work that outlives its request, such as reset
+	// mail.
+	background sync.WaitGroup
 }
 
+// passwordResetTimeout bounds the lookup and mail behind a reset request.
+const passwordResetTimeout = time.Minute
+
 type CreatePetInput struct {
-	Name         string
-	Species      db.Species
-	AgeYears     int32
-	PictureURL   string
-	Description  string
-	BreederName  string
-	BreederEmail string
+	StoreSlug       string
+	Name            string
+	Species         string
+	AgeYears        int32
+	PictureURL      *string
+	Picture         *Upload
+	Description     string
+	BreederName     string
+	BreederEmail    string
+	PriceMinorUnits int32
+}
+
+type UpdatePetInput struct {
+	Name            *string
+	Species         *string
+	AgeYears        *int32
+	PictureURL      *string
+	Picture         *Upload
+	Description     *string
+	BreederName     *string
+	BreederEmail    *string
+	PriceMinorUnits *int32
+}
+
+func (in UpdatePetInput) patch() db.PetPatch {
+	patch := db.PetPatch{
+		Name:         in.Name,
+		Species:      in.Species,
+		PictureURL:   in.PictureURL,
+		Description:  in.Description,
+		BreederName:  in.BreederName,
+		BreederEmail: in.BreederEmail,
+	}
+	if in.AgeYears != nil {
+		age := int(*in.AgeYears)
+		patch.AgeYears = &age
+	}
+	if in.PriceMinorUnits != nil {
+		price := int64(*in.PriceMinorUnits)
+		patch.PriceMinor = &price
+	}
+	return patch
+}
+
+type PetFilterInput struct {
+	Species      *[]string
+	MinAge       *int32
+	MaxAge       *int32
+	CreatedAfter *gql.Time
+	Query        *string
+}
+
+func (f *PetFilterInput) filter() db.PetFilter {
+	var filter db.PetFilter
+	if f == nil {
+		return filter
+	}
+	if f.Species != nil {
+		filter.Species = *f.Species
+	}
+	if f.MinAge != nil {
+		age := int(*f.MinAge)
+		filter.MinAge = &age
+	}
+	if f.MaxAge != nil {
+		age := int(*f.MaxAge)
+		filter.MaxAge = &age
+	}
+	if f.CreatedAfter != nil {
+		filter.CreatedAfter = &f.CreatedAfter.Time
+	}
+	if f.Query != nil {
+		filter.Query = *f.Query
+	}
+	return filter
 }
 
 type PurchasePetsInput struct {
-	StoreSlug string
-	PetIDs    []gql.ID
+	StoreSlug      string
+	PetIDs         *[]gql.ID
+	FromCart       bool
+	IdempotencyKey *string
 }
 
-func (r *Resolver) MerchantPets(ctx context.Context) ([]*PetResolver, error) {
-	principal, err := auth.FromContext(ctx)
+func (r *Resolver) MerchantPets(ctx context.Context, args struct {
+	StoreSlug string
+	Archived  db.ArchiveFilter
+}) ([]*PetResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
 	if err != nil {
 		return nil, err
 	}
-	if principal.Role != auth.RoleMerchant {
-		return nil, errors.New("merchant access required")
-	}
-	pets, err := r.Store.ListMerchantPets(ctx, principal.StoreID)
+	pets, err := r.Backend.ListMerchantPets(ctx, storeID, args.Archived)
 	if err != nil {
 		return nil, err
 	}
@@ -45,82 +134,199 @@ func (r *Resolver) MerchantPets(ctx context.Context) ([]*PetResolver, error) {
 }
 
 func (r *Resolver) StorePets(ctx context.Context, args struct{ StoreSlug string }) ([]*PetResolver, error) {
-	principal, err := auth.FromContext(ctx)
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
 	if err != nil {
 		return nil, err
 	}
-	if principal.Role != auth.RoleCustomer {
-		return nil, errors.New("customer access required")
+	pets, err := r.Backend.ListAvailablePets(ctx, principal.StoreID, principal.UserID)
+	if err != nil {
+		return nil, err
 	}
-	if principal.StoreSlug != args.StoreSlug {
-		return nil, errors.New("store access denied")
+	return wrapPets(pets), nil
+}
+
+func (r *Resolver) PurchasedPets(ctx context.Context, args struct{ StoreSlug string }) ([]*PetResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
 	}
-	pets, err := r.Store.ListAvailablePets(ctx, principal.StoreID)
+	pets, err := r.Backend.ListPurchasedPets(ctx, principal.StoreID, principal.UserID)
 	if err != nil {
 		return nil, err
 	}
 	return wrapPets(pets), nil
 }
 
-func (r *Resolver) PurchasedPets(ctx context.Context, args struct{ StoreSlug string }) ([]*PetResolver, error) {
-	principal, err := auth.FromContext(ctx)
+func (r *Resolver) MerchantPetsConnection(ctx context.Context, args struct {
+	StoreSlug string
+	Archived  db.ArchiveFilter
+	PageArgs
+}) (*PetConnectionResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
 	if err != nil {
 		return nil, err
 	}
-	if principal.Role != auth.RoleCustomer {
-		return nil, errors.New("customer access required")
+	page, err := r.Backend.PageMerchantPets(ctx, storeID, args.Archived, args.pageRequest())
+	if err != nil {
+		return nil, err
 	}
-	if principal.StoreSlug != args.StoreSlug {
-		return nil, errors.New("store access denied")
+	return &PetConnectionResolver{page: page}, nil
+}
+
+func (r *Resolver) StorePetsConnection(ctx context.Context, args struct {
+	StoreSlug string
+	Filter    *PetFilterInput
+	Sort      *db.PetSort
+	PageArgs
+}) (*PetSearchConnectionResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
 	}
-	pets, err := r.Store.ListPurchasedPets(ctx, principal.StoreID, principal.UserID)
+	search := db.PetSearch{Filter: args.Filter.filter(), Page: args.pageRequest()}
+	if args.Sort != nil {
+		search.Sort = *args.Sort
+	}
+	page, err := r.Backend.SearchAvailablePets(ctx, principal.StoreID, principal.UserID, search)
 	if err != nil {
 		return nil, err
 	}
-	return wrapPets(pets), nil
+	return &PetSearchConnectionResolver{
+		PetConnectionResolver: &PetConnectionResolver{page: page},
+		store:                 r.Backend,
+		storeID:               principal.StoreID,
+		customerID:            principal.UserID,
+		filter:                search.Filter,
+	}, nil
+}
+
+func (r *Resolver) PurchasedPetsConnection(ctx context.Context, args struct {
+	StoreSlug string
+	PageArgs
+}) (*PetConnectionResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	page, err := r.Backend.PagePurchasedPets(ctx, principal.StoreID, principal.UserID, args.pageRequest())
+	if err != nil {
+		return nil, err
+	}
+	return &PetConnectionResolver{page: page}, nil
 }
 
 func (r *Resolver) CreatePet(ctx context.Context, args struct{ Input CreatePetInput }) (*PetResolver, error) {
-	principal, err := auth.FromContext(ctx)
+	_, storeID, err := merchantStore(ctx, args.Input.StoreSlug)
 	if err != nil {
 		return nil, err
 	}
-	if principal.Role != auth.RoleMerchant {
-		return nil, errors.New("merchant access required")
+	picture, err := r.savePicture(ctx, storeID, args.Input.PictureURL, args.Input.Picture)
+	if err != nil {
+		return nil, err
 	}
-	pet, err := r.Store.CreatePet(ctx, principal.StoreID, db.Pet{
+	input := db.Pet{
 		Name:         args.Input.Name,
-		Species:      args.Input.Species,
+		Species:      db.Species{Slug: args.Input.Species},
 		AgeYears:     int(args.Input.AgeYears),
-		PictureURL:   args.Input.PictureURL,
+		Picture:      picture,
 		Description:  args.Input.Description,
 		BreederName:  args.Input.BreederName,
 		BreederEmail: args.Input.BreederEmail,
-	})
+		Price:        db.Money{Amount: int64(args.Input.PriceMinorUnits)},
+	}
+	if args.Input.PictureURL != nil {
+		input.PictureURL = *args.Input.PictureURL
+	}
+	pet, err := r.Backend.CreatePet(ctx, storeID, input)
 	if err != nil {
+		r.discardPicture(ctx, picture)
 		return nil, err
 	}
 	return &PetResolver{pet: pet}, nil
 }
 
-func (r *Resolver) PurchasePets(ctx context.Context, args struct{ Input PurchasePetsInput }) (*PurchaseResultResolver, error) {
-	principal, err := auth.FromContext(ctx)
+func (r *Resolver) UpdatePet(ctx context.Context, args struct {
+	StoreSlug       string
+	ID              gql.ID
+	ExpectedVersion int32
+	Input           UpdatePetInput
+}) (*PetResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
 	if err != nil {
 		return nil, err
 	}
-	if principal.Role != auth.RoleCustomer {
-		return nil, errors.New("customer access required")
+	patch := args.Input.patch()
+	if patch.Picture, err = r.savePicture(ctx, storeID, args.Input.PictureURL, args.Input.Picture); err != nil {
+		return nil, err
+	}
+	// A replaced picture is kept: it is served as immutable, so pages
+	// cached before the change may still link to it.
+	pet, err := r.Backend.UpdatePet(ctx, storeID, string(args.ID), int(args.ExpectedVersion), patch)
+	if err != nil {
+		r.discardPicture(ctx, patch.Picture)
+		return nil, petMutationError(err)
+	}
+	return &PetResolver{pet: pet}, nil
+}
+
+func (r *Resolver) ArchivePet(ctx context.Context, args struct {
+	StoreSlug       string
+	ID              gql.ID
+	ExpectedVersion int32
+}) (*PetResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
 	}
-	if principal.StoreSlug != args.Input.StoreSlug {
-		return nil, errors.New("store access denied")
+	pet, err := r.Backend.ArchivePet(ctx, storeID, string(args.ID), int(args.ExpectedVersion))
+	if err != nil {
+		return nil, petMutationError(err)
 	}
+	return &PetResolver{pet: pet}, nil
+}
 
-	ids := make([]string, 0, len(args.Input.PetIDs))
-	for _, id := range args.Input.PetIDs {
-		ids = append(ids, string(id))
+func (r *Resolver) RestorePet(ctx context.Context, args struct {
+	StoreSlug       string
+	ID              gql.ID
+	ExpectedVersion int32
+}) (*PetResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	pet, err := r.Backend.RestorePet(ctx, storeID, string(args.ID), int(args.ExpectedVersion))
+	if err != nil {
+		return nil, petMutationError(err)
 	}
+	return &PetResolver{pet: pet}, nil
+}
 
-	result, err := r.Store.PurchasePets(ctx, principal.StoreID, principal.UserID, ids)
+func (r *Resolver) PurchasePets(ctx context.Context, args struct{ Input PurchasePetsInput }) (*PurchaseResultResolver, error) {
+	principal, err := customerPrincipal(ctx, args.Input.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	var key string
+	if args.Input.IdempotencyKey != nil {
+		key = *args.Input.IdempotencyKey
+	}
+	var result db.PurchaseResult
+	if args.Input.FromCart {
+		if args.Input.PetIDs != nil {
+			return nil, errors.New("petIds cannot be combined with fromCart")
+		}
+		result, err = r.Backend.CheckoutCart(ctx, principal.StoreID, principal.UserID, key)
+	} else {
+		if args.Input.PetIDs == nil {
+			return nil, errors.New("petIds or fromCart is required")
+		}
+		ids := make([]string, 0, len(*args.Input.PetIDs))
+		for _, id := range *args.Input.PetIDs {
+			ids = append(ids, string(id))
+		}
+		result, err = r.Backend.PurchasePetsWithKey(ctx, principal.StoreID, principal.UserID, key, ids)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -139,15 +345,22 @@ func wrapPets(pets []db.Pet) []*PetResolver {
 	return resolvers
 }
 
-func (p *PetResolver) ID() gql.ID           { return gql.ID(p.pet.ID) }
-func (p *PetResolver) Name() string         { return p.pet.Name }
-func (p *PetResolver) Species() db.Species  { return p.pet.Species }
-func (p *PetResolver) AgeYears() int32      { return int32(p.pet.AgeYears) }
-func (p *PetResolver) PictureUrl() string   { return p.pet.PictureURL }
-func (p *PetResolver) Description() string  { return p.pet.Description }
-func (p *PetResolver) BreederName() string  { return p.pet.BreederName }
-func (p *PetResolver) BreederEmail() string { return p.pet.BreederEmail }
-func (p *PetResolver) CreatedAt() gql.Time  { return gql.Time{Time: p.pet.CreatedAt} }
+func (p *PetResolver) ID() gql.ID                { return gql.ID(p.pet.ID) }
+func (p *PetResolver) Name() string              { return p.pet.Name }
+func (p *PetResolver) Version() int32            { return int32(p.pet.Version) }
+func (p *PetResolver) Species() *SpeciesResolver { return &SpeciesResolver{species: p.pet.Species} }
+func (p *PetResolver) AgeYears() int32           { return int32(p.pet.AgeYears) }
+func (p *PetResolver) Picture() *PictureResolver { return &PictureResolver{pet: p.pet} }
+func (p *PetResolver) Description() string       { return p.pet.Description }
+func (p *PetResolver) BreederName() string       { return p.pet.BreederName }
+func (p *PetResolver) BreederEmail() string      { return p.pet.BreederEmail }
+func (p *PetResolver) Price() *MoneyResolver     { return &MoneyResolver{money: p.pet.Price} }
+func (p *PetResolver) CreatedAt() gql.Time       { return gql.Time{Time: p.pet.CreatedAt} }
+
+func (p *PetResolver) PictureUrl(ctx context.Context) string {
+	return p.Picture().Url(ctx)
+}
+
 func (p *PetResolver) PurchasedAt() *gql.Time {
 	if p.pet.PurchasedAt == nil {
 		return nil
@@ -155,6 +368,13 @@ func (p *PetResolver) PurchasedAt() *gql.Time {
 	t := gql.Time{Time: *p.pet.PurchasedAt}
 	return &t
 }
+func (p *PetResolver) ArchivedAt() *gql.Time {
+	if p.pet.ArchivedAt == nil {
+		return nil
+	}
+	t := gql.Time{Time: *p.pet.ArchivedAt}
+	return &t
+}
 
 type PurchaseErrorResolver struct {
 	err db.PurchaseError
@@ -175,6 +395,16 @@ func (r *PurchaseResultResolver) PurchasedIds() []gql.ID {
 	return ids
 }
 
+func (r *PurchaseResultResolver) Order() *OrderResolver {
+	if r.result.Order == nil {
+		return nil
+	}
+	return &OrderResolver{order: *r.result.Order}
+}
+
+func (r *PurchaseResultResolver) Subtotal() *MoneyResolver { return optionalMoney(r.result.Subtotal()) }
+func (r *PurchaseResultResolver) Total() *MoneyResolver    { return optionalMoney(r.result.Total()) }
+
 func (r *PurchaseResultResolver) Errors() []*PurchaseErrorResolver {
 	errs := make([]*PurchaseErrorResolver, 0, len(r.result.Errors))
 	for _, err := range r.result.Errors {
@@ -182,3 +412,26 @@ func (r *PurchaseResultResolver) Errors() []*PurchaseErrorResolver {
 	}
 	return errs
 }
+
+type MoneyResolver struct {
+	money db.Money
+}
+
+// MinorUnits fails rather than wrap for sums too large for an Int, such
+// as a cart full of expensive pets; Amount is always exact.
+func (m *MoneyResolver) MinorUnits() (int32, error) {
+	if m.money.Amount > math.MaxInt32 || m.money.Amount < math.MinInt32 {
+		return 0, errors.New("amount is too large for minorUnits; use amount")
+	}
+	return int32(m.money.Amount), nil
+}
+
+func (m *MoneyResolver) Currency() string { return m.money.Currency }
+func (m *MoneyResolver) Amount() string   { return m.money.Decimal() }
+
+func optionalMoney(money *db.Money) *MoneyResolver {
+	if money == nil {
+		return nil
+	}
+	return &MoneyResolver{money: *money}
+}
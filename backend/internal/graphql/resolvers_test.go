@@ -0,0 +1,496 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	gql "github.com/graph-gophers/graphql-go"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/crypto"
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/mail"
+	"nimble-challenge/backend/internal/ratelimit"
+)
+
+func TestPurchasePetsAgainstMemoryStore(t *testing.T) {
+	api := newTestAPI(t)
+	ctx, schema := api.customerCtx, api.schema
+
+	var pets struct {
+		StorePets []struct{ ID string }
+	}
+	run(t, ctx, schema, `{ storePets(storeSlug: "demo") { id } }`, nil, &pets)
+	if len(pets.StorePets) != 3 {
+		t.Fatalf("expected 3 pets, got %d", len(pets.StorePets))
+	}
+
+	var purchase struct {
+		PurchasePets struct {
+			PurchasedIds []string
+			Errors       []struct{ Message string }
+			Total        struct{ MinorUnits int }
+		}
+	}
+	ids := []interface{}{pets.StorePets[0].ID, pets.StorePets[0].ID}
+	run(t, ctx, schema, `mutation($ids: [ID!]) {
+		purchasePets(input: {storeSlug: "demo", petIds: $ids}) { purchasedIds errors { message } total { minorUnits } }
+	}`, map[string]interface{}{"ids": ids}, &purchase)
+	if len(purchase.PurchasePets.PurchasedIds) != 1 || len(purchase.PurchasePets.Errors) != 0 {
+		t.Fatalf("unexpected result %+v", purchase.PurchasePets)
+	}
+	if purchase.PurchasePets.Total.MinorUnits == 0 {
+		t.Fatal("expected a total")
+	}
+}
+
+// testAPI is the demo store in memory, seeded like newTestMemoryStore in
+// package db, with a schema over it and its merchant and customer signed
+// in. Tests that need a resolver with more than the store build their own
+// schema over api.store.
+type testAPI struct {
+	store       *db.MemoryStore
+	schema      *gql.Schema
+	merchant    *auth.Principal
+	customer    *auth.Principal
+	merchantCtx context.Context
+	customerCtx context.Context
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	store := newTestStore(t)
+	if err := store.EnsureDemoData(context.Background(), "demo", "Demo", "USD", "merchant", "merchant_pw", "customer", "customer_pw"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	api := &testAPI{store: store, schema: gql.MustParseSchema(Schema, &Resolver{Backend: store})}
+	api.merchantCtx = signIn(t, context.Background(), store, "merchant", "merchant_pw")
+	api.customerCtx = signIn(t, context.Background(), store, "customer", "customer_pw")
+	api.merchant, _ = auth.FromContext(api.merchantCtx)
+	api.customer, _ = auth.FromContext(api.customerCtx)
+	return api
+}
+
+// newTestStore returns an empty memory store, for tests that seed their
+// own stores.
+func newTestStore(t *testing.T) *db.MemoryStore {
+	t.Helper()
+	cipher, err := crypto.NewCipherFromBase64(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatalf("cipher: %v", err)
+	}
+	return db.NewMemoryStore(cipher)
+}
+
+// signIn authenticates the user and returns ctx carrying the principal.
+func signIn(t *testing.T, ctx context.Context, store *db.MemoryStore, username, password string) context.Context {
+	t.Helper()
+	principal, err := store.Authenticate(ctx, username, password)
+	if err != nil {
+		t.Fatalf("authenticate %s: %v", username, err)
+	}
+	return auth.WithPrincipal(ctx, principal)
+}
+
+func run(t *testing.T, ctx context.Context, schema *gql.Schema, query string, vars map[string]interface{}, out interface{}) {
+	t.Helper()
+	resp := schema.Exec(ctx, query, "", vars)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("%s: %v", query, resp.Errors)
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+}
+
+func TestMerchantStoreMemberships(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	for _, seed := range []struct{ slug, name, merchant string }{
+		{"north", "North", "owner"},
+		{"south", "South", "owner"},
+		{"east", "East", "rival"},
+	} {
+		if err := store.EnsureDemoData(ctx, seed.slug, seed.name, "USD", seed.merchant, "merchant_pw", "customer", "customer_pw"); err != nil {
+			t.Fatalf("seed %s: %v", seed.slug, err)
+		}
+	}
+	ctx = signIn(t, ctx, store, "owner", "merchant_pw")
+	schema := gql.MustParseSchema(Schema, &Resolver{Backend: store})
+
+	var mine struct {
+		MyStores []struct{ Slug string }
+	}
+	run(t, ctx, schema, `{ myStores { slug } }`, nil, &mine)
+	if len(mine.MyStores) != 2 || mine.MyStores[0].Slug != "north" || mine.MyStores[1].Slug != "south" {
+		t.Fatalf("unexpected stores %+v", mine.MyStores)
+	}
+
+	var created struct {
+		CreatePet struct{ ID string }
+	}
+	run(t, ctx, schema, `mutation {
+		createPet(input: {storeSlug: "south", name: "Pip", species: "frog", ageYears: 1, pictureUrl: "https://example.com/pip.jpg",
+			description: "Small.", breederName: "Ann", breederEmail: "ann@example.com", priceMinorUnits: 100}) { id }
+	}`, nil, &created)
+
+	var pets struct {
+		MerchantPets []struct{ ID string }
+	}
+	run(t, ctx, schema, `{ merchantPets(storeSlug: "south") { id } }`, nil, &pets)
+	if len(pets.MerchantPets) != 4 {
+		t.Fatalf("expected the new pet in south, got %d pets", len(pets.MerchantPets))
+	}
+	run(t, ctx, schema, `{ merchantPets(storeSlug: "north") { id } }`, nil, &pets)
+	for _, pet := range pets.MerchantPets {
+		if pet.ID == created.CreatePet.ID {
+			t.Fatal("pet created in south listed in north")
+		}
+	}
+
+	resp := schema.Exec(ctx, `{ merchantPets(storeSlug: "east") { id } }`, "", nil)
+	if len(resp.Errors) == 0 || resp.Errors[0].Message != "store access denied" {
+		t.Fatalf("expected store access denied, got %v", resp.Errors)
+	}
+}
+
+func TestStoreAdministration(t *testing.T) {
+	api := newTestAPI(t)
+	store, schema := api.store, api.schema
+	ctx := context.Background()
+	if err := store.EnsureAdmin(ctx, "admin", "admin_pw"); err != nil {
+		t.Fatalf("seed admin: %v", err)
+	}
+	create := `mutation { createStore(input: {slug: "annex", name: "Annex", currency: "EUR", ownerUsername: "merchant"}) { slug active } }`
+
+	resp := schema.Exec(api.merchantCtx, create, "", nil)
+	if len(resp.Errors) == 0 || resp.Errors[0].Message != "admin access required" {
+		t.Fatalf("expected admin access required, got %v", resp.Errors)
+	}
+
+	adminCtx := signIn(t, ctx, store, "admin", "admin_pw")
+	var created struct {
+		CreateStore struct {
+			Slug   string
+			Active bool
+		}
+	}
+	run(t, adminCtx, schema, create, nil, &created)
+	if created.CreateStore.Slug != "annex" || !created.CreateStore.Active {
+		t.Fatalf("unexpected store %+v", created.CreateStore)
+	}
+	run(t, adminCtx, schema, `mutation { updateStore(slug: "annex", input: {name: "The Annex"}) { slug } }`, nil, &struct{}{})
+	run(t, adminCtx, schema, `mutation { deactivateStore(slug: "annex") { slug } }`, nil, &struct{}{})
+
+	var public struct {
+		Store *struct {
+			Name     string
+			Currency string
+			Active   bool
+		}
+		Missing *struct{ Name string }
+	}
+	run(t, ctx, schema, `{ store(slug: "annex") { name currency active } missing: store(slug: "nope") { name } }`, nil, &public)
+	if public.Store == nil || public.Store.Name != "The Annex" || public.Store.Currency != "EUR" || public.Store.Active {
+		t.Fatalf("unexpected public store %+v", public.Store)
+	}
+	if public.Missing != nil {
+		t.Fatalf("expected no store for an unknown slug, got %+v", public.Missing)
+	}
+
+	stores, err := store.MerchantStores(ctx, api.merchant.UserID)
+	if err != nil || len(stores) != 2 {
+		t.Fatalf("expected the owner to be added to the new store, got %+v (%v)", stores, err)
+	}
+}
+
+func TestRegisterCustomer(t *testing.T) {
+	store := newTestAPI(t).store
+	ctx := auth.WithRequestMeta(context.Background(), auth.RequestMeta{RemoteAddr: "192.0.2.1:5555"})
+	schema := gql.MustParseSchema(Schema, &Resolver{Backend: store, registrations: ratelimit.New(1, time.Hour)})
+	register := `mutation($username: String!) {
+		registerCustomer(storeSlug: "demo", username: $username, password: "long_enough_pw", email: "new@example.com") {
+			username role email store { slug }
+		}
+	}`
+
+	var registered struct {
+		RegisterCustomer struct {
+			Username string
+			Role     string
+			Email    string
+			Store    struct{ Slug string }
+		}
+	}
+	run(t, ctx, schema, register, map[string]interface{}{"username": "newbie"}, &registered)
+	if got := registered.RegisterCustomer; got.Role != "CUSTOMER" || got.Email != "new@example.com" || got.Store.Slug != "demo" {
+		t.Fatalf("unexpected account %+v", got)
+	}
+	resp := schema.Exec(ctx, register, "", map[string]interface{}{"username": "another"})
+	if len(resp.Errors) == 0 {
+		t.Fatal("expected the second registration from the same IP to be rate limited")
+	}
+
+	ctx = signIn(t, ctx, store, "newbie", "long_enough_pw")
+	var updated struct {
+		UpdateProfile struct{ Phone *string }
+	}
+	run(t, ctx, schema, `mutation { updateProfile(input: {phone: "+1 555 0100"}) { phone } }`, nil, &updated)
+	if updated.UpdateProfile.Phone == nil || *updated.UpdateProfile.Phone != "+1 555 0100" {
+		t.Fatalf("unexpected phone %v", updated.UpdateProfile.Phone)
+	}
+	var me struct {
+		Me struct{ Email, Phone string }
+	}
+	run(t, ctx, schema, `{ me { email phone } }`, nil, &me)
+	if me.Me.Email != "new@example.com" || me.Me.Phone != "+1 555 0100" {
+		t.Fatalf("unexpected profile %+v", me.Me)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	store := newTestAPI(t).store
+	ctx := context.Background()
+	reg := db.Registration{Username: "forgetful", Password: "first_password", Email: "forgetful@example.com"}
+	if _, err := store.RegisterCustomer(ctx, "demo", reg); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	var outbox bytes.Buffer
+	resolver := &Resolver{
+		Backend:          store,
+		mailer:           mail.NewWriterMailer(&outbox, "shop@example.com"),
+		passwordResetURL: "https://shop.example.com/reset",
+	}
+	schema := gql.MustParseSchema(Schema, resolver)
+
+	run(t, ctx, schema, `mutation { requestPasswordReset(username: "nobody") }`, nil, &struct{}{})
+	resolver.background.Wait()
+	if outbox.Len() != 0 {
+		t.Fatalf("mail sent for an unknown username: %q", outbox.String())
+	}
+	run(t, ctx, schema, `mutation { requestPasswordReset(username: "forgetful") }`, nil, &struct{}{})
+	resolver.background.Wait()
+	match := regexp.MustCompile(`https://shop\.example\.com/reset\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(outbox.String())
+	if match == nil || !strings.Contains(outbox.String(), "To: forgetful@example.com") {
+		t.Fatalf("expected a reset link mailed to the customer, got %q", outbox.String())
+	}
+
+	reset := `mutation($token: String!) { resetPassword(token: $token, newPassword: "second_password") }`
+	run(t, ctx, schema, reset, map[string]interface{}{"token": match[1]}, &struct{}{})
+	if _, err := store.Authenticate(ctx, "forgetful", "second_password"); err != nil {
+		t.Fatalf("authenticate with the new password: %v", err)
+	}
+	resp := schema.Exec(ctx, reset, "", map[string]interface{}{"token": match[1]})
+	if len(resp.Errors) == 0 || resp.Errors[0].Message != db.ErrInvalidResetToken.Error() {
+		t.Fatalf("expected the token to be single-use, got %v", resp.Errors)
+	}
+}
+
+func TestMerchantSpecies(t *testing.T) {
+	api := newTestAPI(t)
+	ctx, schema, merchantCtx, customerCtx := context.Background(), api.schema, api.merchantCtx, api.customerCtx
+	createCategory := `mutation { createSpecies(storeSlug: "demo", input: {slug: "birds", name: "Birds"}) { slug } }`
+
+	resp := schema.Exec(customerCtx, createCategory, "", nil)
+	if len(resp.Errors) == 0 || resp.Errors[0].Message != "merchant access required" {
+		t.Fatalf("expected merchant access required, got %v", resp.Errors)
+	}
+
+	run(t, merchantCtx, schema, createCategory, nil, &struct{}{})
+	run(t, merchantCtx, schema, `mutation { createSpecies(storeSlug: "demo", input: {slug: "parrot", name: "Parrot", parentSlug: "birds"}) { slug } }`, nil, &struct{}{})
+	var created struct {
+		CreatePet struct {
+			Species struct {
+				Name   string
+				Parent *struct{ Slug string }
+			}
+		}
+	}
+	run(t, merchantCtx, schema, `mutation {
+		createPet(input: {storeSlug: "demo", name: "Kiwi", species: "parrot", ageYears: 3, pictureUrl: "https://example.com/kiwi.jpg",
+			description: "Talks a lot.", breederName: "Ann", breederEmail: "ann@example.com", priceMinorUnits: 9000}) { species { name parent { slug } } }
+	}`, nil, &created)
+	if got := created.CreatePet.Species; got.Name != "Parrot" || got.Parent == nil || got.Parent.Slug != "birds" {
+		t.Fatalf("unexpected species %+v", got)
+	}
+
+	var catalog struct {
+		StorePetsConnection struct {
+			Edges         []struct{ Node struct{ Name string } }
+			SpeciesFacets []struct {
+				Species struct{ Slug string }
+				Count   int
+			}
+		}
+	}
+	run(t, customerCtx, schema, `{
+		storePetsConnection(storeSlug: "demo", filter: {species: ["birds"]}) { edges { node { name } } speciesFacets { species { slug } count } }
+	}`, nil, &catalog)
+	if edges := catalog.StorePetsConnection.Edges; len(edges) != 1 || edges[0].Node.Name != "Kiwi" {
+		t.Fatalf("expected the category filter to match the parrot, got %+v", edges)
+	}
+	if len(catalog.StorePetsConnection.SpeciesFacets) != 4 {
+		t.Fatalf("expected a facet per stocked species, got %+v", catalog.StorePetsConnection.SpeciesFacets)
+	}
+
+	var public struct {
+		Species []struct{ Slug string }
+	}
+	run(t, ctx, schema, `{ species(storeSlug: "demo") { slug } }`, nil, &public)
+	if len(public.Species) != 5 || public.Species[0].Slug != "birds" || public.Species[1].Slug != "parrot" {
+		t.Fatalf("unexpected public species %+v", public.Species)
+	}
+	resp = schema.Exec(merchantCtx, `mutation { deleteSpecies(storeSlug: "demo", slug: "parrot") }`, "", nil)
+	if len(resp.Errors) == 0 || resp.Errors[0].Message != db.ErrSpeciesInUse.Error() {
+		t.Fatalf("expected the parrot to stay while a pet uses it, got %v", resp.Errors)
+	}
+}
+
+func TestSavedSearchNotifications(t *testing.T) {
+	api := newTestAPI(t)
+	schema, merchantCtx, customerCtx := api.schema, api.merchantCtx, api.customerCtx
+
+	var saved struct {
+		SaveSearch struct {
+			ID     string
+			Filter struct {
+				Species []string
+				Query   *string
+			}
+		}
+	}
+	run(t, customerCtx, schema, `mutation {
+		saveSearch(storeSlug: "demo", name: "Frogs", filter: {species: ["frog", "frog"]}) { id filter { species query } }
+	}`, nil, &saved)
+	if f := saved.SaveSearch.Filter; len(f.Species) != 1 || f.Query != nil {
+		t.Fatalf("unexpected saved filter %+v", f)
+	}
+	resp := schema.Exec(merchantCtx, `{ savedSearches(storeSlug: "demo") { id } }`, "", nil)
+	if len(resp.Errors) == 0 {
+		t.Fatal("expected merchants to be refused")
+	}
+
+	run(t, merchantCtx, schema, `mutation {
+		createPet(input: {storeSlug: "demo", name: "Pip", species: "frog", ageYears: 1, pictureUrl: "https://example.com/pip.jpg",
+			description: "Small.", breederName: "Ann", breederEmail: "ann@example.com", priceMinorUnits: 900}) { id }
+	}`, nil, &struct{}{})
+
+	var inbox struct {
+		UnreadNotificationCount int
+		Notifications           struct {
+			Edges []struct {
+				Node struct {
+					ID            string
+					Kind          string
+					Message       string
+					SavedSearchId string
+					Pet           struct{ Name string }
+				}
+			}
+		}
+	}
+	query := `{
+		unreadNotificationCount(storeSlug: "demo")
+		notifications(storeSlug: "demo", unreadOnly: true) { edges { node { id kind message savedSearchId pet { name } } } }
+	}`
+	run(t, customerCtx, schema, query, nil, &inbox)
+	if inbox.UnreadNotificationCount != 1 || len(inbox.Notifications.Edges) != 1 {
+		t.Fatalf("expected one unread notification, got %+v", inbox)
+	}
+	if n := inbox.Notifications.Edges[0].Node; n.Kind != "SAVED_SEARCH_MATCH" || n.Pet.Name != "Pip" || n.SavedSearchId != saved.SaveSearch.ID {
+		t.Fatalf("unexpected notification %+v", n)
+	}
+
+	var marked struct{ MarkNotificationsRead int }
+	run(t, customerCtx, schema, `mutation { markNotificationsRead(storeSlug: "demo") }`, nil, &marked)
+	if marked.MarkNotificationsRead != 1 {
+		t.Fatalf("expected 1 notification marked read, got %d", marked.MarkNotificationsRead)
+	}
+	run(t, customerCtx, schema, query, nil, &inbox)
+	if inbox.UnreadNotificationCount != 0 || len(inbox.Notifications.Edges) != 0 {
+		t.Fatalf("expected no unread notifications, got %+v", inbox)
+	}
+}
+
+func TestMerchantWebhooks(t *testing.T) {
+	api := newTestAPI(t)
+	schema, merchantCtx := api.schema, api.merchantCtx
+
+	var created struct {
+		CreateWebhookEndpoint struct {
+			Secret   string
+			Endpoint struct {
+				ID     string
+				Events []string
+				Active bool
+			}
+		}
+	}
+	run(t, merchantCtx, schema, `mutation {
+		createWebhookEndpoint(storeSlug: "demo", input: {url: "https://hooks.example.com/pets", events: [PET_CREATED, PET_CREATED]}) {
+			secret endpoint { id events active }
+		}
+	}`, nil, &created)
+	endpoint := created.CreateWebhookEndpoint.Endpoint
+	if !strings.HasPrefix(created.CreateWebhookEndpoint.Secret, "whsec_") || len(endpoint.Events) != 1 || !endpoint.Active {
+		t.Fatalf("unexpected endpoint %+v", created.CreateWebhookEndpoint)
+	}
+	resp := schema.Exec(merchantCtx, `mutation {
+		createWebhookEndpoint(storeSlug: "demo", input: {url: "http://hooks.example.com", events: [PET_CREATED]}) { secret }
+	}`, "", nil)
+	if len(resp.Errors) == 0 {
+		t.Fatal("expected a non-https URL to be refused")
+	}
+	resp = schema.Exec(api.customerCtx, `{ webhookEndpoints(storeSlug: "demo") { id } }`, "", nil)
+	if len(resp.Errors) == 0 {
+		t.Fatal("expected customers to be refused")
+	}
+
+	run(t, merchantCtx, schema, `mutation {
+		createPet(input: {storeSlug: "demo", name: "Pip", species: "frog", ageYears: 1, pictureUrl: "https://example.com/pip.jpg",
+			description: "Small.", breederName: "Ann", breederEmail: "ann@example.com", priceMinorUnits: 900}) { id }
+	}`, nil, &struct{}{})
+
+	var log struct {
+		WebhookDeliveries struct {
+			Edges []struct {
+				Node struct {
+					ID      string
+					Event   string
+					Status  string
+					Payload string
+				}
+			}
+		}
+	}
+	query := `query($endpoint: ID) { webhookDeliveries(storeSlug: "demo", endpointId: $endpoint) { edges { node { id event status payload } } } }`
+	run(t, merchantCtx, schema, query, map[string]interface{}{"endpoint": endpoint.ID}, &log)
+	edges := log.WebhookDeliveries.Edges
+	if len(edges) != 1 || edges[0].Node.Event != "PET_CREATED" || edges[0].Node.Status != "PENDING" ||
+		!strings.Contains(edges[0].Node.Payload, `"type":"pet.created"`) {
+		t.Fatalf("unexpected deliveries %+v", edges)
+	}
+
+	var redelivered struct {
+		RedeliverWebhook struct{ ID string }
+	}
+	run(t, merchantCtx, schema, `mutation($id: ID!) { redeliverWebhook(storeSlug: "demo", deliveryId: $id) { id } }`,
+		map[string]interface{}{"id": edges[0].Node.ID}, &redelivered)
+	run(t, merchantCtx, schema, `mutation($id: ID!) {
+		updateWebhookEndpoint(storeSlug: "demo", id: $id, input: {active: false}) { active }
+	}`, map[string]interface{}{"id": endpoint.ID}, &struct{}{})
+	run(t, merchantCtx, schema, `mutation {
+		createPet(input: {storeSlug: "demo", name: "Pop", species: "frog", ageYears: 1, pictureUrl: "https://example.com/pop.jpg",
+			description: "Small.", breederName: "Ann", breederEmail: "ann@example.com", priceMinorUnits: 900}) { id }
+	}`, nil, &struct{}{})
+	run(t, merchantCtx, schema, query, map[string]interface{}{"endpoint": endpoint.ID}, &log)
+	if len(log.WebhookDeliveries.Edges) != 2 {
+		t.Fatalf("expected the redelivery and nothing for the inactive endpoint, got %+v", log.WebhookDeliveries.Edges)
+	}
+}
@@ -0,0 +1,13 @@
+package graphql
+
+import (
+	"testing"
+
+	gql "github.com/graph-gophers/graphql-go"
+)
+
+func TestSchemaMatchesResolvers(t *testing.T) {
+	if _, err := gql.ParseSchema(Schema, &Resolver{}); err != nil {
+		t.Fatalf("parse schema: %v", err)
+	}
+}
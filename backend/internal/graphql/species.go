@@ -0,0 +1,105 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+// Species is public like Store, so the catalog's filters can be built
+// before signing in.
+func (r *Resolver) Species(ctx context.Context, args struct{ StoreSlug string }) ([]*SpeciesResolver, error) {
+	store, err := r.Backend.GetStore(ctx, args.StoreSlug)
+	if errors.Is(err, db.ErrStoreNotFound) {
+		return []*SpeciesResolver{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	species, err := r.Backend.ListSpecies(ctx, store.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*SpeciesResolver, len(species))
+	for i := range species {
+		out[i] = &SpeciesResolver{species: species[i]}
+	}
+	return out, nil
+}
+
+type CreateSpeciesInput struct {
+	Slug       string
+	Name       string
+	ParentSlug *string
+}
+
+type UpdateSpeciesInput struct {
+	Name       *string
+	ParentSlug *string
+}
+
+func (r *Resolver) CreateSpecies(ctx context.Context, args struct {
+	StoreSlug string
+	Input     CreateSpeciesInput
+}) (*SpeciesResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	input := db.SpeciesInput{Slug: args.Input.Slug, Name: args.Input.Name}
+	if args.Input.ParentSlug != nil {
+		input.ParentSlug = *args.Input.ParentSlug
+	}
+	species, err := r.Backend.CreateSpecies(ctx, storeID, input)
+	if err != nil {
+		return nil, err
+	}
+	return &SpeciesResolver{species: species}, nil
+}
+
+func (r *Resolver) UpdateSpecies(ctx context.Context, args struct {
+	StoreSlug string
+	Slug      string
+	Input     UpdateSpeciesInput
+}) (*SpeciesResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	species, err := r.Backend.UpdateSpecies(ctx, storeID, args.Slug, db.SpeciesPatch{
+		Name:       args.Input.Name,
+		ParentSlug: args.Input.ParentSlug,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SpeciesResolver{species: species}, nil
+}
+
+func (r *Resolver) DeleteSpecies(ctx context.Context, args struct {
+	StoreSlug string
+	Slug      string
+}) (bool, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return false, err
+	}
+	if err := r.Backend.DeleteSpecies(ctx, storeID, args.Slug); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type SpeciesResolver struct {
+	species db.Species
+}
+
+func (s *SpeciesResolver) Slug() string { return s.species.Slug }
+func (s *SpeciesResolver) Name() string { return s.species.Name }
+func (s *SpeciesResolver) Parent() *SpeciesResolver {
+	if s.species.Parent == nil {
+		return nil
+	}
+	return &SpeciesResolver{species: *s.species.Parent}
+}
@@ -0,0 +1,109 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/db"
+)
+
+func (r *Resolver) MyStores(ctx context.Context) ([]*StoreResolver, error) {
+	principal, err := auth.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if principal.Role != auth.RoleMerchant {
+		return nil, errors.New("merchant access required")
+	}
+	stores, err := r.Backend.MerchantStores(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*StoreResolver, len(stores))
+	for i := range stores {
+		out[i] = &StoreResolver{store: stores[i]}
+	}
+	return out, nil
+}
+
+// Store is public: anyone may look up a store, including a deactivated one.
+func (r *Resolver) Store(ctx context.Context, args struct{ Slug string }) (*StoreResolver, error) {
+	store, err := r.Backend.GetStore(ctx, args.Slug)
+	if errors.Is(err, db.ErrStoreNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &StoreResolver{store: store}, nil
+}
+
+type CreateStoreInput struct {
+	Slug          string
+	Name          string
+	Currency      string
+	OwnerUsername *string
+}
+
+type UpdateStoreInput struct {
+	Name     *string
+	Currency *string
+}
+
+func (r *Resolver) CreateStore(ctx context.Context, args struct{ Input CreateStoreInput }) (*StoreResolver, error) {
+	if _, err := adminPrincipal(ctx); err != nil {
+		return nil, err
+	}
+	input := db.StoreInput{Slug: args.Input.Slug, Name: args.Input.Name, Currency: args.Input.Currency}
+	if args.Input.OwnerUsername != nil {
+		input.OwnerUsername = *args.Input.OwnerUsername
+	}
+	store, err := r.Backend.CreateStore(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return &StoreResolver{store: store}, nil
+}
+
+func (r *Resolver) UpdateStore(ctx context.Context, args struct {
+	Slug  string
+	Input UpdateStoreInput
+}) (*StoreResolver, error) {
+	if _, err := adminPrincipal(ctx); err != nil {
+		return nil, err
+	}
+	store, err := r.Backend.UpdateStore(ctx, args.Slug, db.StorePatch{Name: args.Input.Name, Currency: args.Input.Currency})
+	if err != nil {
+		return nil, err
+	}
+	return &StoreResolver{store: store}, nil
+}
+
+func (r *Resolver) DeactivateStore(ctx context.Context, args struct{ Slug string }) (*StoreResolver, error) {
+	return r.setStoreActive(ctx, args.Slug, false)
+}
+
+func (r *Resolver) ReactivateStore(ctx context.Context, args struct{ Slug string }) (*StoreResolver, error) {
+	return r.setStoreActive(ctx, args.Slug, true)
+}
+
+func (r *Resolver) setStoreActive(ctx context.Context, slug string, active bool) (*StoreResolver, error) {
+	if _, err := adminPrincipal(ctx); err != nil {
+		return nil, err
+	}
+	store, err := r.Backend.SetStoreActive(ctx, slug, active)
+	if err != nil {
+		return nil, err
+	}
+	return &StoreResolver{store: store}, nil
+}
+
+type StoreResolver struct {
+	store db.StoreInfo
+}
+
+func (s *StoreResolver) Slug() string     { return s.store.Slug }
+func (s *StoreResolver) Name() string     { return s.store.Name }
+func (s *StoreResolver) Currency() string { return s.store.Currency }
+func (s *StoreResolver) Active() bool     { return s.store.Active }
@@ -0,0 +1,119 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	gql "github.com/graph-gophers/graphql-go"
+
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/events"
+)
+
+func (r *Resolver) PetAvailabilityChanged(ctx context.Context, args struct{ StoreSlug string }) (<-chan *PetAvailabilityChangeResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	storeID, _ := principal.Store(args.StoreSlug)
+	source, err := r.subscribe(ctx, storeID)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *PetAvailabilityChangeResolver)
+	go func() {
+		defer close(out)
+		for e := range source {
+			if !e.ChangesAvailability() {
+				continue
+			}
+			pets, ok := r.eventPets(ctx, e)
+			if !ok {
+				return
+			}
+			for _, pet := range pets {
+				select {
+				case out <- &PetAvailabilityChangeResolver{pet: pet, kind: e.Kind}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (r *Resolver) StoreActivity(ctx context.Context, args struct{ StoreSlug string }) (<-chan *StoreActivityResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	source, err := r.subscribe(ctx, storeID)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *StoreActivityResolver)
+	go func() {
+		defer close(out)
+		for e := range source {
+			pets, ok := r.eventPets(ctx, e)
+			if !ok {
+				return
+			}
+			select {
+			case out <- &StoreActivityResolver{event: e, pets: pets}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (r *Resolver) subscribe(ctx context.Context, storeID int64) (<-chan events.Event, error) {
+	if r.events == nil {
+		return nil, errors.New("subscriptions are not available")
+	}
+	return r.events.Subscribe(ctx, storeID), nil
+}
+
+// eventPets loads the current state of the event's pets. On failure the
+// subscription ends, which tells the client to resubscribe and reread.
+func (r *Resolver) eventPets(ctx context.Context, e events.Event) ([]db.Pet, bool) {
+	pets, err := r.Backend.GetPets(ctx, e.StoreID, e.PetIDs)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("subscription: load pets for %s: %v", e.Kind, err)
+		}
+		return nil, false
+	}
+	return pets, true
+}
+
+type PetAvailabilityChangeResolver struct {
+	pet  db.Pet
+	kind events.Kind
+}
+
+func (c *PetAvailabilityChangeResolver) Pet() *PetResolver { return &PetResolver{pet: c.pet} }
+func (c *PetAvailabilityChangeResolver) Available() bool {
+	return c.pet.PurchasedAt == nil && c.pet.ArchivedAt == nil
+}
+func (c *PetAvailabilityChangeResolver) Reason() string { return string(c.kind) }
+
+type StoreActivityResolver struct {
+	event events.Event
+	pets  []db.Pet
+}
+
+func (a *StoreActivityResolver) Kind() string         { return string(a.event.Kind) }
+func (a *StoreActivityResolver) OccurredAt() gql.Time { return gql.Time{Time: a.event.OccurredAt} }
+func (a *StoreActivityResolver) Pets() []*PetResolver { return wrapPets(a.pets) }
+func (a *StoreActivityResolver) OrderID() *gql.ID {
+	if a.event.OrderID == "" {
+		return nil
+	}
+	id := gql.ID(a.event.OrderID)
+	return &id
+}
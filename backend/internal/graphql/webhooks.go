@@ -0,0 +1,200 @@
+package graphql
+
+import (
+	"context"
+
+	gql "github.com/graph-gophers/graphql-go"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+type CreateWebhookEndpointInput struct {
+	URL         string
+	Description *string
+	Events      []db.WebhookEvent
+}
+
+type UpdateWebhookEndpointInput struct {
+	URL         *string
+	Description *string
+	Events      *[]db.WebhookEvent
+	Active      *bool
+}
+
+func (r *Resolver) WebhookEndpoints(ctx context.Context, args struct{ StoreSlug string }) ([]*WebhookEndpointResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := r.Backend.ListWebhookEndpoints(ctx, storeID)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*WebhookEndpointResolver, 0, len(endpoints))
+	for _, e := range endpoints {
+		resolvers = append(resolvers, &WebhookEndpointResolver{endpoint: e})
+	}
+	return resolvers, nil
+}
+
+func (r *Resolver) WebhookDeliveries(ctx context.Context, args struct {
+	StoreSlug  string
+	EndpointID *gql.ID
+	Status     *db.WebhookDeliveryStatus
+	PageArgs
+}) (*WebhookDeliveryConnectionResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	filter := db.WebhookDeliveryFilter{Status: args.Status}
+	if args.EndpointID != nil {
+		filter.EndpointID = string(*args.EndpointID)
+	}
+	page, err := r.Backend.PageWebhookDeliveries(ctx, storeID, filter, args.pageRequest())
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookDeliveryConnectionResolver{page: page}, nil
+}
+
+func (r *Resolver) CreateWebhookEndpoint(ctx context.Context, args struct {
+	StoreSlug string
+	Input     CreateWebhookEndpointInput
+}) (*CreatedWebhookEndpointResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	input := db.WebhookEndpointInput{URL: args.Input.URL, Events: args.Input.Events}
+	if args.Input.Description != nil {
+		input.Description = *args.Input.Description
+	}
+	endpoint, secret, err := r.Backend.CreateWebhookEndpoint(ctx, storeID, input)
+	if err != nil {
+		return nil, err
+	}
+	return &CreatedWebhookEndpointResolver{endpoint: endpoint, secret: secret}, nil
+}
+
+func (r *Resolver) UpdateWebhookEndpoint(ctx context.Context, args struct {
+	StoreSlug string
+	ID        gql.ID
+	Input     UpdateWebhookEndpointInput
+}) (*WebhookEndpointResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	patch := db.WebhookEndpointPatch{
+		URL:         args.Input.URL,
+		Description: args.Input.Description,
+		Events:      args.Input.Events,
+		Active:      args.Input.Active,
+	}
+	endpoint, err := r.Backend.UpdateWebhookEndpoint(ctx, storeID, string(args.ID), patch)
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookEndpointResolver{endpoint: endpoint}, nil
+}
+
+func (r *Resolver) DeleteWebhookEndpoint(ctx context.Context, args struct {
+	StoreSlug string
+	ID        gql.ID
+}) (bool, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return false, err
+	}
+	if err := r.Backend.DeleteWebhookEndpoint(ctx, storeID, string(args.ID)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *Resolver) RedeliverWebhook(ctx context.Context, args struct {
+	StoreSlug  string
+	DeliveryID gql.ID
+}) (*WebhookDeliveryResolver, error) {
+	_, storeID, err := merchantStore(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := r.Backend.RedeliverWebhook(ctx, storeID, string(args.DeliveryID))
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookDeliveryResolver{delivery: delivery}, nil
+}
+
+type WebhookEndpointResolver struct {
+	endpoint db.WebhookEndpoint
+}
+
+func (e *WebhookEndpointResolver) ID() gql.ID                { return gql.ID(e.endpoint.ID) }
+func (e *WebhookEndpointResolver) URL() string               { return e.endpoint.URL }
+func (e *WebhookEndpointResolver) Description() string       { return e.endpoint.Description }
+func (e *WebhookEndpointResolver) Events() []db.WebhookEvent { return e.endpoint.Events }
+func (e *WebhookEndpointResolver) Active() bool              { return e.endpoint.Active }
+func (e *WebhookEndpointResolver) CreatedAt() gql.Time       { return gql.Time{Time: e.endpoint.CreatedAt} }
+
+type CreatedWebhookEndpointResolver struct {
+	endpoint db.WebhookEndpoint
+	secret   string
+}
+
+func (c *CreatedWebhookEndpointResolver) Endpoint() *WebhookEndpointResolver {
+	return &WebhookEndpointResolver{endpoint: c.endpoint}
+}
+
+func (c *CreatedWebhookEndpointResolver) Secret() string { return c.secret }
+
+type WebhookDeliveryResolver struct {
+	delivery db.WebhookDelivery
+}
+
+func (d *WebhookDeliveryResolver) ID() gql.ID                       { return gql.ID(d.delivery.ID) }
+func (d *WebhookDeliveryResolver) EndpointId() gql.ID               { return gql.ID(d.delivery.EndpointID) }
+func (d *WebhookDeliveryResolver) EventId() gql.ID                  { return gql.ID(d.delivery.EventID) }
+func (d *WebhookDeliveryResolver) Event() db.WebhookEvent           { return d.delivery.Event }
+func (d *WebhookDeliveryResolver) Payload() string                  { return string(d.delivery.Payload) }
+func (d *WebhookDeliveryResolver) Status() db.WebhookDeliveryStatus { return d.delivery.Status }
+func (d *WebhookDeliveryResolver) Attempts() int32                  { return int32(d.delivery.Attempts) }
+func (d *WebhookDeliveryResolver) ResponseStatus() *int32 {
+	return optionalInt(d.delivery.ResponseStatus)
+}
+func (d *WebhookDeliveryResolver) LastError() *string  { return optionalString(d.delivery.LastError) }
+func (d *WebhookDeliveryResolver) CreatedAt() gql.Time { return gql.Time{Time: d.delivery.CreatedAt} }
+
+func (d *WebhookDeliveryResolver) CompletedAt() *gql.Time {
+	if d.delivery.CompletedAt == nil {
+		return nil
+	}
+	return &gql.Time{Time: *d.delivery.CompletedAt}
+}
+
+type WebhookDeliveryConnectionResolver struct {
+	page db.WebhookDeliveryPage
+}
+
+func (c *WebhookDeliveryConnectionResolver) Edges() []*WebhookDeliveryEdgeResolver {
+	edges := make([]*WebhookDeliveryEdgeResolver, 0, len(c.page.Edges))
+	for _, edge := range c.page.Edges {
+		edges = append(edges, &WebhookDeliveryEdgeResolver{edge: edge})
+	}
+	return edges
+}
+
+func (c *WebhookDeliveryConnectionResolver) PageInfo() *PageInfoResolver {
+	return &PageInfoResolver{info: c.page.PageInfo}
+}
+
+type WebhookDeliveryEdgeResolver struct {
+	edge db.WebhookDeliveryEdge
+}
+
+func (e *WebhookDeliveryEdgeResolver) Cursor() string { return e.edge.Cursor }
+func (e *WebhookDeliveryEdgeResolver) Node() *WebhookDeliveryResolver {
+	return &WebhookDeliveryResolver{delivery: e.edge.Delivery}
+}
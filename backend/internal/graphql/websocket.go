@@ -0,0 +1,352 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gql "github.com/graph-gophers/graphql-go"
+
+	"nimble-challenge/backend/internal/auth"
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/websocket"
+)
+
+const (
+	// graphqlWSProtocol is the protocol of the graphql-ws client library,
+	// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md.
+	graphqlWSProtocol = "graphql-transport-ws"
+
+	connectionInitWait = 10 * time.Second
+	// Browsers answer pings on their own, so a connection that stays
+	// silent past wsIdleTimeout is gone.
+	wsPingInterval = 30 * time.Second
+	wsIdleTimeout  = 75 * time.Second
+	// maxSubscriptions is how many operations one connection may run at
+	// once.
+	maxSubscriptions = 20
+	// defaultReauthInterval is how often a connection's credentials are
+	// checked again, so that a password change or reset, or a store being
+	// deactivated, ends the subscriptions it no longer allows.
+	defaultReauthInterval = time.Minute
+)
+
+// Close codes graphql-ws defines for protocol violations.
+const (
+	closeBadRequest       = 4400
+	closeUnauthorized     = 4401
+	closeForbidden        = 4403
+	closeInitTimeout      = 4408
+	closeSubscriberExists = 4409
+	closeTooManyInits     = 4429
+)
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// wsSession is one graphql-ws connection. Credentials come from the
+// upgrade request, checked by auth.OptionalMiddleware like any other
+// request, or from an "authorization" field in the connection_init payload
+// for clients that cannot set headers on a WebSocket, such as browsers.
+// Either way they are checked again every reauthInterval while the
+// connection is open.
+type wsSession struct {
+	conn           *websocket.Conn
+	schema         *gql.Schema
+	authenticator  db.Authenticator
+	authorization  string
+	reauthInterval time.Duration
+
+	// ctx carries the principal once the connection is acknowledged.
+	ctx       context.Context
+	principal *auth.Principal
+	acked     atomic.Bool
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func serveWebSocket(w http.ResponseWriter, r *http.Request, schema *gql.Schema, authenticator db.Authenticator, reauthInterval time.Duration) {
+	conn, err := websocket.Upgrade(w, r, []string{graphqlWSProtocol})
+	if err != nil {
+		return
+	}
+	conn.SetIdleTimeout(wsIdleTimeout)
+	ctx, cancel := context.WithCancel(r.Context())
+	s := &wsSession{
+		conn:           conn,
+		schema:         schema,
+		authenticator:  authenticator,
+		authorization:  r.Header.Get("Authorization"),
+		reauthInterval: reauthInterval,
+		ctx:            ctx,
+		subs:           make(map[string]context.CancelFunc),
+	}
+	defer func() {
+		cancel()
+		s.wg.Wait()
+		_ = conn.Close(websocket.CloseNormal, "")
+	}()
+
+	initTimer := time.AfterFunc(connectionInitWait, func() {
+		if !s.acked.Load() {
+			_ = conn.Close(closeInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer initTimer.Stop()
+	go s.keepAlive(ctx)
+	s.read()
+}
+
+func (s *wsSession) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.conn.WriteMessage(websocket.OpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// read handles messages until the connection closes.
+func (s *wsSession) read() {
+	initialised := false
+	for {
+		opcode, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg wsMessage
+		if opcode != websocket.OpText || json.Unmarshal(data, &msg) != nil || msg.Type == "" {
+			_ = s.conn.Close(closeBadRequest, "Invalid message received")
+			return
+		}
+		switch msg.Type {
+		case "connection_init":
+			if initialised {
+				_ = s.conn.Close(closeTooManyInits, "Too many initialisation requests")
+				return
+			}
+			initialised = true
+			if !s.init(msg.Payload) {
+				_ = s.conn.Close(closeForbidden, "Forbidden")
+				return
+			}
+			s.acked.Store(true)
+			s.send(wsMessage{Type: "connection_ack"})
+			go s.reauthenticate(s.ctx)
+		case "ping":
+			s.send(wsMessage{Type: "pong", Payload: msg.Payload})
+		case "pong":
+		case "subscribe":
+			if !s.acked.Load() {
+				_ = s.conn.Close(closeUnauthorized, "Unauthorized")
+				return
+			}
+			if msg.ID == "" {
+				_ = s.conn.Close(closeBadRequest, "Invalid message received")
+				return
+			}
+			if !s.subscribe(msg.ID, msg.Payload) {
+				return
+			}
+		case "complete":
+			s.complete(msg.ID)
+		default:
+			_ = s.conn.Close(closeBadRequest, "Invalid message received")
+			return
+		}
+	}
+}
+
+// init authenticates the connection and reports whether it may go on.
+func (s *wsSession) init(payload json.RawMessage) bool {
+	var params struct {
+		Authorization string `json:"authorization"`
+	}
+	if len(payload) > 0 && json.Unmarshal(payload, &params) != nil {
+		return false
+	}
+	if params.Authorization != "" {
+		principal, err := auth.FromAuthorization(s.ctx, s.authenticator, params.Authorization)
+		if err != nil {
+			return false
+		}
+		s.authorization = params.Authorization
+		s.principal = principal
+		s.ctx = auth.WithPrincipal(s.ctx, principal)
+		return true
+	}
+	principal, err := auth.FromContext(s.ctx)
+	if err != nil {
+		return false
+	}
+	s.principal = principal
+	return true
+}
+
+// reauthenticate checks the connection's credentials every
+// reauthInterval and closes it once they no longer authenticate the
+// same user with the same stores.
+func (s *wsSession) reauthenticate(ctx context.Context) {
+	ticker := time.NewTicker(s.reauthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			principal, err := auth.FromAuthorization(ctx, s.authenticator, s.authorization)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil || !stillAllows(principal, s.principal) {
+				_ = s.conn.Close(closeForbidden, "Forbidden")
+				return
+			}
+		}
+	}
+}
+
+// stillAllows reports whether current is the same user as before and
+// keeps every store before had.
+func stillAllows(current, before *auth.Principal) bool {
+	if current.Role != before.Role || current.UserID != before.UserID {
+		return false
+	}
+	for _, m := range before.Memberships {
+		if id, ok := current.Store(m.StoreSlug); !ok || id != m.StoreID {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribe starts an operation. It returns false if the connection was
+// closed for reusing an ID.
+func (s *wsSession) subscribe(id string, payload json.RawMessage) bool {
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.Unmarshal(payload, &params); err != nil || params.Query == "" {
+		_ = s.conn.Close(closeBadRequest, "Invalid message received")
+		return false
+	}
+
+	s.mu.Lock()
+	if _, exists := s.subs[id]; exists {
+		s.mu.Unlock()
+		_ = s.conn.Close(closeSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", id))
+		return false
+	}
+	if len(s.subs) >= maxSubscriptions {
+		s.mu.Unlock()
+		s.sendErrors(id, fmt.Sprintf("at most %d operations may run at once", maxSubscriptions))
+		return true
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.subs[id] = cancel
+	s.mu.Unlock()
+
+	responses, err := s.schema.Subscribe(ctx, params.Query, params.OperationName, params.Variables)
+	if err != nil {
+		s.forget(id)
+		s.sendErrors(id, err.Error())
+		return true
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.forward(id, responses)
+	}()
+	return true
+}
+
+// forward sends an operation's results until it ends or the client
+// completes it. An operation refused before it started gets a single
+// error message.
+func (s *wsSession) forward(id string, responses <-chan interface{}) {
+	first := true
+	for r := range responses {
+		resp, ok := r.(*gql.Response)
+		if !ok {
+			continue
+		}
+		if first && len(resp.Errors) > 0 && (len(resp.Data) == 0 || string(resp.Data) == "null") {
+			if s.forget(id) {
+				errs, _ := json.Marshal(resp.Errors)
+				s.send(wsMessage{ID: id, Type: "error", Payload: errs})
+			}
+			// Drain so graphql-go's goroutines can finish.
+			for range responses {
+			}
+			return
+		}
+		first = false
+		if !s.active(id) {
+			continue
+		}
+		body, err := json.Marshal(resp)
+		if err != nil {
+			continue
+		}
+		s.send(wsMessage{ID: id, Type: "next", Payload: body})
+	}
+	if s.forget(id) {
+		s.send(wsMessage{ID: id, Type: "complete"})
+	}
+}
+
+func (s *wsSession) active(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.subs[id]
+	return ok
+}
+
+// forget cancels the operation and reports whether it was still running.
+func (s *wsSession) forget(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cancel, ok := s.subs[id]
+	if ok {
+		cancel()
+		delete(s.subs, id)
+	}
+	return ok
+}
+
+// complete stops an operation the client is no longer interested in; it
+// is not told again that it completed.
+func (s *wsSession) complete(id string) {
+	s.forget(id)
+}
+
+func (s *wsSession) sendErrors(id, message string) {
+	errs, _ := json.Marshal([]map[string]string{{"message": message}})
+	s.send(wsMessage{ID: id, Type: "error", Payload: errs})
+}
+
+func (s *wsSession) send(msg wsMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	// A failed write means the connection is gone; read notices.
+	_ = s.conn.WriteMessage(websocket.OpText, data)
+}
@@ -0,0 +1,236 @@
+package graphql
+
+import (
+	"context"
+
+	gql "github.com/graph-gophers/graphql-go"
+
+	"nimble-challenge/backend/internal/db"
+)
+
+func (r *Resolver) Favorites(ctx context.Context, args struct{ StoreSlug string }) ([]*PetResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	pets, err := r.Backend.ListFavorites(ctx, principal.StoreID, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return wrapPets(pets), nil
+}
+
+func (r *Resolver) FavoritePet(ctx context.Context, args struct {
+	StoreSlug string
+	PetID     gql.ID
+}) (*PetResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	pet, err := r.Backend.FavoritePet(ctx, principal.StoreID, principal.UserID, string(args.PetID))
+	if err != nil {
+		return nil, err
+	}
+	return &PetResolver{pet: pet}, nil
+}
+
+func (r *Resolver) UnfavoritePet(ctx context.Context, args struct {
+	StoreSlug string
+	PetID     gql.ID
+}) (bool, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return false, err
+	}
+	if err := r.Backend.UnfavoritePet(ctx, principal.StoreID, principal.UserID, string(args.PetID)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *Resolver) SavedSearches(ctx context.Context, args struct{ StoreSlug string }) ([]*SavedSearchResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	searches, err := r.Backend.ListSavedSearches(ctx, principal.StoreID, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*SavedSearchResolver, 0, len(searches))
+	for _, ss := range searches {
+		resolvers = append(resolvers, &SavedSearchResolver{search: ss})
+	}
+	return resolvers, nil
+}
+
+func (r *Resolver) SaveSearch(ctx context.Context, args struct {
+	StoreSlug string
+	Name      string
+	Filter    PetFilterInput
+}) (*SavedSearchResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := r.Backend.CreateSavedSearch(ctx, principal.StoreID, principal.UserID, args.Name, args.Filter.filter())
+	if err != nil {
+		return nil, err
+	}
+	return &SavedSearchResolver{search: saved}, nil
+}
+
+func (r *Resolver) DeleteSavedSearch(ctx context.Context, args struct {
+	StoreSlug string
+	ID        gql.ID
+}) (bool, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return false, err
+	}
+	if err := r.Backend.DeleteSavedSearch(ctx, principal.StoreID, principal.UserID, string(args.ID)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *Resolver) Notifications(ctx context.Context, args struct {
+	StoreSlug  string
+	UnreadOnly bool
+	PageArgs
+}) (*NotificationConnectionResolver, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return nil, err
+	}
+	page, err := r.Backend.PageNotifications(ctx, principal.StoreID, principal.UserID, args.UnreadOnly, args.pageRequest())
+	if err != nil {
+		return nil, err
+	}
+	return &NotificationConnectionResolver{page: page}, nil
+}
+
+func (r *Resolver) UnreadNotificationCount(ctx context.Context, args struct{ StoreSlug string }) (int32, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return 0, err
+	}
+	count, err := r.Backend.CountUnreadNotifications(ctx, principal.StoreID, principal.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return int32(count), nil
+}
+
+func (r *Resolver) MarkNotificationsRead(ctx context.Context, args struct {
+	StoreSlug string
+	IDs       *[]gql.ID
+}) (int32, error) {
+	principal, err := customerPrincipal(ctx, args.StoreSlug)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	if args.IDs != nil {
+		ids = make([]string, 0, len(*args.IDs))
+		for _, id := range *args.IDs {
+			ids = append(ids, string(id))
+		}
+	}
+	marked, err := r.Backend.MarkNotificationsRead(ctx, principal.StoreID, principal.UserID, ids)
+	if err != nil {
+		return 0, err
+	}
+	return int32(marked), nil
+}
+
+type SavedSearchResolver struct {
+	search db.SavedSearch
+}
+
+func (s *SavedSearchResolver) ID() gql.ID          { return gql.ID(s.search.ID) }
+func (s *SavedSearchResolver) Name() string        { return s.search.Name }
+func (s *SavedSearchResolver) CreatedAt() gql.Time { return gql.Time{Time: s.search.CreatedAt} }
+func (s *SavedSearchResolver) Filter() *SavedPetFilterResolver {
+	return &SavedPetFilterResolver{filter: s.search.Filter}
+}
+
+type SavedPetFilterResolver struct {
+	filter db.PetFilter
+}
+
+func (f *SavedPetFilterResolver) Species() []string {
+	if f.filter.Species == nil {
+		return []string{}
+	}
+	return f.filter.Species
+}
+
+func (f *SavedPetFilterResolver) MinAge() *int32 { return optionalInt(f.filter.MinAge) }
+func (f *SavedPetFilterResolver) MaxAge() *int32 { return optionalInt(f.filter.MaxAge) }
+func (f *SavedPetFilterResolver) Query() *string { return optionalString(f.filter.Query) }
+
+func (f *SavedPetFilterResolver) CreatedAfter() *gql.Time {
+	if f.filter.CreatedAfter == nil {
+		return nil
+	}
+	return &gql.Time{Time: *f.filter.CreatedAfter}
+}
+
+func optionalInt(n *int) *int32 {
+	if n == nil {
+		return nil
+	}
+	v := int32(*n)
+	return &v
+}
+
+type NotificationResolver struct {
+	notification db.Notification
+}
+
+func (n *NotificationResolver) ID() gql.ID                { return gql.ID(n.notification.ID) }
+func (n *NotificationResolver) Kind() db.NotificationKind { return n.notification.Kind }
+func (n *NotificationResolver) Message() string           { return n.notification.Message }
+func (n *NotificationResolver) Pet() *PetResolver         { return &PetResolver{pet: n.notification.Pet} }
+func (n *NotificationResolver) CreatedAt() gql.Time       { return gql.Time{Time: n.notification.CreatedAt} }
+func (n *NotificationResolver) SavedSearchId() *gql.ID {
+	if n.notification.SavedSearchID == "" {
+		return nil
+	}
+	id := gql.ID(n.notification.SavedSearchID)
+	return &id
+}
+
+func (n *NotificationResolver) ReadAt() *gql.Time {
+	if n.notification.ReadAt == nil {
+		return nil
+	}
+	return &gql.Time{Time: *n.notification.ReadAt}
+}
+
+type NotificationConnectionResolver struct {
+	page db.NotificationPage
+}
+
+func (c *NotificationConnectionResolver) Edges() []*NotificationEdgeResolver {
+	edges := make([]*NotificationEdgeResolver, 0, len(c.page.Edges))
+	for _, edge := range c.page.Edges {
+		edges = append(edges, &NotificationEdgeResolver{edge: edge})
+	}
+	return edges
+}
+
+func (c *NotificationConnectionResolver) PageInfo() *PageInfoResolver {
+	return &PageInfoResolver{info: c.page.PageInfo}
+}
+
+type NotificationEdgeResolver struct {
+	edge db.NotificationEdge
+}
+
+func (e *NotificationEdgeResolver) Cursor() string { return e.edge.Cursor }
+func (e *NotificationEdgeResolver) Node() *NotificationResolver {
+	return &NotificationResolver{notification: e.edge.Notification}
+}
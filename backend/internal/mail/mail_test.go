@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterMailer(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := NewWriterMailer(&buf, "shop@example.com")
+	err := mailer.Send(context.Background(), Message{To: "ann@example.com", Subject: "Hi", Body: "line one\nline two"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"From: shop@example.com\r\n", "To: ann@example.com\r\n", "Subject: Hi\r\n", "\r\n\r\nline one\r\nline two\r\n"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in %q", want, out)
+		}
+	}
+
+	err = mailer.Send(context.Background(), Message{To: "ann@example.com", Subject: "Hi\r\nBcc: eve@example.com"})
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected a subject with a line break to be rejected, got %v", err)
+	}
+}
+
+func TestSMTPMailerGivesUpOnStuckRelay(t *testing.T) {
+	// The relay accepts connections but never sends its greeting.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = NewSMTPMailer(ln.Addr().String(), "shop@example.com", "", "").Send(ctx, Message{To: "ann@example.com", Subject: "Hi"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to end the delivery, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("send took %s", elapsed)
+	}
+}
@@ -0,0 +1,48 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadOrdersAndPairs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_orders.up.sql":   {Data: []byte("CREATE TABLE orders ();")},
+		"migrations/0002_orders.down.sql": {Data: []byte("DROP TABLE orders;")},
+		"migrations/0001_init.up.sql":     {Data: []byte("CREATE TABLE stores ();")},
+	}
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 migrations, got %d", len(migrations))
+	}
+	if migrations[0].Version != 1 || migrations[1].Version != 2 {
+		t.Fatalf("unexpected order: %d, %d", migrations[0].Version, migrations[1].Version)
+	}
+	if migrations[1].Name != "orders" || migrations[1].Down == "" {
+		t.Fatalf("expected paired down script for orders, got %+v", migrations[1])
+	}
+}
+
+func TestLoadRejectsMissingUp(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0001_init.down.sql": {Data: []byte("DROP TABLE stores;")},
+	}
+	if _, err := Load(fsys); err == nil {
+		t.Fatalf("expected error for migration without up script")
+	}
+}
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	migrations, err := Load(embedded)
+	if err != nil {
+		t.Fatalf("load embedded: %v", err)
+	}
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			t.Fatalf("expected contiguous versions, got %d at position %d", mig.Version, i)
+		}
+	}
+}
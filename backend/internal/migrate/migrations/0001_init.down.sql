@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS pets;
+DROP TABLE IF EXISTS customers;
+DROP TABLE IF EXISTS merchants;
+DROP TABLE IF EXISTS stores;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_pets_store_customer_purchased;
+DROP INDEX IF EXISTS idx_pets_store_created;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_pets_store_created ON pets (store_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_pets_store_customer_purchased ON pets (store_id, purchased_by_customer_id, purchased_at, id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_pets_store_species;
+DROP INDEX IF EXISTS idx_pets_search_vector;
+ALTER TABLE pets DROP COLUMN IF EXISTS search_vector;
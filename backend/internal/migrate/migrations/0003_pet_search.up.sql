@@ -0,0 +1,8 @@
+ALTER TABLE pets ADD COLUMN IF NOT EXISTS search_vector tsvector
+  GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(description, '')), 'B')
+  ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_pets_search_vector ON pets USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_pets_store_species ON pets (store_id, species) WHERE purchased_at IS NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_pets_store_species;
+CREATE INDEX IF NOT EXISTS idx_pets_store_species ON pets (store_id, species) WHERE purchased_at IS NULL;
+
+ALTER TABLE pets DROP COLUMN IF EXISTS archived_at;
@@ -0,0 +1,4 @@
+ALTER TABLE pets ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS idx_pets_store_species;
+CREATE INDEX IF NOT EXISTS idx_pets_store_species ON pets (store_id, species) WHERE purchased_at IS NULL AND archived_at IS NULL;
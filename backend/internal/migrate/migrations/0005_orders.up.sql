@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS orders (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  store_id BIGINT NOT NULL REFERENCES stores(id),
+  customer_id BIGINT NOT NULL REFERENCES customers(id),
+  status TEXT NOT NULL DEFAULT 'PLACED',
+  placed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  pet_id UUID NOT NULL REFERENCES pets(id),
+  PRIMARY KEY (order_id, pet_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_store_placed ON orders (store_id, placed_at, id);
+CREATE INDEX IF NOT EXISTS idx_orders_customer_placed ON orders (customer_id, placed_at, id);
+CREATE INDEX IF NOT EXISTS idx_order_items_pet ON order_items (pet_id);
+
+-- Every pet bought in one checkout shares the transaction's NOW(), so
+-- existing purchases group back into their original orders.
+INSERT INTO orders (store_id, customer_id, placed_at)
+SELECT DISTINCT p.store_id, p.purchased_by_customer_id, p.purchased_at
+FROM pets p
+WHERE p.purchased_at IS NOT NULL
+  AND p.purchased_by_customer_id IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.pet_id = p.id);
+
+INSERT INTO order_items (order_id, pet_id)
+SELECT o.id, p.id
+FROM pets p
+JOIN orders o
+  ON o.store_id = p.store_id
+ AND o.customer_id = p.purchased_by_customer_id
+ AND o.placed_at = p.purchased_at
+WHERE NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.pet_id = p.id);
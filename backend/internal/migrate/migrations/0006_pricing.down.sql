@@ -0,0 +1,10 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS total_minor;
+ALTER TABLE orders DROP COLUMN IF EXISTS subtotal_minor;
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE order_items DROP COLUMN IF EXISTS currency;
+ALTER TABLE order_items DROP COLUMN IF EXISTS unit_price_minor;
+
+ALTER TABLE pets DROP COLUMN IF EXISTS price_minor;
+
+ALTER TABLE stores DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS cart_holds (
+  pet_id UUID PRIMARY KEY REFERENCES pets(id) ON DELETE CASCADE,
+  store_id BIGINT NOT NULL REFERENCES stores(id),
+  customer_id BIGINT NOT NULL REFERENCES customers(id),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_cart_holds_customer ON cart_holds (store_id, customer_id, expires_at);
+CREATE INDEX IF NOT EXISTS idx_cart_holds_expires ON cart_holds (expires_at);
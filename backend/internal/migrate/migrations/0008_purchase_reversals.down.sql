@@ -0,0 +1,5 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS reversal_reason;
+ALTER TABLE order_items DROP COLUMN IF EXISTS reversed_by_user_id;
+ALTER TABLE order_items DROP COLUMN IF EXISTS reversed_by_role;
+ALTER TABLE order_items DROP COLUMN IF EXISTS reversed_at;
+ALTER TABLE order_items DROP COLUMN IF EXISTS status;
@@ -0,0 +1,5 @@
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'PURCHASED';
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS reversed_at TIMESTAMPTZ;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS reversed_by_role TEXT;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS reversed_by_user_id BIGINT;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS reversal_reason TEXT;
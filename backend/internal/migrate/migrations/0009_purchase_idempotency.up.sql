@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS purchase_idempotency_keys (
+  customer_id BIGINT NOT NULL REFERENCES customers(id),
+  key TEXT NOT NULL,
+  fingerprint TEXT NOT NULL,
+  order_id UUID REFERENCES orders(id),
+  purchased_ids UUID[] NOT NULL DEFAULT '{}',
+  errors JSONB NOT NULL DEFAULT '[]',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (customer_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_purchase_idempotency_expires ON purchase_idempotency_keys (expires_at);
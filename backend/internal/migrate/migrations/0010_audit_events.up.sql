@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  store_id BIGINT REFERENCES stores(id),
+  actor_role TEXT,
+  actor_id BIGINT,
+  actor_username TEXT,
+  action TEXT NOT NULL,
+  target_ids TEXT[] NOT NULL DEFAULT '{}',
+  request_id TEXT,
+  remote_addr TEXT,
+  outcome TEXT NOT NULL,
+  detail TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_store_occurred ON audit_events (store_id, occurred_at DESC, id DESC);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+  BEFORE UPDATE OR DELETE ON audit_events
+  FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1 @@
+ALTER TABLE pets ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+ALTER TABLE merchants DROP COLUMN IF EXISTS permissions;
@@ -0,0 +1 @@
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS permissions TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS merchant_stores (
+  merchant_id BIGINT NOT NULL REFERENCES merchants(id) ON DELETE CASCADE,
+  store_id BIGINT NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (merchant_id, store_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_merchant_stores_store ON merchant_stores (store_id);
+
+INSERT INTO merchant_stores (merchant_id, store_id)
+SELECT id, store_id FROM merchants
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS platform_admins;
+ALTER TABLE stores DROP COLUMN IF EXISTS deactivated_at;
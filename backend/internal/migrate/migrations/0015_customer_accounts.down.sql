@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_customers_username_lower;
+DROP INDEX IF EXISTS idx_merchants_username_lower;
+
+ALTER TABLE customers
+  DROP COLUMN IF EXISTS phone_nonce,
+  DROP COLUMN IF EXISTS phone_enc,
+  DROP COLUMN IF EXISTS email_nonce,
+  DROP COLUMN IF EXISTS email_enc;
@@ -0,0 +1,8 @@
+ALTER TABLE customers
+  ADD COLUMN IF NOT EXISTS email_enc BYTEA,
+  ADD COLUMN IF NOT EXISTS email_nonce BYTEA,
+  ADD COLUMN IF NOT EXISTS phone_enc BYTEA,
+  ADD COLUMN IF NOT EXISTS phone_nonce BYTEA;
+
+CREATE INDEX IF NOT EXISTS idx_merchants_username_lower ON merchants (LOWER(username));
+CREATE INDEX IF NOT EXISTS idx_customers_username_lower ON customers (LOWER(username));
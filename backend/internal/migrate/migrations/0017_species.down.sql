@@ -0,0 +1,3 @@
+ALTER TABLE pets DROP CONSTRAINT IF EXISTS pets_species_fkey;
+UPDATE pets SET species = UPPER(species);
+DROP TABLE IF EXISTS species;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS species (
+  id BIGSERIAL PRIMARY KEY,
+  store_id BIGINT NOT NULL REFERENCES stores(id),
+  slug TEXT NOT NULL,
+  name TEXT NOT NULL,
+  parent_id BIGINT REFERENCES species(id),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (store_id, slug)
+);
+
+CREATE INDEX IF NOT EXISTS idx_species_parent ON species (parent_id);
+
+-- Every existing store gets the three species of the old enum, and pets
+-- switch from the enum values to the slugs.
+INSERT INTO species (store_id, slug, name)
+SELECT s.id, v.slug, v.name
+FROM stores s
+CROSS JOIN (VALUES ('cat', 'Cat'), ('dog', 'Dog'), ('frog', 'Frog')) AS v (slug, name)
+ON CONFLICT (store_id, slug) DO NOTHING;
+
+UPDATE pets SET species = LOWER(species) WHERE species <> LOWER(species);
+
+INSERT INTO species (store_id, slug, name)
+SELECT DISTINCT store_id, species, INITCAP(species)
+FROM pets
+ON CONFLICT (store_id, slug) DO NOTHING;
+
+ALTER TABLE pets DROP CONSTRAINT IF EXISTS pets_species_fkey;
+ALTER TABLE pets ADD CONSTRAINT pets_species_fkey
+  FOREIGN KEY (store_id, species) REFERENCES species (store_id, slug);
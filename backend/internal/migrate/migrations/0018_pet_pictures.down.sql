@@ -0,0 +1,4 @@
+ALTER TABLE pets DROP CONSTRAINT IF EXISTS pets_picture_check;
+ALTER TABLE pets DROP COLUMN IF EXISTS picture_height;
+ALTER TABLE pets DROP COLUMN IF EXISTS picture_width;
+ALTER TABLE pets DROP COLUMN IF EXISTS picture_key;
@@ -0,0 +1,10 @@
+ALTER TABLE pets ADD COLUMN IF NOT EXISTS picture_key TEXT;
+ALTER TABLE pets ADD COLUMN IF NOT EXISTS picture_width INT;
+ALTER TABLE pets ADD COLUMN IF NOT EXISTS picture_height INT;
+
+-- An uploaded picture replaces the external URL, which is then left empty.
+ALTER TABLE pets DROP CONSTRAINT IF EXISTS pets_picture_check;
+ALTER TABLE pets ADD CONSTRAINT pets_picture_check CHECK (
+  (picture_key IS NULL AND picture_width IS NULL AND picture_height IS NULL AND picture_url <> '')
+  OR (picture_key IS NOT NULL AND picture_width > 0 AND picture_height > 0 AND picture_url = '')
+);
@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS favorites (
+  customer_id BIGINT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+  pet_id UUID NOT NULL REFERENCES pets(id) ON DELETE CASCADE,
+  favorited_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (customer_id, pet_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_favorites_pet ON favorites (pet_id);
+
+CREATE TABLE IF NOT EXISTS saved_searches (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  store_id BIGINT NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+  customer_id BIGINT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  species TEXT[] NOT NULL DEFAULT '{}',
+  min_age INT,
+  max_age INT,
+  created_after TIMESTAMPTZ,
+  query TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_searches_store ON saved_searches (store_id);
+CREATE INDEX IF NOT EXISTS idx_saved_searches_customer ON saved_searches (customer_id, created_at);
+
+-- The message is written when the alert is raised, so renaming the pet or
+-- deleting the saved search later does not change it.
+CREATE TABLE IF NOT EXISTS notifications (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  store_id BIGINT NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+  customer_id BIGINT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL CHECK (kind IN ('SAVED_SEARCH_MATCH', 'FAVORITE_SOLD')),
+  pet_id UUID NOT NULL REFERENCES pets(id) ON DELETE CASCADE,
+  saved_search_id UUID REFERENCES saved_searches(id) ON DELETE SET NULL,
+  message TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  read_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_customer ON notifications (customer_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications (customer_id) WHERE read_at IS NULL;
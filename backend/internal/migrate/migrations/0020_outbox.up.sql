@@ -0,0 +1,20 @@
+-- Mail written in the same transaction as the change it reports and sent
+-- later by the dispatcher. Recipients stay encrypted like the breeder
+-- emails they are copied from.
+CREATE TABLE IF NOT EXISTS outbox (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  store_id BIGINT NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL CHECK (kind IN ('PET_LISTED', 'PET_SOLD')),
+  recipient_enc BYTEA NOT NULL,
+  recipient_nonce BYTEA NOT NULL,
+  payload JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'SENT', 'DEAD')),
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_error TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox (next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS idx_outbox_sent ON outbox (sent_at) WHERE status = 'SENT';
@@ -0,0 +1,86 @@
+// Package outbox delivers the mail that storage queues in the same
+// transaction as the change it reports, retrying failures with backoff
+// until they succeed or are dead-lettered.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/mail"
+	"nimble-challenge/backend/internal/retry"
+)
+
+const (
+	DefaultMaxAttempts = 8
+
+	// sendTimeout bounds one delivery; the SMTP mailer gives up by then.
+	sendTimeout = 30 * time.Second
+)
+
+type Dispatcher struct {
+	store       db.Outbox
+	crypto      db.Crypto
+	mailer      mail.Mailer
+	maxAttempts int
+	backoff     func(attempts int) time.Duration
+}
+
+func NewDispatcher(store db.Outbox, crypto db.Crypto, mailer mail.Mailer) *Dispatcher {
+	return &Dispatcher{store: store, crypto: crypto, mailer: mailer, maxAttempts: DefaultMaxAttempts, backoff: retry.Backoff}
+}
+
+// SetMaxAttempts changes how many deliveries a message gets before it is
+// dead-lettered.
+func (d *Dispatcher) SetMaxAttempts(n int) {
+	if n > 0 {
+		d.maxAttempts = n
+	}
+}
+
+// Dispatch delivers every message that is due and reports how many were
+// sent. Failed deliveries are rescheduled or dead-lettered and do not make
+// it fail; only storage errors do.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	return retry.Queue[db.OutboxMessage, struct{}]{
+		Name:         "outbox",
+		MaxAttempts:  d.maxAttempts,
+		Backoff:      d.backoff,
+		Timeout:      sendTimeout,
+		LeaseExpired: db.ErrLeaseExpired,
+		Claim:        d.store.ClaimOutboxMessages,
+		Send: func(ctx context.Context, msg db.OutboxMessage) (struct{}, error) {
+			return struct{}{}, d.send(ctx, msg)
+		},
+		Complete: func(ctx context.Context, msg db.OutboxMessage, _ struct{}) error {
+			return d.store.CompleteOutboxMessage(ctx, msg.ID, msg.Attempts)
+		},
+		Fail: func(ctx context.Context, msg db.OutboxMessage, _ struct{}, cause string, retryAt *time.Time) error {
+			return d.store.FailOutboxMessage(ctx, msg.ID, msg.Attempts, cause, retryAt)
+		},
+		Describe: func(msg db.OutboxMessage) string { return fmt.Sprintf("%s message %s", msg.Kind, msg.ID) },
+		Attempts: func(msg db.OutboxMessage) int { return msg.Attempts },
+	}.Dispatch(ctx)
+}
+
+func (d *Dispatcher) send(ctx context.Context, msg db.OutboxMessage) error {
+	to, err := d.crypto.Decrypt(msg.RecipientEnc, msg.RecipientNonce)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("decrypt recipient: %w", err))
+	}
+	m, err := render(msg.Kind, msg.Payload)
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	m.To = to
+	if err := d.mailer.Send(ctx, m); err != nil {
+		if errors.Is(err, mail.ErrInvalidMessage) {
+			return retry.Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package outbox
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nimble-challenge/backend/internal/crypto"
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/mail"
+)
+
+// flakyMailer fails its first failures sends with err and passes the
+// rest on to next.
+type flakyMailer struct {
+	next     mail.Mailer
+	failures int
+	err      error
+	calls    int
+}
+
+func (m *flakyMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.calls++
+	if m.calls <= m.failures {
+		return m.err
+	}
+	return m.next.Send(ctx, msg)
+}
+
+func newTestStore(t *testing.T) (*db.MemoryStore, *crypto.Cipher) {
+	t.Helper()
+	cipher, err := crypto.NewCipherFromBase64(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatalf("cipher: %v", err)
+	}
+	store := db.NewMemoryStore(cipher)
+	if err := store.EnsureDemoData(context.Background(), "demo", "Demo", "USD", "merchant", "merchant_pw", "customer", "customer_pw"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	return store, cipher
+}
+
+func newFileMailer(t *testing.T) (*mail.WriterMailer, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mail.log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open mail file: %v", err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	return mail.NewWriterMailer(f, "shop@example.com"), path
+}
+
+func readMail(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read mail file: %v", err)
+	}
+	return string(data)
+}
+
+func TestDispatchSendsBreederMail(t *testing.T) {
+	store, cipher := newTestStore(t)
+	ctx := context.Background()
+	mailer, path := newFileMailer(t)
+	d := NewDispatcher(store, cipher, mailer)
+
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 3 {
+		t.Fatalf("expected the 3 demo listings to be mailed, got %d (%v)", sent, err)
+	}
+	customer, err := store.Authenticate(ctx, "customer", "customer_pw")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	pets, err := store.ListAvailablePets(ctx, customer.StoreID, customer.UserID)
+	if err != nil {
+		t.Fatalf("list pets: %v", err)
+	}
+	if _, err := store.PurchasePets(ctx, customer.StoreID, customer.UserID, []string{pets[0].ID}); err != nil {
+		t.Fatalf("purchase: %v", err)
+	}
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 1 {
+		t.Fatalf("expected the sale to be mailed, got %d (%v)", sent, err)
+	}
+
+	out := readMail(t, path)
+	sold := fmt.Sprintf("Subject: %s has been sold\r\n", pets[0].Name)
+	body := fmt.Sprintf("%s has just been sold at Demo for %s USD.", pets[0].Name, pets[0].Price.Decimal())
+	for _, want := range []string{"To: " + pets[0].BreederEmail + "\r\n", sold, body} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in %q", want, out)
+		}
+	}
+	if n := strings.Count(out, "is now listed at Demo"); n != 6 {
+		t.Fatalf("expected 3 listing mails, found %d mentions", n)
+	}
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected nothing left to send, got %d (%v)", sent, err)
+	}
+}
+
+func TestDispatchRetriesAndDeadLetters(t *testing.T) {
+	store, cipher := newTestStore(t)
+	ctx := context.Background()
+	fileMailer, path := newFileMailer(t)
+	mailer := &flakyMailer{next: fileMailer, failures: 4, err: errors.New("connection refused")}
+	d := NewDispatcher(store, cipher, mailer)
+	d.SetMaxAttempts(2)
+	d.backoff = func(int) time.Duration { return 0 }
+
+	// All three demo listings fail their first attempt. On the second, the
+	// first one fails again and is dead-lettered while the others go out.
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected every first attempt to fail, got %d (%v)", sent, err)
+	}
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 2 {
+		t.Fatalf("expected 2 retries to go through, got %d (%v)", sent, err)
+	}
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 || mailer.calls != 6 {
+		t.Fatalf("expected the dead-lettered message to stay put, got %d after %d calls (%v)", sent, mailer.calls, err)
+	}
+	if n := strings.Count(readMail(t, path), "Subject: "); n != 2 {
+		t.Fatalf("expected 2 mails, got %d", n)
+	}
+}
+
+func TestDispatchDeadLettersInvalidMessages(t *testing.T) {
+	store, cipher := newTestStore(t)
+	ctx := context.Background()
+	fileMailer, _ := newFileMailer(t)
+	mailer := &flakyMailer{next: fileMailer, failures: 3, err: fmt.Errorf("%w: invalid recipient", mail.ErrInvalidMessage)}
+	d := NewDispatcher(store, cipher, mailer)
+	d.backoff = func(int) time.Duration { return 0 }
+
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected nothing to be sent, got %d (%v)", sent, err)
+	}
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 || mailer.calls != 3 {
+		t.Fatalf("expected invalid messages not to be retried, got %d after %d calls (%v)", sent, mailer.calls, err)
+	}
+}
+
+func TestLateOutcomeIsNotRecorded(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+	first, err := store.ClaimOutboxMessages(ctx, 10, 0)
+	if err != nil || len(first) == 0 {
+		t.Fatalf("claim: %d messages (%v)", len(first), err)
+	}
+	// The lease has run out, so another dispatcher claims the message.
+	second, err := store.ClaimOutboxMessages(ctx, 10, time.Minute)
+	if err != nil || len(second) != len(first) || second[0].ID != first[0].ID || second[0].Attempts != 2 {
+		t.Fatalf("expected the same message to be claimed again, got %+v (%v)", second, err)
+	}
+	if err := store.CompleteOutboxMessage(ctx, first[0].ID, first[0].Attempts); !errors.Is(err, db.ErrLeaseExpired) {
+		t.Fatalf("expected the first claim's outcome to be refused, got %v", err)
+	}
+	if err := store.FailOutboxMessage(ctx, first[0].ID, first[0].Attempts, "late", nil); !errors.Is(err, db.ErrLeaseExpired) {
+		t.Fatalf("expected the first claim's failure to be refused, got %v", err)
+	}
+	if err := store.CompleteOutboxMessage(ctx, second[0].ID, second[0].Attempts); err != nil {
+		t.Fatalf("complete under the latest claim: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package outbox
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/mail"
+)
+
+// templates holds a "<kind>.subject" and a "<kind>.body" template for
+// every db.OutboxKind, executed with the message's db.OutboxPayload.
+var templates = template.Must(template.New("outbox").Parse(`
+{{- define "PET_LISTED.subject"}}{{.PetName}} is now listed at {{.StoreName}}{{end}}
+{{- define "PET_LISTED.body"}}Hello {{.BreederName}},
+
+{{.PetName}} is now listed at {{.StoreName}} for {{.Price.Decimal}} {{.Currency}}.
+We will let you know when it is sold.
+{{end}}
+{{- define "PET_SOLD.subject"}}{{.PetName}} has been sold{{end}}
+{{- define "PET_SOLD.body"}}Hello {{.BreederName}},
+
+Good news: {{.PetName}} has just been sold at {{.StoreName}} for {{.Price.Decimal}} {{.Currency}}.
+{{end}}
+`))
+
+func render(kind db.OutboxKind, payload db.OutboxPayload) (mail.Message, error) {
+	var subject, body strings.Builder
+	if err := templates.ExecuteTemplate(&subject, string(kind)+".subject", payload); err != nil {
+		return mail.Message{}, fmt.Errorf("render %s subject: %w", kind, err)
+	}
+	if err := templates.ExecuteTemplate(&body, string(kind)+".body", payload); err != nil {
+		return mail.Message{}, fmt.Errorf("render %s body: %w", kind, err)
+	}
+	return mail.Message{Subject: subject.String(), Body: body.String()}, nil
+}
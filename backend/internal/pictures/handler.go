@@ -0,0 +1,47 @@
+package pictures
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"nimble-challenge/backend/internal/blob"
+)
+
+// Handler serves GET PathPrefix + key for pictures and thumbnails. Keys
+// are random and never reused for different content, so responses may be
+// cached for good.
+func (l *Library) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'")
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, PathPrefix)
+		contentType, ok := contentTypes[path.Ext(key)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		obj, err := l.blobs.Open(r.Context(), key)
+		if errors.Is(err, blob.ErrNotFound) || errors.Is(err, blob.ErrInvalidKey) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("open picture %q: %v", key, err)
+			http.Error(w, "picture unavailable", http.StatusInternalServerError)
+			return
+		}
+		defer obj.Close()
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("ETag", `"`+path.Base(key)+`"`)
+		http.ServeContent(w, r, "", obj.ModTime, obj)
+	})
+}
@@ -0,0 +1,142 @@
+// Package pictures checks uploaded pet photos, stores them with a
+// thumbnail and serves both back over HTTP.
+package pictures
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"path"
+	"strings"
+
+	"nimble-challenge/backend/internal/blob"
+	"nimble-challenge/backend/internal/db"
+)
+
+const (
+	// MaxBytes is the largest picture accepted for upload.
+	MaxBytes = 5 << 20
+	// maxPixels bounds the decoded size, since a small file can still
+	// decode to a huge image.
+	maxPixels = 25_000_000
+	// ThumbnailSize is the longer side of a thumbnail in pixels.
+	ThumbnailSize = 320
+
+	// PathPrefix is where Handler is mounted.
+	PathPrefix = "/pictures/"
+)
+
+var (
+	ErrUnsupportedType = errors.New("picture must be a JPEG, PNG or GIF image")
+	ErrTooLarge        = fmt.Errorf("picture must not be larger than %d MB", MaxBytes>>20)
+	ErrTooManyPixels   = fmt.Errorf("picture must not have more than %d megapixels", maxPixels/1_000_000)
+	ErrUnreadable      = errors.New("picture could not be read")
+)
+
+// extensions maps the accepted content types to the extension their blobs
+// are stored with; contentTypes is the reverse, used when serving.
+var (
+	extensions = map[string]string{
+		"image/jpeg": ".jpg",
+		"image/png":  ".png",
+		"image/gif":  ".gif",
+	}
+	contentTypes = map[string]string{
+		".jpg": "image/jpeg",
+		".png": "image/png",
+		".gif": "image/gif",
+	}
+)
+
+// Library keeps pictures and their thumbnails in blob storage.
+type Library struct {
+	blobs blob.Store
+}
+
+func NewLibrary(blobs blob.Store) *Library {
+	return &Library{blobs: blobs}
+}
+
+// Save checks that data is a supported image and stores it with a
+// thumbnail under a new key in the store's folder. The type is sniffed from
+// the data itself; whatever the client claimed is ignored.
+func (l *Library) Save(ctx context.Context, storeID int64, data []byte) (db.Picture, error) {
+	if len(data) > MaxBytes {
+		return db.Picture{}, ErrTooLarge
+	}
+	ext, ok := extensions[http.DetectContentType(data)]
+	if !ok {
+		return db.Picture{}, ErrUnsupportedType
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return db.Picture{}, ErrUnreadable
+	}
+	if config.Width*config.Height > maxPixels {
+		return db.Picture{}, ErrTooManyPixels
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return db.Picture{}, ErrUnreadable
+	}
+	var thumb bytes.Buffer
+	if err := encode(&thumb, thumbnail(img, ThumbnailSize), thumbnailExt(ext)); err != nil {
+		return db.Picture{}, fmt.Errorf("encode thumbnail: %w", err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return db.Picture{}, fmt.Errorf("picture key: %w", err)
+	}
+	picture := db.Picture{
+		Key:    fmt.Sprintf("pets/%d/%s%s", storeID, hex.EncodeToString(id), ext),
+		Width:  config.Width,
+		Height: config.Height,
+	}
+	if err := l.blobs.Put(ctx, picture.Key, bytes.NewReader(data)); err != nil {
+		return db.Picture{}, err
+	}
+	if err := l.blobs.Put(ctx, ThumbnailKey(picture.Key), &thumb); err != nil {
+		_ = l.blobs.Delete(ctx, picture.Key)
+		return db.Picture{}, err
+	}
+	return picture, nil
+}
+
+// Delete removes a picture and its thumbnail.
+func (l *Library) Delete(ctx context.Context, picture db.Picture) error {
+	return errors.Join(
+		l.blobs.Delete(ctx, picture.Key),
+		l.blobs.Delete(ctx, ThumbnailKey(picture.Key)),
+	)
+}
+
+// ThumbnailKey is the key of the thumbnail stored for a picture. JPEGs
+// keep their format; PNGs and GIFs get PNG thumbnails so that transparency
+// survives.
+func ThumbnailKey(key string) string {
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + ".thumb" + thumbnailExt(ext)
+}
+
+func thumbnailExt(ext string) string {
+	if ext == ".jpg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+func encode(buf *bytes.Buffer, img image.Image, ext string) error {
+	if ext == ".jpg" {
+		return jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+	}
+	return png.Encode(buf, img)
+}
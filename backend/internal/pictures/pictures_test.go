@@ -0,0 +1,117 @@
+package pictures
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nimble-challenge/backend/internal/blob"
+)
+
+func newTestLibrary(t *testing.T) *Library {
+	t.Helper()
+	blobs, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("blob store: %v", err)
+	}
+	return NewLibrary(blobs)
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSavePicture(t *testing.T) {
+	lib := newTestLibrary(t)
+	ctx := context.Background()
+
+	picture, err := lib.Save(ctx, 7, testPNG(t, 800, 400))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if picture.Width != 800 || picture.Height != 400 {
+		t.Fatalf("unexpected size %dx%d", picture.Width, picture.Height)
+	}
+	obj, err := lib.blobs.Open(ctx, ThumbnailKey(picture.Key))
+	if err != nil {
+		t.Fatalf("open thumbnail: %v", err)
+	}
+	defer obj.Close()
+	config, format, err := image.DecodeConfig(obj)
+	if err != nil || format != "png" || config.Width != ThumbnailSize || config.Height != ThumbnailSize/2 {
+		t.Fatalf("unexpected thumbnail %s %dx%d: %v", format, config.Width, config.Height, err)
+	}
+
+	if _, err := lib.Save(ctx, 7, []byte("<html>not a picture</html>")); !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("expected ErrUnsupportedType, got %v", err)
+	}
+	if _, err := lib.Save(ctx, 7, make([]byte, MaxBytes+1)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	if _, err := lib.Save(ctx, 7, testPNG(t, 800, 400)[:100]); !errors.Is(err, ErrUnreadable) {
+		t.Fatalf("expected a truncated picture to be rejected, got %v", err)
+	}
+
+	if err := lib.Delete(ctx, picture); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := lib.blobs.Open(ctx, picture.Key); !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("expected the picture to be gone, got %v", err)
+	}
+}
+
+func TestServePicture(t *testing.T) {
+	lib := newTestLibrary(t)
+	picture, err := lib.Save(context.Background(), 7, testPNG(t, 40, 30))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	get := func(target, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		lib.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(PathPrefix+ThumbnailKey(picture.Key), "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Fatalf("unexpected Cache-Control %q", rec.Header().Get("Cache-Control"))
+	}
+	if rec := get(PathPrefix+picture.Key, rec.Header().Get("ETag")); rec.Code != http.StatusOK {
+		t.Fatalf("expected the thumbnail's ETag not to match the picture, got %d", rec.Code)
+	}
+	etag := get(PathPrefix+picture.Key, "").Header().Get("ETag")
+	if rec := get(PathPrefix+picture.Key, etag); rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for a matching ETag, got %d", rec.Code)
+	}
+
+	for _, target := range []string{PathPrefix + "pets/7/missing.png", PathPrefix + "../secret.png", PathPrefix + "pets/7/notes.txt"} {
+		if rec := get(target, ""); rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for %s, got %d", target, rec.Code)
+		}
+	}
+}
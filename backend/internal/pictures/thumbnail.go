@@ -0,0 +1,49 @@
+package pictures
+
+import (
+	"image"
+	"image/draw"
+)
+
+// thumbnail scales img down so that its longer side is at most size. Each
+// thumbnail pixel is the average of the source pixels it covers; smaller
+// images keep their size.
+func thumbnail(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+
+	// Source rows are converted to RGBA one band per thumbnail row, so a
+	// large picture is never held twice in memory.
+	band := image.NewRGBA(image.Rect(0, 0, w, (h+th-1)/th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, (y+1)*h/th
+		draw.Draw(band, image.Rect(0, 0, w, y1-y0), img, image.Pt(b.Min.X, b.Min.Y+y0), draw.Src)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, (x+1)*w/tw
+			var sum [4]int
+			for sy := 0; sy < y1-y0; sy++ {
+				row := band.Pix[sy*band.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := range sum {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			offset := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[offset+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,46 @@
+// Package ratelimit hands out a fixed number of requests per key and
+// window, such as per client IP.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type Limiter struct {
+	mu      sync.Mutex
+	max     int
+	window  time.Duration
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens int
+	last   time.Time
+}
+
+func New(max int, window time.Duration) *Limiter {
+	return &Limiter{max: max, window: window, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token for key, refilling the bucket once a full window has
+// passed since it was last filled.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.max, last: now}
+		l.buckets[key] = b
+	}
+	if now.Sub(b.last) >= l.window {
+		b.tokens = l.max
+		b.last = now
+	}
+	if b.tokens <= 0 {
+		return false
+	}
+	b.tokens--
+	return true
+}
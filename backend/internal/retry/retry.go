@@ -0,0 +1,140 @@
+// Package retry runs the delivery loop the mail outbox and webhooks share:
+// claim the jobs that are due, make one attempt at each and record the
+// outcome, rescheduling failures with backoff until they succeed or run
+// out of attempts.
+package retry
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	batchSize = 20
+	// leaseMargin is added to the time a full batch of attempts may take,
+	// for the claim and the recording of outcomes.
+	leaseMargin = time.Minute
+	baseBackoff = time.Minute
+	maxBackoff  = 6 * time.Hour
+)
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// Permanent marks err as a failure that retrying cannot fix, so the job is
+// given up on at once.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Queue is a kind of job and how to send it. R is what an attempt reports
+// besides its error, recorded with the outcome either way.
+type Queue[J, R any] struct {
+	// Name prefixes the log lines.
+	Name        string
+	MaxAttempts int
+	Backoff     func(attempts int) time.Duration
+	// Timeout bounds each attempt. A claimed job stays hidden from other
+	// dispatchers for long enough that a whole batch of attempts can time
+	// out first; one that is not reported back by then is tried again.
+	Timeout time.Duration
+	// LeaseExpired is what Complete and Fail return for an outcome that
+	// came too late, after the job was claimed again. The newer claim
+	// records its own, so this one is dropped.
+	LeaseExpired error
+
+	Claim func(ctx context.Context, limit int, lease time.Duration) ([]J, error)
+	Send  func(ctx context.Context, job J) (R, error)
+	// Complete records a successful attempt and Fail a failed one;
+	// retryAt is nil when the job is given up on. Both must only succeed
+	// for the latest claim of the job.
+	Complete func(ctx context.Context, job J, result R) error
+	Fail     func(ctx context.Context, job J, result R, cause string, retryAt *time.Time) error
+	// Describe names a job in log lines. Attempts is how many times it has
+	// been claimed, the current attempt included.
+	Describe func(job J) string
+	Attempts func(job J) int
+}
+
+// Dispatch sends every job that is due and reports how many succeeded.
+// Failed attempts are rescheduled or given up on and do not make it fail;
+// only storage errors do.
+func (q Queue[J, R]) Dispatch(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		jobs, err := q.Claim(ctx, batchSize, q.lease())
+		if err != nil {
+			return sent, err
+		}
+		for _, job := range jobs {
+			ok, err := q.deliver(ctx, job)
+			if err != nil {
+				return sent, err
+			}
+			if ok {
+				sent++
+			}
+		}
+		if len(jobs) < batchSize {
+			return sent, nil
+		}
+	}
+}
+
+// lease is how long a claim lasts: as long as a full batch of attempts
+// timing out, and then some.
+func (q Queue[J, R]) lease() time.Duration {
+	return batchSize*q.Timeout + leaseMargin
+}
+
+func (q Queue[J, R]) deliver(ctx context.Context, job J) (bool, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, q.Timeout)
+	result, err := q.Send(attemptCtx, job)
+	cancel()
+	if err == nil {
+		return true, q.record(job, q.Complete(ctx, job, result))
+	}
+	if ctx.Err() != nil {
+		// Shutting down; the job is tried again once its lease expires.
+		return false, ctx.Err()
+	}
+	attempts := q.Attempts(job)
+	var retryAt *time.Time
+	var permanent permanentError
+	if !errors.As(err, &permanent) && attempts < q.MaxAttempts {
+		at := time.Now().Add(q.Backoff(attempts))
+		retryAt = &at
+	}
+	if retryAt == nil {
+		log.Printf("%s: %s given up after %d attempts: %v", q.Name, q.Describe(job), attempts, err)
+	} else {
+		log.Printf("%s: %s failed, retrying at %s: %v", q.Name, q.Describe(job), retryAt.Format(time.RFC3339), err)
+	}
+	return false, q.record(job, q.Fail(ctx, job, result, err.Error(), retryAt))
+}
+
+// record drops the error of an outcome that came after the job was
+// claimed again.
+func (q Queue[J, R]) record(job J, err error) error {
+	if q.LeaseExpired != nil && errors.Is(err, q.LeaseExpired) {
+		log.Printf("%s: %s outlasted its claim; its outcome is not recorded", q.Name, q.Describe(job))
+		return nil
+	}
+	return err
+}
+
+// Backoff doubles the wait after every failed attempt, starting at a
+// minute, up to six hours.
+func Backoff(attempts int) time.Duration {
+	wait := baseBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
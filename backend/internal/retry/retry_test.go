@@ -0,0 +1,118 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type job struct {
+	id       int
+	attempts int
+}
+
+type outcome struct {
+	job     int
+	ok      bool
+	cause   string
+	retryAt *time.Time
+}
+
+// queue runs jobs whose attempts fail with the errors in failures, keyed
+// by job id, and records each outcome.
+func queue(jobs []job, failures map[int]error) (Queue[job, int], *[]outcome) {
+	var outcomes []outcome
+	claimed := false
+	return Queue[job, int]{
+		Name:        "test",
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return time.Hour },
+		Timeout:     time.Second,
+		Claim: func(context.Context, int, time.Duration) ([]job, error) {
+			if claimed {
+				return nil, nil
+			}
+			claimed = true
+			return jobs, nil
+		},
+		Send: func(_ context.Context, j job) (int, error) { return j.id * 10, failures[j.id] },
+		Complete: func(_ context.Context, j job, result int) error {
+			outcomes = append(outcomes, outcome{job: j.id, ok: result == j.id*10})
+			return nil
+		},
+		Fail: func(_ context.Context, j job, _ int, cause string, retryAt *time.Time) error {
+			outcomes = append(outcomes, outcome{job: j.id, cause: cause, retryAt: retryAt})
+			return nil
+		},
+		Describe: func(j job) string { return fmt.Sprintf("job %d", j.id) },
+		Attempts: func(j job) int { return j.attempts },
+	}, &outcomes
+}
+
+func TestDispatch(t *testing.T) {
+	q, outcomes := queue(
+		[]job{{id: 1, attempts: 1}, {id: 2, attempts: 1}, {id: 3, attempts: 3}, {id: 4, attempts: 1}},
+		map[int]error{2: errors.New("busy"), 3: errors.New("busy"), 4: Permanent(errors.New("bad"))},
+	)
+	sent, err := q.Dispatch(context.Background())
+	if err != nil || sent != 1 || len(*outcomes) != 4 {
+		t.Fatalf("unexpected dispatch: %d sent, %+v (%v)", sent, *outcomes, err)
+	}
+	got := *outcomes
+	if !got[0].ok {
+		t.Fatalf("expected job 1 to complete with its result, got %+v", got[0])
+	}
+	if got[1].cause != "busy" || got[1].retryAt == nil {
+		t.Fatalf("expected job 2 to be retried, got %+v", got[1])
+	}
+	if got[2].retryAt != nil || got[3].retryAt != nil || got[3].cause != "bad" {
+		t.Fatalf("expected jobs 3 and 4 to be given up on, got %+v %+v", got[2], got[3])
+	}
+}
+
+func TestDispatchStopsOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q, outcomes := queue([]job{{id: 1, attempts: 1}}, nil)
+	q.Send = func(context.Context, job) (int, error) {
+		cancel()
+		return 0, context.Canceled
+	}
+	if _, err := q.Dispatch(ctx); !errors.Is(err, context.Canceled) || len(*outcomes) != 0 {
+		t.Fatalf("expected the job to be left to its lease, got %+v (%v)", *outcomes, err)
+	}
+}
+
+func TestDispatchDropsLateOutcomes(t *testing.T) {
+	errExpired := errors.New("expired")
+	q, _ := queue([]job{{id: 1, attempts: 1}, {id: 2, attempts: 1}}, nil)
+	q.LeaseExpired = errExpired
+	q.Complete = func(_ context.Context, j job, _ int) error {
+		if j.id == 1 {
+			return fmt.Errorf("complete: %w", errExpired)
+		}
+		return nil
+	}
+	var lease time.Duration
+	claim := q.Claim
+	q.Claim = func(ctx context.Context, limit int, l time.Duration) ([]job, error) {
+		lease = l
+		return claim(ctx, limit, l)
+	}
+	sent, err := q.Dispatch(context.Background())
+	if err != nil || sent != 2 {
+		t.Fatalf("expected a late outcome to be dropped, not to stop the batch, got %d (%v)", sent, err)
+	}
+	if lease < batchSize*q.Timeout {
+		t.Fatalf("lease %s does not outlast a batch of %d attempts of %s", lease, batchSize, q.Timeout)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 20: maxBackoff} {
+		if got := Backoff(attempts); got != want {
+			t.Fatalf("Backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package webhooks sends the pet events storage queues for merchants'
+// endpoints, signing each request and retrying failures with backoff.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"nimble-challenge/backend/internal/db"
+	"nimble-challenge/backend/internal/retry"
+)
+
+const (
+	DefaultMaxAttempts = 8
+
+	// Headers sent with every delivery. The signature covers the timestamp
+	// and the body; see Sign.
+	HeaderDelivery  = "X-Webhook-Id"
+	HeaderEventID   = "X-Webhook-Event-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+
+	requestTimeout = 10 * time.Second
+	// maxDrain is how much of a response is read so that its connection
+	// can be reused.
+	maxDrain = 64 << 10
+)
+
+var (
+	ErrBadSignature = errors.New("webhook signature does not match")
+	ErrStaleRequest = errors.New("webhook timestamp is outside the tolerance")
+	// ErrForbiddenAddress is a delivery refused because the endpoint's
+	// host resolved to an address outside the public internet.
+	ErrForbiddenAddress = errors.New("endpoint address is not public")
+)
+
+// reservedPrefixes are ranges not covered by the netip predicates that
+// must not be reached either: "this network", carrier-grade NAT,
+// benchmarking and the reserved block.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// responseError is a request the endpoint answered with a status other
+// than 2xx. The body is not kept: merchants read the delivery log, and
+// what an endpoint answers is not theirs to see unless they run it.
+type responseError struct {
+	status int
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("endpoint answered %d", e.status)
+}
+
+type Dispatcher struct {
+	store       db.WebhookQueue
+	crypto      db.Crypto
+	client      *http.Client
+	maxAttempts int
+	backoff     func(attempts int) time.Duration
+}
+
+func NewDispatcher(store db.WebhookQueue, crypto db.Crypto) *Dispatcher {
+	return &Dispatcher{store: store, crypto: crypto, client: newClient(false), maxAttempts: DefaultMaxAttempts, backoff: retry.Backoff}
+}
+
+// newClient returns the client deliveries are sent with. Addresses are
+// checked as each connection is made, after DNS resolution, so a hostname
+// that resolves or later rebinds to a private address is refused too.
+// allowLoopback exists for tests against httptest servers.
+func newClient(allowLoopback bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkAddress(address, allowLoopback)
+		},
+	}
+	return &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			// No proxy: it would connect on our behalf, past the check.
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: requestTimeout,
+			MaxIdleConns:        20,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// A redirect counts as a failure rather than sending the signed
+		// payload somewhere the merchant did not register.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+func checkAddress(address string, allowLoopback bool) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() && allowLoopback {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return ErrForbiddenAddress
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(ip) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// SetMaxAttempts changes how many requests a delivery gets before it is
+// marked failed.
+func (d *Dispatcher) SetMaxAttempts(n int) {
+	if n > 0 {
+		d.maxAttempts = n
+	}
+}
+
+// Dispatch sends every delivery that is due and reports how many
+// succeeded. Failed requests are rescheduled or marked failed and do not
+// make it fail; only storage errors do.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	return retry.Queue[db.WebhookJob, int]{
+		Name:         "webhooks",
+		MaxAttempts:  d.maxAttempts,
+		Backoff:      d.backoff,
+		Timeout:      requestTimeout,
+		LeaseExpired: db.ErrLeaseExpired,
+		Claim:        d.store.ClaimWebhookDeliveries,
+		Send:         d.send,
+		Complete: func(ctx context.Context, job db.WebhookJob, status int) error {
+			return d.store.CompleteWebhookDelivery(ctx, job.DeliveryID, job.Attempts, status)
+		},
+		Fail: func(ctx context.Context, job db.WebhookJob, status int, cause string, retryAt *time.Time) error {
+			var responseStatus *int
+			if status != 0 {
+				responseStatus = &status
+			}
+			return d.store.FailWebhookDelivery(ctx, job.DeliveryID, job.Attempts, responseStatus, cause, retryAt)
+		},
+		Describe: func(job db.WebhookJob) string { return fmt.Sprintf("%s delivery %s", job.Event.Name(), job.DeliveryID) },
+		Attempts: func(job db.WebhookJob) int { return job.Attempts },
+	}.Dispatch(ctx)
+}
+
+// send posts the delivery and returns the response status, or 0 if there
+// was no response.
+func (d *Dispatcher) send(ctx context.Context, job db.WebhookJob) (int, error) {
+	secret, err := d.crypto.Decrypt(job.SecretEnc, job.SecretNonce)
+	if err != nil {
+		return 0, retry.Permanent(fmt.Errorf("decrypt secret: %w", err))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.URL, bytes.NewReader(job.Payload))
+	if err != nil {
+		return 0, retry.Permanent(fmt.Errorf("build request: %w", err))
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nimble-webhooks/1")
+	req.Header.Set(HeaderDelivery, job.DeliveryID)
+	req.Header.Set(HeaderEventID, job.EventID)
+	req.Header.Set(HeaderEvent, job.Event.Name())
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, job.Payload))
+
+	resp, err := d.client.Do(req)
+	if errors.Is(err, ErrForbiddenAddress) {
+		return 0, retry.Permanent(ErrForbiddenAddress)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrain))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &responseError{status: resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the X-Webhook-Signature value for a body sent at
+// timestamp: the hex HMAC-SHA256 of "timestamp.body" keyed with the
+// endpoint's secret, prefixed with "sha256=".
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a received delivery the way an endpoint should: the
+// signature must match and the timestamp be within tolerance of now, so
+// that captured requests cannot be replayed later.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp := header.Get(HeaderTimestamp)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleRequest
+	}
+	if age := now.Sub(time.Unix(sent, 0)); age > tolerance || age < -tolerance {
+		return ErrStaleRequest
+	}
+	if !hmac.Equal([]byte(header.Get(HeaderSignature)), []byte(Sign(secret, timestamp, body))) {
+		return ErrBadSignature
+	}
+	return nil
+}
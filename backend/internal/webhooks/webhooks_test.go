@@ -0,0 +1,274 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"nimble-challenge/backend/internal/crypto"
+	"nimble-challenge/backend/internal/db"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is an endpoint that answers the first failures requests with
+// 500 and records every request it gets.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []received
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, received{header: req.Header.Clone(), body: body})
+	if len(r.requests) <= r.failures {
+		http.Error(w, "try later", http.StatusInternalServerError)
+	}
+}
+
+func setup(t *testing.T, failures int) (*db.MemoryStore, *Dispatcher, *receiver, int64, string, string) {
+	t.Helper()
+	ctx := context.Background()
+	cipher, err := crypto.NewCipherFromBase64(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatalf("cipher: %v", err)
+	}
+	store := db.NewMemoryStore(cipher)
+	if err := store.EnsureDemoData(ctx, "demo", "Demo", "USD", "merchant", "merchant_pw", "customer", "customer_pw"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	merchant, err := store.Authenticate(ctx, "merchant", "merchant_pw")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	rec := &receiver{failures: failures}
+	server := httptest.NewTLSServer(rec)
+	t.Cleanup(server.Close)
+	endpoint, secret, err := store.CreateWebhookEndpoint(ctx, merchant.StoreID, db.WebhookEndpointInput{
+		URL:    server.URL + "/hooks",
+		Events: []db.WebhookEvent{db.WebhookPetCreated, db.WebhookPetPurchased},
+	})
+	if err != nil {
+		t.Fatalf("create endpoint: %v", err)
+	}
+	d := NewDispatcher(store, cipher)
+	d.client = testClient(server)
+	d.backoff = func(int) time.Duration { return 0 }
+	return store, d, rec, merchant.StoreID, endpoint.ID, secret
+}
+
+// testClient is the dispatcher's client with the loopback opt-in, trusting
+// the certificate every httptest TLS server shares.
+func testClient(server *httptest.Server) *http.Client {
+	client := newClient(true)
+	client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	return client
+}
+
+func createPet(t *testing.T, store *db.MemoryStore, storeID int64) db.Pet {
+	t.Helper()
+	pet, err := store.CreatePet(context.Background(), storeID, db.Pet{
+		Name: "Pip", Species: db.Species{Slug: "frog"}, AgeYears: 1, PictureURL: "https://example.com/pip.jpg",
+		Description: "Small.", BreederName: "Ann", BreederEmail: "ann@example.com", Price: db.Money{Amount: 1250},
+	})
+	if err != nil {
+		t.Fatalf("create pet: %v", err)
+	}
+	return pet
+}
+
+func TestDispatchSignsDeliveries(t *testing.T) {
+	store, d, rec, storeID, _, secret := setup(t, 0)
+	ctx := context.Background()
+	pet := createPet(t, store, storeID)
+	if _, err := store.ArchivePet(ctx, storeID, pet.ID, pet.Version); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 1 {
+		t.Fatalf("expected only the subscribed event to be sent, got %d (%v)", sent, err)
+	}
+	got := rec.requests[0]
+	if err := Verify(secret, got.header, got.body, time.Minute, time.Now()); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := Verify("whsec_other", got.header, got.body, time.Minute, time.Now()); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for the wrong secret, got %v", err)
+	}
+	if err := Verify(secret, got.header, got.body, time.Minute, time.Now().Add(time.Hour)); !errors.Is(err, ErrStaleRequest) {
+		t.Fatalf("expected ErrStaleRequest for an old request, got %v", err)
+	}
+	if got.header.Get(HeaderEvent) != "pet.created" {
+		t.Fatalf("unexpected event header %q", got.header.Get(HeaderEvent))
+	}
+
+	var payload struct {
+		ID   string
+		Type string
+		Pet  map[string]any
+	}
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Type != "pet.created" || payload.ID != got.header.Get(HeaderEventID) || payload.Pet["id"] != pet.ID ||
+		payload.Pet["currency"] != "USD" || payload.Pet["priceMinorUnits"] != float64(1250) {
+		t.Fatalf("unexpected payload %s", got.body)
+	}
+	if _, ok := payload.Pet["breederEmail"]; ok {
+		t.Fatalf("payload leaks the breeder email: %s", got.body)
+	}
+}
+
+func TestDispatchRetriesAndRedelivers(t *testing.T) {
+	store, d, rec, storeID, endpointID, _ := setup(t, 3)
+	ctx := context.Background()
+	d.SetMaxAttempts(2)
+	createPet(t, store, storeID)
+	createPet(t, store, storeID)
+
+	// Both deliveries fail their first attempt. On the second, the first one
+	// fails again and is given up on while the other goes through.
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected every first attempt to fail, got %d (%v)", sent, err)
+	}
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 1 {
+		t.Fatalf("expected one retry to go through, got %d (%v)", sent, err)
+	}
+
+	failed := db.WebhookFailed
+	page, err := store.PageWebhookDeliveries(ctx, storeID, db.WebhookDeliveryFilter{EndpointID: endpointID, Status: &failed}, db.PageRequest{})
+	if err != nil || len(page.Edges) != 1 {
+		t.Fatalf("expected one failed delivery, got %+v (%v)", page.Edges, err)
+	}
+	delivery := page.Edges[0].Delivery
+	if delivery.Attempts != 2 || delivery.ResponseStatus == nil || *delivery.ResponseStatus != 500 || delivery.LastError != "endpoint answered 500" {
+		t.Fatalf("unexpected failed delivery %+v", delivery)
+	}
+
+	redelivery, err := store.RedeliverWebhook(ctx, storeID, delivery.ID)
+	if err != nil || redelivery.EventID != delivery.EventID || redelivery.Status != db.WebhookPending {
+		t.Fatalf("unexpected redelivery %+v (%v)", redelivery, err)
+	}
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 1 {
+		t.Fatalf("expected the redelivery to go through, got %d (%v)", sent, err)
+	}
+	last := rec.requests[len(rec.requests)-1]
+	if last.header.Get(HeaderDelivery) != redelivery.ID || last.header.Get(HeaderEventID) != delivery.EventID {
+		t.Fatalf("unexpected redelivery headers %v", last.header)
+	}
+	if _, err := store.RedeliverWebhook(ctx, storeID+1, delivery.ID); !errors.Is(err, db.ErrWebhookDeliveryNotFound) {
+		t.Fatalf("expected another store's redelivery to fail, got %v", err)
+	}
+}
+
+func TestDispatchDoesNotFollowRedirects(t *testing.T) {
+	store, d, _, storeID, endpointID, _ := setup(t, 0)
+	ctx := context.Background()
+	target := httptest.NewTLSServer(http.RedirectHandler("https://example.com/elsewhere", http.StatusFound))
+	t.Cleanup(target.Close)
+	url := target.URL
+	if _, err := store.UpdateWebhookEndpoint(ctx, storeID, endpointID, db.WebhookEndpointPatch{URL: &url}); err != nil {
+		t.Fatalf("update endpoint: %v", err)
+	}
+	createPet(t, store, storeID)
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected the redirect to count as a failure, got %d (%v)", sent, err)
+	}
+	page, err := store.PageWebhookDeliveries(ctx, storeID, db.WebhookDeliveryFilter{}, db.PageRequest{})
+	if err != nil || len(page.Edges) != 1 || *page.Edges[0].Delivery.ResponseStatus != http.StatusFound {
+		t.Fatalf("unexpected deliveries %+v (%v)", page.Edges, err)
+	}
+}
+
+func TestDispatchSkipsInactiveEndpoints(t *testing.T) {
+	store, d, rec, storeID, endpointID, _ := setup(t, 1)
+	ctx := context.Background()
+	createPet(t, store, storeID)
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 || len(rec.requests) != 1 {
+		t.Fatalf("expected one failed request, got %d sent after %d requests (%v)", sent, len(rec.requests), err)
+	}
+	inactive := false
+	if _, err := store.UpdateWebhookEndpoint(ctx, storeID, endpointID, db.WebhookEndpointPatch{Active: &inactive}); err != nil {
+		t.Fatalf("deactivate endpoint: %v", err)
+	}
+	createPet(t, store, storeID)
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 || len(rec.requests) != 1 {
+		t.Fatalf("expected nothing more to reach an inactive endpoint, got %d sent after %d requests (%v)", sent, len(rec.requests), err)
+	}
+	page, err := store.PageWebhookDeliveries(ctx, storeID, db.WebhookDeliveryFilter{}, db.PageRequest{})
+	if err != nil || len(page.Edges) != 1 || page.Edges[0].Delivery.Status != db.WebhookPending {
+		t.Fatalf("expected the queued delivery to stay pending, got %+v (%v)", page.Edges, err)
+	}
+	if _, err := store.RedeliverWebhook(ctx, storeID, page.Edges[0].Delivery.ID); !errors.Is(err, db.ErrWebhookEndpointInactive) {
+		t.Fatalf("expected redelivery to an inactive endpoint to be refused, got %v", err)
+	}
+
+	active := true
+	if _, err := store.UpdateWebhookEndpoint(ctx, storeID, endpointID, db.WebhookEndpointPatch{Active: &active}); err != nil {
+		t.Fatalf("activate endpoint: %v", err)
+	}
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 1 || len(rec.requests) != 2 {
+		t.Fatalf("expected the parked delivery to be sent once active, got %d sent after %d requests (%v)", sent, len(rec.requests), err)
+	}
+}
+
+func TestDispatchRefusesPrivateAddresses(t *testing.T) {
+	store, d, rec, storeID, _, _ := setup(t, 0)
+	ctx := context.Background()
+	d.client = newClient(false)
+	createPet(t, store, storeID)
+	if sent, err := d.Dispatch(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected the loopback endpoint to be refused, got %d (%v)", sent, err)
+	}
+	if len(rec.requests) != 0 {
+		t.Fatalf("expected no request to reach the endpoint, got %d", len(rec.requests))
+	}
+	page, err := store.PageWebhookDeliveries(ctx, storeID, db.WebhookDeliveryFilter{}, db.PageRequest{})
+	if err != nil || len(page.Edges) != 1 || page.Edges[0].Delivery.Status != db.WebhookFailed {
+		t.Fatalf("expected the delivery to fail at once, got %+v (%v)", page.Edges, err)
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	for _, address := range []string{
+		"127.0.0.1:443", "[::1]:443", "10.1.2.3:443", "172.16.0.1:443", "192.168.1.1:443",
+		"169.254.169.254:80", "0.0.0.0:443", "[::]:443", "100.64.0.1:443", "[fc00::1]:443",
+		"[fe80::1]:443", "[::ffff:127.0.0.1]:443", "224.0.0.1:443",
+	} {
+		if err := checkAddress(address, false); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("expected %s to be refused, got %v", address, err)
+		}
+	}
+	for _, address := range []string{"93.184.216.34:443", "[2606:2800:220:1::]:443"} {
+		if err := checkAddress(address, false); err != nil {
+			t.Errorf("expected %s to be allowed, got %v", address, err)
+		}
+	}
+	if err := checkAddress("127.0.0.1:443", true); err != nil {
+		t.Errorf("expected the loopback opt-in to allow 127.0.0.1, got %v", err)
+	}
+	if err := checkAddress("10.0.0.1:443", true); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("expected the loopback opt-in to still refuse 10.0.0.1, got %v", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// The same as: printf '1700000000.{"id":"1"}' | openssl dgst -sha256 -hmac whsec_test
+	got := Sign("whsec_test", "1700000000", []byte(`{"id":"1"}`))
+	if want := "sha256=11bf4466ea17c3df3fd743af0b435368e16b7a05eb8eced85e8c4670767bdec5"; got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,356 @@
+// Package websocket is the server side of RFC 6455, as much of it as the
+// GraphQL subscription transport needs: text and binary messages,
+// fragmentation, ping/pong and the closing handshake. Extensions such as
+// compression are never negotiated.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xa
+)
+
+// Close codes from RFC 6455, section 7.4.1.
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseNoStatus      = 1005
+	CloseInvalidData   = 1007
+	CloseTooBig        = 1009
+	CloseInternalError = 1011
+)
+
+const (
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// DefaultReadLimit caps the size of a message, fragments included.
+	DefaultReadLimit = 64 << 10
+	writeTimeout     = 10 * time.Second
+)
+
+// CloseError is returned by ReadMessage once the connection is closed,
+// with the code the peer sent or the one the server closed it with.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+var ErrClosed = errors.New("websocket is closed")
+
+// IsUpgrade reports whether r asks to switch to WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// The subprotocol is the first of protocols the client offered; a client
+// that offers none of them is refused. On failure an HTTP error has been
+// written.
+func Upgrade(w http.ResponseWriter, r *http.Request, protocols []string) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("invalid websocket key")
+	}
+	protocol := chooseProtocol(r.Header, protocols)
+	if protocol == "" {
+		http.Error(w, "unsupported websocket subprotocol", http.StatusBadRequest)
+		return nil, errors.New("no supported subprotocol")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijack: %w", err)
+	}
+	// The server's read and write timeouts would otherwise still apply.
+	_ = conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n" +
+		"Sec-WebSocket-Protocol: " + protocol + "\r\n\r\n"
+	_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write handshake: %w", err)
+	}
+	return &Conn{conn: conn, br: rw.Reader, protocol: protocol, readLimit: DefaultReadLimit}, nil
+}
+
+func chooseProtocol(h http.Header, supported []string) string {
+	for _, value := range h.Values("Sec-WebSocket-Protocol") {
+		for _, offered := range strings.Split(value, ",") {
+			offered = strings.TrimSpace(offered)
+			for _, p := range supported {
+				if offered == p {
+					return p
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn is an upgraded connection. One goroutine may read while any number
+// write.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	protocol    string
+	readLimit   int64
+	idleTimeout time.Duration
+
+	mu        sync.Mutex
+	closeSent bool
+}
+
+func (c *Conn) Subprotocol() string { return c.protocol }
+
+func (c *Conn) SetReadLimit(n int64) { c.readLimit = n }
+
+// SetIdleTimeout closes the connection when no frame, pongs included,
+// arrives for d. Zero disables it.
+func (c *Conn) SetIdleTimeout(d time.Duration) { c.idleTimeout = d }
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs dropped along the way. Once the peer closes, or a protocol
+// error makes the server close, it returns a *CloseError.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var opcode int
+	var message []byte
+	for {
+		h, err := c.readHeader()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		if h.opcode >= OpClose {
+			if err := c.handleControl(h); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		switch {
+		case h.opcode == OpContinuation && opcode == 0:
+			return 0, nil, c.fail(&CloseError{CloseProtocolError, "unexpected continuation frame"})
+		case h.opcode != OpContinuation && opcode != 0:
+			return 0, nil, c.fail(&CloseError{CloseProtocolError, "expected continuation frame"})
+		case h.opcode != OpContinuation && h.opcode != OpText && h.opcode != OpBinary:
+			return 0, nil, c.fail(&CloseError{CloseProtocolError, "unknown opcode"})
+		}
+		if h.opcode != OpContinuation {
+			opcode = h.opcode
+		}
+		if int64(len(message))+h.length > c.readLimit {
+			return 0, nil, c.fail(&CloseError{CloseTooBig, "message too big"})
+		}
+		payload, err := c.readPayload(h)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		message = append(message, payload...)
+		if h.fin {
+			if opcode == OpText && !utf8.Valid(message) {
+				return 0, nil, c.fail(&CloseError{CloseInvalidData, "invalid UTF-8"})
+			}
+			return opcode, message, nil
+		}
+	}
+}
+
+type frameHeader struct {
+	fin    bool
+	opcode int
+	length int64
+	mask   [4]byte
+}
+
+func (c *Conn) readHeader() (frameHeader, error) {
+	if c.idleTimeout > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+	}
+	var h frameHeader
+	var b [8]byte
+	if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+		return h, err
+	}
+	h.fin = b[0]&0x80 != 0
+	h.opcode = int(b[0] & 0x0f)
+	if b[0]&0x70 != 0 {
+		return h, &CloseError{CloseProtocolError, "reserved bits set"}
+	}
+	if b[1]&0x80 == 0 {
+		return h, &CloseError{CloseProtocolError, "client frames must be masked"}
+	}
+	h.length = int64(b[1] & 0x7f)
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return h, err
+		}
+		n := binary.BigEndian.Uint64(b[:8])
+		if n > 1<<62 {
+			return h, &CloseError{CloseTooBig, "message too big"}
+		}
+		h.length = int64(n)
+	}
+	if h.opcode >= OpClose && (h.length > 125 || !h.fin) {
+		return h, &CloseError{CloseProtocolError, "invalid control frame"}
+	}
+	if _, err := io.ReadFull(c.br, h.mask[:]); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+func (c *Conn) readPayload(h frameHeader) ([]byte, error) {
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= h.mask[i%4]
+	}
+	return payload, nil
+}
+
+func (c *Conn) handleControl(h frameHeader) error {
+	payload, err := c.readPayload(h)
+	if err != nil {
+		return c.fail(err)
+	}
+	switch h.opcode {
+	case OpPing:
+		if err := c.WriteMessage(OpPong, payload); err != nil {
+			return c.fail(err)
+		}
+	case OpPong:
+	case OpClose:
+		closeErr := &CloseError{Code: CloseNoStatus}
+		if len(payload) >= 2 {
+			closeErr.Code = int(binary.BigEndian.Uint16(payload))
+			closeErr.Reason = string(payload[2:])
+		}
+		code := closeErr.Code
+		if code == CloseNoStatus {
+			code = CloseNormal
+		}
+		_ = c.Close(code, "")
+		return closeErr
+	default:
+		return c.fail(&CloseError{CloseProtocolError, "unknown opcode"})
+	}
+	return nil
+}
+
+// fail closes the connection after a read error, telling the peer why if
+// it broke the protocol.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		_ = c.Close(closeErr.Code, closeErr.Reason)
+		return closeErr
+	}
+	c.conn.Close()
+	return &CloseError{Code: CloseGoingAway, Reason: err.Error()}
+}
+
+// WriteMessage sends one unfragmented frame.
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	return c.writeFrame(opcode, data)
+}
+
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(opcode)
+	switch n := len(data); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(header, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close sends a close frame with the code and reason and closes the
+// connection without waiting for the peer's reply.
+func (c *Conn) Close(code int, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	_ = c.writeFrame(OpClose, append(payload, reason...))
+	return c.conn.Close()
+}
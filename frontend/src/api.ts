@@ -1,4 +1,4 @@
-import { Pet, PurchaseError } from "./types";
+import { Cart, Pet, PurchaseError, Species } from "./types";
 
 const API_URL = import.meta.env.VITE_API_URL as string;
 const CUSTOMER_USER = import.meta.env.VITE_CUSTOMER_USER as string;
@@ -17,20 +17,38 @@ function basicAuthHeader(username: string, password: string) {
   return `Basic ${token}`;
 }
 
+// files maps variable paths such as "input.picture" to the files to upload
+// in their place. Requests with files are sent as GraphQL multipart
+// requests, which the API only accepts with a GraphQL-Preflight header.
 async function request<T>(
   query: string,
   variables: Record<string, unknown>,
-  auth?: { username: string; password: string }
+  auth?: { username: string; password: string },
+  files?: Record<string, File>
 ): Promise<T> {
   const credentials = auth ?? { username: CUSTOMER_USER, password: CUSTOMER_PASS };
-  const res = await fetch(API_URL, {
-    method: "POST",
-    headers: {
-      "Content-Type": "application/json",
-      Authorization: basicAuthHeader(credentials.username, credentials.password),
-    },
-    body: JSON.stringify({ query, variables }),
-  });
+  const headers: Record<string, string> = {
+    Authorization: basicAuthHeader(credentials.username, credentials.password),
+  };
+  let body: BodyInit;
+  const uploads = Object.entries(files ?? {});
+  if (uploads.length > 0) {
+    const form = new FormData();
+    form.append("operations", JSON.stringify({ query, variables }));
+    form.append(
+      "map",
+      JSON.stringify(
+        Object.fromEntries(uploads.map(([path], i) => [String(i), [`variables.${path}`]]))
+      )
+    );
+    uploads.forEach(([, file], i) => form.append(String(i), file));
+    headers["GraphQL-Preflight"] = "1";
+    body = form;
+  } else {
+    headers["Content-Type"] = "application/json";
+    body = JSON.stringify({ query, variables });
+  }
+  const res = await fetch(API_URL, { method: "POST", headers, body });
   if (!res.ok) {
     throw new Error(`Request failed (${res.status})`);
   }
@@ -50,12 +68,25 @@ export async function fetchStorePets(slug = STORE_SLUG): Promise<Pet[]> {
       storePets(storeSlug: $storeSlug) {
         id
         name
-        species
+        species {
+          slug
+          name
+        }
         ageYears
-        pictureUrl
+        picture {
+          url
+          thumbnailUrl
+          width
+          height
+        }
         description
         breederName
         breederEmail
+        price {
+          minorUnits
+          currency
+          amount
+        }
         createdAt
         purchasedAt
       }
@@ -65,17 +96,47 @@ export async function fetchStorePets(slug = STORE_SLUG): Promise<Pet[]> {
   return data.storePets;
 }
 
+export async function fetchSpecies(slug = STORE_SLUG): Promise<Species[]> {
+  const query = `
+    query Species($storeSlug: String!) {
+      species(storeSlug: $storeSlug) {
+        slug
+        name
+        parent {
+          slug
+          name
+        }
+      }
+    }
+  `;
+  const data = await request<{ species: Species[] }>(query, { storeSlug: slug });
+  return data.species;
+}
+
 export async function fetchPurchasedPets(slug = STORE_SLUG): Promise<Pet[]> {
   const query = `
     query PurchasedPets($storeSlug: String!) {
       purchasedPets(storeSlug: $storeSlug) {
         id
         name
-        species
+        species {
+          slug
+          name
+        }
         ageYears
-        pictureUrl
+        picture {
+          url
+          thumbnailUrl
+          width
+          height
+        }
         description
         breederName
+        price {
+          minorUnits
+          currency
+          amount
+        }
         createdAt
         purchasedAt
       }
@@ -85,6 +146,94 @@ export async function fetchPurchasedPets(slug = STORE_SLUG): Promise<Pet[]> {
   return data.purchasedPets;
 }
 
+const CART_FIELDS = `
+  items {
+    expiresAt
+    pet {
+      id
+      name
+      species {
+        slug
+        name
+      }
+      ageYears
+      picture {
+        url
+        thumbnailUrl
+        width
+        height
+      }
+      description
+      breederName
+      breederEmail
+      price {
+        minorUnits
+        currency
+        amount
+      }
+      createdAt
+      purchasedAt
+    }
+  }
+  subtotal {
+    minorUnits
+    currency
+    amount
+  }
+  expiresAt
+`;
+
+export async function fetchCart(slug = STORE_SLUG): Promise<Cart> {
+  const query = `
+    query Cart($storeSlug: String!) {
+      cart(storeSlug: $storeSlug) {${CART_FIELDS}}
+    }
+  `;
+  const data = await request<{ cart: Cart }>(query, { storeSlug: slug });
+  return data.cart;
+}
+
+export async function addToCart(petId: string, slug = STORE_SLUG): Promise<Cart> {
+  const query = `
+    mutation AddToCart($storeSlug: String!, $petId: ID!) {
+      addToCart(storeSlug: $storeSlug, petId: $petId) {${CART_FIELDS}}
+    }
+  `;
+  const data = await request<{ addToCart: Cart }>(query, { storeSlug: slug, petId });
+  return data.addToCart;
+}
+
+export async function removeFromCart(petId: string, slug = STORE_SLUG): Promise<Cart> {
+  const query = `
+    mutation RemoveFromCart($storeSlug: String!, $petId: ID!) {
+      removeFromCart(storeSlug: $storeSlug, petId: $petId) {${CART_FIELDS}}
+    }
+  `;
+  const data = await request<{ removeFromCart: Cart }>(query, { storeSlug: slug, petId });
+  return data.removeFromCart;
+}
+
+export async function checkoutCart(
+  idempotencyKey: string,
+  slug = STORE_SLUG
+): Promise<{ purchasedIds: string[]; errors: PurchaseError[] }> {
+  const query = `
+    mutation CheckoutCart($input: PurchasePetsInput!) {
+      purchasePets(input: $input) {
+        purchasedIds
+        errors {
+          petName
+          message
+        }
+      }
+    }
+  `;
+  const data = await request<{
+    purchasePets: { purchasedIds: string[]; errors: PurchaseError[] };
+  }>(query, { input: { storeSlug: slug, fromCart: true, idempotencyKey } });
+  return data.purchasePets;
+}
+
 export async function purchasePets(
   petIds: string[],
   slug = STORE_SLUG
@@ -106,34 +255,54 @@ export async function purchasePets(
   return data.purchasePets;
 }
 
-export async function createPet(input: {
-  name: string;
-  species: "CAT" | "DOG" | "FROG";
-  ageYears: number;
-  pictureUrl: string;
-  description: string;
-  breederName: string;
-  breederEmail: string;
-}): Promise<Pet> {
+export async function createPet(
+  input: {
+    name: string;
+    species: string;
+    ageYears: number;
+    pictureUrl?: string;
+    picture?: File;
+    description: string;
+    breederName: string;
+    breederEmail: string;
+    priceMinorUnits: number;
+  },
+  slug = STORE_SLUG
+): Promise<Pet> {
   const query = `
     mutation CreatePet($input: CreatePetInput!) {
       createPet(input: $input) {
         id
         name
-        species
+        species {
+          slug
+          name
+        }
         ageYears
-        pictureUrl
+        picture {
+          url
+          thumbnailUrl
+          width
+          height
+        }
         description
         breederName
         breederEmail
+        price {
+          minorUnits
+          currency
+          amount
+        }
         createdAt
       }
     }
   `;
+  const { picture, ...fields } = input;
   const data = await request<{ createPet: Pet }>(
     query,
-    { input },
-    { username: MERCHANT_USER, password: MERCHANT_PASS }
+    { input: { ...fields, storeSlug: slug, picture: null } },
+    { username: MERCHANT_USER, password: MERCHANT_PASS },
+    picture ? { "input.picture": picture } : undefined
   );
   return data.createPet;
 }
@@ -1,46 +1,80 @@
-import { useState } from "react";
-import { createPet } from "../api";
+import { useEffect, useState } from "react";
+import { createPet, fetchSpecies } from "../api";
+import { Species } from "../types";
 
 type AddPetFormProps = {
+  slug?: string;
   onPetCreated: () => Promise<void>;
 };
 
 type FormState = {
   name: string;
-  species: "CAT" | "DOG" | "FROG";
+  species: string;
   ageYears: number;
   pictureUrl: string;
+  picture: File | null;
   description: string;
   breederName: string;
   breederEmail: string;
+  price: number;
 };
 
 const defaultState: FormState = {
   name: "",
-  species: "CAT",
+  species: "",
   ageYears: 1,
   pictureUrl: "",
+  picture: null,
   description: "",
   breederName: "",
   breederEmail: "",
+  price: 0,
 };
 
-export default function AddPetForm({ onPetCreated }: AddPetFormProps) {
+// Matches the API's upload limit, so oversized files fail before uploading.
+const MAX_PICTURE_BYTES = 5 * 1024 * 1024;
+
+export default function AddPetForm({ slug, onPetCreated }: AddPetFormProps) {
   const [formState, setFormState] = useState<FormState>(defaultState);
+  // File inputs cannot be cleared through their value, so the input is
+  // remounted after each submit instead.
+  const [pictureInputKey, setPictureInputKey] = useState(0);
+  const [species, setSpecies] = useState<Species[]>([]);
   const [formError, setFormError] = useState<string | null>(null);
   const [formSuccess, setFormSuccess] = useState<string | null>(null);
 
+  useEffect(() => {
+    fetchSpecies(slug)
+      .then(setSpecies)
+      .catch((err: unknown) => {
+        if (err instanceof Error) {
+          setFormError(err.message);
+        }
+      });
+  }, [slug]);
+
   async function handleSubmit(event: React.FormEvent) {
     event.preventDefault();
     setFormError(null);
     setFormSuccess(null);
+    const { price, picture, pictureUrl, ...rest } = formState;
+    if (picture && picture.size > MAX_PICTURE_BYTES) {
+      setFormError("Pictures must not be larger than 5 MB.");
+      return;
+    }
     try {
-      await createPet({
-        ...formState,
-        ageYears: Number(formState.ageYears),
-      });
+      await createPet(
+        {
+          ...rest,
+          ...(picture ? { picture } : { pictureUrl }),
+          ageYears: Number(formState.ageYears),
+          priceMinorUnits: Math.round(Number(price) * 100),
+        },
+        slug
+      );
       setFormSuccess("Pet created successfully.");
       setFormState(defaultState);
+      setPictureInputKey((key) => key + 1);
       await onPetCreated();
     } catch (err: unknown) {
       if (err instanceof Error) {
@@ -73,13 +107,19 @@ export default function AddPetForm({ onPetCreated }: AddPetFormProps) {
             onChange={(event) =>
               setFormState({
                 ...formState,
-                species: event.target.value as FormState["species"],
+                species: event.target.value,
               })
             }
+            required
           >
-            <option value="CAT">Cat</option>
-            <option value="DOG">Dog</option>
-            <option value="FROG">Frog</option>
+            <option value="" disabled>
+              Choose a species
+            </option>
+            {species.map((sp) => (
+              <option key={sp.slug} value={sp.slug}>
+                {sp.parent ? `${sp.parent.name} / ${sp.name}` : sp.name}
+              </option>
+            ))}
           </select>
         </label>
         <label>
@@ -98,13 +138,45 @@ export default function AddPetForm({ onPetCreated }: AddPetFormProps) {
           />
         </label>
         <label>
-          Picture URL
+          Price
+          <input
+            type="number"
+            min={0}
+            step={0.01}
+            value={formState.price}
+            onChange={(event) =>
+              setFormState({
+                ...formState,
+                price: Number(event.target.value),
+              })
+            }
+            required
+          />
+        </label>
+        <label>
+          Picture
+          <input
+            key={pictureInputKey}
+            type="file"
+            accept="image/jpeg,image/png,image/gif"
+            onChange={(event) =>
+              setFormState({
+                ...formState,
+                picture: event.target.files?.[0] ?? null,
+              })
+            }
+          />
+        </label>
+        <label>
+          Or picture URL
           <input
+            type="url"
             value={formState.pictureUrl}
+            disabled={formState.picture !== null}
             onChange={(event) =>
               setFormState({ ...formState, pictureUrl: event.target.value })
             }
-            required
+            required={formState.picture === null}
           />
         </label>
         <label>
@@ -13,14 +13,17 @@ export default function PetGrid({ pets, cart, onToggleCart }: PetGridProps) {
         const inCart = Boolean(cart[pet.id]);
         return (
           <div key={pet.id} className="card">
-            <img src={pet.pictureUrl} alt={pet.name} />
+            <img src={pet.picture.thumbnailUrl} alt={pet.name} loading="lazy" />
             <div className="card-body">
               <div className="card-title">
                 <h3>{pet.name}</h3>
-                <span className="tag">{pet.species}</span>
+                <span className="tag">{pet.species.name}</span>
               </div>
               <p className="muted">{pet.description}</p>
               <div className="meta">
+                <span>
+                  Price: {pet.price.amount} {pet.price.currency}
+                </span>
                 <span>Age: {pet.ageYears} years</span>
                 <span>Breeder: {pet.breederName}</span>
                 <span className="code">ID: {pet.id}</span>
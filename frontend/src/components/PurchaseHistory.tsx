@@ -15,7 +15,7 @@ export default function PurchaseHistory({ pets }: PurchaseHistoryProps) {
           {pets.map((pet) => (
             <div key={pet.id} className="history-card">
               <div>
-                <strong>{pet.name}</strong> ({pet.species})
+                <strong>{pet.name}</strong> ({pet.species.name})
               </div>
               <div className="muted">
                 Purchased:{" "}
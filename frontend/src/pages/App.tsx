@@ -1,7 +1,14 @@
-import { useEffect, useMemo, useState } from "react";
+import { useEffect, useMemo, useRef, useState } from "react";
 import { useParams } from "react-router-dom";
-import { fetchPurchasedPets, fetchStorePets, purchasePets } from "../api";
-import { Pet, PurchaseError } from "../types";
+import {
+  addToCart,
+  checkoutCart,
+  fetchCart,
+  fetchPurchasedPets,
+  fetchStorePets,
+  removeFromCart,
+} from "../api";
+import { Cart, Pet, PurchaseError } from "../types";
 import AddPetForm from "../components/AddPetForm";
 import PetGrid from "../components/PetGrid";
 import PurchaseHistory from "../components/PurchaseHistory";
@@ -17,17 +24,29 @@ export default function App() {
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState<string | null>(null);
   const [checkoutErrors, setCheckoutErrors] = useState<PurchaseError[]>([]);
+  // Reused until the server answers, so a retry after a dropped connection
+  // gets the original result instead of "already purchased".
+  const checkoutKey = useRef(crypto.randomUUID());
 
   const cartItems = useMemo(() => Object.values(cart), [cart]);
 
+  function applyCart(serverCart: Cart) {
+    const next: Record<string, Pet> = {};
+    for (const item of serverCart.items) {
+      next[item.pet.id] = item.pet;
+    }
+    setCart(next);
+  }
+
   useEffect(() => {
     let mounted = true;
     setLoading(true);
-    Promise.all([fetchStorePets(slug), fetchPurchasedPets(slug)])
-      .then(([available, purchasedList]) => {
+    Promise.all([fetchStorePets(slug), fetchPurchasedPets(slug), fetchCart(slug)])
+      .then(([available, purchasedList, serverCart]) => {
         if (!mounted) return;
         setPets(available);
         setPurchased(purchasedList);
+        applyCart(serverCart);
         setError(null);
       })
       .catch((err) => {
@@ -43,27 +62,29 @@ export default function App() {
     };
   }, [slug]);
 
-  function toggleCart(pet: Pet) {
-    setCart((prev) => {
-      const next = { ...prev };
-      if (next[pet.id]) {
-        delete next[pet.id];
-      } else {
-        next[pet.id] = pet;
+  async function toggleCart(pet: Pet) {
+    setCheckoutErrors([]);
+    try {
+      const serverCart = cart[pet.id]
+        ? await removeFromCart(pet.id, slug)
+        : await addToCart(pet.id, slug);
+      applyCart(serverCart);
+    } catch (err: unknown) {
+      if (err instanceof Error) {
+        setCheckoutErrors([{ petName: pet.name, message: err.message }]);
       }
-      return next;
-    });
+    }
   }
 
   async function handleCheckout() {
     setCheckoutErrors([]);
     try {
-      const result = await purchasePets(cartItems.map((p) => p.id), slug);
+      const result = await checkoutCart(checkoutKey.current, slug);
+      checkoutKey.current = crypto.randomUUID();
       if (result.errors.length > 0) {
         setCheckoutErrors(result.errors);
-      } else {
-        setCart({});
       }
+      applyCart(await fetchCart(slug));
       const refreshed = await fetchStorePets(slug);
       setPets(refreshed);
       const purchasedList = await fetchPurchasedPets(slug);
@@ -110,7 +131,7 @@ export default function App() {
         <PetGrid pets={pets} cart={cart} onToggleCart={toggleCart} />
       )}
 
-      {activeTab === "add" && <AddPetForm onPetCreated={refreshPets} />}
+      {activeTab === "add" && <AddPetForm slug={slug} onPetCreated={refreshPets} />}
 
       {activeTab === "history" && <PurchaseHistory pets={purchased} />}
     </div>
@@ -1,12 +1,32 @@
+export type Money = {
+  minorUnits: number;
+  currency: string;
+  amount: string;
+};
+
+export type Species = {
+  slug: string;
+  name: string;
+  parent?: { slug: string; name: string } | null;
+};
+
+export type Picture = {
+  url: string;
+  thumbnailUrl: string;
+  width?: number | null;
+  height?: number | null;
+};
+
 export type Pet = {
   id: string;
   name: string;
-  species: "CAT" | "DOG" | "FROG";
+  species: Species;
   ageYears: number;
-  pictureUrl: string;
+  picture: Picture;
   description: string;
   breederName: string;
   breederEmail: string;
+  price: Money;
   createdAt: string;
   purchasedAt?: string | null;
 };
@@ -15,3 +35,14 @@ export type PurchaseError = {
   petName: string;
   message: string;
 };
+
+export type CartItem = {
+  pet: Pet;
+  expiresAt: string;
+};
+
+export type Cart = {
+  items: CartItem[];
+  subtotal: Money;
+  expiresAt?: string | null;
+};